@@ -69,8 +69,8 @@ func main() {
 		serverURL,
 		insecureTLS,
 		insecureTransport,
-		certPins,
-		rootPool,
+		service.NewTLSSettings(certPins, rootPool),
+		nil,
 		logger,
 	)
 	if err != nil {
@@ -5,21 +5,39 @@ package main
 
 import (
 	"context"
+	"crypto/rsa"
+	"io/ioutil"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/actor"
+	"github.com/kolide/krypto"
+	"github.com/kolide/launcher/pkg/agent/flags"
 	"github.com/kolide/launcher/pkg/control"
 	"github.com/kolide/launcher/pkg/launcher"
 	"github.com/pkg/errors"
 	"go.etcd.io/bbolt"
 )
 
-func createControl(ctx context.Context, db *bbolt.DB, logger log.Logger, opts *launcher.Options) (*actor.Actor, error) {
+// agentFlagsSubsystemName is the control server subsystem carrying
+// runtime overrides of launcher options
+const agentFlagsSubsystemName = "agent_flags"
+
+func createControl(ctx context.Context, db *bbolt.DB, logger log.Logger, opts *launcher.Options, flagsStore *flags.Flags) (*actor.Actor, error) {
 	level.Debug(logger).Log("msg", "creating control client")
 
+	if opts.ControlServerKeyPath == "" {
+		return nil, errors.New("control requires control_server_key")
+	}
+	serverKey, err := readControlServerKey(opts.ControlServerKeyPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading control server key")
+	}
+
 	controlOpts := []control.Option{
 		control.WithLogger(logger),
+		control.WithGetInterval(opts.ControlRequestInterval),
+		control.WithServerKey(serverKey),
 	}
 	if opts.InsecureTLS {
 		controlOpts = append(controlOpts, control.WithInsecureSkipVerify())
@@ -32,6 +50,10 @@ func createControl(ctx context.Context, db *bbolt.DB, logger log.Logger, opts *l
 		return nil, errors.Wrap(err, "creating control client")
 	}
 
+	if err := controlClient.RegisterConsumer(agentFlagsSubsystemName, flagsStore); err != nil {
+		return nil, errors.Wrap(err, "registering agent flags consumer")
+	}
+
 	return &actor.Actor{
 		Execute: func() error {
 			level.Info(logger).Log("msg", "control started")
@@ -44,3 +66,23 @@ func createControl(ctx context.Context, db *bbolt.DB, logger log.Logger, opts *l
 		},
 	}, nil
 }
+
+// readControlServerKey reads the PEM encoded RSA public key at path
+func readControlServerKey(path string) (*rsa.PublicKey, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	key, err := krypto.KeyFromPem(pemBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing key")
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.Errorf("%s is not an RSA public key", path)
+	}
+
+	return rsaKey, nil
+}
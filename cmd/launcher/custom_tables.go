@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// customTablesConfigKey is the key in the osquery config sent by the
+// server, that holds its custom table specs.
+const customTablesConfigKey = "kolide_custom_tables"
+
+// customTableSpecs returns a function which loads the custom table specs
+// from the file at path, if any, and from the last config the server
+// sent. The server may only declare tables that read files: tables that
+// exec commands run them as launcher's user, so they must come from the
+// local file.
+func customTableSpecs(logger log.Logger, db *bbolt.DB, path string) func() []dataflattentable.TableSpec {
+	return func() []dataflattentable.TableSpec {
+		var specs []dataflattentable.TableSpec
+
+		if path != "" {
+			fileSpecs, err := readCustomTableSpecs(path)
+			if err != nil {
+				level.Info(logger).Log("msg", "could not read custom tables", "path", path, "err", err)
+			}
+			specs = append(specs, fileSpecs...)
+		}
+
+		config, err := osquery.ConfigFromDB(db)
+		if err != nil {
+			level.Debug(logger).Log("msg", "could not read config for custom tables", "err", err)
+			return specs
+		}
+		serverSpecs, err := parseServerCustomTableSpecs(config)
+		if err != nil {
+			level.Info(logger).Log("msg", "could not parse custom tables from config", "err", err)
+		}
+		return append(specs, serverSpecs...)
+	}
+}
+
+func readCustomTableSpecs(path string) ([]dataflattentable.TableSpec, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading custom tables")
+	}
+
+	var specs []dataflattentable.TableSpec
+	if err := json.Unmarshal(contents, &specs); err != nil {
+		return nil, errors.Wrap(err, "parsing custom tables")
+	}
+	return specs, nil
+}
+
+// parseServerCustomTableSpecs returns the custom table specs in an osquery
+// config, dropping any that exec commands.
+func parseServerCustomTableSpecs(config string) ([]dataflattentable.TableSpec, error) {
+	if config == "" {
+		return nil, nil
+	}
+
+	var parsed map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(config), &parsed); err != nil {
+		return nil, errors.Wrap(err, "parsing config")
+	}
+	raw, ok := parsed[customTablesConfigKey]
+	if !ok {
+		return nil, nil
+	}
+
+	var specs []dataflattentable.TableSpec
+	if err := json.Unmarshal(raw, &specs); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", customTablesConfigKey)
+	}
+
+	fileSpecs := specs[:0]
+	for _, spec := range specs {
+		if len(spec.Exec) > 0 {
+			continue
+		}
+		fileSpecs = append(fileSpecs, spec)
+	}
+	return fileSpecs, nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseServerCustomTableSpecs(t *testing.T) {
+	t.Parallel()
+
+	specs, err := parseServerCustomTableSpecs("")
+	require.NoError(t, err)
+	require.Empty(t, specs)
+
+	specs, err = parseServerCustomTableSpecs(`{"options": {"host_identifier": "uuid"}}`)
+	require.NoError(t, err)
+	require.Empty(t, specs)
+
+	_, err = parseServerCustomTableSpecs(`{"kolide_custom_tables": "nope"}`)
+	require.Error(t, err)
+
+	// Only tables that read files are taken from the server
+	specs, err = parseServerCustomTableSpecs(`{
+		"schedule": {},
+		"kolide_custom_tables": [
+			{"name": "kolide_compose", "path": "/srv/*/compose.json", "parser": "json"},
+			{"name": "kolide_shell", "exec": ["/bin/sh", "-c", "id"], "parser": "kv"}
+		]
+	}`)
+	require.NoError(t, err)
+	require.Equal(t, []dataflattentable.TableSpec{
+		{Name: "kolide_compose", Path: "/srv/*/compose.json", Parser: "json"},
+	}, specs)
+}
+
+func TestReadCustomTableSpecs(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "custom_tables.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte(`[
+		{"name": "kolide_lsblk2", "exec": ["lsblk", "-J"], "bin_dirs": ["/usr/bin", "/bin"], "parser": "json", "cache_ttl_seconds": 30}
+	]`), 0644))
+
+	specs, err := readCustomTableSpecs(path)
+	require.NoError(t, err)
+	require.Equal(t, []dataflattentable.TableSpec{
+		{Name: "kolide_lsblk2", Exec: []string{"lsblk", "-J"}, BinDirs: []string{"/usr/bin", "/bin"}, Parser: "json", CacheTTLSeconds: 30},
+	}, specs)
+
+	_, err = readCustomTableSpecs(filepath.Join(t.TempDir(), "missing.json"))
+	require.Error(t, err)
+}
@@ -4,20 +4,21 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/actor"
-	"github.com/kolide/launcher/cmd/launcher/internal"
+	"github.com/kolide/launcher/pkg/agent/flags"
 	"github.com/kolide/launcher/pkg/augeas"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/kolide/launcher/pkg/launcher"
 	kolidelog "github.com/kolide/launcher/pkg/log"
 	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/kolide/launcher/pkg/osquery/logsink"
 	"github.com/kolide/launcher/pkg/osquery/runtime"
+	"github.com/kolide/launcher/pkg/osquery/runtime/history"
 	ktable "github.com/kolide/launcher/pkg/osquery/table"
 	"github.com/kolide/launcher/pkg/service"
 	"github.com/osquery/osquery-go/plugin/config"
@@ -41,7 +42,7 @@ func (aq actorQuerier) Query(query string) ([]map[string]string, error) {
 
 // TODO: the extension, runtime, and client are all kind of entangled
 // here. Untangle the underlying libraries and separate into units
-func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient service.KolideService, opts *launcher.Options) (
+func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient service.KolideService, opts *launcher.Options, flagsStore *flags.Flags, fileReloader *reloader) (
 	run *actorQuerier,
 	restart func() error, // restart osqueryd runner
 	shutdown func() error, // shutdown osqueryd runner
@@ -49,12 +50,24 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 ) {
 	logger := log.With(ctxlog.FromContext(ctx), "caller", log.DefaultCaller)
 
-	// read the enroll secret, if either it or the path has been specified
+	// read the enroll secret, if either it or the path has been
+	// specified. A secret read from a file is updated when the file
+	// changes.
+	var ext *osquery.Extension
 	var enrollSecret string
 	if opts.EnrollSecret != "" {
 		enrollSecret = opts.EnrollSecret
 	} else if opts.EnrollSecretPath != "" {
-		content, err := ioutil.ReadFile(opts.EnrollSecretPath)
+		content, err := fileReloader.watch(opts.EnrollSecretPath, func(contents []byte) error {
+			secret := string(bytes.TrimSpace(contents))
+			if secret == "" {
+				return errors.New("enroll secret is empty")
+			}
+			// The secret itself is never logged
+			level.Info(logger).Log("msg", "enroll secret changed", "path", opts.EnrollSecretPath)
+			ext.SetEnrollSecret(secret)
+			return nil
+		})
 		if err != nil {
 			return nil, nil, nil, errors.Wrapf(err, "could not read enroll_secret_path: %s", opts.EnrollSecretPath)
 		}
@@ -65,60 +78,58 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 	extOpts := osquery.ExtensionOpts{
 		EnrollSecret:                      enrollSecret,
 		Logger:                            logger,
-		LoggingInterval:                   opts.LoggingInterval,
+		LoggingInterval:                   flagsStore.LoggingInterval(),
 		RunDifferentialQueriesImmediately: opts.EnableInitialRunner,
+		DisableServerLogs:                 opts.DisableServerLogs,
 	}
 
-	// Setting MaxBytesPerBatch is a tradeoff. If it's too low, we
-	// can never send a large result. But if it's too high, we may
-	// not be able to send the data over a low bandwidth
-	// connection before the connection is timed out.
-	//
-	// The logic for setting this is spread out. The underlying
-	// extension defaults to 3mb, to support GRPC's hardcoded 4MB
-	// limit. But as we're transport aware here. we can set it to
-	// 5MB for others.
-	if opts.LogMaxBytesPerBatch != 0 {
-		if opts.Transport == "grpc" && opts.LogMaxBytesPerBatch > 3 {
-			level.Info(logger).Log(
-				"msg", "LogMaxBytesPerBatch is set above the grpc recommended maximum of 3. Expect errors",
-				"LogMaxBytesPerBatch", opts.LogMaxBytesPerBatch,
-			)
-		}
-		extOpts.MaxBytesPerBatch = opts.LogMaxBytesPerBatch << 20
-	} else if opts.Transport == "grpc" {
-		extOpts.MaxBytesPerBatch = 3 << 20
-	} else if opts.Transport != "grpc" {
-		extOpts.MaxBytesPerBatch = 5 << 20
+	extOpts.LogSinks, err = createLogSinks(opts)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "creating log sinks")
 	}
 
+	extOpts.MaxBytesPerBatch, extOpts.MaxBytesPerLog = logSizeLimits(logger, opts)
+
 	// create the extension
-	ext, err := osquery.NewExtension(launcherClient, db, extOpts)
+	ext, err = osquery.NewExtension(launcherClient, db, extOpts)
 	if err != nil {
 		return nil, nil, nil, errors.Wrap(err, "starting grpc extension")
 	}
 
-	var runnerOptions []runtime.OsqueryInstanceOption
+	runner := runtime.LaunchUnstartedInstance(grpcRunnerOptions(logger, db, opts, flagsStore, ext)...)
 
-	if opts.Transport == "osquery" {
-		var err error
-		runnerOptions, err = osqueryRunnerOptions(logger, db, opts)
-		if err != nil {
-			return nil, nil, nil, errors.Wrap(err, "creating osquery runner options")
+	// Additional osquery instances are supervised alongside the primary
+	secondaryRunners := make([]*runtime.Runner, 0, len(opts.OsqueryInstances))
+	for _, instance := range opts.OsqueryInstances {
+		if instance.RootDirectory == "" {
+			instance.RootDirectory = filepath.Join(opts.RootDirectory, "osquery-"+instance.Name)
 		}
-	} else {
-		runnerOptions = grpcRunnerOptions(logger, db, opts, ext)
+		if err := os.MkdirAll(instance.RootDirectory, 0700); err != nil {
+			return nil, nil, nil, errors.Wrapf(err, "creating root directory for osquery instance %s", instance.Name)
+		}
+		secondaryRunners = append(secondaryRunners, runtime.LaunchUnstartedInstance(secondaryRunnerOptions(logger, db, opts, flagsStore, ext, instance)...))
 	}
 
-	runner := runtime.LaunchUnstartedInstance(runnerOptions...)
+	flagsStore.RegisterChangeObserver(flags.ObserverFunc(func(keys ...flags.FlagKey) {
+		ext.SetLoggingInterval(flagsStore.LoggingInterval())
+	}), flags.LoggingInterval)
+
+	flagsStore.RegisterChangeObserver(flags.ObserverFunc(func(keys ...flags.FlagKey) {
+		level.Info(logger).Log("msg", "osquery verbosity changed, restarting osqueryd", "verbose", flagsStore.OsqueryVerbose())
+		if err := runner.RestartWithOptions(runtime.WithOsqueryVerbose(flagsStore.OsqueryVerbose())); err != nil {
+			level.Info(logger).Log("msg", "error restarting osqueryd", "err", err)
+		}
+	}), flags.OsqueryVerbose)
 
+	// restartFunc is called by the osquery updater, once it has
+	// installed a new osqueryd
 	restartFunc := func() error {
 		level.Debug(logger).Log(
 			"caller", log.DefaultCaller,
 			"msg", "restart function",
 		)
 
-		return runner.Restart()
+		return runner.RestartWithTrigger(history.RestartUpdater)
 	}
 
 	return &actorQuerier{
@@ -131,16 +142,6 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 						return errors.Wrap(err, "launching osquery instance")
 					}
 
-					// If we're using osquery transport, we don't need the extension
-					if opts.Transport == "osquery" {
-						level.Debug(logger).Log("msg", "Using osquery transport, skipping extension startup")
-
-						// TODO: remove when underlying libs are refactored
-						// everything exits right now, so block this actor on the context finishing
-						<-ctx.Done()
-						return nil
-					}
-
 					// The runner allows querying the osqueryd instance from the extension.
 					// Used by the Enroll method below to get initial enrollment details.
 					ext.SetQuerier(runner)
@@ -172,6 +173,15 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 
 					level.Info(logger).Log("msg", "extension started")
 
+					// A broken additional instance shouldn't stop the
+					// primary, so failures are only logged. The runner
+					// keeps retrying it.
+					for i, secondary := range secondaryRunners {
+						if err := secondary.Start(); err != nil {
+							level.Info(logger).Log("msg", "error launching osquery instance, retrying", "osquery_instance", opts.OsqueryInstances[i].Name, "err", err)
+						}
+					}
+
 					// TODO: remove when underlying libs are refactored
 					// everything exits right now, so block this actor on the context finishing
 					<-ctx.Done()
@@ -180,6 +190,11 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 				Interrupt: func(err error) {
 					level.Info(logger).Log("msg", "extension interrupted", "err", err)
 					level.Debug(logger).Log("msg", "extension interrupted", "err", err, "stack", fmt.Sprintf("%+v", err))
+					for i, secondary := range secondaryRunners {
+						if err := secondary.Shutdown(); err != nil {
+							level.Info(logger).Log("msg", "error shutting down osquery instance", "osquery_instance", opts.OsqueryInstances[i].Name, "err", err)
+						}
+					}
 					ext.Shutdown()
 					if runner != nil {
 						if err := runner.Shutdown(); err != nil {
@@ -196,8 +211,40 @@ func createExtensionRuntime(ctx context.Context, db *bbolt.DB, launcherClient se
 		nil
 }
 
-// commonRunnerOptions returns osquery runtime options common to all transports
-func commonRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options) []runtime.OsqueryInstanceOption {
+// createLogSinks returns the additional log destinations configured in opts
+func createLogSinks(opts *launcher.Options) ([]osquery.LogSink, error) {
+	var sinks []osquery.LogSink
+
+	if opts.LogSinkFile != "" {
+		sinks = append(sinks, logsink.NewFileSink(opts.LogSinkFile))
+	}
+
+	if opts.LogSinkSyslog != "" {
+		destination := opts.LogSinkSyslog
+		if destination == "local" {
+			destination = ""
+		}
+
+		syslogSink, err := logsink.NewSyslogSink(destination)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating syslog sink")
+		}
+		sinks = append(sinks, syslogSink)
+	}
+
+	if opts.LogSinkWebhook != "" {
+		var webhookOpts []logsink.WebhookOption
+		for k, v := range opts.LogSinkWebhookHeaders {
+			webhookOpts = append(webhookOpts, logsink.WithWebhookHeader(k, v))
+		}
+		sinks = append(sinks, logsink.NewWebhookSink(opts.LogSinkWebhook, webhookOpts...))
+	}
+
+	return sinks, nil
+}
+
+// commonRunnerOptions returns osquery runtime options that don't depend on the extension
+func commonRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options, flagsStore *flags.Flags) []runtime.OsqueryInstanceOption {
 	// create the logging adapters for osquery
 	osqueryStderrLogger := kolidelog.NewOsqueryLogAdapter(
 		logger,
@@ -219,56 +266,23 @@ func commonRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options
 		runtime.WithStdout(osqueryStdoutLogger),
 		runtime.WithStderr(osqueryStderrLogger),
 		runtime.WithLogger(logger),
-		runtime.WithOsqueryVerbose(opts.OsqueryVerbose),
+		runtime.WithOsqueryVerbose(flagsStore.OsqueryVerbose()),
 		runtime.WithOsqueryFlags(opts.OsqueryFlags),
 		runtime.WithAugeasLensFunction(augeas.InstallLenses),
 		runtime.WithAutoloadedExtensions(opts.AutoloadedExtensions...),
+		runtime.WithResourceLimits(runtime.ResourceLimits{
+			MaxCPUPercent: float64(opts.OsqueryMaxCPUPercent),
+			MaxRSSBytes:   uint64(opts.OsqueryMaxMemoryMB) << 20,
+		}),
+		runtime.WithExecCacheTTL(opts.TableExecCacheTTL),
 	}
 }
 
-// osqueryRunnerOptions returns the osquery runtime options when using native osquery transport
-func osqueryRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options) ([]runtime.OsqueryInstanceOption, error) {
-	// As osquery requires TLS server certs, we'll  use our embedded defaults if not specified
-	caCertFile := opts.RootPEM
-	if caCertFile == "" {
-		var err error
-		caCertFile, err = internal.InstallCaCerts(opts.RootDirectory)
-		if err != nil {
-			return nil, errors.Wrap(err, "writing CA certs")
-		}
-	}
-
-	runtimeOptions := append(
-		commonRunnerOptions(logger, db, opts),
-		runtime.WithConfigPluginFlag("tls"),
-		runtime.WithDistributedPluginFlag("tls"),
-		runtime.WithLoggerPluginFlag("tls"),
-		runtime.WithTlsConfigEndpoint(opts.OsqueryTlsConfigEndpoint),
-		runtime.WithTlsDistributedReadEndpoint(opts.OsqueryTlsDistributedReadEndpoint),
-		runtime.WithTlsDistributedWriteEndpoint(opts.OsqueryTlsDistributedWriteEndpoint),
-		runtime.WithTlsEnrollEndpoint(opts.OsqueryTlsEnrollEndpoint),
-		runtime.WithTlsHostname(opts.KolideServerURL),
-		runtime.WithTlsLoggerEndpoint(opts.OsqueryTlsLoggerEndpoint),
-		runtime.WithTlsServerCerts(caCertFile),
-	)
-
-	// Enroll secrets... Either we pass a file, or we write a
-	// secret, and pass _that_ file
-	if opts.EnrollSecretPath != "" {
-		runtimeOptions = append(runtimeOptions, runtime.WithEnrollSecretPath(opts.EnrollSecretPath))
-	} else if opts.EnrollSecret != "" {
-		filename := filepath.Join(opts.RootDirectory, "secret")
-		os.WriteFile(filename, []byte(opts.EnrollSecret), 0400)
-		runtimeOptions = append(runtimeOptions, runtime.WithEnrollSecretPath(filename))
-	}
-
-	return runtimeOptions, nil
-}
-
-// grpcRunnerOptions returns the osquery runtime options when using launcher transports. (Eg: grpc or jsonrpc)
-func grpcRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options, ext *osquery.Extension) []runtime.OsqueryInstanceOption {
+// grpcRunnerOptions returns the osquery runtime options, which route osquery's config, logs, and
+// distributed queries through the launcher extension, and from there to the selected transport.
+func grpcRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options, flagsStore *flags.Flags, ext *osquery.Extension) []runtime.OsqueryInstanceOption {
 	return append(
-		commonRunnerOptions(logger, db, opts),
+		commonRunnerOptions(logger, db, opts, flagsStore),
 		runtime.WithConfigPluginFlag("kolide_grpc"),
 		runtime.WithLoggerPluginFlag("kolide_grpc"),
 		runtime.WithDistributedPluginFlag("kolide_grpc"),
@@ -276,6 +290,79 @@ func grpcRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options,
 			config.NewPlugin("kolide_grpc", ext.GenerateConfigs),
 			distributed.NewPlugin("kolide_grpc", ext.GetQueries, ext.WriteResults),
 			osquerylogger.NewPlugin("kolide_grpc", ext.LogString),
+			ktable.LauncherExtensionStats(ext),
 		),
+		runtime.WithCustomTables(customTableSpecs(logger, db, opts.CustomTablesPath)),
 	)
 }
+
+// secondaryRunnerOptions returns the osquery runtime options for an
+// additional osquery instance. It reads its own config file, runs no
+// distributed queries, and sends its logs through the launcher extension
+// tagged with its name.
+func secondaryRunnerOptions(logger log.Logger, db *bbolt.DB, opts *launcher.Options, flagsStore *flags.Flags, ext *osquery.Extension, instance launcher.OsqueryInstance) []runtime.OsqueryInstanceOption {
+	logger = log.With(logger, "osquery_instance", instance.Name)
+
+	noQueries := func(ctx context.Context) (*distributed.GetQueriesResult, error) {
+		return &distributed.GetQueriesResult{}, nil
+	}
+	discardResults := func(ctx context.Context, results []distributed.Result) error {
+		return nil
+	}
+
+	// These follow the common options, and so replace them
+	return append(
+		commonRunnerOptions(logger, db, opts, flagsStore),
+		runtime.WithInstanceName(instance.Name),
+		runtime.WithRootDirectory(instance.RootDirectory),
+		runtime.WithOsqueryFlags(append([]string{"config_path=" + instance.ConfigPath}, instance.Flags...)),
+		runtime.WithResourceLimits(runtime.ResourceLimits{
+			MaxCPUPercent: float64(instance.MaxCPUPercent),
+			MaxRSSBytes:   uint64(instance.MaxMemoryMB) << 20,
+		}),
+		runtime.WithConfigPluginFlag("filesystem"),
+		runtime.WithLoggerPluginFlag("kolide_grpc"),
+		runtime.WithDistributedPluginFlag("kolide_grpc"),
+		runtime.WithOsqueryExtensionPlugins(
+			distributed.NewPlugin("kolide_grpc", noQueries, discardResults),
+			osquerylogger.NewPlugin("kolide_grpc", ext.LogStringForInstance(instance.Name)),
+		),
+	)
+}
+
+// logSizeLimits returns the MaxBytesPerBatch and MaxBytesPerLog the
+// extension should use for the configured transport.
+func logSizeLimits(logger log.Logger, opts *launcher.Options) (maxBytesPerBatch, maxBytesPerLog int) {
+	// Setting MaxBytesPerBatch is a tradeoff. If it's too low, we
+	// can never send a large result. But if it's too high, we may
+	// not be able to send the data over a low bandwidth
+	// connection before the connection is timed out.
+	//
+	// The logic for setting this is spread out. The underlying
+	// extension defaults to 3mb, to support GRPC's hardcoded 4MB
+	// limit. But as we're transport aware here. we can set it to
+	// 5MB for others.
+	if opts.LogMaxBytesPerBatch != 0 {
+		if opts.Transport == "grpc" && opts.LogMaxBytesPerBatch > 3 {
+			level.Info(logger).Log(
+				"msg", "LogMaxBytesPerBatch is set above the grpc recommended maximum of 3. Expect errors",
+				"LogMaxBytesPerBatch", opts.LogMaxBytesPerBatch,
+			)
+		}
+		maxBytesPerBatch = opts.LogMaxBytesPerBatch << 20
+	} else if opts.Transport == "grpc" {
+		maxBytesPerBatch = 3 << 20
+	} else {
+		maxBytesPerBatch = 5 << 20
+	}
+
+	// The grpc, jsonrpc and http clients split logs that don't fit in a
+	// single request, so larger logs can be sent, rather than dropped.
+	// They're still capped, so a runaway query can't wedge the buffer.
+	switch opts.Transport {
+	case "grpc", "jsonrpc", "http":
+		maxBytesPerLog = 64 << 20
+	}
+
+	return maxBytesPerBatch, maxBytesPerLog
+}
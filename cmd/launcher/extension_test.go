@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/launcher"
+	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/kolide/launcher/pkg/service/mock"
+	"github.com/mixer/clock"
+	osquerylogger "github.com/osquery/osquery-go/plugin/logger"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestLogSizeLimits(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		opts     launcher.Options
+		perBatch int
+		perLog   int
+	}{
+		{opts: launcher.Options{Transport: "grpc"}, perBatch: 3 << 20, perLog: 64 << 20},
+		{opts: launcher.Options{Transport: "jsonrpc"}, perBatch: 5 << 20, perLog: 64 << 20},
+		{opts: launcher.Options{Transport: "http"}, perBatch: 5 << 20, perLog: 64 << 20},
+		{opts: launcher.Options{Transport: "osquery"}, perBatch: 5 << 20, perLog: 0},
+		{opts: launcher.Options{Transport: "grpc", LogMaxBytesPerBatch: 2}, perBatch: 2 << 20, perLog: 64 << 20},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.opts.Transport, func(t *testing.T) {
+			t.Parallel()
+
+			perBatch, perLog := logSizeLimits(log.NewNopLogger(), &tt.opts)
+			require.Equal(t, tt.perBatch, perBatch)
+			require.Equal(t, tt.perLog, perLog)
+		})
+	}
+}
+
+func TestExtensionSendsLogsLargerThanBatch(t *testing.T) {
+	t.Parallel()
+
+	file, err := ioutil.TempFile("", "kolide_launcher_test")
+	require.NoError(t, err)
+	file.Close()
+	defer os.Remove(file.Name())
+
+	db, err := bbolt.Open(file.Name(), 0600, nil)
+	require.NoError(t, err)
+	defer db.Close()
+
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	client := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType osquerylogger.LogType, logs []string) (string, string, bool, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			sent = append(sent, logs...)
+			return "", "", false, nil
+		},
+	}
+
+	extOpts := osquery.ExtensionOpts{
+		EnrollSecret:    "enroll_secret",
+		Logger:          log.NewNopLogger(),
+		LoggingInterval: time.Minute,
+		Clock:           clock.NewMockClock(),
+	}
+	extOpts.MaxBytesPerBatch, extOpts.MaxBytesPerLog = logSizeLimits(log.NewNopLogger(), &launcher.Options{Transport: "grpc"})
+
+	ext, err := osquery.NewExtension(client, db, extOpts)
+	require.NoError(t, err)
+
+	big := strings.Repeat("x", extOpts.MaxBytesPerBatch+1)
+	require.NoError(t, ext.LogString(context.Background(), osquerylogger.LogTypeString, big))
+
+	// The loop writes buffered logs as soon as it starts
+	ext.Start()
+	defer ext.Shutdown()
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(sent) == 1 && sent[0] == big
+	}, 5*time.Second, 10*time.Millisecond, "log larger than the batch size reaches the client")
+	require.Equal(t, uint64(0), ext.DroppedLogs())
+}
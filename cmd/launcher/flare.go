@@ -271,8 +271,8 @@ func reportGRPCNetwork(
 		serverURL,
 		insecureTLS,
 		insecureTransport,
-		certPins,
-		rootPool,
+		service.NewTLSSettings(certPins, rootPool),
+		nil,
 		logger,
 	)
 
@@ -12,6 +12,20 @@ type Updater struct {
 	mock.Mock
 }
 
+// Reevaluate provides a mock function with given fields:
+func (_m *Updater) Reevaluate() error {
+	ret := _m.Called()
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func() error); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Run provides a mock function with given fields: opts
 func (_m *Updater) Run(opts ...tuf.Option) (func(), error) {
 	_va := make([]interface{}, len(opts))
@@ -10,8 +10,11 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/kit/actor"
+	"github.com/kolide/launcher/pkg/agent/flags"
 	"github.com/kolide/launcher/pkg/autoupdate"
+	"github.com/kolide/launcher/pkg/peercache"
 	"github.com/kolide/updater/tuf"
+	"go.etcd.io/bbolt"
 )
 
 // UpdaterConfig is a struct of update related options. It's used to
@@ -27,6 +30,13 @@ type UpdaterConfig struct {
 	NotaryPrefix       string
 	HTTPClient         *http.Client
 	SigChannel         chan os.Signal
+	Flags              *flags.Flags // optional, runtime overrides of AutoupdateInterval and the policy
+	DB                 *bbolt.DB    // optional, where update decisions are recorded
+	Policy             func() autoupdate.Policy
+	RolloutID          string           // stable host identifier, for staged rollouts
+	TUFRepo            string           // optional, a TUF repo to update from instead of Notary
+	TUFRoot            []byte           // trusted root metadata for TUFRepo
+	PeerCache          *peercache.Cache // optional, shares TUFRepo downloads with peers
 }
 
 // NewUpdater returns an Actor suitable for an oklog/run group. It
@@ -45,6 +55,15 @@ func NewUpdater(
 
 	config.Logger = log.With(config.Logger, "updater", filepath.Base(binaryPath))
 
+	updateCmd := &updaterCmd{
+		ctx:                     ctx,
+		stopChan:                make(chan bool),
+		config:                  config,
+		runUpdaterRetryInterval: 30 * time.Minute,
+		intervalChanged:         make(chan struct{}, 1),
+		policyChanged:           make(chan struct{}, 1),
+	}
+
 	// create the updater
 	updater, err := autoupdate.NewUpdater(
 		binaryPath,
@@ -57,17 +76,25 @@ func NewUpdater(
 		autoupdate.WithFinalizer(finalizer),
 		autoupdate.WithUpdateChannel(config.UpdateChannel),
 		autoupdate.WithSigChannel(config.SigChannel),
+		autoupdate.WithDB(config.DB),
+		autoupdate.WithPolicy(config.Policy),
+		autoupdate.WithRolloutID(config.RolloutID),
+		autoupdate.WithTUFRepo(config.TUFRepo, config.TUFRoot),
+		autoupdate.WithCheckInterval(updateCmd.autoupdateInterval),
+		autoupdate.WithPeerCache(config.PeerCache),
 	)
 	if err != nil {
 		return nil, err
 	}
-
-	updateCmd := &updaterCmd{
-		updater:                 updater,
-		ctx:                     ctx,
-		stopChan:                make(chan bool),
-		config:                  config,
-		runUpdaterRetryInterval: 30 * time.Minute,
+	updateCmd.updater = updater
+
+	if config.Flags != nil {
+		config.Flags.RegisterChangeObserver(updateCmd,
+			flags.AutoupdateInterval,
+			flags.AutoupdateLauncherVersion,
+			flags.AutoupdateOsquerydVersion,
+			flags.AutoupdateRolloutPercent,
+		)
 	}
 
 	return &actor.Actor{
@@ -79,6 +106,7 @@ func NewUpdater(
 // updater allows us to mock *autoupdate.Updater during testing
 type updater interface {
 	Run(opts ...tuf.Option) (stop func(), err error)
+	Reevaluate() error
 }
 
 type updaterCmd struct {
@@ -88,6 +116,39 @@ type updaterCmd struct {
 	stopExecution           func()
 	config                  *UpdaterConfig
 	runUpdaterRetryInterval time.Duration
+	intervalChanged         chan struct{}
+	policyChanged           chan struct{}
+}
+
+// FlagsChanged is called when the autoupdate interval or policy is
+// changed at runtime. The updater is restarted with a new interval,
+// which also reevaluates the downloaded updates. A new policy only
+// needs the reevaluation.
+func (u *updaterCmd) FlagsChanged(keys ...flags.FlagKey) {
+	changed := u.intervalChanged
+	if len(keys) > 0 {
+		changed = u.policyChanged
+		for _, key := range keys {
+			if key == flags.AutoupdateInterval {
+				changed = u.intervalChanged
+			}
+		}
+	}
+
+	// non-blocking channel send, a pending change already covers this one
+	select {
+	case changed <- struct{}{}:
+	default:
+	}
+}
+
+// autoupdateInterval returns the current autoupdate interval, preferring
+// any runtime override.
+func (u *updaterCmd) autoupdateInterval() time.Duration {
+	if u.config.Flags != nil {
+		return u.config.Flags.AutoupdateInterval()
+	}
+	return u.config.AutoupdateInterval
 }
 
 func (u *updaterCmd) execute() error {
@@ -107,6 +168,45 @@ func (u *updaterCmd) execute() error {
 		break
 	}
 
+	for {
+		if stopped := u.runUpdater(); stopped {
+			return nil
+		}
+
+		if done := u.waitForChanges(); done {
+			return nil
+		}
+	}
+}
+
+// waitForChanges waits for the autoupdate interval to change, when the
+// running updater is stopped so it can be restarted, reevaluating the
+// updates whenever the policy changes in the meantime. It returns true
+// if launcher is shutting down.
+func (u *updaterCmd) waitForChanges() bool {
+	level.Debug(u.config.Logger).Log("msg", "updater waiting ... just sitting until done signal")
+	for {
+		select {
+		case <-u.ctx.Done():
+			return true
+		case <-u.intervalChanged:
+			level.Info(u.config.Logger).Log("msg", "autoupdate interval changed, restarting updater", "interval", u.autoupdateInterval())
+			if u.stopExecution != nil {
+				u.stopExecution()
+			}
+			return false
+		case <-u.policyChanged:
+			level.Info(u.config.Logger).Log("msg", "autoupdate policy changed, reevaluating updates")
+			if err := u.updater.Reevaluate(); err != nil {
+				level.Error(u.config.Logger).Log("msg", "error reevaluating updates", "err", err)
+			}
+		}
+	}
+}
+
+// runUpdater starts the updater, retrying until it succeeds. It returns
+// true if a stop was requested before the updater could start.
+func (u *updaterCmd) runUpdater() bool {
 	// Failing to start the updater is not a fatal launcher
 	// error. If there's a problem, sleep and try
 	// again. Implementing this is a bit gnarly. In the event of a
@@ -117,10 +217,10 @@ func (u *updaterCmd) execute() error {
 		level.Debug(u.config.Logger).Log("msg", "updater starting")
 
 		// run the updater and set the stop function so that the interrupt has access to it
-		stop, err := u.updater.Run(tuf.WithFrequency(u.config.AutoupdateInterval), tuf.WithLogger(u.config.Logger))
+		stop, err := u.updater.Run(tuf.WithFrequency(u.autoupdateInterval()), tuf.WithLogger(u.config.Logger))
 		u.stopExecution = stop
 		if err == nil {
-			break
+			return false
 		}
 
 		// err != nil, log it and loop again
@@ -128,16 +228,11 @@ func (u *updaterCmd) execute() error {
 		select {
 		case <-u.stopChan:
 			level.Debug(u.config.Logger).Log("msg", "updater stop requested, Breaking loop")
-			return nil
+			return true
 		case <-time.After(u.runUpdaterRetryInterval):
 			break
 		}
 	}
-
-	level.Debug(u.config.Logger).Log("msg", "updater waiting ... just sitting until done signal")
-	<-u.ctx.Done()
-
-	return nil
 }
 
 func (u *updaterCmd) interrupt(err error) {
@@ -9,6 +9,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/kolide/launcher/cmd/launcher/internal/updater/mocks"
+	"github.com/kolide/launcher/pkg/agent/flags"
 	"github.com/kolide/updater/tuf"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -224,3 +225,79 @@ func Test_updaterCmd_interrupt(t *testing.T) {
 		})
 	}
 }
+
+func Test_updaterCmd_intervalChanged(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	updater := &mocks.Updater{}
+	u := &updaterCmd{
+		updater:         updater,
+		ctx:             ctx,
+		stopChan:        make(chan bool),
+		config:          &UpdaterConfig{Logger: log.NewNopLogger()},
+		intervalChanged: make(chan struct{}, 1),
+	}
+
+	runCalled := make(chan struct{}, 2)
+	stopCalled := make(chan struct{}, 2)
+	stop := func() { stopCalled <- struct{}{} }
+	updater.On("Run", mock.AnythingOfType("tuf.Option"), mock.AnythingOfType("tuf.Option")).
+		Run(func(mock.Arguments) { runCalled <- struct{}{} }).
+		Return(stop, nil).Twice()
+
+	executeDone := make(chan error)
+	go func() { executeDone <- u.execute() }()
+
+	<-runCalled
+	u.FlagsChanged()
+
+	// The running updater is stopped, and a new one started
+	<-stopCalled
+	<-runCalled
+
+	cancelCtx()
+	assert.NoError(t, <-executeDone)
+	updater.AssertExpectations(t)
+}
+
+func Test_updaterCmd_policyChanged(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	defer cancelCtx()
+
+	updater := &mocks.Updater{}
+	u := &updaterCmd{
+		updater:         updater,
+		ctx:             ctx,
+		stopChan:        make(chan bool),
+		config:          &UpdaterConfig{Logger: log.NewNopLogger()},
+		intervalChanged: make(chan struct{}, 1),
+		policyChanged:   make(chan struct{}, 1),
+	}
+
+	runCalled := make(chan struct{}, 1)
+	reevaluateCalled := make(chan struct{}, 1)
+	updater.On("Run", mock.AnythingOfType("tuf.Option"), mock.AnythingOfType("tuf.Option")).
+		Run(func(mock.Arguments) { runCalled <- struct{}{} }).
+		Return(func() {}, nil).Once()
+	updater.On("Reevaluate").
+		Run(func(mock.Arguments) { reevaluateCalled <- struct{}{} }).
+		Return(nil).Once()
+
+	executeDone := make(chan error)
+	go func() { executeDone <- u.execute() }()
+
+	<-runCalled
+	u.FlagsChanged(flags.AutoupdateRolloutPercent)
+
+	// The updates are reevaluated, without restarting the updater
+	<-reevaluateCalled
+
+	cancelCtx()
+	assert.NoError(t, <-executeDone)
+	updater.AssertExpectations(t)
+}
@@ -24,12 +24,20 @@ import (
 	"github.com/kolide/launcher/cmd/launcher/internal/updater"
 	desktopRuntime "github.com/kolide/launcher/ee/desktop/runtime"
 	"github.com/kolide/launcher/ee/localserver"
+	"github.com/kolide/launcher/pkg/agent/flags"
+	"github.com/kolide/launcher/pkg/autoupdate"
+	"github.com/kolide/launcher/pkg/clientcert"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/kolide/launcher/pkg/debug"
+	"github.com/kolide/launcher/pkg/execwrapper"
 	"github.com/kolide/launcher/pkg/launcher"
 	"github.com/kolide/launcher/pkg/log/checkpoint"
+	"github.com/kolide/launcher/pkg/log/levellogger"
 	"github.com/kolide/launcher/pkg/osquery"
 	osqueryInstanceHistory "github.com/kolide/launcher/pkg/osquery/runtime/history"
+	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
+	"github.com/kolide/launcher/pkg/peercache"
+	"github.com/kolide/launcher/pkg/proxy"
 	"github.com/kolide/launcher/pkg/service"
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
@@ -43,6 +51,8 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 	logger := log.With(ctxlog.FromContext(ctx), "caller", log.DefaultCaller)
 	level.Debug(logger).Log("msg", "runLauncher starting")
 
+	recordLauncherStart(ctx, logger)
+
 	// determine the root directory, create one if it's not provided
 	rootDirectory := opts.RootDirectory
 	if rootDirectory == "" {
@@ -66,21 +76,29 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 		return errors.Wrap(err, "detecting platform")
 	}
 
+	if opts.TableExecSandbox {
+		sandbox := tablehelpers.DefaultSandbox(opts.TableExecUser)
+		tablehelpers.SetSandbox(&sandbox)
+	}
+
 	debugAddrPath := filepath.Join(rootDirectory, "debug_addr")
 	debug.AttachDebugHandler(debugAddrPath, logger)
 	defer os.Remove(debugAddrPath)
 
+	// Every outbound connection goes through the configured proxy, so
+	// this comes before anything dials out.
+	if err := proxy.Configure(opts.ProxyURL, opts.ProxyPAC, logger); err != nil {
+		return errors.Wrap(err, "configuring proxy")
+	}
+
 	// construct the appropriate http client based on security settings
-	httpClient := http.DefaultClient
+	transport := proxy.NewTransport()
 	if opts.InsecureTLS {
-		httpClient = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{
-					InsecureSkipVerify: true,
-				},
-			},
+		transport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true,
 		}
 	}
+	httpClient := &http.Client{Transport: transport}
 
 	// open the database for storing launcher data, we do it here
 	// because it's passed to multiple actors. Add a timeout to
@@ -106,18 +124,72 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 	// Try to ensure useful info in the logs
 	checkpoint.Run(logger, db, *opts)
 
-	// create the certificate pool
+	// The flags store layers server provided overrides on top of opts
+	flagsStore, err := flags.NewFlags(db, opts, logger)
+	if err != nil {
+		return errors.Wrap(err, "creating agent flags store")
+	}
+
+	if levelLogger := levellogger.FromContext(ctx); levelLogger != nil {
+		levelLogger.SetDebug(flagsStore.Debug())
+		flagsStore.RegisterChangeObserver(flags.ObserverFunc(func(...flags.FlagKey) {
+			level.Info(logger).Log("msg", "debug logging changed", "debug", flagsStore.Debug())
+			levelLogger.SetDebug(flagsStore.Debug())
+		}), flags.Debug)
+	}
+
+	// The reloader watches the root PEM, cert pins and enroll secret
+	// files, so they can be rotated without a restart.
+	fileReloader := newReloader(logger, defaultReloadInterval)
+	var tlsSettings *service.TLSSettings
 	var rootPool *x509.CertPool
+	certPins := opts.CertPins
+
+	// create the certificate pool
 	if opts.RootPEM != "" {
-		rootPool = x509.NewCertPool()
-		pemContents, err := ioutil.ReadFile(opts.RootPEM)
+		pemContents, err := fileReloader.watch(opts.RootPEM, func(contents []byte) error {
+			pool := x509.NewCertPool()
+			if ok := pool.AppendCertsFromPEM(contents); !ok {
+				return errors.Errorf("found no valid certs in PEM at path: %s", opts.RootPEM)
+			}
+			level.Info(logger).Log("msg", "root certificates changed", "path", opts.RootPEM, "certs", countCerts(contents))
+			rootPool = pool
+			tlsSettings.Update(certPins, rootPool)
+			return nil
+		})
 		if err != nil {
 			return errors.Wrapf(err, "reading root certs PEM at path: %s", opts.RootPEM)
 		}
+		rootPool = x509.NewCertPool()
 		if ok := rootPool.AppendCertsFromPEM(pemContents); !ok {
 			return errors.Errorf("found no valid certs in PEM at path: %s", opts.RootPEM)
 		}
 	}
+
+	if opts.CertPinsPath != "" {
+		pinsContents, err := fileReloader.watch(opts.CertPinsPath, func(contents []byte) error {
+			pins, err := parseCertPinsFile(contents)
+			if err != nil {
+				return err
+			}
+			added, removed := diffCertPins(certPins, pins)
+			level.Info(logger).Log("msg", "cert pins changed", "path", opts.CertPinsPath, "added", strings.Join(added, ","), "removed", strings.Join(removed, ","))
+			certPins = pins
+			tlsSettings.Update(certPins, rootPool)
+			return nil
+		})
+		if err != nil {
+			return errors.Wrapf(err, "reading cert pins at path: %s", opts.CertPinsPath)
+		}
+		if certPins, err = parseCertPinsFile(pinsContents); err != nil {
+			return errors.Wrapf(err, "parsing cert pins at path: %s", opts.CertPinsPath)
+		}
+	}
+
+	// The transports share tlsSettings, so changes to the root PEM and
+	// cert pins reach every connection.
+	tlsSettings = service.NewTLSSettings(certPins, rootPool)
+
 	// create a rungroup for all the actors we create to allow for easy start/stop
 	var runGroup run.Group
 
@@ -140,11 +212,38 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 		close(sigChannel)
 	})
 
+	// The client certificate for mutual TLS, if one is used
+	var clientCert service.ClientCertificateFunc
+	switch {
+	case opts.ClientCertPath != "":
+		certSource, err := clientcert.NewFileSource(opts.ClientCertPath, opts.ClientKeyPath, logger)
+		if err != nil {
+			return errors.Wrap(err, "loading client certificate")
+		}
+		clientCert = certSource.GetClientCertificate
+	case opts.GenerateClientCert:
+		if err := osquery.SetupLauncherKeys(db); err != nil {
+			return errors.Wrap(err, "setting up launcher keys")
+		}
+		signer := service.NewCSRSigner(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, tlsSettings, logger)
+		// Connections made before a certificate is obtained or renewed
+		// are dropped, so the next request presents the new one.
+		certManager, err := clientcert.NewManager(db, signer,
+			clientcert.WithLogger(logger),
+			clientcert.WithUpdateHook(tlsSettings.Reconnect),
+		)
+		if err != nil {
+			return errors.Wrap(err, "creating client certificate manager")
+		}
+		runGroup.Add(certManager.Execute, certManager.Interrupt)
+		clientCert = certManager.GetClientCertificate
+	}
+
 	var client service.KolideService
 	{
 		switch opts.Transport {
 		case "grpc":
-			grpcConn, err := service.DialGRPC(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, opts.CertPins, rootPool, logger)
+			grpcConn, err := service.DialGRPC(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, tlsSettings, clientCert, logger)
 			if err != nil {
 				return errors.Wrap(err, "dialing grpc server")
 			}
@@ -153,9 +252,17 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 			queryTargeter := createQueryTargetUpdater(logger, db, grpcConn)
 			runGroup.Add(queryTargeter.Execute, queryTargeter.Interrupt)
 		case "jsonrpc":
-			client = service.NewJSONRPCClient(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, opts.CertPins, rootPool, logger)
+			client = service.NewJSONRPCClient(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, tlsSettings, clientCert, logger)
+		case "http":
+			client = service.NewHTTPClient(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, tlsSettings, clientCert, logger)
 		case "osquery":
-			client = service.NewNoopClient(logger)
+			client = service.NewOsqueryTLSClient(opts.KolideServerURL, opts.InsecureTLS, opts.InsecureTransport, tlsSettings, clientCert, service.OsqueryTLSEndpoints{
+				Enroll:           opts.OsqueryTlsEnrollEndpoint,
+				Config:           opts.OsqueryTlsConfigEndpoint,
+				Logger:           opts.OsqueryTlsLoggerEndpoint,
+				DistributedRead:  opts.OsqueryTlsDistributedReadEndpoint,
+				DistributedWrite: opts.OsqueryTlsDistributedWriteEndpoint,
+			}, logger)
 		default:
 			return errors.New("invalid transport option selected")
 		}
@@ -167,11 +274,12 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 	}
 
 	// create the osquery extension for launcher. This is where osquery itself is launched.
-	extension, runnerRestart, runnerShutdown, err := createExtensionRuntime(ctx, db, client, opts)
+	extension, runnerRestart, runnerShutdown, err := createExtensionRuntime(ctx, db, client, opts, flagsStore, fileReloader)
 	if err != nil {
 		return errors.Wrap(err, "create extension with runtime")
 	}
 	runGroup.Add(extension.Execute, extension.Interrupt)
+	runGroup.Add(fileReloader.Execute, fileReloader.Interrupt)
 
 	versionInfo := version.Version()
 	level.Info(logger).Log(
@@ -182,7 +290,7 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 
 	// If the control server has been opted-in to, run it
 	if opts.Control {
-		control, err := createControl(ctx, db, logger, opts)
+		control, err := createControl(ctx, db, logger, opts, flagsStore)
 		if err != nil {
 			return errors.Wrap(err, "create control actor")
 		}
@@ -215,17 +323,69 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 
 	// If the autoupdater is enabled, enable it for both osquery and launcher
 	if opts.Autoupdate {
+		// Staged rollouts are keyed on the launcher identifier, which
+		// is stable for the host.
+		rolloutID, err := osquery.IdentifierFromDB(db)
+		if err != nil {
+			return errors.Wrap(err, "getting autoupdate rollout id")
+		}
+
+		var tufRoot []byte
+		if opts.AutoupdateTUFRepo != "" {
+			tufRoot, err = ioutil.ReadFile(opts.AutoupdateTUFRoot)
+			if err != nil {
+				return errors.Wrap(err, "reading autoupdate TUF root")
+			}
+		}
+
+		// Both updaters share a cache of their downloads with peers
+		var peerCache *peercache.Cache
+		if opts.AutoupdatePeerCacheAddress != "" || opts.AutoupdatePeerDiscovery || len(opts.AutoupdatePeers) > 0 {
+			cacheOpts := []peercache.Option{
+				peercache.WithLogger(logger),
+				peercache.WithPeers(opts.AutoupdatePeers...),
+			}
+			if opts.AutoupdatePeerDiscovery {
+				cacheOpts = append(cacheOpts, peercache.WithDiscovery())
+			}
+
+			peerCache, err = peercache.New(filepath.Join(rootDirectory, "update-cache"), cacheOpts...)
+			if err != nil {
+				return errors.Wrap(err, "creating autoupdate peer cache")
+			}
+
+			if opts.AutoupdatePeerCacheAddress != "" {
+				peerCacheServer, err := peercache.NewServer(peerCache, opts.AutoupdatePeerCacheAddress, opts.AutoupdatePeerDiscovery)
+				if err != nil {
+					return errors.Wrap(err, "creating autoupdate peer cache server")
+				}
+				runGroup.Add(peerCacheServer.Execute, peerCacheServer.Interrupt)
+			}
+		}
+
 		osqueryUpdaterconfig := &updater.UpdaterConfig{
 			Logger:             logger,
 			RootDirectory:      rootDirectory,
-			AutoupdateInterval: opts.AutoupdateInterval,
+			AutoupdateInterval: flagsStore.AutoupdateInterval(),
 			UpdateChannel:      opts.UpdateChannel,
 			NotaryURL:          opts.NotaryServerURL,
 			MirrorURL:          opts.MirrorServerURL,
 			NotaryPrefix:       opts.NotaryPrefix,
 			HTTPClient:         httpClient,
-			InitialDelay:       opts.AutoupdateInitialDelay + opts.AutoupdateInterval/2,
+			InitialDelay:       opts.AutoupdateInitialDelay + flagsStore.AutoupdateInterval()/2,
 			SigChannel:         sigChannel,
+			Flags:              flagsStore,
+			DB:                 db,
+			RolloutID:          rolloutID,
+			TUFRepo:            opts.AutoupdateTUFRepo,
+			TUFRoot:            tufRoot,
+			PeerCache:          peerCache,
+			Policy: func() autoupdate.Policy {
+				return autoupdate.Policy{
+					PinnedVersion:  flagsStore.AutoupdateOsquerydVersion(),
+					RolloutPercent: flagsStore.AutoupdateRolloutPercent(),
+				}
+			},
 		}
 
 		// create an updater for osquery
@@ -238,7 +398,7 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 		launcherUpdaterconfig := &updater.UpdaterConfig{
 			Logger:             logger,
 			RootDirectory:      rootDirectory,
-			AutoupdateInterval: opts.AutoupdateInterval,
+			AutoupdateInterval: flagsStore.AutoupdateInterval(),
 			UpdateChannel:      opts.UpdateChannel,
 			NotaryURL:          opts.NotaryServerURL,
 			MirrorURL:          opts.MirrorServerURL,
@@ -246,6 +406,18 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 			HTTPClient:         httpClient,
 			InitialDelay:       opts.AutoupdateInitialDelay,
 			SigChannel:         sigChannel,
+			Flags:              flagsStore,
+			DB:                 db,
+			RolloutID:          rolloutID,
+			TUFRepo:            opts.AutoupdateTUFRepo,
+			TUFRoot:            tufRoot,
+			PeerCache:          peerCache,
+			Policy: func() autoupdate.Policy {
+				return autoupdate.Policy{
+					PinnedVersion:  flagsStore.AutoupdateLauncherVersion(),
+					RolloutPercent: flagsStore.AutoupdateRolloutPercent(),
+				}
+			},
 		}
 
 		// create an updater for launcher
@@ -275,6 +447,52 @@ func runLauncher(ctx context.Context, cancel func(), opts *launcher.Options) err
 	return errors.Wrap(err, "run service")
 }
 
+// recordLauncherStart records this start of launcher, if it's running
+// an update. If the update has started too many times without running
+// for long, it's crash looping, so roll back by exec'ing whatever
+// FindNewestSelf now returns. Otherwise, the update is marked healthy
+// once it's run for long enough.
+func recordLauncherStart(ctx context.Context, logger log.Logger) {
+	launcherPath, err := os.Executable()
+	if err != nil {
+		level.Info(logger).Log("msg", "finding launcher executable", "err", err)
+		return
+	}
+
+	ok, err := autoupdate.RecordStart(launcherPath)
+	if err != nil {
+		level.Info(logger).Log("msg", "recording launcher start", "err", err)
+	}
+
+	if !ok {
+		previousBinary, err := autoupdate.FindNewestSelf(ctx)
+		if err != nil || previousBinary == "" {
+			level.Error(logger).Log("msg", "no binary to roll back to", "binary", launcherPath, "err", err)
+			return
+		}
+
+		level.Info(logger).Log(
+			"msg", "launcher update is crash looping, rolling back",
+			"binary", launcherPath,
+			"previousBinary", previousBinary,
+		)
+		if err := execwrapper.Exec(ctx, previousBinary, os.Args, os.Environ()); err != nil {
+			logutil.Fatal(logger, err, "exec")
+		}
+		return
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-time.After(autoupdate.HealthyAfter):
+			if err := autoupdate.MarkHealthy(launcherPath); err != nil {
+				level.Info(logger).Log("msg", "marking launcher update healthy", "err", err)
+			}
+		}
+	}()
+}
+
 func writePidFile(path string) error {
 	err := ioutil.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0600)
 	return errors.Wrap(err, "writing pidfile")
@@ -18,6 +18,7 @@ import (
 	"github.com/kolide/launcher/pkg/autoupdate"
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/kolide/launcher/pkg/execwrapper"
+	"github.com/kolide/launcher/pkg/log/levellogger"
 	"github.com/kolide/launcher/pkg/log/locallogger"
 	"github.com/kolide/launcher/pkg/log/teelogger"
 	"github.com/pkg/errors"
@@ -89,8 +90,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	// recreate the logger with  the appropriate level.
-	logger = logutil.NewServerLogger(opts.Debug)
+	// recreate the logger with  the appropriate level. The server may
+	// change it later, through the agent flags.
+	levelLogger := levellogger.New(logutil.NewServerLogger(false), logutil.NewServerLogger(true), opts.Debug)
+	logger = levelLogger
 
 	// Create a local logger. This logs to a known path, and aims to help diagnostics
 	if opts.RootDirectory != "" {
@@ -108,6 +111,7 @@ func main() {
 	}()
 
 	ctx = ctxlog.NewContext(ctx, logger)
+	ctx = levellogger.NewContext(ctx, levelLogger)
 
 	if err := runLauncher(ctx, cancel, opts); err != nil {
 		level.Debug(logger).Log(err, "run launcher", "stack", fmt.Sprintf("%+v", err))
@@ -2,11 +2,14 @@ package main
 
 import (
 	"encoding/hex"
+	"encoding/json"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
 	"strings"
 	"time"
@@ -45,25 +48,44 @@ func parseOptions(args []string) (*launcher.Options, error) {
 
 	var (
 		// Primary options
-		flAutoloadedExtensions arrayFlags
-		flCertPins             = flagset.String("cert_pins", "", "Comma separated, hex encoded SHA256 hashes of pinned subject public key info")
-		flControl              = flagset.Bool("control", false, "Whether or not the control server is enabled (default: false)")
-		flControlServerURL     = flagset.String("control_hostname", "", "The hostname of the control server")
-		flEnrollSecret         = flagset.String("enroll_secret", "", "The enroll secret that is used in your environment")
-		flEnrollSecretPath     = flagset.String("enroll_secret_path", "", "Optionally, the path to your enrollment secret")
-		flInitialRunner        = flagset.Bool("with_initial_runner", false, "Run differential queries from config ahead of scheduled interval.")
-		flKolideServerURL      = flagset.String("hostname", "", "The hostname of the gRPC server")
-		flKolideHosted         = flagset.Bool("kolide_hosted", false, "Use Kolide SaaS settings for defaults")
-		flTransport            = flagset.String("transport", "grpc", "The transport protocol that should be used to communicate with remote (default: grpc)")
-		flLoggingInterval      = flagset.Duration("logging_interval", 60*time.Second, "The interval at which logs should be flushed to the server")
-		flOsquerydPath         = flagset.String("osqueryd_path", "", "Path to the osqueryd binary to use (Default: find osqueryd in $PATH)")
-		flRootDirectory        = flagset.String("root_directory", "", "The location of the local database, pidfiles, etc.")
-		flRootPEM              = flagset.String("root_pem", "", "Path to PEM file including root certificates to verify against")
-		flVersion              = flagset.Bool("version", false, "Print Launcher version and exit")
-		flLogMaxBytesPerBatch  = flagset.Int("log_max_bytes_per_batch", 0, "Maximum size of a batch of logs. Recommend leaving unset, and launcher will determine")
-		flOsqueryFlags         arrayFlags // set below with flagset.Var
-		flCompactDbMaxTx       = flagset.Int64("compactdb-max-tx", 65536, "Maximum transaction size used when compacting the internal DB")
-		_                      = flagset.String("config", "", "config file to parse options from (optional)")
+		flAutoloadedExtensions   arrayFlags
+		flAutoupdatePeers        arrayFlags
+		flLogSinkWebhookHeaders  arrayFlags
+		flCertPins               = flagset.String("cert_pins", "", "Comma separated, hex encoded SHA256 hashes of pinned subject public key info")
+		flCertPinsPath           = flagset.String("cert_pins_path", "", "Optionally, the path to a file of cert pins, separated by commas or newlines")
+		flControl                = flagset.Bool("control", false, "Whether or not the control server is enabled (default: false)")
+		flControlServerURL       = flagset.String("control_hostname", "", "The hostname of the control server")
+		flControlRequestInterval = flagset.Duration("control_request_interval", 60*time.Second, "The interval at which the control server requests will be made")
+		flControlServerKey       = flagset.String("control_server_key", "", "Path to the PEM encoded public key which signs control server responses")
+		flEnrollSecret           = flagset.String("enroll_secret", "", "The enroll secret that is used in your environment")
+		flEnrollSecretPath       = flagset.String("enroll_secret_path", "", "Optionally, the path to your enrollment secret")
+		flInitialRunner          = flagset.Bool("with_initial_runner", false, "Run differential queries from config ahead of scheduled interval.")
+		flKolideServerURL        = flagset.String("hostname", "", "The hostname of the gRPC server")
+		flKolideHosted           = flagset.Bool("kolide_hosted", false, "Use Kolide SaaS settings for defaults")
+		flTransport              = flagset.String("transport", "grpc", "The transport protocol that should be used to communicate with remote: grpc, jsonrpc, http, or osquery (default: grpc)")
+		flLoggingInterval        = flagset.Duration("logging_interval", 60*time.Second, "The interval at which logs should be flushed to the server")
+		flOsquerydPath           = flagset.String("osqueryd_path", "", "Path to the osqueryd binary to use (Default: find osqueryd in $PATH)")
+		flRootDirectory          = flagset.String("root_directory", "", "The location of the local database, pidfiles, etc.")
+		flRootPEM                = flagset.String("root_pem", "", "Path to PEM file including root certificates to verify against")
+		flClientCert             = flagset.String("client_cert", "", "Path to a PEM client certificate to authenticate to the server with (mutual TLS)")
+		flClientKey              = flagset.String("client_key", "", "Path to the PEM private key for --client_cert")
+		flGenerateClientCert     = flagset.Bool("generate_client_cert", false, "Have the server sign a client certificate for the launcher key, and use it for mutual TLS (default: false)")
+		flVersion                = flagset.Bool("version", false, "Print Launcher version and exit")
+		flLogMaxBytesPerBatch    = flagset.Int("log_max_bytes_per_batch", 0, "Maximum size of a batch of logs. Recommend leaving unset, and launcher will determine")
+		flOsqueryFlags           arrayFlags // set below with flagset.Var
+		flOsqueryMaxCPUPercent   = flagset.Int("osquery_max_cpu_percent", 0, "Restart osqueryd when it stays over this CPU use, as a percentage of one core (default: no limit)")
+		flOsqueryMaxMemoryMB     = flagset.Int("osquery_max_memory_mb", 0, "Restart osqueryd when it stays over this resident memory, in MB (default: no limit)")
+		flOsqueryInstances       = flagset.String("osquery_instances", "", "Optionally, the path to a JSON file of additional osqueryd instances to run")
+		flCustomTables           = flagset.String("custom_tables", "", "Optionally, the path to a JSON file of custom tables to declare")
+		flTableExecSandbox       = flagset.Bool("table_exec_sandbox", false, "Run the commands tables exec with resource limits and a cleared environment. Linux only (default: false)")
+		flTableExecUser          = flagset.String("table_exec_user", "", "With --table_exec_sandbox, the user to run commands as when a table doesn't need root (default: launcher's user)")
+		flTableExecCacheTTL      = flagset.Duration("table_exec_cache_ttl", 30*time.Second, "How long tables cache the output of the commands they exec. 0 disables caching")
+		flCompactDbMaxTx         = flagset.Int64("compactdb-max-tx", 65536, "Maximum transaction size used when compacting the internal DB")
+		flLogSinkFile            = flagset.String("log_sink_file", "", "Optionally, a path to also write osquery result logs to, as rotated JSONL")
+		flLogSinkSyslog          = flagset.String("log_sink_syslog", "", "Optionally, also send osquery result logs to syslog. Either 'local', or a URL like udp://host:514")
+		flLogSinkWebhook         = flagset.String("log_sink_webhook", "", "Optionally, a URL to also POST osquery result logs to")
+		flDisableServerLogs      = flagset.Bool("disable_server_logs", false, "Do not send osquery logs to the server, only to the configured log sinks (default: false)")
+		_                        = flagset.String("config", "", "config file to parse options from (optional)")
 
 		// osquery TLS endpoints
 		flOsqTlsConfig    = flagset.String("config_tls_endpoint", "", "Config endpoint for the osquery tls transport")
@@ -80,6 +102,13 @@ func parseOptions(args []string) (*launcher.Options, error) {
 		flUpdateChannel          = flagset.String("update_channel", "stable", "The channel to pull updates from (options: stable, beta, nightly)")
 		flNotaryPrefix           = flagset.String("notary_prefix", autoupdate.DefaultNotaryPrefix, "The prefix for Notary path that contains the collections (default: kolide/)")
 		flAutoupdateInitialDelay = flagset.Duration("autoupdater_initial_delay", 1*time.Hour, "Initial autoupdater subprocess delay")
+		flLauncherVersion        = flagset.String("autoupdate_launcher_version", "", "Pin launcher to this version, holding updates to any other")
+		flOsquerydVersion        = flagset.String("autoupdate_osqueryd_version", "", "Pin osqueryd to this version, holding updates to any other")
+		flRolloutPercent         = flagset.Int("autoupdate_rollout_percent", 100, "The percentage of hosts which use new versions (default: 100)")
+		flTUFRepo                = flagset.String("autoupdate_tuf_repo", "", "A TUF repository, as an http(s) URL or a local path, to update from instead of Notary")
+		flTUFRoot                = flagset.String("autoupdate_tuf_root", "", "Path to the trusted root.json for autoupdate_tuf_repo")
+		flPeerCacheAddress       = flagset.String("autoupdate_peer_cache_address", "", "Address to serve downloaded updates to peers on, eg :8383 (default: disabled)")
+		flPeerDiscovery          = flagset.Bool("autoupdate_peer_discovery", false, "Find peers, and advertise the peer cache, by mDNS (default: false)")
 
 		// Development & Debugging options
 		flDebug             = flagset.Bool("debug", false, "Whether or not debug logging is enabled (default: false)")
@@ -88,6 +117,8 @@ func parseOptions(args []string) (*launcher.Options, error) {
 		flDisableControlTLS = flagset.Bool("disable_control_tls", false, "Disable TLS encryption for the control features")
 		flInsecureTransport = flagset.Bool("insecure_transport", false, "Do not use TLS for transport layer (default: false)")
 		flInsecureTLS       = flagset.Bool("insecure", false, "Do not verify TLS certs for outgoing connections (default: false)")
+		flProxyURL          = flagset.String("proxy", "", "Proxy for all outgoing connections, as http://host:port or socks5://host:port (default: from HTTP_PROXY/HTTPS_PROXY)")
+		flProxyPAC          = flagset.String("proxy_pac", "", "Path or URL of a PAC file choosing the proxy for outgoing connections")
 
 		// deprecated options, kept for any kind of config file compatibility
 		_ = flagset.String("debug_log_file", "", "DEPRECATED")
@@ -95,6 +126,8 @@ func parseOptions(args []string) (*launcher.Options, error) {
 
 	flagset.Var(&flOsqueryFlags, "osquery_flag", "Flags to pass to osquery (possibly overriding Launcher defaults)")
 	flagset.Var(&flAutoloadedExtensions, "autoloaded_extension", "extension paths to autoload, filename without path may be used in same directory as launcher")
+	flagset.Var(&flAutoupdatePeers, "autoupdate_peer", "A peer to download updates from, as host:port (may be repeated)")
+	flagset.Var(&flLogSinkWebhookHeaders, "log_sink_webhook_header", "A header to send with log_sink_webhook requests, as 'Name: value' (may be repeated)")
 
 	ffOpts := []ff.Option{
 		ff.WithConfigFileFlag("config"),
@@ -154,6 +187,10 @@ func parseOptions(args []string) (*launcher.Options, error) {
 		osquerydPath = osquerydPath + ".exe"
 	}
 
+	if *flControlRequestInterval <= 0 {
+		return nil, fmt.Errorf("control_request_interval %s must be positive", *flControlRequestInterval)
+	}
+
 	if *flEnrollSecret != "" && *flEnrollSecretPath != "" {
 		return nil, errors.New("Both enroll_secret and enroll_secret_path were defined")
 	}
@@ -172,35 +209,105 @@ func parseOptions(args []string) (*launcher.Options, error) {
 		return nil, fmt.Errorf("unknown update channel %s", *flUpdateChannel)
 	}
 
+	if *flRolloutPercent < 0 || *flRolloutPercent > 100 {
+		return nil, fmt.Errorf("autoupdate_rollout_percent %d is not between 0 and 100", *flRolloutPercent)
+	}
+
+	if *flTableExecCacheTTL < 0 {
+		return nil, fmt.Errorf("table_exec_cache_ttl %s is negative", *flTableExecCacheTTL)
+	}
+
+	if *flTUFRepo != "" && *flTUFRoot == "" {
+		return nil, errors.New("autoupdate_tuf_repo requires autoupdate_tuf_root")
+	}
+
+	// Downloads from peers are verified against the TUF metadata, which
+	// only the native TUF client exposes
+	if (*flPeerCacheAddress != "" || *flPeerDiscovery || len(flAutoupdatePeers) > 0) && *flTUFRepo == "" {
+		return nil, errors.New("the autoupdate peer cache requires autoupdate_tuf_repo")
+	}
+
+	if *flCertPins != "" && *flCertPinsPath != "" {
+		return nil, errors.New("Both cert_pins and cert_pins_path were defined")
+	}
+
+	if *flLogSinkSyslog != "" && runtime.GOOS == "windows" {
+		return nil, errors.New("log_sink_syslog is not supported on windows")
+	}
+
+	if len(flLogSinkWebhookHeaders) > 0 && *flLogSinkWebhook == "" {
+		return nil, errors.New("log_sink_webhook_header requires log_sink_webhook")
+	}
+
+	webhookHeaders, err := parseHeaders(flLogSinkWebhookHeaders)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing log_sink_webhook_header")
+	}
+
 	certPins, err := parseCertPins(*flCertPins)
 	if err != nil {
 		return nil, err
 	}
 
+	osqueryInstances, err := parseOsqueryInstances(*flOsqueryInstances)
+	if err != nil {
+		return nil, err
+	}
+
+	if (*flClientCert == "") != (*flClientKey == "") {
+		return nil, errors.New("--client_cert and --client_key must be used together")
+	}
+	if *flClientCert != "" && *flGenerateClientCert {
+		return nil, errors.New("--client_cert and --generate_client_cert are mutually exclusive")
+	}
+
 	opts := &launcher.Options{
 		Autoupdate:                         *flAutoupdate,
 		AutoupdateInterval:                 *flAutoupdateInterval,
 		AutoupdateInitialDelay:             *flAutoupdateInitialDelay,
+		AutoupdateLauncherVersion:          *flLauncherVersion,
+		AutoupdateOsquerydVersion:          *flOsquerydVersion,
+		AutoupdateRolloutPercent:           *flRolloutPercent,
+		AutoupdateTUFRepo:                  *flTUFRepo,
+		AutoupdateTUFRoot:                  *flTUFRoot,
+		AutoupdatePeerCacheAddress:         *flPeerCacheAddress,
+		AutoupdatePeers:                    flAutoupdatePeers,
+		AutoupdatePeerDiscovery:            *flPeerDiscovery,
 		CertPins:                           certPins,
+		CertPinsPath:                       *flCertPinsPath,
+		ClientCertPath:                     *flClientCert,
+		ClientKeyPath:                      *flClientKey,
 		CompactDbMaxTx:                     *flCompactDbMaxTx,
 		Control:                            *flControl,
 		ControlServerURL:                   *flControlServerURL,
+		ControlRequestInterval:             *flControlRequestInterval,
+		ControlServerKeyPath:               *flControlServerKey,
+		CustomTablesPath:                   *flCustomTables,
 		Debug:                              *flDebug,
 		DisableControlTLS:                  *flDisableControlTLS,
+		DisableServerLogs:                  *flDisableServerLogs,
 		EnableInitialRunner:                *flInitialRunner,
 		EnrollSecret:                       *flEnrollSecret,
 		EnrollSecretPath:                   *flEnrollSecretPath,
+		GenerateClientCert:                 *flGenerateClientCert,
 		AutoloadedExtensions:               flAutoloadedExtensions,
 		InsecureTLS:                        *flInsecureTLS,
 		InsecureTransport:                  *flInsecureTransport,
 		KolideHosted:                       *flKolideHosted,
 		KolideServerURL:                    *flKolideServerURL,
 		LogMaxBytesPerBatch:                *flLogMaxBytesPerBatch,
+		LogSinkFile:                        *flLogSinkFile,
+		LogSinkSyslog:                      *flLogSinkSyslog,
+		LogSinkWebhook:                     *flLogSinkWebhook,
+		LogSinkWebhookHeaders:              webhookHeaders,
 		LoggingInterval:                    *flLoggingInterval,
 		MirrorServerURL:                    *flMirrorURL,
 		NotaryPrefix:                       *flNotaryPrefix,
 		NotaryServerURL:                    *flNotaryServerURL,
 		OsqueryFlags:                       flOsqueryFlags,
+		OsqueryMaxCPUPercent:               *flOsqueryMaxCPUPercent,
+		OsqueryMaxMemoryMB:                 *flOsqueryMaxMemoryMB,
+		OsqueryInstances:                   osqueryInstances,
 		OsqueryTlsConfigEndpoint:           *flOsqTlsConfig,
 		OsqueryTlsDistributedReadEndpoint:  *flOsqTlsDistRead,
 		OsqueryTlsDistributedWriteEndpoint: *flOsqTlsDistWrite,
@@ -208,8 +315,13 @@ func parseOptions(args []string) (*launcher.Options, error) {
 		OsqueryTlsLoggerEndpoint:           *flOsqTlsLogger,
 		OsqueryVerbose:                     *flOsqueryVerbose,
 		OsquerydPath:                       osquerydPath,
+		ProxyPAC:                           *flProxyPAC,
+		ProxyURL:                           *flProxyURL,
 		RootDirectory:                      *flRootDirectory,
 		RootPEM:                            *flRootPEM,
+		TableExecSandbox:                   *flTableExecSandbox,
+		TableExecUser:                      *flTableExecUser,
+		TableExecCacheTTL:                  *flTableExecCacheTTL,
 		Transport:                          *flTransport,
 		UpdateChannel:                      updateChannel,
 	}
@@ -249,10 +361,18 @@ func shortUsage(flagset *flag.FlagSet) {
 	printOpt("root_directory")
 	printOpt("osqueryd_path")
 	fmt.Fprintf(os.Stderr, "\n")
+	printOpt("proxy")
+	printOpt("proxy_pac")
+	fmt.Fprintf(os.Stderr, "\n")
+	printOpt("client_cert")
+	printOpt("client_key")
+	printOpt("generate_client_cert")
+	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("autoupdate")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("control")
 	printOpt("control_hostname")
+	printOpt("control_server_key")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("version")
 	fmt.Fprintf(os.Stderr, "\n")
@@ -299,13 +419,24 @@ func developerUsage(flagset *flag.FlagSet) {
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("logging_interval")
 	fmt.Fprintf(os.Stderr, "\n")
+	printOpt("log_sink_file")
+	printOpt("log_sink_syslog")
+	printOpt("log_sink_webhook")
+	printOpt("log_sink_webhook_header")
+	printOpt("disable_server_logs")
+	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("notary_url")
 	printOpt("mirror_url")
 	printOpt("autoupdate_interval")
 	printOpt("update_channel")
 	printOpt("notary_prefix")
+	printOpt("autoupdate_tuf_repo")
+	printOpt("autoupdate_tuf_root")
+	printOpt("autoupdate_peer_cache_address")
+	printOpt("autoupdate_peer")
+	printOpt("autoupdate_peer_discovery")
 	fmt.Fprintf(os.Stderr, "\n")
-	printOpt("control_get_shells_interval")
+	printOpt("control_request_interval")
 	printOpt("disable_control_tls")
 	fmt.Fprintf(os.Stderr, "\n")
 	printOpt("osquery_flag")
@@ -322,7 +453,7 @@ func parseCertPins(pins string) ([][]byte, error) {
 	var certPins [][]byte
 	if pins != "" {
 		for _, hexPin := range strings.Split(pins, ",") {
-			pin, err := hex.DecodeString(hexPin)
+			pin, err := hex.DecodeString(strings.TrimSpace(hexPin))
 			if err != nil {
 				return nil, errors.Wrap(err, "decoding cert pin")
 			}
@@ -332,6 +463,64 @@ func parseCertPins(pins string) ([][]byte, error) {
 	return certPins, nil
 }
 
+// parseHeaders parses HTTP headers given as "Name: value"
+func parseHeaders(headers []string) (map[string]string, error) {
+	if len(headers) == 0 {
+		return nil, nil
+	}
+
+	parsed := make(map[string]string, len(headers))
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		name := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || name == "" {
+			return nil, errors.Errorf("invalid header %q, expected 'Name: value'", header)
+		}
+		parsed[name] = strings.TrimSpace(parts[1])
+	}
+
+	return parsed, nil
+}
+
+// osqueryInstanceNameRegexp is what additional osquery instance names
+// may look like. They're used in paths and plugin names.
+var osqueryInstanceNameRegexp = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// parseOsqueryInstances reads the additional osquery instances from the
+// JSON file at path, if one is given.
+func parseOsqueryInstances(path string) ([]launcher.OsqueryInstance, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading osquery_instances at path: %s", path)
+	}
+
+	var instances []launcher.OsqueryInstance
+	if err := json.Unmarshal(contents, &instances); err != nil {
+		return nil, errors.Wrapf(err, "parsing osquery_instances at path: %s", path)
+	}
+
+	names := make(map[string]bool, len(instances))
+	for _, instance := range instances {
+		if !osqueryInstanceNameRegexp.MatchString(instance.Name) {
+			return nil, errors.Errorf("invalid osquery instance name %q, expected lowercase letters, numbers and underscores", instance.Name)
+		}
+		if names[instance.Name] {
+			return nil, errors.Errorf("duplicate osquery instance name %q", instance.Name)
+		}
+		names[instance.Name] = true
+
+		if instance.ConfigPath == "" {
+			return nil, errors.Errorf("osquery instance %q has no config_path", instance.Name)
+		}
+	}
+
+	return instances, nil
+}
+
 // findOsquery will attempt to find osquery. We don't much care about
 // errors here, either we find it, or we don't.
 func findOsquery() string {
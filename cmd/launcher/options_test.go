@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"testing"
@@ -87,6 +88,89 @@ func TestOptionsFromFile(t *testing.T) { // nolint:paralleltest
 	require.Equal(t, expectedOpts, opts)
 }
 
+func TestControlRequestIntervalValidation(t *testing.T) {
+	t.Parallel()
+
+	for _, interval := range []string{"0s", "-1m"} {
+		_, err := parseOptions([]string{"-osqueryd_path", windowsAddExe("/dev/null"), "-control_request_interval", interval})
+		require.Error(t, err, interval)
+	}
+}
+
+func TestParseHeaders(t *testing.T) {
+	t.Parallel()
+
+	headers, err := parseHeaders(nil)
+	require.NoError(t, err)
+	require.Nil(t, headers)
+
+	headers, err = parseHeaders([]string{"Authorization: Bearer a:b", "X-Empty:"})
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"Authorization": "Bearer a:b", "X-Empty": ""}, headers)
+
+	for _, invalid := range []string{"no colon", ": value"} {
+		_, err := parseHeaders([]string{invalid})
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestParseOsqueryInstances(t *testing.T) {
+	t.Parallel()
+
+	instances, err := parseOsqueryInstances("")
+	require.NoError(t, err)
+	require.Empty(t, instances)
+
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		contents string
+		want     []launcher.OsqueryInstance
+		wantErr  bool
+	}{
+		{
+			name:     "valid",
+			contents: `[{"name": "fim", "config_path": "/etc/fim.conf", "flags": ["verbose"], "max_memory_mb": 200}]`,
+			want: []launcher.OsqueryInstance{
+				{Name: "fim", ConfigPath: "/etc/fim.conf", Flags: []string{"verbose"}, MaxMemoryMB: 200},
+			},
+		},
+		{
+			name:     "bad_name",
+			contents: `[{"name": "../fim", "config_path": "/etc/fim.conf"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "duplicate_name",
+			contents: `[{"name": "fim", "config_path": "/etc/a.conf"}, {"name": "fim", "config_path": "/etc/b.conf"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "no_config",
+			contents: `[{"name": "fim"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "not_json",
+			contents: `fim`,
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, tt.name+".json")
+		require.NoError(t, ioutil.WriteFile(path, []byte(tt.contents), 0600))
+
+		instances, err := parseOsqueryInstances(path)
+		if tt.wantErr {
+			require.Error(t, err, tt.name)
+			continue
+		}
+		require.NoError(t, err, tt.name)
+		require.Equal(t, tt.want, instances, tt.name)
+	}
+}
+
 func getArgsAndResponse() (map[string]string, *launcher.Options) {
 	randomHostname := fmt.Sprintf("%s.example.com", stringutil.RandomString(8))
 	randomInt := rand.Intn(1024)
@@ -103,19 +187,22 @@ func getArgsAndResponse() (map[string]string, *launcher.Options) {
 	}
 
 	opts := &launcher.Options{
-		AutoupdateInitialDelay: 1 * time.Hour,
-		AutoupdateInterval:     48 * time.Hour,
-		CompactDbMaxTx:         int64(65536),
-		Control:                true,
-		KolideServerURL:        randomHostname,
-		LoggingInterval:        time.Duration(randomInt) * time.Second,
-		MirrorServerURL:        "https://dl.kolide.co",
-		NotaryPrefix:           "kolide",
-		NotaryServerURL:        "https://notary.kolide.co",
-		OsquerydPath:           windowsAddExe("/dev/null"),
-		Transport:              "grpc",
-		UpdateChannel:          "stable",
-		AutoloadedExtensions:   []string{"some-extension.ext"},
+		AutoupdateInitialDelay:   1 * time.Hour,
+		AutoupdateInterval:       48 * time.Hour,
+		AutoupdateRolloutPercent: 100,
+		CompactDbMaxTx:           int64(65536),
+		Control:                  true,
+		ControlRequestInterval:   60 * time.Second,
+		KolideServerURL:          randomHostname,
+		LoggingInterval:          time.Duration(randomInt) * time.Second,
+		MirrorServerURL:          "https://dl.kolide.co",
+		NotaryPrefix:             "kolide",
+		NotaryServerURL:          "https://notary.kolide.co",
+		OsquerydPath:             windowsAddExe("/dev/null"),
+		TableExecCacheTTL:        30 * time.Second,
+		Transport:                "grpc",
+		UpdateChannel:            "stable",
+		AutoloadedExtensions:     []string{"some-extension.ext"},
 	}
 
 	return args, opts
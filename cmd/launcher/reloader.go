@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+const defaultReloadInterval = 30 * time.Second
+
+// reloader watches files launcher reads its configuration from, such as
+// the root PEM and the enroll secret, and hands their new contents to a
+// callback when they change. This lets them be rotated without
+// restarting launcher.
+type reloader struct {
+	logger    log.Logger
+	interval  time.Duration
+	interrupt chan struct{}
+
+	lock    sync.Mutex
+	watches []*watchedFile
+}
+
+type watchedFile struct {
+	path     string
+	contents []byte
+	onChange func(contents []byte) error
+}
+
+func newReloader(logger log.Logger, interval time.Duration) *reloader {
+	return &reloader{
+		logger:    log.With(logger, "component", "reloader"),
+		interval:  interval,
+		interrupt: make(chan struct{}),
+	}
+}
+
+// watch reads the file at path, and calls onChange with its contents
+// whenever it changes from then on. If onChange returns an error, the
+// change is logged and retried at the next check.
+func (r *reloader) watch(path string, onChange func(contents []byte) error) ([]byte, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading %s", path)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.watches = append(r.watches, &watchedFile{
+		path:     path,
+		contents: contents,
+		onChange: onChange,
+	})
+	return contents, nil
+}
+
+func (r *reloader) Execute() error {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.interrupt:
+			return nil
+		case <-ticker.C:
+			r.check()
+		}
+	}
+}
+
+func (r *reloader) Interrupt(err error) {
+	close(r.interrupt)
+}
+
+// check calls onChange for each watched file whose contents have
+// changed. Contents are compared, rather than modification times, as
+// some tools replace files without changing them.
+func (r *reloader) check() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for _, w := range r.watches {
+		contents, err := ioutil.ReadFile(w.path)
+		if err != nil {
+			level.Info(r.logger).Log("msg", "could not read watched file", "path", w.path, "err", err)
+			continue
+		}
+		if bytes.Equal(contents, w.contents) {
+			continue
+		}
+
+		if err := w.onChange(contents); err != nil {
+			level.Info(r.logger).Log("msg", "could not apply changed file, keeping previous contents", "path", w.path, "err", err)
+			continue
+		}
+		w.contents = contents
+	}
+}
+
+// countCerts returns the number of certificates in PEM encoded contents.
+func countCerts(contents []byte) int {
+	var count int
+	for {
+		var block *pem.Block
+		block, contents = pem.Decode(contents)
+		if block == nil {
+			return count
+		}
+		if block.Type == "CERTIFICATE" {
+			count++
+		}
+	}
+}
+
+// parseCertPinsFile parses the contents of a cert pins file, which uses
+// the same format as the cert_pins flag, but may also separate pins with
+// newlines.
+func parseCertPinsFile(contents []byte) ([][]byte, error) {
+	var pins []string
+	for _, field := range strings.FieldsFunc(string(contents), func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	}) {
+		if field = strings.TrimSpace(field); field != "" {
+			pins = append(pins, field)
+		}
+	}
+	return parseCertPins(strings.Join(pins, ","))
+}
+
+// diffCertPins returns the hex encoded pins added and removed between
+// old and new.
+func diffCertPins(old, new [][]byte) (added, removed []string) {
+	oldSet := make(map[string]bool, len(old))
+	for _, pin := range old {
+		oldSet[hex.EncodeToString(pin)] = true
+	}
+	newSet := make(map[string]bool, len(new))
+	for _, pin := range new {
+		newSet[hex.EncodeToString(pin)] = true
+	}
+
+	for pin := range newSet {
+		if !oldSet[pin] {
+			added = append(added, pin)
+		}
+	}
+	for pin := range oldSet {
+		if !newSet[pin] {
+			removed = append(removed, pin)
+		}
+	}
+	return added, removed
+}
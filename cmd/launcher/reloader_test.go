@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloaderCheck(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, ioutil.WriteFile(path, []byte("first"), 0600))
+
+	r := newReloader(log.NewNopLogger(), defaultReloadInterval)
+
+	var changes []string
+	reject := false
+	contents, err := r.watch(path, func(contents []byte) error {
+		if reject {
+			return errors.New("rejected")
+		}
+		changes = append(changes, string(contents))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, "first", string(contents))
+
+	// Nothing changed
+	r.check()
+	require.Empty(t, changes)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("second"), 0600))
+	r.check()
+	require.Equal(t, []string{"second"}, changes)
+
+	// A rejected change is retried at the next check
+	require.NoError(t, ioutil.WriteFile(path, []byte("third"), 0600))
+	reject = true
+	r.check()
+	require.Equal(t, []string{"second"}, changes)
+	reject = false
+	r.check()
+	require.Equal(t, []string{"second", "third"}, changes)
+}
+
+func TestReloaderWatchMissing(t *testing.T) {
+	t.Parallel()
+
+	r := newReloader(log.NewNopLogger(), defaultReloadInterval)
+	_, err := r.watch(filepath.Join(t.TempDir(), "missing"), func([]byte) error { return nil })
+	require.Error(t, err)
+}
+
+func TestParseCertPinsFile(t *testing.T) {
+	t.Parallel()
+
+	pins, err := parseCertPinsFile([]byte("0a0b,0c\n0d\r\n\n"))
+	require.NoError(t, err)
+	require.Equal(t, [][]byte{{0x0a, 0x0b}, {0x0c}, {0x0d}}, pins)
+
+	_, err = parseCertPinsFile([]byte("not hex"))
+	require.Error(t, err)
+
+	added, removed := diffCertPins([][]byte{{0x0a}, {0x0b}}, [][]byte{{0x0b}, {0x0c}, {0x0d}})
+	sort.Strings(added)
+	require.Equal(t, []string{"0c", "0d"}, added)
+	require.Equal(t, []string{"0a"}, removed)
+}
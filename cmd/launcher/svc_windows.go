@@ -18,6 +18,7 @@ import (
 	"github.com/kolide/launcher/pkg/contexts/ctxlog"
 	"github.com/kolide/launcher/pkg/launcher"
 	"github.com/kolide/launcher/pkg/log/eventlog"
+	"github.com/kolide/launcher/pkg/log/levellogger"
 	"github.com/kolide/launcher/pkg/log/locallogger"
 	"github.com/kolide/launcher/pkg/log/teelogger"
 	"github.com/pkg/errors"
@@ -56,12 +57,10 @@ func runWindowsSvc(args []string) error {
 		logger = teelogger.New(logger, locallogger.NewKitLogger(filepath.Join(opts.RootDirectory, "debug.log")))
 	}
 
-	// Now that we've parsed the options, let's set a filter on our logger
-	if opts.Debug {
-		logger = level.NewFilter(logger, level.AllowDebug())
-	} else {
-		logger = level.NewFilter(logger, level.AllowInfo())
-	}
+	// Now that we've parsed the options, let's set a filter on our
+	// logger. The server may change it later, through the agent flags.
+	levelLogger := levellogger.New(level.NewFilter(logger, level.AllowInfo()), level.NewFilter(logger, level.AllowDebug()), opts.Debug)
+	logger = levelLogger
 
 	// Use the FindNewest mechanism to delete old
 	// updates. We do this here, as windows will pick up
@@ -93,7 +92,7 @@ func runWindowsSvc(args []string) error {
 		}
 	}()
 
-	if err := svc.Run(serviceName, &winSvc{logger: logger, opts: opts}); err != nil {
+	if err := svc.Run(serviceName, &winSvc{logger: logger, levelLogger: levelLogger, opts: opts}); err != nil {
 		// TODO The caller doesn't have the event log configured, so we
 		// need to log here. this implies we need some deeper refactoring
 		// of the logging
@@ -134,8 +133,9 @@ func runWindowsSvcForeground(args []string) error {
 }
 
 type winSvc struct {
-	logger log.Logger
-	opts   *launcher.Options
+	logger      log.Logger
+	levelLogger *levellogger.Logger
+	opts        *launcher.Options
 }
 
 func (w *winSvc) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
@@ -148,6 +148,9 @@ func (w *winSvc) Execute(args []string, r <-chan svc.ChangeRequest, changes chan
 	defer cancel()
 
 	ctx = ctxlog.NewContext(ctx, w.logger)
+	if w.levelLogger != nil {
+		ctx = levellogger.NewContext(ctx, w.levelLogger)
+	}
 
 	go func() {
 		err := runLauncher(ctx, cancel, w.opts)
@@ -0,0 +1,340 @@
+// Package flags provides a store for launcher options that may be
+// overridden at runtime by the server. Overrides are persisted in
+// bbolt, so they survive restarts, and observers are notified when a
+// value changes.
+package flags
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/launcher"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// bucketName is the bucket the server provided overrides are stored in
+const bucketName = "agent_flags"
+
+// Observer is notified of changes to the flags it registered for
+type Observer interface {
+	FlagsChanged(keys ...FlagKey)
+}
+
+// ObserverFunc adapts a function to the Observer interface
+type ObserverFunc func(keys ...FlagKey)
+
+func (f ObserverFunc) FlagsChanged(keys ...FlagKey) {
+	f(keys...)
+}
+
+type observerRegistration struct {
+	observer Observer
+	keys     map[FlagKey]bool
+}
+
+// Flags provides the current value of the runtime adjustable launcher
+// options. A value set by the server takes precedence over the value
+// parsed at startup.
+type Flags struct {
+	db     *bbolt.DB
+	opts   *launcher.Options
+	logger log.Logger
+
+	lock      sync.RWMutex
+	overrides map[FlagKey]string
+
+	observersLock sync.Mutex
+	observers     []observerRegistration
+}
+
+// NewFlags returns a Flags backed by db, falling back to opts for any
+// value the server has not overridden.
+func NewFlags(db *bbolt.DB, opts *launcher.Options, logger log.Logger) (*Flags, error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	f := &Flags{
+		db:        db,
+		opts:      opts,
+		logger:    log.With(logger, "component", "agent_flags"),
+		overrides: make(map[FlagKey]string),
+	}
+
+	if err := f.load(); err != nil {
+		return nil, errors.Wrap(err, "loading stored flags")
+	}
+
+	return f, nil
+}
+
+// RegisterChangeObserver registers an observer to be notified when
+// any of keys change.
+func (f *Flags) RegisterChangeObserver(observer Observer, keys ...FlagKey) {
+	reg := observerRegistration{
+		observer: observer,
+		keys:     make(map[FlagKey]bool, len(keys)),
+	}
+	for _, k := range keys {
+		reg.keys[k] = true
+	}
+
+	f.observersLock.Lock()
+	defer f.observersLock.Unlock()
+	f.observers = append(f.observers, reg)
+}
+
+// LoggingInterval is the interval at which buffered logs are sent
+func (f *Flags) LoggingInterval() time.Duration {
+	return f.duration(LoggingInterval, f.opts.LoggingInterval)
+}
+
+// SetLoggingInterval overrides the logging interval
+func (f *Flags) SetLoggingInterval(d time.Duration) error {
+	return f.Set(LoggingInterval, d.String())
+}
+
+// AutoupdateInterval is the interval at which the updaters check for
+// new versions
+func (f *Flags) AutoupdateInterval() time.Duration {
+	return f.duration(AutoupdateInterval, f.opts.AutoupdateInterval)
+}
+
+// SetAutoupdateInterval overrides the autoupdate interval
+func (f *Flags) SetAutoupdateInterval(d time.Duration) error {
+	return f.Set(AutoupdateInterval, d.String())
+}
+
+// OsqueryVerbose is whether osqueryd should be run with --verbose
+func (f *Flags) OsqueryVerbose() bool {
+	return f.bool(OsqueryVerbose, f.opts.OsqueryVerbose)
+}
+
+// SetOsqueryVerbose overrides osquery verbosity
+func (f *Flags) SetOsqueryVerbose(v bool) error {
+	return f.Set(OsqueryVerbose, strconv.FormatBool(v))
+}
+
+// Debug is whether launcher logs at debug level
+func (f *Flags) Debug() bool {
+	return f.bool(Debug, f.opts.Debug)
+}
+
+// SetDebug overrides debug logging
+func (f *Flags) SetDebug(v bool) error {
+	return f.Set(Debug, strconv.FormatBool(v))
+}
+
+// AutoupdateLauncherVersion is the version the launcher updater is
+// pinned to, if any
+func (f *Flags) AutoupdateLauncherVersion() string {
+	return f.string(AutoupdateLauncherVersion, f.opts.AutoupdateLauncherVersion)
+}
+
+// AutoupdateOsquerydVersion is the version the osqueryd updater is
+// pinned to, if any
+func (f *Flags) AutoupdateOsquerydVersion() string {
+	return f.string(AutoupdateOsquerydVersion, f.opts.AutoupdateOsquerydVersion)
+}
+
+// AutoupdateRolloutPercent is the percentage of hosts which use new
+// versions
+func (f *Flags) AutoupdateRolloutPercent() int {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if v, ok := f.overrides[AutoupdateRolloutPercent]; ok {
+		if i, err := strconv.Atoi(v); err == nil {
+			return i
+		}
+	}
+	return f.opts.AutoupdateRolloutPercent
+}
+
+// Set overrides a single flag, persists it, and notifies observers
+func (f *Flags) Set(key FlagKey, value string) error {
+	return f.apply(map[FlagKey]string{key: value}, false)
+}
+
+// Update replaces the full set of overrides with a JSON object of flag
+// name to value, as sent by the server. Flags missing from the object
+// revert to their startup value. It satisfies the control server's
+// consumer interface.
+func (f *Flags) Update(r io.Reader) error {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return errors.Wrap(err, "decoding flags")
+	}
+
+	values := make(map[FlagKey]string, len(raw))
+	for k, v := range raw {
+		values[FlagKey(k)] = fmt.Sprint(v)
+	}
+
+	return f.apply(values, true)
+}
+
+// apply validates and stores values. If replace is set, any existing
+// override not present in values is removed. Invalid values are logged
+// and skipped, so one bad flag doesn't block the rest.
+func (f *Flags) apply(values map[FlagKey]string, replace bool) error {
+	valid := make(map[FlagKey]string, len(values))
+	var lastErr error
+	for k, v := range values {
+		canonical, err := validate(k, v)
+		if err != nil {
+			level.Info(f.logger).Log("msg", "ignoring invalid flag", "flag", k, "value", v, "err", err)
+			lastErr = errors.Wrapf(err, "validating %s", k)
+			continue
+		}
+		valid[k] = canonical
+	}
+
+	f.lock.Lock()
+	var changed []FlagKey
+	for k, v := range valid {
+		if f.overrides[k] != v {
+			changed = append(changed, k)
+		}
+	}
+	var removed []FlagKey
+	if replace {
+		for k := range f.overrides {
+			if _, ok := valid[k]; !ok {
+				removed = append(removed, k)
+			}
+		}
+	}
+
+	if err := f.store(valid, removed); err != nil {
+		f.lock.Unlock()
+		return errors.Wrap(err, "storing flags")
+	}
+
+	for k, v := range valid {
+		f.overrides[k] = v
+	}
+	for _, k := range removed {
+		delete(f.overrides, k)
+	}
+	f.lock.Unlock()
+
+	changed = append(changed, removed...)
+	if len(changed) > 0 {
+		level.Info(f.logger).Log("msg", "agent flags changed", "flags", fmt.Sprint(changed))
+		f.notify(changed)
+	}
+
+	// Only single flag sets surface validation errors, a bulk update
+	// from the server applies whatever it can.
+	if !replace {
+		return lastErr
+	}
+	return nil
+}
+
+// notify calls the observers of the changed flags. They're called
+// without observersLock held, as they may be slow, or register
+// observers themselves.
+func (f *Flags) notify(changed []FlagKey) {
+	type notification struct {
+		observer Observer
+		keys     []FlagKey
+	}
+
+	var notifications []notification
+	f.observersLock.Lock()
+	for _, reg := range f.observers {
+		var keys []FlagKey
+		for _, k := range changed {
+			if reg.keys[k] {
+				keys = append(keys, k)
+			}
+		}
+		if len(keys) > 0 {
+			notifications = append(notifications, notification{observer: reg.observer, keys: keys})
+		}
+	}
+	f.observersLock.Unlock()
+
+	for _, n := range notifications {
+		n.observer.FlagsChanged(n.keys...)
+	}
+}
+
+func (f *Flags) duration(key FlagKey, fallback time.Duration) time.Duration {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if v, ok := f.overrides[key]; ok {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return fallback
+}
+
+func (f *Flags) bool(key FlagKey, fallback bool) bool {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if v, ok := f.overrides[key]; ok {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
+func (f *Flags) string(key FlagKey, fallback string) string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	if v, ok := f.overrides[key]; ok {
+		return v
+	}
+	return fallback
+}
+
+func (f *Flags) load() error {
+	return f.db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		if err != nil {
+			return errors.Wrap(err, "creating bucket")
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			canonical, err := validate(FlagKey(k), string(v))
+			if err != nil {
+				level.Info(f.logger).Log("msg", "ignoring stored flag", "flag", string(k), "err", err)
+				return nil
+			}
+			f.overrides[FlagKey(k)] = canonical
+			return nil
+		})
+	})
+}
+
+func (f *Flags) store(set map[FlagKey]string, remove []FlagKey) error {
+	return f.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		for k, v := range set {
+			if err := b.Put([]byte(k), []byte(v)); err != nil {
+				return err
+			}
+		}
+		for _, k := range remove {
+			if err := b.Delete([]byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
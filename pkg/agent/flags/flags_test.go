@@ -0,0 +1,145 @@
+package flags
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kolide/launcher/pkg/launcher"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+type recordingObserver struct {
+	changes [][]FlagKey
+}
+
+func (r *recordingObserver) FlagsChanged(keys ...FlagKey) {
+	r.changes = append(r.changes, keys)
+}
+
+func makeTestDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "flags.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestDefaultsAndOverrides(t *testing.T) {
+	t.Parallel()
+
+	db := makeTestDB(t)
+	opts := &launcher.Options{
+		LoggingInterval:    60 * time.Second,
+		AutoupdateInterval: time.Hour,
+	}
+
+	f, err := NewFlags(db, opts, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, 60*time.Second, f.LoggingInterval())
+	require.Equal(t, time.Hour, f.AutoupdateInterval())
+	require.False(t, f.OsqueryVerbose())
+	require.False(t, f.Debug())
+
+	observer := &recordingObserver{}
+	f.RegisterChangeObserver(observer, LoggingInterval)
+
+	require.NoError(t, f.SetLoggingInterval(30*time.Second))
+	require.NoError(t, f.SetOsqueryVerbose(true))
+	require.NoError(t, f.SetDebug(true))
+	require.Error(t, f.SetLoggingInterval(time.Millisecond), "out of range values are rejected")
+
+	require.Equal(t, 30*time.Second, f.LoggingInterval())
+	require.True(t, f.OsqueryVerbose())
+	require.True(t, f.Debug())
+	require.Equal(t, [][]FlagKey{{LoggingInterval}}, observer.changes, "observer only sees the flags it registered for")
+
+	// Setting the same value again isn't a change
+	require.NoError(t, f.SetLoggingInterval(30*time.Second))
+	require.Len(t, observer.changes, 1)
+
+	// Overrides persist
+	f2, err := NewFlags(db, opts, nil)
+	require.NoError(t, err)
+	require.Equal(t, 30*time.Second, f2.LoggingInterval())
+	require.True(t, f2.OsqueryVerbose())
+	require.True(t, f2.Debug())
+}
+
+func TestUpdate(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewFlags(makeTestDB(t), &launcher.Options{LoggingInterval: time.Minute, AutoupdateInterval: time.Hour}, nil)
+	require.NoError(t, err)
+
+	observer := &recordingObserver{}
+	f.RegisterChangeObserver(observer, LoggingInterval, AutoupdateInterval, OsqueryVerbose)
+
+	require.NoError(t, f.Update(strings.NewReader(`{"logging_interval": "10s", "osquery_verbose": true, "autoupdate_interval": "1ms", "not_a_flag": 1}`)))
+	require.Equal(t, 10*time.Second, f.LoggingInterval())
+	require.True(t, f.OsqueryVerbose())
+	require.Equal(t, time.Hour, f.AutoupdateInterval(), "invalid values are skipped")
+	require.Len(t, observer.changes, 1)
+	require.ElementsMatch(t, []FlagKey{LoggingInterval, OsqueryVerbose}, observer.changes[0])
+
+	// Flags missing from an update revert to their defaults
+	require.NoError(t, f.Update(strings.NewReader(`{"osquery_verbose": true}`)))
+	require.Equal(t, time.Minute, f.LoggingInterval())
+	require.True(t, f.OsqueryVerbose())
+	require.Equal(t, []FlagKey{LoggingInterval}, observer.changes[1])
+
+	require.Error(t, f.Update(strings.NewReader(`not json`)))
+}
+
+func TestObserversCalledWithoutLock(t *testing.T) {
+	t.Parallel()
+
+	f, err := NewFlags(makeTestDB(t), &launcher.Options{LoggingInterval: time.Minute}, nil)
+	require.NoError(t, err)
+
+	// An observer may register another observer
+	nested := &recordingObserver{}
+	f.RegisterChangeObserver(ObserverFunc(func(keys ...FlagKey) {
+		f.RegisterChangeObserver(nested, LoggingInterval)
+	}), LoggingInterval)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		require.NoError(t, f.SetLoggingInterval(10*time.Second))
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("notifying observers deadlocked")
+	}
+
+	require.NoError(t, f.SetLoggingInterval(20*time.Second))
+	require.Equal(t, [][]FlagKey{{LoggingInterval}}, nested.changes)
+}
+
+func TestAutoupdatePolicyFlags(t *testing.T) {
+	t.Parallel()
+
+	opts := &launcher.Options{AutoupdateLauncherVersion: "0.11.0", AutoupdateRolloutPercent: 100}
+	f, err := NewFlags(makeTestDB(t), opts, nil)
+	require.NoError(t, err)
+
+	require.Equal(t, "0.11.0", f.AutoupdateLauncherVersion())
+	require.Equal(t, "", f.AutoupdateOsquerydVersion())
+	require.Equal(t, 100, f.AutoupdateRolloutPercent())
+
+	require.NoError(t, f.Update(strings.NewReader(`{"autoupdate_launcher_version": "", "autoupdate_osqueryd_version": "5.2.2", "autoupdate_rollout_percent": 25}`)))
+	require.Equal(t, "", f.AutoupdateLauncherVersion(), "an empty override unpins")
+	require.Equal(t, "5.2.2", f.AutoupdateOsquerydVersion())
+	require.Equal(t, 25, f.AutoupdateRolloutPercent())
+
+	require.Error(t, f.Set(AutoupdateRolloutPercent, "101"))
+	require.Error(t, f.Set(AutoupdateRolloutPercent, "half"))
+	require.Error(t, f.Set(AutoupdateOsquerydVersion, "latest"))
+	require.Equal(t, 25, f.AutoupdateRolloutPercent())
+	require.Equal(t, "5.2.2", f.AutoupdateOsquerydVersion())
+}
@@ -0,0 +1,91 @@
+package flags
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FlagKey identifies a launcher option that may be overridden at runtime
+type FlagKey string
+
+const (
+	LoggingInterval    FlagKey = "logging_interval"
+	AutoupdateInterval FlagKey = "autoupdate_interval"
+	OsqueryVerbose     FlagKey = "osquery_verbose"
+	Debug              FlagKey = "debug"
+
+	AutoupdateLauncherVersion FlagKey = "autoupdate_launcher_version"
+	AutoupdateOsquerydVersion FlagKey = "autoupdate_osqueryd_version"
+	AutoupdateRolloutPercent  FlagKey = "autoupdate_rollout_percent"
+)
+
+// Bounds on the durations the server may set. These exist so that a
+// bad value can't turn launcher into a tight loop, or effectively
+// disable it.
+const (
+	minLoggingInterval    = 5 * time.Second
+	maxLoggingInterval    = 10 * time.Minute
+	minAutoupdateInterval = 1 * time.Minute
+	maxAutoupdateInterval = 24 * time.Hour
+)
+
+// validate checks that value is acceptable for key, and returns its
+// canonical string form for storage.
+func validate(key FlagKey, value string) (string, error) {
+	switch key {
+	case LoggingInterval:
+		return validateDuration(value, minLoggingInterval, maxLoggingInterval)
+	case AutoupdateInterval:
+		return validateDuration(value, minAutoupdateInterval, maxAutoupdateInterval)
+	case OsqueryVerbose, Debug:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return "", errors.Wrap(err, "parsing bool")
+		}
+		return strconv.FormatBool(b), nil
+	case AutoupdateLauncherVersion, AutoupdateOsquerydVersion:
+		return validateVersion(value)
+	case AutoupdateRolloutPercent:
+		return validateInt(value, 0, 100)
+	default:
+		return "", errors.Errorf("unknown flag %s", key)
+	}
+}
+
+func validateDuration(value string, min, max time.Duration) (string, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing duration")
+	}
+	if d < min || d > max {
+		return "", errors.Errorf("duration %s outside of allowed range %s to %s", d, min, max)
+	}
+	return d.String(), nil
+}
+
+// versionPattern matches an empty string, which unpins, or a version
+// such as 1.2.3 or 0.11.24-3-g1234abc
+var versionPattern = regexp.MustCompile(`^(\d+\.\d+\.\d+[0-9A-Za-z.\-+]*)?$`)
+
+func validateVersion(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if !versionPattern.MatchString(value) {
+		return "", errors.Errorf("invalid version %q", value)
+	}
+	return value, nil
+}
+
+func validateInt(value string, min, max int) (string, error) {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return "", errors.Wrap(err, "parsing int")
+	}
+	if i < min || i > max {
+		return "", errors.Errorf("%d outside of allowed range %d to %d", i, min, max)
+	}
+	return strconv.Itoa(i), nil
+}
@@ -12,6 +12,11 @@
 //    limiting. It downloads on _metadata_ change, and not as a file
 //    comparison)
 //
+//    With WithTUFRepo, a standard TUF client from
+//    github.com/theupdateframework/go-tuf is used instead. It reads
+//    from an http(s) or filesystem mirror, and only downloads when the
+//    target's hashes change. It calls the same handler.
+//
 //    tuf.NotificationHandler is responsible for moving the downloaded
 //    binary into the desired location. It defined by this package,
 //    and is passed to TUF as a function. It is also used by TUF as a
@@ -52,12 +57,15 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/kolide/launcher/pkg/peercache"
 	"github.com/kolide/updater/tuf"
 	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
 )
 
 // UpdateChannel determines the TUF target for a Updater.
@@ -91,6 +99,14 @@ type Updater struct {
 	updateChannel      UpdateChannel   // Update channel (stable, nightly, etc)
 	settings           *tuf.Settings   // tuf.Settings
 	sigChannel         chan os.Signal  // channel for shutdown signaling
+	db                 *bbolt.DB       // optional, where decisions are recorded
+	policy             func() Policy   // optional, restricts which versions are used
+	rolloutID          string          // stable host identifier, for staged rollouts
+	tufRepo            string          // optional, a TUF repo to use instead of Notary
+	tufRoot            []byte          // trusted root metadata for tufRepo
+	tufMetadataPath    string          // where the TUF client stores tufRepo's metadata
+	checkInterval      func() time.Duration
+	peerCache          *peercache.Cache // optional, shares tufRepo downloads with peers
 	client             *http.Client
 	logger             log.Logger
 }
@@ -146,6 +162,7 @@ func NewUpdater(binaryPath, rootDirectory string, opts ...UpdaterOption) (*Updat
 	// cases)
 	updater.stagingPath = filepath.Join(rootDirectory, fmt.Sprintf("%s-staging", binaryName))
 	updater.updatesDirectory = filepath.Join(FindBaseDir(binaryPath), fmt.Sprintf("%s-updates", binaryName))
+	updater.tufMetadataPath = filepath.Join(rootDirectory, fmt.Sprintf("%s-tuf-metadata", strippedBinaryName))
 
 	// create TUF from local assets, but allow overriding with a no-op in tests.
 	updater.bootstrapFn = updater.createLocalTufRepo
@@ -158,8 +175,11 @@ func NewUpdater(binaryPath, rootDirectory string, opts ...UpdaterOption) (*Updat
 		return nil, errors.Wrapf(err, "set updater target for destination %s", binaryPath)
 	}
 
-	if err := updater.bootstrapFn(); err != nil {
-		return nil, errors.Wrap(err, "creating local TUF repo")
+	// The bundled Notary metadata is only needed for Notary
+	if updater.tufRepo == "" {
+		if err := updater.bootstrapFn(); err != nil {
+			return nil, errors.Wrap(err, "creating local TUF repo")
+		}
 	}
 
 	level.Debug(updater.logger).Log(
@@ -290,6 +310,60 @@ func WithSigChannel(sc chan os.Signal) UpdaterOption {
 	}
 }
 
+// WithDB configures a database to record the updater's decisions in.
+func WithDB(db *bbolt.DB) UpdaterOption {
+	return func(u *Updater) {
+		u.db = db
+	}
+}
+
+// WithPolicy configures a function returning the current update
+// Policy. If unspecified, every update is used.
+func WithPolicy(policy func() Policy) UpdaterOption {
+	return func(u *Updater) {
+		u.policy = policy
+	}
+}
+
+// WithRolloutID configures the identifier which places this host in
+// staged rollouts. It should be stable for the host.
+func WithRolloutID(id string) UpdaterOption {
+	return func(u *Updater) {
+		u.rolloutID = id
+	}
+}
+
+// WithTUFRepo configures the updater to use a standard TUF client,
+// rather than Notary. repo is either an http(s) URL, or a path to a
+// local directory, for air-gapped networks. rootJSON is the trusted root
+// metadata, used the first time the client runs. After that, the client
+// follows the repo's root rotations.
+func WithTUFRepo(repo string, rootJSON []byte) UpdaterOption {
+	return func(u *Updater) {
+		u.tufRepo = repo
+		u.tufRoot = rootJSON
+	}
+}
+
+// WithCheckInterval configures how often the TUF client configured by
+// WithTUFRepo checks for updates. It's read each time the updater is
+// run. (The Notary client takes this as a Run option)
+func WithCheckInterval(interval func() time.Duration) UpdaterOption {
+	return func(u *Updater) {
+		u.checkInterval = interval
+	}
+}
+
+// WithPeerCache configures the TUF client configured by WithTUFRepo to
+// download targets from peers when it can, and to keep its downloads in
+// cache, for peers. Downloads from peers are verified against the TUF
+// metadata, and fall back to the repo.
+func WithPeerCache(cache *peercache.Cache) UpdaterOption {
+	return func(u *Updater) {
+		u.peerCache = cache
+	}
+}
+
 // WithUpdate configures the update channel.
 // If unspecified, the Updater will use the Stable channel.
 func WithUpdateChannel(channel UpdateChannel) UpdaterOption {
@@ -342,7 +416,16 @@ func withoutBootstrap() UpdaterOption {
 }
 
 // Run starts the updater, which will run until the stop function is called.
+// Downloaded updates are reevaluated first, as the Policy may have changed.
 func (u *Updater) Run(opts ...tuf.Option) (stop func(), err error) {
+	if err := u.Reevaluate(); err != nil {
+		level.Info(u.logger).Log("msg", "reevaluating updates", "err", err)
+	}
+
+	if u.tufRepo != "" {
+		return u.runTUFClient()
+	}
+
 	updaterOpts := []tuf.Option{
 		tuf.WithHTTPClient(u.client),
 		tuf.WithAutoUpdate(u.target, u.stagingPath, u.handler()),
@@ -0,0 +1,107 @@
+package autoupdate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// decisionsBucket holds the history of the Updaters' decisions, keyed by
+// sequence number.
+const decisionsBucket = "autoupdate_decisions"
+
+// maxDecisions is how many decisions are kept. Older ones are pruned.
+const maxDecisions = 100
+
+type DecisionAction string
+
+const (
+	// ActionDownloaded updates were downloaded, and are in use.
+	ActionDownloaded DecisionAction = "downloaded"
+	// ActionHeld updates were downloaded, but are held by the Policy.
+	ActionHeld DecisionAction = "held"
+	// ActionReleased updates were held, but are now in use.
+	ActionReleased DecisionAction = "released"
+	// ActionRejected updates were downloaded broken, and removed.
+	ActionRejected DecisionAction = "rejected"
+	// ActionRolledBack updates failed after being started, and were
+	// replaced by the previous binary.
+	ActionRolledBack DecisionAction = "rolled_back"
+)
+
+// Decision is a record of something an Updater did with an update.
+type Decision struct {
+	Time    time.Time      `json:"time"`
+	Binary  string         `json:"binary"`
+	Version string         `json:"version"`
+	Action  DecisionAction `json:"action"`
+	Reason  string         `json:"reason,omitempty"`
+}
+
+// recordDecision adds a decision to the history in db, pruning the
+// oldest once there are more than maxDecisions.
+func recordDecision(db *bbolt.DB, decision Decision) error {
+	data, err := json.Marshal(decision)
+	if err != nil {
+		return errors.Wrap(err, "marshalling decision")
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(decisionsBucket))
+		if err != nil {
+			return errors.Wrap(err, "creating bucket")
+		}
+
+		seq, err := b.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "getting sequence")
+		}
+		if err := b.Put(sequenceKey(seq), data); err != nil {
+			return errors.Wrap(err, "storing decision")
+		}
+
+		if seq > maxDecisions {
+			if err := b.Delete(sequenceKey(seq - maxDecisions)); err != nil {
+				return errors.Wrap(err, "pruning decisions")
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "recording autoupdate decision")
+}
+
+// GetDecisions returns the history of the Updaters' decisions, oldest
+// first.
+func GetDecisions(db *bbolt.DB) ([]Decision, error) {
+	var decisions []Decision
+
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(decisionsBucket))
+		if b == nil {
+			return nil
+		}
+
+		return b.ForEach(func(k, v []byte) error {
+			var decision Decision
+			if err := json.Unmarshal(v, &decision); err != nil {
+				return errors.Wrapf(err, "unmarshalling decision %d", binary.BigEndian.Uint64(k))
+			}
+			decisions = append(decisions, decision)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading autoupdate decisions")
+	}
+
+	return decisions, nil
+}
+
+func sequenceKey(seq uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, seq)
+	return k
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"time"
@@ -116,7 +117,8 @@ func FindNewestSelf(ctx context.Context, opts ...newestOption) (string, error) {
 // FindNewest takes the full path to a binary, and returns the newest
 // update on disk. If there are no updates on disk, it returns the
 // original path. It will return the same fullBinaryPath if that is
-// the newest version.
+// the newest version. Updates held by the Policy, or rolled back, are
+// skipped.
 func FindNewest(ctx context.Context, fullBinaryPath string, opts ...newestOption) string {
 	logger := log.With(ctxlog.FromContext(ctx), "caller", log.DefaultCaller)
 
@@ -193,6 +195,14 @@ func FindNewest(ctx context.Context, fullBinaryPath string, opts ...newestOption
 			}
 		}
 
+		// Skip updates which are held by policy, or were rolled back
+		if state, err := readUpdateState(basedir); err != nil {
+			level.Info(logger).Log("msg", "reading update state. Treating as usable", "dir", basedir, "err", err)
+		} else if !state.usable() {
+			level.Debug(logger).Log("msg", "skipping unusable update", "dir", basedir, "status", state.Status)
+			continue
+		}
+
 		// If the file is _not_ the running executable, sanity
 		// check that executions work. If the exec fails,
 		// there's clearly an issue and we should remove it.
@@ -229,6 +239,14 @@ func FindNewest(ctx context.Context, fullBinaryPath string, opts ...newestOption
 
 	level.Debug(logger).Log("msg", "no updates found")
 
+	// If we were called with an update, and there are no usable
+	// updates, that one has been held or rolled back. So fall back to
+	// the original binary.
+	if strings.HasPrefix(fullBinaryPath, updateDir+string(filepath.Separator)) {
+		fullBinaryPath = filepath.Join(filepath.Dir(updateDir), binaryName)
+		level.Debug(logger).Log("msg", "falling back to the original binary", "binary", fullBinaryPath)
+	}
+
 	if newestSettings.skipFullBinaryPathCheck {
 		return fullBinaryPath
 	}
@@ -310,6 +328,24 @@ func checkExecutable(ctx context.Context, potentialBinary string, args ...string
 	return supressRoutineErrors(execErr)
 }
 
+// versionPattern matches the version in the output of `--version`
+var versionPattern = regexp.MustCompile(`\d+\.\d+\.\d+[0-9A-Za-z.\-+]*`)
+
+// binaryVersion returns the version reported by the binary's
+// `--version` flag, or an empty string if it can't be found.
+func binaryVersion(ctx context.Context, binaryPath string) string {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binaryPath, "--version")
+	cmd.Env = append(cmd.Env, "LAUNCHER_SKIP_UPDATES=TRUE")
+
+	// Ignore the error, some binaries exit non-zero after printing
+	// their version.
+	out, _ := cmd.CombinedOutput()
+	return versionPattern.FindString(string(out))
+}
+
 // supressNormalErrors attempts to tell whether the error was a
 // program that has executed, and then exited, vs one that's execution
 // was entirely unsuccessful. This differentiation allows us to
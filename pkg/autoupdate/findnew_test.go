@@ -203,6 +203,29 @@ func TestFindNewestCleanup(t *testing.T) {
 	}
 }
 
+func TestFindNewestSkipsUnusable(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, binaryName := setupTestDir(t, executableUpdates)
+	ctx := context.TODO()
+	binaryPath := filepath.Join(tmpDir, binaryName)
+	updatesDir := fmt.Sprintf("%s%s", binaryPath, updateDirSuffix)
+
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "5"), &updateState{Status: StatusFailed}))
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "3"), &updateState{Status: StatusHeld}))
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "2"), &updateState{Status: StatusActive}))
+
+	expectedNewest := filepath.Join(updatesDir, "2", binaryName)
+	require.Equal(t, expectedNewest, FindNewest(ctx, binaryPath), "should skip failed and held updates")
+	require.Equal(t, expectedNewest, FindNewest(ctx, filepath.Join(updatesDir, "5", binaryName)), "should roll back from a failed update")
+
+	for _, n := range []string{"2", "1"} {
+		require.NoError(t, writeUpdateState(filepath.Join(updatesDir, n), &updateState{Status: StatusFailed}))
+	}
+	require.Equal(t, binaryPath, FindNewest(ctx, binaryPath), "no usable updates")
+	require.Equal(t, binaryPath, FindNewest(ctx, filepath.Join(updatesDir, "5", binaryName)), "should fall back to the original binary")
+}
+
 func TestCheckExecutableCorruptCleanup(t *testing.T) {
 	t.Parallel()
 
@@ -16,11 +16,8 @@ import (
 // confusingly, it's used as an error reporting channel for any kind
 // of issue. In this case, it's called with an err set.
 //
-// Second, it's called when tuf detects a change with the remote metadata.
-// The handler method will do the following:
-// 1) untar the staged download
-// 2) place binary into the updates/<timestamp> directory
-// 3) call the Updater's finalizer method, usually a restart function for the running binary.
+// Second, it's called when tuf detects a change with the remote metadata,
+// and the staged download is handled by handleStaged.
 func (u *Updater) handler() tuf.NotificationHandler {
 	return func(stagingPath string, err error) {
 		if err != nil {
@@ -31,109 +28,166 @@ func (u *Updater) handler() tuf.NotificationHandler {
 			return
 		}
 
-		level.Debug(u.logger).Log(
-			"msg", "Starting to handle a staged TUF download",
-			"file", stagingPath,
-			"target", u.target,
-		)
+		u.handleStaged(stagingPath)
+	}
+}
 
-		// We store the updated file in a dated directory. The
-		// dated directory is a bit odd, but it's plastering
-		// over how tuf works. This way we ensure we're always
-		// running the mostly recently downloaded file.  There
-		// are other patterns we should investigate if we
-		// change the way we denote stable in notary.
-		updateDir := filepath.Join(u.updatesDirectory, strconv.FormatInt(time.Now().Unix(), 10))
+// handleStaged does the following with a staged download:
+// 1) untar the staged download
+// 2) place binary into the updates/<timestamp> directory
+// 3) record the update's version, and hold it if the Policy doesn't allow it
+// 4) call the Updater's finalizer method, usually a restart function for the running binary.
+//
+// It returns whether the update was installed or held. Otherwise, it
+// failed, and may be retried.
+func (u *Updater) handleStaged(stagingPath string) bool {
+	level.Debug(u.logger).Log(
+		"msg", "Starting to handle a staged TUF download",
+		"file", stagingPath,
+		"target", u.target,
+	)
 
-		// Note that this is expecting the binary in the
-		// tarball to be named binaryName. There some some
-		// extension weirdness issues on windows vs posix.
-		outputBinary := filepath.Join(updateDir, u.binaryName)
+	// We store the updated file in a dated directory. The
+	// dated directory is a bit odd, but it's plastering
+	// over how tuf works. This way we ensure we're always
+	// running the mostly recently downloaded file.  There
+	// are other patterns we should investigate if we
+	// change the way we denote stable in notary.
+	updateDir := filepath.Join(u.updatesDirectory, strconv.FormatInt(time.Now().Unix(), 10))
 
-		if err := os.MkdirAll(updateDir, 0755); err != nil {
-			level.Error(u.logger).Log(
-				"msg", "making updates directory",
-				"dir", updateDir,
-				"err", err)
-			return
-		}
+	// Note that this is expecting the binary in the
+	// tarball to be named binaryName. There some some
+	// extension weirdness issues on windows vs posix.
+	outputBinary := filepath.Join(updateDir, u.binaryName)
 
-		cleanupBrokenUpdate := func() {
-			if err := os.RemoveAll(updateDir); err != nil {
-				level.Error(u.logger).Log(
-					"msg", "failed to removed broken update directory",
-					"updateDir", updateDir,
-					"err", err,
-				)
-			}
-		}
+	if err := os.MkdirAll(updateDir, 0755); err != nil {
+		level.Error(u.logger).Log(
+			"msg", "making updates directory",
+			"dir", updateDir,
+			"err", err)
+		return false
+	}
 
-		// The UntarBundle(destination, source) paths are a
-		// little weird. Source is a tarball, obvious
-		// enough. But destination is a string that's passed
-		// through filepath.Dir. Which means it strips off the
-		// last component.
-		if err := fsutil.UntarBundle(outputBinary, stagingPath); err != nil {
+	cleanupBrokenUpdate := func() {
+		if err := os.RemoveAll(updateDir); err != nil {
 			level.Error(u.logger).Log(
-				"msg", "untar downloaded target",
-				"binary", outputBinary,
+				"msg", "failed to removed broken update directory",
+				"updateDir", updateDir,
 				"err", err,
 			)
-			cleanupBrokenUpdate()
-			return
 		}
+	}
 
-		// Ensure it's executable
-		if err := os.Chmod(outputBinary, 0755); err != nil {
-			level.Error(u.logger).Log(
-				"msg", "setting +x permissions on binary",
-				"binary", outputBinary,
-				"err", err,
-			)
-			cleanupBrokenUpdate()
-			return
-		}
+	// The UntarBundle(destination, source) paths are a
+	// little weird. Source is a tarball, obvious
+	// enough. But destination is a string that's passed
+	// through filepath.Dir. Which means it strips off the
+	// last component.
+	if err := fsutil.UntarBundle(outputBinary, stagingPath); err != nil {
+		level.Error(u.logger).Log(
+			"msg", "untar downloaded target",
+			"binary", outputBinary,
+			"err", err,
+		)
+		cleanupBrokenUpdate()
+		return false
+	}
 
-		// Check that it all came through okay
-		if err := checkExecutable(context.TODO(), outputBinary, "--version"); err != nil {
-			level.Error(u.logger).Log(
-				"msg", "Broken updated binary. Removing",
-				"target", u.target,
-				"outputBinary", outputBinary,
-				"err", err,
-			)
+	// Ensure it's executable
+	if err := os.Chmod(outputBinary, 0755); err != nil {
+		level.Error(u.logger).Log(
+			"msg", "setting +x permissions on binary",
+			"binary", outputBinary,
+			"err", err,
+		)
+		cleanupBrokenUpdate()
+		return false
+	}
+
+	// Check that it all came through okay
+	if err := checkExecutable(context.TODO(), outputBinary, "--version"); err != nil {
+		level.Error(u.logger).Log(
+			"msg", "Broken updated binary. Removing",
+			"target", u.target,
+			"outputBinary", outputBinary,
+			"err", err,
+		)
+		cleanupBrokenUpdate()
+		u.recordDecision("", ActionRejected, err.Error())
+		return false
+	}
+
+	// Record the version, and whether the policy allows it. If
+	// the state can't be written, a held update would look like a
+	// usable one, so it's removed.
+	version := binaryVersion(context.TODO(), outputBinary)
+	state := &updateState{Version: version, Status: StatusActive, Recorded: true}
+	action := ActionDownloaded
+	if ok, reason := u.allows(version); !ok {
+		state.Status = StatusHeld
+		state.Reason = reason
+		action = ActionHeld
+	}
+
+	if err := writeUpdateState(updateDir, state); err != nil {
+		level.Error(u.logger).Log(
+			"msg", "writing update state",
+			"updateDir", updateDir,
+			"err", err,
+		)
+		if state.Status == StatusHeld {
 			cleanupBrokenUpdate()
-			return
+			return false
 		}
+	}
+	u.recordDecision(version, action, state.Reason)
 
+	if state.Status == StatusHeld {
 		level.Info(u.logger).Log(
-			"msg", "Updated Binary ready to go",
+			"msg", "Holding updated binary",
 			"target", u.target,
 			"outputBinary", outputBinary,
+			"version", version,
+			"reason", state.Reason,
 		)
+		return true
+	}
 
-		if err := u.finalizer(); err != nil {
-			// Some kinds of updates require a full launcher restart. For
-			// example, windows doesn't have an exec. Instead launcher exits
-			// so the service manager restarts it. There may be others.
-			if IsLauncherRestartNeededErr(err) {
-				level.Info(u.logger).Log(
-					"msg", "signaling for a full restart",
-					"binary", outputBinary,
-				)
-				u.sigChannel <- os.Interrupt
-				return
-			}
+	level.Info(u.logger).Log(
+		"msg", "Updated Binary ready to go",
+		"target", u.target,
+		"outputBinary", outputBinary,
+		"version", version,
+	)
 
-			level.Error(u.logger).Log(
-				"msg", "calling restart function for updated binary",
+	u.finalize(outputBinary)
+	return true
+}
+
+// finalize calls the finalizer, to switch to the binary now returned by
+// FindNewest, signaling for a full restart if it can't.
+func (u *Updater) finalize(outputBinary string) {
+	if err := u.finalizer(); err != nil {
+		// Some kinds of updates require a full launcher restart. For
+		// example, windows doesn't have an exec. Instead launcher exits
+		// so the service manager restarts it. There may be others.
+		if IsLauncherRestartNeededErr(err) {
+			level.Info(u.logger).Log(
+				"msg", "signaling for a full restart",
 				"binary", outputBinary,
-				"err", err)
-			// Reaching this point represents an unclear error. Trigger a restart
+			)
 			u.sigChannel <- os.Interrupt
 			return
 		}
 
-		level.Debug(u.logger).Log("msg", "completed update for binary", "binary", outputBinary)
+		level.Error(u.logger).Log(
+			"msg", "calling restart function for updated binary",
+			"binary", outputBinary,
+			"err", err)
+		// Reaching this point represents an unclear error. Trigger a restart
+		u.sigChannel <- os.Interrupt
+		return
 	}
+
+	level.Debug(u.logger).Log("msg", "completed update for binary", "binary", outputBinary)
 }
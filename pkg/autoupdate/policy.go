@@ -0,0 +1,167 @@
+package autoupdate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// Policy restricts which downloaded versions an Updater may use. It's
+// read each time a download is handled, and when the Updater is
+// reevaluated, so it may change at runtime.
+type Policy struct {
+	// PinnedVersion holds the binary at an exact version. Downloads of
+	// any other version are held. It takes precedence over
+	// RolloutPercent.
+	PinnedVersion string
+
+	// RolloutPercent is the percentage of hosts which use new
+	// versions. A host's place in the rollout comes from a hash of its
+	// rollout ID, so it's stable, and a host included at a given
+	// percentage is included at any higher one.
+	RolloutPercent int
+}
+
+// allows returns whether the policy lets a host with rolloutID use
+// version, and if not, why.
+func (p Policy) allows(version, rolloutID string) (bool, string) {
+	if p.PinnedVersion != "" {
+		if version == p.PinnedVersion {
+			return true, ""
+		}
+		return false, fmt.Sprintf("pinned to version %s", p.PinnedVersion)
+	}
+
+	if bucket := rolloutBucket(rolloutID); bucket >= p.RolloutPercent {
+		return false, fmt.Sprintf("rollout at %d%%, host is at %d%%", p.RolloutPercent, bucket)
+	}
+
+	return true, ""
+}
+
+// rolloutBucket places rolloutID into one of 100 buckets.
+func rolloutBucket(rolloutID string) int {
+	sum := sha256.Sum256([]byte(rolloutID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// allows returns whether the updater's policy allows version, and if
+// not, why.
+func (u *Updater) allows(version string) (bool, string) {
+	if u.policy == nil {
+		return true, ""
+	}
+	return u.policy().allows(version, u.rolloutID)
+}
+
+// recordDecision records a decision about an update of the updater's
+// binary, if the updater has a database.
+func (u *Updater) recordDecision(version string, action DecisionAction, reason string) {
+	if u.db == nil {
+		return
+	}
+
+	if err := recordDecision(u.db, Decision{
+		Time:    time.Now().UTC(),
+		Binary:  u.strippedBinaryName,
+		Version: version,
+		Action:  action,
+		Reason:  reason,
+	}); err != nil {
+		level.Info(u.logger).Log("msg", "recording autoupdate decision", "err", err)
+	}
+}
+
+// Reevaluate applies the current Policy to the downloaded updates. Held
+// updates it now allows are released, and updates it no longer allows
+// are held. If that changes which update should be used, the finalizer
+// is called. Updates that were rolled back since the last call are
+// recorded in the decision history.
+func (u *Updater) Reevaluate() error {
+	dirEntries, err := ioutil.ReadDir(u.updatesDirectory)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrap(err, "reading updates directory")
+	}
+
+	changed := false
+	for _, entry := range dirEntries {
+		if !entry.IsDir() {
+			continue
+		}
+		updateDir := filepath.Join(u.updatesDirectory, entry.Name())
+
+		state, err := readUpdateState(updateDir)
+		if err != nil {
+			level.Info(u.logger).Log("msg", "reading update state", "updateDir", updateDir, "err", err)
+			continue
+		}
+
+		// Updates downloaded before states were recorded have been
+		// in use, so start out healthy.
+		legacy := state == nil
+		if legacy {
+			state = &updateState{
+				Version:  binaryVersion(context.TODO(), filepath.Join(updateDir, u.binaryName)),
+				Status:   StatusHealthy,
+				Recorded: true,
+			}
+		}
+
+		var action DecisionAction
+		switch ok, reason := u.allows(state.Version); {
+		case state.Status == StatusFailed && !state.Recorded:
+			action = ActionRolledBack
+		case state.Status == StatusHeld && ok:
+			state.Status = StatusActive
+			state.Reason = ""
+			action = ActionReleased
+		case (state.Status == StatusActive || state.Status == StatusHealthy) && !ok:
+			state.Status = StatusHeld
+			state.Reason = reason
+			action = ActionHeld
+		default:
+			if legacy {
+				if err := writeUpdateState(updateDir, state); err != nil {
+					level.Info(u.logger).Log("msg", "writing update state", "updateDir", updateDir, "err", err)
+				}
+			}
+			continue
+		}
+
+		state.Recorded = true
+		if err := writeUpdateState(updateDir, state); err != nil {
+			level.Info(u.logger).Log("msg", "writing update state", "updateDir", updateDir, "err", err)
+			continue
+		}
+		u.recordDecision(state.Version, action, state.Reason)
+
+		level.Info(u.logger).Log(
+			"msg", "reevaluated update",
+			"updateDir", updateDir,
+			"version", state.Version,
+			"action", action,
+			"reason", state.Reason,
+		)
+
+		if action != ActionRolledBack {
+			changed = true
+		}
+	}
+
+	if changed {
+		u.finalize(FindNewest(context.TODO(), filepath.Join(u.updatesDirectory, u.binaryName)))
+	}
+
+	return nil
+}
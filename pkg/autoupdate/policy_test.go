@@ -0,0 +1,147 @@
+package autoupdate
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name    string
+		policy  Policy
+		version string
+		allowed bool
+	}{
+		{name: "full rollout", policy: Policy{RolloutPercent: 100}, version: "1.0.0", allowed: true},
+		{name: "no rollout", policy: Policy{RolloutPercent: 0}, version: "1.0.0", allowed: false},
+		{name: "pinned", policy: Policy{PinnedVersion: "1.0.0"}, version: "1.0.0", allowed: true},
+		{name: "pinned elsewhere", policy: Policy{PinnedVersion: "1.0.0", RolloutPercent: 100}, version: "1.0.1", allowed: false},
+		{name: "pinned without version", policy: Policy{PinnedVersion: "1.0.0"}, version: "", allowed: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			allowed, reason := tt.policy.allows(tt.version, "host")
+			require.Equal(t, tt.allowed, allowed)
+			if !allowed {
+				require.NotEmpty(t, reason)
+			}
+		})
+	}
+}
+
+func TestRolloutBuckets(t *testing.T) {
+	t.Parallel()
+
+	counts := make([]int, 100)
+	for i := 0; i < 10000; i++ {
+		id := fmt.Sprintf("host-%d", i)
+		bucket := rolloutBucket(id)
+		require.Equal(t, bucket, rolloutBucket(id), "buckets are stable")
+		counts[bucket]++
+
+		// A host included at some percentage is included at any higher one
+		included := false
+		for percent := 0; percent <= 100; percent += 10 {
+			allowed, _ := Policy{RolloutPercent: percent}.allows("1.0.0", id)
+			require.False(t, included && !allowed, "host %s dropped out of the rollout at %d%%", id, percent)
+			included = allowed
+		}
+		require.True(t, included)
+	}
+
+	for bucket, count := range counts {
+		require.InDelta(t, 100, count, 50, "bucket %d is uneven", bucket)
+	}
+}
+
+func TestReevaluate(t *testing.T) {
+	t.Parallel()
+
+	tmpDir, binaryName := setupTestDir(t, executableUpdates)
+	ctx := context.TODO()
+	binaryPath := filepath.Join(tmpDir, binaryName)
+	updatesDir := fmt.Sprintf("%s%s", binaryPath, updateDirSuffix)
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "autoupdate.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	policy := Policy{PinnedVersion: "1.1.0"}
+	finalized := 0
+	u, err := NewUpdater(binaryPath, t.TempDir(),
+		withoutBootstrap(),
+		WithDB(db),
+		WithPolicy(func() Policy { return policy }),
+		WithFinalizer(func() error { finalized++; return nil }),
+	)
+	require.NoError(t, err)
+
+	// Update 1 predates update states
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "2"), &updateState{Version: "1.0.0", Status: StatusHealthy, Recorded: true}))
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "3"), &updateState{Version: "1.1.0", Status: StatusActive, Recorded: true}))
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "5"), &updateState{Version: "1.2.0", Status: StatusHeld, Recorded: true}))
+
+	require.NoError(t, u.Reevaluate())
+	require.Equal(t, 1, finalized)
+	require.Equal(t, filepath.Join(updatesDir, "3", binaryName), FindNewest(ctx, binaryPath), "pinned version")
+
+	// Nothing changed, so nothing to do
+	require.NoError(t, u.Reevaluate())
+	require.Equal(t, 1, finalized)
+
+	// A rollback is recorded, but already happened
+	require.NoError(t, writeUpdateState(filepath.Join(updatesDir, "3"), &updateState{Version: "1.1.0", Status: StatusFailed, Reason: "crash looped"}))
+	require.NoError(t, u.Reevaluate())
+	require.Equal(t, 1, finalized)
+
+	policy = Policy{RolloutPercent: 100}
+	require.NoError(t, u.Reevaluate())
+	require.Equal(t, 2, finalized)
+	require.Equal(t, filepath.Join(updatesDir, "5", binaryName), FindNewest(ctx, binaryPath), "released newest version")
+
+	decisions, err := GetDecisions(db)
+	require.NoError(t, err)
+
+	var actions []string
+	for _, d := range decisions {
+		require.Equal(t, "binary", d.Binary)
+		actions = append(actions, fmt.Sprintf("%s %s", d.Action, d.Version))
+	}
+	require.Equal(t, []string{
+		"held ",
+		"held 1.0.0",
+		"rolled_back 1.1.0",
+		"released ",
+		"released 1.0.0",
+		"released 1.2.0",
+	}, actions)
+}
+
+func TestRecordDecisionPrunes(t *testing.T) {
+	t.Parallel()
+
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "autoupdate.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	for i := 0; i < maxDecisions+10; i++ {
+		require.NoError(t, recordDecision(db, Decision{Version: fmt.Sprintf("%d", i), Action: ActionDownloaded}))
+	}
+
+	decisions, err := GetDecisions(db)
+	require.NoError(t, err)
+	require.Len(t, decisions, maxDecisions)
+	require.Equal(t, "10", decisions[0].Version)
+	require.Equal(t, fmt.Sprintf("%d", maxDecisions+9), decisions[maxDecisions-1].Version)
+}
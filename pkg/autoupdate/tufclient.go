@@ -0,0 +1,421 @@
+package autoupdate
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	tufclient "github.com/theupdateframework/go-tuf/client"
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/util"
+)
+
+// defaultCheckInterval is how often the TUF client checks for updates,
+// if the updater isn't configured with an interval.
+const defaultCheckInterval = 1 * time.Hour
+
+// handledTargetFile records the hashes of the last target the TUF
+// client installed or held, in its metadata directory. Once it has, a
+// target is only downloaded again when its hashes change.
+const handledTargetFile = "handled_target.json"
+
+// peerDownloadTimeout is how long downloading the target from peers may
+// take, before falling back to the repo.
+const peerDownloadTimeout = 10 * time.Minute
+
+// runTUFClient is Run, for updaters configured by WithTUFRepo. It checks
+// for updates immediately, and then on the updater's check interval,
+// until the stop function is called.
+func (u *Updater) runTUFClient() (stop func(), err error) {
+	interval := defaultCheckInterval
+	if u.checkInterval != nil {
+		interval = u.checkInterval()
+	}
+	if interval <= 0 {
+		return nil, errors.Errorf("invalid %s TUF check interval %s", u.strippedBinaryName, interval)
+	}
+
+	client, err := u.newTUFClient()
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating %s TUF client", u.strippedBinaryName)
+	}
+
+	level.Debug(u.logger).Log(
+		"msg", "Running TUF client",
+		"targetName", u.target,
+		"repo", u.tufRepo,
+		"metadataPath", u.tufMetadataPath,
+		"stagingPath", u.stagingPath,
+		"interval", interval,
+	)
+
+	quit := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			u.checkTUFRepo(client)
+
+			select {
+			case <-quit:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(quit)
+		<-done
+	}, nil
+}
+
+// newTUFClient returns a client for the updater's repo. The first time,
+// it trusts the configured root metadata. After that, it trusts the
+// root it has stored, which follows any root rotations.
+func (u *Updater) newTUFClient() (*tufclient.Client, error) {
+	remote, err := newTUFRemoteStore(u.tufRepo, u.client)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating remote store")
+	}
+
+	local, err := newFileLocalStore(u.tufMetadataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating local store")
+	}
+
+	client := tufclient.NewClient(local, remote)
+
+	meta, err := local.GetMeta()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading local metadata")
+	}
+	if _, ok := meta["root.json"]; !ok {
+		if len(u.tufRoot) == 0 {
+			return nil, errors.New("no trusted root metadata")
+		}
+		if err := client.InitLocal(u.tufRoot); err != nil {
+			return nil, errors.Wrap(err, "initializing from trusted root metadata")
+		}
+	}
+
+	return client, nil
+}
+
+// checkTUFRepo updates the TUF metadata, and if the target has changed
+// since it was last handled, downloads it and passes it to the handler.
+// Errors are passed to the handler too, as the Notary client does.
+func (u *Updater) checkTUFRepo(client *tufclient.Client) {
+	handler := u.handler()
+
+	if _, err := client.Update(); err != nil {
+		handler("", errors.Wrap(err, "updating TUF metadata"))
+		return
+	}
+
+	// Target searches delegations, if the target isn't in the
+	// top-level targets.
+	meta, err := client.Target(u.target)
+	if err != nil {
+		handler("", errors.Wrapf(err, "finding target %s", u.target))
+		return
+	}
+
+	hashes, err := json.Marshal(meta.Hashes)
+	if err != nil {
+		handler("", errors.Wrap(err, "marshalling target hashes"))
+		return
+	}
+
+	handledFile := filepath.Join(u.tufMetadataPath, handledTargetFile)
+	handled, err := ioutil.ReadFile(handledFile)
+	if err == nil && string(handled) == string(hashes) {
+		level.Debug(u.logger).Log("msg", "target unchanged", "target", u.target)
+		return
+	}
+
+	if err := os.MkdirAll(u.stagingPath, 0755); err != nil {
+		handler("", errors.Wrap(err, "making staging directory"))
+		return
+	}
+
+	stagedFile := filepath.Join(u.stagingPath, filepath.Base(u.target))
+	if err := u.downloadTarget(client, meta, stagedFile); err != nil {
+		handler("", errors.Wrapf(err, "downloading target %s", u.target))
+		return
+	}
+	defer os.Remove(stagedFile)
+
+	if u.peerCache != nil {
+		u.updatePeerCache(stagedFile, meta.Hashes, handled)
+	}
+
+	// A target that couldn't be installed is retried on the next
+	// check, rather than only when it changes.
+	if !u.handleStaged(stagedFile) {
+		return
+	}
+
+	if err := ioutil.WriteFile(handledFile, hashes, 0644); err != nil {
+		level.Info(u.logger).Log("msg", "recording handled target", "target", u.target, "err", err)
+	}
+}
+
+// downloadTarget downloads the target to stagedFile. If the updater has
+// a peer cache, and a peer has a copy matching the target's metadata,
+// that's used. Otherwise, it's downloaded from the repo.
+func (u *Updater) downloadTarget(client *tufclient.Client, meta data.TargetFileMeta, stagedFile string) error {
+	if u.peerCache != nil {
+		err := u.downloadFromPeers(meta, stagedFile)
+		if err == nil {
+			return nil
+		}
+		level.Debug(u.logger).Log("msg", "downloading target from peers", "target", u.target, "err", err)
+	}
+
+	dest, err := os.Create(stagedFile)
+	if err != nil {
+		return errors.Wrap(err, "creating staged file")
+	}
+
+	// Download verifies the length and hashes, and removes the staged
+	// file if they're wrong.
+	err = client.Download(u.target, &stagedDestination{dest})
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// downloadFromPeers downloads the target to stagedFile from the first
+// peer with a copy matching the target's metadata.
+func (u *Updater) downloadFromPeers(meta data.TargetFileMeta, stagedFile string) error {
+	hash, ok := peerCacheKey(meta.Hashes)
+	if !ok {
+		return errors.New("target has no sha512 or sha256 hash")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), peerDownloadTimeout)
+	defer cancel()
+
+	for _, peer := range u.peerCache.Peers(ctx) {
+		err := u.downloadFromPeer(ctx, peer, hash, meta, stagedFile)
+		if err == nil {
+			level.Info(u.logger).Log("msg", "downloaded target from peer", "target", u.target, "peer", peer)
+			return nil
+		}
+		level.Debug(u.logger).Log("msg", "downloading target from peer", "target", u.target, "peer", peer, "err", err)
+	}
+
+	return errors.New("no peer has the target")
+}
+
+func (u *Updater) downloadFromPeer(ctx context.Context, peer, hash string, meta data.TargetFileMeta, stagedFile string) error {
+	body, err := u.peerCache.Get(ctx, peer, hash, meta.Length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	dest, err := os.Create(stagedFile)
+	if err != nil {
+		return errors.Wrap(err, "creating staged file")
+	}
+
+	_, err = io.Copy(dest, body)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyTarget(stagedFile, meta)
+	}
+	if err != nil {
+		os.Remove(stagedFile)
+		return err
+	}
+
+	return nil
+}
+
+// peerCacheKey returns the hash a target is stored in the peer cache
+// as. It's the strongest hash the metadata has, which the peer cache
+// supports.
+func peerCacheKey(hashes data.Hashes) (string, bool) {
+	for _, algorithm := range []string{"sha512", "sha256"} {
+		if hash, ok := hashes[algorithm]; ok {
+			return hash.String(), true
+		}
+	}
+	return "", false
+}
+
+// verifyTarget checks the length and hashes of the file at path against
+// the target's metadata.
+func verifyTarget(path string, meta data.TargetFileMeta) error {
+	var algorithms []string
+	for algorithm := range meta.Hashes {
+		if algorithm == "sha256" || algorithm == "sha512" {
+			algorithms = append(algorithms, algorithm)
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening downloaded target")
+	}
+	defer f.Close()
+
+	actual, err := util.GenerateTargetFileMeta(f, algorithms...)
+	if err != nil {
+		return errors.Wrap(err, "hashing downloaded target")
+	}
+
+	return errors.Wrap(util.TargetFileMetaEqual(actual, meta), "verifying downloaded target")
+}
+
+// updatePeerCache adds the verified download at stagedFile to the peer
+// cache, replacing the previously handled target.
+func (u *Updater) updatePeerCache(stagedFile string, hashes data.Hashes, handled []byte) {
+	if hash, ok := peerCacheKey(hashes); ok {
+		if err := u.peerCache.Add(hash, stagedFile); err != nil {
+			level.Info(u.logger).Log("msg", "adding target to peer cache", "target", u.target, "err", err)
+		}
+	}
+
+	var handledHashes data.Hashes
+	if err := json.Unmarshal(handled, &handledHashes); err != nil {
+		return
+	}
+	if hash, ok := peerCacheKey(handledHashes); ok {
+		if err := u.peerCache.Remove(hash); err != nil {
+			level.Info(u.logger).Log("msg", "removing target from peer cache", "target", u.target, "err", err)
+		}
+	}
+}
+
+// stagedDestination is a tufclient.Destination, writing to a file.
+type stagedDestination struct {
+	*os.File
+}
+
+func (d *stagedDestination) Delete() error {
+	d.File.Close()
+	return os.Remove(d.File.Name())
+}
+
+// newTUFRemoteStore returns a remote store for repo, which is either an
+// http(s) URL, or a path to a local directory. Either way, the metadata
+// is at the top of the repo, and the targets under `targets/`.
+func newTUFRemoteStore(repo string, client *http.Client) (tufclient.RemoteStore, error) {
+	if strings.HasPrefix(repo, "http://") || strings.HasPrefix(repo, "https://") {
+		return tufclient.HTTPRemoteStore(strings.TrimSuffix(repo, "/"), nil, client)
+	}
+
+	dir := strings.TrimPrefix(repo, "file://")
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "checking repo directory")
+	}
+	if !info.IsDir() {
+		return nil, errors.Errorf("repo %s is not a directory", dir)
+	}
+
+	return fileRemoteStore(dir), nil
+}
+
+// fileRemoteStore is a tufclient.RemoteStore reading from a directory,
+// for repos on local or network file systems.
+type fileRemoteStore string
+
+func (f fileRemoteStore) GetMeta(name string) (io.ReadCloser, int64, error) {
+	return f.open(name)
+}
+
+func (f fileRemoteStore) GetTarget(path string) (io.ReadCloser, int64, error) {
+	return f.open(filepath.Join("targets", filepath.FromSlash(path)))
+}
+
+func (f fileRemoteStore) open(name string) (io.ReadCloser, int64, error) {
+	// Cleaning the name as a rooted path keeps it within the repo
+	file, err := os.Open(filepath.Join(string(f), filepath.Clean(string(filepath.Separator)+name)))
+	if os.IsNotExist(err) {
+		return nil, 0, tufclient.ErrNotFound{File: name}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+
+	return file, info.Size(), nil
+}
+
+// fileLocalStore is a tufclient.LocalStore, keeping each piece of
+// metadata in its own file in a directory.
+type fileLocalStore string
+
+func newFileLocalStore(dir string) (fileLocalStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", errors.Wrapf(err, "mkdir %s", dir)
+	}
+	return fileLocalStore(dir), nil
+}
+
+func (f fileLocalStore) GetMeta() (map[string]json.RawMessage, error) {
+	files, err := filepath.Glob(filepath.Join(string(f), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	meta := make(map[string]json.RawMessage)
+	for _, file := range files {
+		name := filepath.Base(file)
+		if name == handledTargetFile {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", name)
+		}
+		meta[name] = data
+	}
+
+	return meta, nil
+}
+
+func (f fileLocalStore) SetMeta(name string, meta json.RawMessage) error {
+	file := filepath.Join(string(f), filepath.Base(name))
+	if err := ioutil.WriteFile(file+".tmp", meta, 0644); err != nil {
+		return errors.Wrapf(err, "writing %s", name)
+	}
+	return errors.Wrapf(os.Rename(file+".tmp", file), "renaming %s", name)
+}
+
+func (f fileLocalStore) DeleteMeta(name string) error {
+	err := os.Remove(filepath.Join(string(f), filepath.Base(name)))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f fileLocalStore) Close() error {
+	return nil
+}
@@ -0,0 +1,368 @@
+package autoupdate
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/kolide/launcher/pkg/peercache"
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/go-tuf"
+	"github.com/theupdateframework/go-tuf/data"
+	"github.com/theupdateframework/go-tuf/pkg/keys"
+)
+
+func TestTUFClient(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test binaries are shell scripts")
+	}
+
+	var tests = []struct {
+		name   string
+		mirror func(t *testing.T, repoDir string) string
+	}{
+		{
+			name: "file",
+			mirror: func(t *testing.T, repoDir string) string {
+				return filepath.Join(repoDir, "repository")
+			},
+		},
+		{
+			name: "http",
+			mirror: func(t *testing.T, repoDir string) string {
+				server := httptest.NewServer(http.FileServer(http.Dir(filepath.Join(repoDir, "repository"))))
+				t.Cleanup(server.Close)
+				return server.URL + "/"
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			binaryDir := t.TempDir()
+			rootDir := t.TempDir()
+			repoDir := t.TempDir()
+
+			finalized := 0
+			u, err := NewUpdater(filepath.Join(binaryDir, "launcher"), rootDir, WithFinalizer(func() error {
+				finalized++
+				return nil
+			}), withoutBootstrap())
+			require.NoError(t, err)
+
+			repo := newTestTUFRepo(t, repoDir, u.target)
+			repo.publish(t, "1.0.0", false)
+
+			rootJSON, err := ioutil.ReadFile(filepath.Join(repoDir, "repository", "root.json"))
+			require.NoError(t, err)
+			WithTUFRepo(tt.mirror(t, repoDir), rootJSON)(u)
+
+			client, err := u.newTUFClient()
+			require.NoError(t, err)
+
+			u.checkTUFRepo(client)
+			require.Equal(t, 1, finalized, "finalized after the first download")
+			requireNewestVersion(t, u, "1.0.0")
+
+			// An unchanged target isn't downloaded again
+			u.checkTUFRepo(client)
+			require.Equal(t, 1, finalized, "finalized after checking an unchanged target")
+
+			// Rotating the root key, and then publishing a new version,
+			// requires following the rotation.
+			repo.publish(t, "1.1.0", true)
+
+			u.checkTUFRepo(client)
+			require.Equal(t, 2, finalized, "finalized after the root rotation")
+			requireNewestVersion(t, u, "1.1.0")
+
+			// A new client trusts the rotated root it stored, not the
+			// configured one.
+			client, err = u.newTUFClient()
+			require.NoError(t, err)
+			repo.publish(t, "1.2.0", false)
+
+			u.checkTUFRepo(client)
+			require.Equal(t, 3, finalized, "finalized after restarting the client")
+			requireNewestVersion(t, u, "1.2.0")
+		})
+	}
+}
+
+func TestTUFClientRejectsTamperedTarget(t *testing.T) {
+	t.Parallel()
+
+	binaryDir := t.TempDir()
+	rootDir := t.TempDir()
+	repoDir := t.TempDir()
+
+	finalized := 0
+	u, err := NewUpdater(filepath.Join(binaryDir, "launcher"), rootDir, WithFinalizer(func() error {
+		finalized++
+		return nil
+	}), withoutBootstrap())
+	require.NoError(t, err)
+
+	repo := newTestTUFRepo(t, repoDir, u.target)
+	repo.publish(t, "1.0.0", false)
+
+	// Replace the published target, without updating the metadata
+	targetPaths, err := filepath.Glob(filepath.Join(repoDir, "repository", "targets", "*", "*.tar.gz"))
+	require.NoError(t, err)
+	require.NotEmpty(t, targetPaths)
+	for _, targetPath := range targetPaths {
+		writeTestTarball(t, targetPath, "6.6.6")
+	}
+
+	rootJSON, err := ioutil.ReadFile(filepath.Join(repoDir, "repository", "root.json"))
+	require.NoError(t, err)
+	WithTUFRepo(filepath.Join(repoDir, "repository"), rootJSON)(u)
+
+	client, err := u.newTUFClient()
+	require.NoError(t, err)
+
+	u.checkTUFRepo(client)
+	require.Equal(t, 0, finalized)
+
+	_, err = os.Stat(u.updatesDirectory)
+	require.True(t, os.IsNotExist(err), "no update downloaded")
+}
+
+func TestTUFClientRetriesFailedTarget(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test binaries are shell scripts")
+	}
+
+	binaryDir := t.TempDir()
+	rootDir := t.TempDir()
+	repoDir := t.TempDir()
+
+	finalized := 0
+	u, err := NewUpdater(filepath.Join(binaryDir, "launcher"), rootDir, WithFinalizer(func() error {
+		finalized++
+		return nil
+	}), withoutBootstrap())
+	require.NoError(t, err)
+
+	repo := newTestTUFRepo(t, repoDir, u.target)
+	repo.publish(t, "1.0.0", false)
+
+	rootJSON, err := ioutil.ReadFile(filepath.Join(repoDir, "repository", "root.json"))
+	require.NoError(t, err)
+	WithTUFRepo(filepath.Join(repoDir, "repository"), rootJSON)(u)
+
+	client, err := u.newTUFClient()
+	require.NoError(t, err)
+
+	// With a file in the way of the updates directory, the handler
+	// can't install the target
+	require.NoError(t, ioutil.WriteFile(u.updatesDirectory, nil, 0644))
+	u.checkTUFRepo(client)
+	require.Equal(t, 0, finalized)
+
+	_, err = os.Stat(filepath.Join(u.tufMetadataPath, handledTargetFile))
+	require.True(t, os.IsNotExist(err), "failed target isn't recorded as handled")
+
+	// The unchanged target is retried
+	require.NoError(t, os.Remove(u.updatesDirectory))
+	u.checkTUFRepo(client)
+	require.Equal(t, 1, finalized)
+	requireNewestVersion(t, u, "1.0.0")
+}
+
+func TestTUFClientRequiresInterval(t *testing.T) {
+	t.Parallel()
+
+	u, err := NewUpdater("/tmp/app", t.TempDir(), WithTUFRepo(t.TempDir(), nil))
+	require.NoError(t, err)
+	u.checkInterval = func() time.Duration { return 0 }
+
+	_, err = u.runTUFClient()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "check interval")
+}
+
+func TestTUFClientPeerCache(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS == "windows" {
+		t.Skip("test binaries are shell scripts")
+	}
+
+	repoDir := t.TempDir()
+	repoPath := filepath.Join(repoDir, "repository")
+
+	newPeer := func(peers ...string) (*Updater, *int) {
+		cache, err := peercache.New(t.TempDir(), peercache.WithPeers(peers...))
+		require.NoError(t, err)
+
+		finalized := 0
+		u, err := NewUpdater(filepath.Join(t.TempDir(), "launcher"), t.TempDir(), WithFinalizer(func() error {
+			finalized++
+			return nil
+		}), withoutBootstrap(), WithPeerCache(cache))
+		require.NoError(t, err)
+		return u, &finalized
+	}
+
+	// The first peer downloads from the repo, and caches it
+	seed, seedFinalized := newPeer()
+	seedServer := httptest.NewServer(seed.peerCache)
+	defer seedServer.Close()
+
+	repo := newTestTUFRepo(t, repoDir, seed.target)
+	repo.publish(t, "1.0.0", false)
+
+	rootJSON, err := ioutil.ReadFile(filepath.Join(repoPath, "root.json"))
+	require.NoError(t, err)
+	WithTUFRepo(repoPath, rootJSON)(seed)
+
+	client, err := seed.newTUFClient()
+	require.NoError(t, err)
+	seed.checkTUFRepo(client)
+	require.Equal(t, 1, *seedFinalized)
+
+	// With the target removed from the repo, the second peer can only
+	// download it from the first.
+	targetsDir := filepath.Join(repoPath, "targets")
+	require.NoError(t, os.Rename(targetsDir, targetsDir+".moved"))
+
+	consumer, consumerFinalized := newPeer(seedServer.URL)
+	WithTUFRepo(repoPath, rootJSON)(consumer)
+
+	client, err = consumer.newTUFClient()
+	require.NoError(t, err)
+	consumer.checkTUFRepo(client)
+	require.Equal(t, 1, *consumerFinalized, "downloaded from the peer")
+	requireNewestVersion(t, consumer, "1.0.0")
+
+	// A peer serving something other than the target isn't trusted
+	require.NoError(t, os.Rename(targetsDir+".moved", targetsDir))
+	repo.publish(t, "1.1.0", false)
+
+	poisoned := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tarball := filepath.Join(t.TempDir(), "poisoned.tar.gz")
+		writeTestTarball(t, tarball, "6.6.6")
+		http.ServeFile(w, r, tarball)
+	}))
+	defer poisoned.Close()
+
+	victim, victimFinalized := newPeer(poisoned.URL)
+	WithTUFRepo(repoPath, rootJSON)(victim)
+
+	client, err = victim.newTUFClient()
+	require.NoError(t, err)
+	victim.checkTUFRepo(client)
+	require.Equal(t, 1, *victimFinalized, "downloaded from the repo")
+	requireNewestVersion(t, victim, "1.1.0")
+}
+
+func TestNewTUFClientRequiresRoot(t *testing.T) {
+	t.Parallel()
+
+	u, err := NewUpdater("/tmp/app", t.TempDir(), WithTUFRepo(t.TempDir(), nil))
+	require.NoError(t, err)
+
+	_, err = u.newTUFClient()
+	require.Error(t, err)
+}
+
+func requireNewestVersion(t *testing.T, u *Updater, version string) {
+	newest := FindNewest(context.TODO(), filepath.Join(u.updatesDirectory, u.binaryName))
+	require.Equal(t, version, binaryVersion(context.TODO(), newest))
+}
+
+// testTUFRepo is a TUF repo, with consistent snapshots, whose targets
+// are delegated to a releases role.
+type testTUFRepo struct {
+	*tuf.Repo
+	dir    string
+	target string
+}
+
+func newTestTUFRepo(t *testing.T, dir, target string) *testTUFRepo {
+	local := tuf.FileSystemStore(dir, nil)
+	repo, err := tuf.NewRepo(local)
+	require.NoError(t, err)
+	require.NoError(t, repo.Init(true))
+
+	for _, role := range []string{"root", "targets", "snapshot", "timestamp"} {
+		_, err := repo.GenKey(role)
+		require.NoError(t, err)
+	}
+
+	releasesKey, err := keys.GenerateEd25519Key()
+	require.NoError(t, err)
+	require.NoError(t, local.SaveSigner("releases", releasesKey))
+	require.NoError(t, repo.AddDelegatedRole("targets", data.DelegatedRole{
+		Name:      "releases",
+		KeyIDs:    releasesKey.PublicData().IDs(),
+		Paths:     []string{"*/*"},
+		Threshold: 1,
+	}, []*data.PublicKey{releasesKey.PublicData()}))
+
+	return &testTUFRepo{Repo: repo, dir: dir, target: target}
+}
+
+// publish publishes a tarball of a binary reporting version. If
+// rotateRoot is set, the root key is replaced first.
+func (r *testTUFRepo) publish(t *testing.T, version string, rotateRoot bool) {
+	if rotateRoot {
+		oldKeys, err := r.RootKeys()
+		require.NoError(t, err)
+		_, err = r.GenKey("root")
+		require.NoError(t, err)
+		for _, key := range oldKeys {
+			for _, id := range key.IDs() {
+				require.NoError(t, r.RevokeKey("root", id))
+			}
+		}
+	}
+
+	stagedTarget := filepath.Join(r.dir, "staged", "targets", filepath.FromSlash(r.target))
+	require.NoError(t, os.MkdirAll(filepath.Dir(stagedTarget), 0755))
+	writeTestTarball(t, stagedTarget, version)
+
+	require.NoError(t, r.AddTargetToPreferredRole(r.target, nil, "releases"))
+	require.NoError(t, r.Snapshot())
+	require.NoError(t, r.Timestamp())
+	require.NoError(t, r.Commit())
+}
+
+// writeTestTarball writes a tarball of a launcher shell script, which
+// reports version.
+func writeTestTarball(t *testing.T, path, version string) {
+	script := []byte(fmt.Sprintf("#!/bin/sh\necho launcher - version %s\n", version))
+
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{
+		Name: "launcher",
+		Mode: 0755,
+		Size: int64(len(script)),
+	}))
+	_, err = tw.Write(script)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+}
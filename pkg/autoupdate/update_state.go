@@ -0,0 +1,218 @@
+package autoupdate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// updateStateFile is written alongside each downloaded binary, in its
+// update directory, to record how the update has fared. Updates
+// without one predate it, and are treated as usable.
+const updateStateFile = "update.json"
+
+// HealthyAfter is how long an updated binary must run before it's
+// considered healthy. Until then, each start counts towards
+// maxUnhealthyStarts, and each crash towards maxUnhealthyCrashes.
+const HealthyAfter = 10 * time.Minute
+
+// maxUnhealthyStarts is how many times an update may be started without
+// becoming healthy, before it's considered to be crash looping, and is
+// rolled back.
+const maxUnhealthyStarts = 5
+
+// maxUnhealthyCrashes is how many times an update may crash without
+// becoming healthy, before it's rolled back. It's for binaries, like
+// osqueryd, which launcher restarts itself, and may run several of.
+const maxUnhealthyCrashes = 5
+
+type UpdateStatus string
+
+const (
+	// StatusActive updates may be used, but haven't yet run for
+	// HealthyAfter.
+	StatusActive UpdateStatus = "active"
+	// StatusHealthy updates have run for HealthyAfter.
+	StatusHealthy UpdateStatus = "healthy"
+	// StatusHeld updates were downloaded, but the Policy doesn't allow
+	// them.
+	StatusHeld UpdateStatus = "held"
+	// StatusFailed updates were rolled back, and are not used again.
+	StatusFailed UpdateStatus = "failed"
+)
+
+type updateState struct {
+	Version  string       `json:"version"`
+	Status   UpdateStatus `json:"status"`
+	Reason   string       `json:"reason,omitempty"`
+	Starts   int          `json:"starts"`
+	Recorded bool         `json:"recorded,omitempty"` // whether the status is in the decision history
+
+	Crashes   int        `json:"crashes,omitempty"`
+	LastCrash *time.Time `json:"last_crash,omitempty"`
+}
+
+// usable returns whether FindNewest may pick the update.
+func (s *updateState) usable() bool {
+	return s == nil || (s.Status != StatusHeld && s.Status != StatusFailed)
+}
+
+// readUpdateState reads the state of the update in updateDir. It returns
+// nil, and no error, if the update has no state.
+func readUpdateState(updateDir string) (*updateState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(updateDir, updateStateFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "reading update state")
+	}
+
+	var state updateState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling update state")
+	}
+	return &state, nil
+}
+
+// writeUpdateState writes the state of the update in updateDir. It's
+// written to a temporary file first, so a crash can't leave it half
+// written.
+func writeUpdateState(updateDir string, state *updateState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "marshalling update state")
+	}
+
+	tmpFile := filepath.Join(updateDir, updateStateFile+".tmp")
+	if err := ioutil.WriteFile(tmpFile, data, 0644); err != nil {
+		return errors.Wrap(err, "writing update state")
+	}
+	if err := os.Rename(tmpFile, filepath.Join(updateDir, updateStateFile)); err != nil {
+		return errors.Wrap(err, "renaming update state")
+	}
+	return nil
+}
+
+// RecordStart records that binaryPath is being started. It returns
+// false if the binary has now been started too many times without
+// becoming healthy, in which case it's marked as failed, and the caller
+// should call FindNewest again to find the binary to roll back to.
+//
+// Binaries which aren't updates, or which are already healthy, are
+// always fine to start.
+func RecordStart(binaryPath string) (bool, error) {
+	updateDir := filepath.Dir(binaryPath)
+	if !isUpdateDir(updateDir) {
+		return true, nil
+	}
+
+	state, err := readUpdateState(updateDir)
+	if err != nil {
+		return true, err
+	}
+	if state == nil || state.Status == StatusHealthy {
+		return true, nil
+	}
+	if !state.usable() {
+		return false, nil
+	}
+
+	state.Starts++
+	ok := true
+	if state.Starts > maxUnhealthyStarts {
+		state.Status = StatusFailed
+		state.Reason = fmt.Sprintf("started %d times without running for %s", state.Starts, HealthyAfter)
+		state.Recorded = false
+		ok = false
+	}
+
+	if err := writeUpdateState(updateDir, state); err != nil {
+		return true, err
+	}
+	return ok, nil
+}
+
+// RecordCrash records that binaryPath exited, or failed to start,
+// without being asked to. It returns false if the update has now
+// crashed too many times without becoming healthy, in which case it's
+// marked as failed, and FindNewest won't return it again.
+//
+// Binaries which aren't updates, or which are already healthy, are
+// never failed.
+func RecordCrash(binaryPath string) (bool, error) {
+	updateDir := filepath.Dir(binaryPath)
+	if !isUpdateDir(updateDir) {
+		return true, nil
+	}
+
+	state, err := readUpdateState(updateDir)
+	if err != nil {
+		return true, err
+	}
+	if state == nil || state.Status == StatusHealthy {
+		return true, nil
+	}
+	if !state.usable() {
+		return false, nil
+	}
+
+	now := time.Now()
+	state.Crashes++
+	state.LastCrash = &now
+	ok := true
+	if state.Crashes > maxUnhealthyCrashes {
+		state.Status = StatusFailed
+		state.Reason = fmt.Sprintf("crashed %d times without running for %s", state.Crashes, HealthyAfter)
+		state.Recorded = false
+		ok = false
+	}
+
+	if err := writeUpdateState(updateDir, state); err != nil {
+		return true, err
+	}
+	return ok, nil
+}
+
+// MarkHealthy records that binaryPath has run for HealthyAfter, so it's
+// no longer at risk of being rolled back.
+func MarkHealthy(binaryPath string) error {
+	return MarkHealthySince(binaryPath, time.Now())
+}
+
+// MarkHealthySince is MarkHealthy, for a run of binaryPath which started
+// at since. It does nothing if another run has crashed since then, so
+// one instance running for HealthyAfter doesn't clear an update that
+// others are crash looping on.
+func MarkHealthySince(binaryPath string, since time.Time) error {
+	updateDir := filepath.Dir(binaryPath)
+	if !isUpdateDir(updateDir) {
+		return nil
+	}
+
+	state, err := readUpdateState(updateDir)
+	if err != nil || state == nil || state.Status != StatusActive {
+		return err
+	}
+	if state.LastCrash != nil && state.LastCrash.After(since) {
+		return nil
+	}
+
+	state.Status = StatusHealthy
+	state.Starts = 0
+	state.Crashes = 0
+	state.LastCrash = nil
+	return writeUpdateState(updateDir, state)
+}
+
+// isUpdateDir returns whether dir is one of the directories updates are
+// downloaded into.
+func isUpdateDir(dir string) bool {
+	return strings.HasSuffix(filepath.Dir(dir), updateDirSuffix)
+}
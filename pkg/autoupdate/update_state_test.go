@@ -0,0 +1,142 @@
+package autoupdate
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordStart(t *testing.T) {
+	t.Parallel()
+
+	updateDir := filepath.Join(t.TempDir(), "binary-updates", "1")
+	require.NoError(t, os.MkdirAll(updateDir, 0755))
+	binaryPath := filepath.Join(updateDir, "binary")
+
+	ok, err := RecordStart(binaryPath)
+	require.NoError(t, err)
+	require.True(t, ok, "binaries without state are always fine")
+
+	ok, err = RecordStart("")
+	require.NoError(t, err)
+	require.True(t, ok, "binaries outside of an update directory are always fine")
+
+	require.NoError(t, writeUpdateState(updateDir, &updateState{Version: "1.2.3", Status: StatusActive}))
+
+	for i := 1; i <= maxUnhealthyStarts; i++ {
+		ok, err := RecordStart(binaryPath)
+		require.NoError(t, err)
+		require.True(t, ok, "start %d", i)
+	}
+
+	ok, err = RecordStart(binaryPath)
+	require.NoError(t, err)
+	require.False(t, ok, "too many starts without becoming healthy")
+
+	state, err := readUpdateState(updateDir)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, state.Status)
+	require.Equal(t, "1.2.3", state.Version)
+	require.False(t, state.usable())
+	require.False(t, state.Recorded, "rollback is not yet in the decision history")
+
+	// Marking a failed update healthy doesn't revive it
+	require.NoError(t, MarkHealthy(binaryPath))
+	ok, err = RecordStart(binaryPath)
+	require.NoError(t, err)
+	require.False(t, ok)
+}
+
+func TestMarkHealthy(t *testing.T) {
+	t.Parallel()
+
+	updateDir := filepath.Join(t.TempDir(), "binary-updates", "1")
+	require.NoError(t, os.MkdirAll(updateDir, 0755))
+	binaryPath := filepath.Join(updateDir, "binary")
+
+	require.NoError(t, MarkHealthy(binaryPath), "binaries without state are ignored")
+
+	require.NoError(t, writeUpdateState(updateDir, &updateState{Version: "1.2.3", Status: StatusActive}))
+	for i := 0; i < maxUnhealthyStarts; i++ {
+		_, err := RecordStart(binaryPath)
+		require.NoError(t, err)
+	}
+	require.NoError(t, MarkHealthy(binaryPath))
+
+	// Once healthy, starts no longer count
+	for i := 0; i < 2*maxUnhealthyStarts; i++ {
+		ok, err := RecordStart(binaryPath)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+
+	state, err := readUpdateState(updateDir)
+	require.NoError(t, err)
+	require.Equal(t, &updateState{Version: "1.2.3", Status: StatusHealthy}, state)
+}
+
+func TestRecordCrash(t *testing.T) {
+	t.Parallel()
+
+	updateDir := filepath.Join(t.TempDir(), "binary-updates", "1")
+	require.NoError(t, os.MkdirAll(updateDir, 0755))
+	binaryPath := filepath.Join(updateDir, "binary")
+
+	ok, err := RecordCrash(binaryPath)
+	require.NoError(t, err)
+	require.True(t, ok, "binaries without state are always fine")
+
+	require.NoError(t, writeUpdateState(updateDir, &updateState{Version: "1.2.3", Status: StatusActive}))
+
+	for i := 1; i <= maxUnhealthyCrashes; i++ {
+		ok, err := RecordCrash(binaryPath)
+		require.NoError(t, err)
+		require.True(t, ok, "crash %d", i)
+	}
+
+	ok, err = RecordCrash(binaryPath)
+	require.NoError(t, err)
+	require.False(t, ok, "too many crashes without becoming healthy")
+
+	state, err := readUpdateState(updateDir)
+	require.NoError(t, err)
+	require.Equal(t, StatusFailed, state.Status)
+	require.False(t, state.usable())
+}
+
+func TestMarkHealthySince(t *testing.T) {
+	t.Parallel()
+
+	updateDir := filepath.Join(t.TempDir(), "binary-updates", "1")
+	require.NoError(t, os.MkdirAll(updateDir, 0755))
+	binaryPath := filepath.Join(updateDir, "binary")
+
+	require.NoError(t, writeUpdateState(updateDir, &updateState{Version: "1.2.3", Status: StatusActive}))
+
+	// One run started, and then another crashed
+	started := time.Now().Add(-HealthyAfter)
+	ok, err := RecordCrash(binaryPath)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	require.NoError(t, MarkHealthySince(binaryPath, started))
+	state, err := readUpdateState(updateDir)
+	require.NoError(t, err)
+	require.Equal(t, StatusActive, state.Status, "not healthy while another run is crashing")
+
+	// A run started after the crash can mark it healthy
+	require.NoError(t, MarkHealthySince(binaryPath, time.Now()))
+	state, err = readUpdateState(updateDir)
+	require.NoError(t, err)
+	require.Equal(t, &updateState{Version: "1.2.3", Status: StatusHealthy}, state)
+
+	// Once healthy, crashes no longer count
+	for i := 0; i < 2*maxUnhealthyCrashes; i++ {
+		ok, err := RecordCrash(binaryPath)
+		require.NoError(t, err)
+		require.True(t, ok)
+	}
+}
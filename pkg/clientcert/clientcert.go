@@ -0,0 +1,132 @@
+// Package clientcert provides the client certificates launcher presents
+// to the server for mutual TLS. They're either read from files, or
+// generated from the launcher key and signed by the server.
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// FileSource serves a client certificate and key read from PEM files.
+// The files are reread when they change, so the certificate can be
+// rotated by replacing them.
+type FileSource struct {
+	certPath string
+	keyPath  string
+	logger   log.Logger
+
+	lock      sync.Mutex
+	cert      *tls.Certificate
+	loadedMod time.Time
+}
+
+// NewFileSource returns a FileSource for the certificate chain at certPath
+// and its private key at keyPath.
+func NewFileSource(certPath, keyPath string, logger log.Logger) (*FileSource, error) {
+	f := &FileSource{
+		certPath: certPath,
+		keyPath:  keyPath,
+		logger:   logger,
+	}
+
+	modTime, err := f.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := f.load(modTime); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// GetClientCertificate returns the certificate, first reloading it if the
+// files have changed. It has the signature of tls.Config's
+// GetClientCertificate.
+func (f *FileSource) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	// If the files can't be reloaded, perhaps because they're midway
+	// through being replaced, the last good certificate is used.
+	if modTime, err := f.modTime(); err != nil {
+		level.Info(f.logger).Log("msg", "checking client certificate files", "err", err)
+	} else if modTime.After(f.loadedMod) {
+		if err := f.load(modTime); err != nil {
+			level.Info(f.logger).Log("msg", "reloading client certificate", "err", err)
+		}
+	}
+
+	return f.cert, nil
+}
+
+// load reads the certificate and key, which must be called with the lock
+// held or before f is shared.
+func (f *FileSource) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		return errors.Wrap(err, "loading client certificate")
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return errors.Wrap(err, "parsing client certificate")
+	}
+
+	level.Info(f.logger).Log(
+		"msg", "loaded client certificate",
+		"subject", cert.Leaf.Subject.String(),
+		"not_after", cert.Leaf.NotAfter,
+	)
+
+	f.cert = &cert
+	f.loadedMod = modTime
+	return nil
+}
+
+// modTime returns the latest modification time of the two files.
+func (f *FileSource) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{f.certPath, f.keyPath} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, errors.Wrap(err, "stat client certificate file")
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// parseChain decodes a PEM encoded certificate chain, leaf first.
+func parseChain(chainPEM []byte) ([][]byte, *x509.Certificate, error) {
+	var chain [][]byte
+	for {
+		var block *pem.Block
+		block, chainPEM = pem.Decode(chainPEM)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			chain = append(chain, block.Bytes)
+		}
+	}
+	if len(chain) == 0 {
+		return nil, nil, errors.New("no certificates found in PEM")
+	}
+
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parsing certificate")
+	}
+
+	return chain, leaf, nil
+}
@@ -0,0 +1,81 @@
+package clientcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// writeSelfSigned writes a self signed certificate and its key, with the
+// given serial number, to certPath and keyPath.
+func writeSelfSigned(t *testing.T, certPath, keyPath string, serial int64) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "launcher"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+
+	require.NoError(t, ioutil.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	require.NoError(t, ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+}
+
+func TestFileSourceReloads(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certPath, keyPath := filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key")
+	writeSelfSigned(t, certPath, keyPath, 1)
+
+	source, err := NewFileSource(certPath, keyPath, log.NewNopLogger())
+	require.NoError(t, err)
+
+	cert, err := source.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), cert.Leaf.SerialNumber.Int64())
+
+	// Replace the files, making sure the modification time moves on.
+	writeSelfSigned(t, certPath, keyPath, 2)
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, later, later))
+
+	cert, err = source.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+
+	// A broken replacement leaves the last good certificate in use.
+	require.NoError(t, ioutil.WriteFile(certPath, []byte("not a cert"), 0600))
+	evenLater := later.Add(time.Minute)
+	require.NoError(t, os.Chtimes(certPath, evenLater, evenLater))
+
+	cert, err = source.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, int64(2), cert.Leaf.SerialNumber.Int64())
+}
+
+func TestFileSourceMissing(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	_, err := NewFileSource(filepath.Join(dir, "client.crt"), filepath.Join(dir, "client.key"), log.NewNopLogger())
+	require.Error(t, err)
+}
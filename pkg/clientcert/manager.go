@@ -0,0 +1,284 @@
+package clientcert
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// bucketName is the bucket the signed certificate is stored in
+	bucketName = "client_certificate"
+	chainKey   = "chain"
+
+	defaultCheckInterval = 10 * time.Minute
+)
+
+// Signer has a certificate request signed, returning the PEM encoded
+// certificate chain. service.CSRSigner is the usual implementation.
+type Signer interface {
+	SignCSR(ctx context.Context, nodeKey string, csrPEM []byte) ([]byte, error)
+}
+
+// Manager serves a client certificate for the launcher key, which it has
+// the server sign once launcher has enrolled. The certificate is stored
+// in bbolt, and renewed once two thirds of its lifetime has passed, so
+// that it's replaced well before it expires.
+type Manager struct {
+	db            *bbolt.DB
+	signer        Signer
+	logger        log.Logger
+	checkInterval time.Duration
+	now           func() time.Time
+	onUpdate      func()
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+
+	interrupt chan struct{}
+}
+
+// Option configures a Manager
+type Option func(*Manager)
+
+// WithLogger sets the logger
+func WithLogger(logger log.Logger) Option {
+	return func(m *Manager) {
+		m.logger = logger
+	}
+}
+
+// WithCheckInterval sets how often the certificate is checked for
+// renewal. It's also how often a certificate is requested, before launcher
+// has one.
+func WithCheckInterval(interval time.Duration) Option {
+	return func(m *Manager) {
+		m.checkInterval = interval
+	}
+}
+
+// WithUpdateHook sets a function called each time a new certificate is
+// obtained. Connections made with the previous certificate, or none, stay
+// authenticated with it until they're closed, so the hook should close
+// them.
+func WithUpdateHook(f func()) Option {
+	return func(m *Manager) {
+		m.onUpdate = f
+	}
+}
+
+// NewManager returns a Manager using the launcher key stored in db, which
+// must already have been set up with osquery.SetupLauncherKeys.
+func NewManager(db *bbolt.DB, signer Signer, opts ...Option) (*Manager, error) {
+	m := &Manager{
+		db:            db,
+		signer:        signer,
+		logger:        log.NewNopLogger(),
+		checkInterval: defaultCheckInterval,
+		now:           time.Now,
+		interrupt:     make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	if err := db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "creating client certificate bucket")
+	}
+
+	// A stored certificate that can't be used is replaced on the first
+	// check, so it's not an error here.
+	if err := m.loadStored(); err != nil {
+		level.Info(m.logger).Log("msg", "ignoring stored client certificate", "err", err)
+	}
+
+	return m, nil
+}
+
+// GetClientCertificate returns the current certificate, or an empty one
+// before the server has signed one. It has the signature of tls.Config's
+// GetClientCertificate.
+func (m *Manager) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if m.cert == nil {
+		return &tls.Certificate{}, nil
+	}
+	return m.cert, nil
+}
+
+// Execute checks the certificate on the check interval, renewing it as
+// needed, until interrupted.
+func (m *Manager) Execute() error {
+	// Interrupting also abandons any request in flight.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-m.interrupt:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(m.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := m.renewIfNeeded(ctx); err != nil {
+			level.Info(m.logger).Log("msg", "renewing client certificate", "err", err)
+		}
+
+		select {
+		case <-m.interrupt:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// Interrupt stops Execute
+func (m *Manager) Interrupt(err error) {
+	close(m.interrupt)
+}
+
+// renewIfNeeded requests a new certificate if there's none yet, or the
+// current one is due for renewal. Nothing is requested until launcher has
+// enrolled, as the node key authenticates the request.
+func (m *Manager) renewIfNeeded(ctx context.Context) error {
+	m.lock.RLock()
+	current := m.cert
+	m.lock.RUnlock()
+
+	if current != nil && m.now().Before(renewalTime(current.Leaf)) {
+		return nil
+	}
+
+	nodeKey, err := osquery.NodeKeyFromDB(m.db)
+	if err != nil {
+		return errors.Wrap(err, "reading node key")
+	}
+	if nodeKey == "" {
+		return nil
+	}
+
+	key, err := osquery.PrivateKeyFromDB(m.db)
+	if err != nil {
+		return errors.Wrap(err, "reading launcher key")
+	}
+
+	identifier, err := osquery.IdentifierFromDB(m.db)
+	if err != nil {
+		return errors.Wrap(err, "reading host identifier")
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: identifier},
+	}, key)
+	if err != nil {
+		return errors.Wrap(err, "creating certificate request")
+	}
+	csrPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+
+	chainPEM, err := m.signer.SignCSR(ctx, nodeKey, csrPEM)
+	if err != nil {
+		return errors.Wrap(err, "signing certificate request")
+	}
+
+	cert, err := certificateFor(chainPEM, key)
+	if err != nil {
+		return err
+	}
+
+	if err := m.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(bucketName)).Put([]byte(chainKey), chainPEM)
+	}); err != nil {
+		return errors.Wrap(err, "storing client certificate")
+	}
+
+	m.lock.Lock()
+	m.cert = cert
+	m.lock.Unlock()
+
+	level.Info(m.logger).Log(
+		"msg", "obtained client certificate",
+		"not_after", cert.Leaf.NotAfter,
+		"renew_at", renewalTime(cert.Leaf),
+	)
+
+	if m.onUpdate != nil {
+		m.onUpdate()
+	}
+
+	return nil
+}
+
+// loadStored loads the certificate stored by a previous run.
+func (m *Manager) loadStored() error {
+	var chainPEM []byte
+	if err := m.db.View(func(tx *bbolt.Tx) error {
+		chainPEM = tx.Bucket([]byte(bucketName)).Get([]byte(chainKey))
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "reading stored client certificate")
+	}
+	if chainPEM == nil {
+		return nil
+	}
+
+	key, err := osquery.PrivateKeyFromDB(m.db)
+	if err != nil {
+		return errors.Wrap(err, "reading launcher key")
+	}
+
+	cert, err := certificateFor(chainPEM, key)
+	if err != nil {
+		return err
+	}
+
+	m.cert = cert
+	return nil
+}
+
+// certificateFor pairs a signed certificate chain with key, checking that
+// the chain is in fact for key.
+func certificateFor(chainPEM []byte, key *rsa.PrivateKey) (*tls.Certificate, error) {
+	chain, leaf, err := parseChain(chainPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	leafKey, ok := leaf.PublicKey.(*rsa.PublicKey)
+	if !ok || leafKey.N.Cmp(key.N) != 0 || leafKey.E != key.E {
+		return nil, errors.New("certificate is not for the launcher key")
+	}
+
+	return &tls.Certificate{
+		Certificate: chain,
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}, nil
+}
+
+// renewalTime is when two thirds of cert's lifetime has passed.
+func renewalTime(cert *x509.Certificate) time.Time {
+	lifetime := cert.NotAfter.Sub(cert.NotBefore)
+	return cert.NotBefore.Add(lifetime * 2 / 3)
+}
@@ -0,0 +1,187 @@
+package clientcert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kolide/launcher/pkg/osquery"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+// testCA signs certificate requests, like the server would.
+type testCA struct {
+	t        *testing.T
+	key      *ecdsa.PrivateKey
+	cert     *x509.Certificate
+	now      time.Time
+	lifetime time.Duration
+	nodeKeys []string
+	serial   int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{t: t, key: key, cert: cert, now: time.Now(), lifetime: 3 * time.Hour, serial: 1}
+}
+
+func (ca *testCA) SignCSR(ctx context.Context, nodeKey string, csrPEM []byte) ([]byte, error) {
+	ca.nodeKeys = append(ca.nodeKeys, nodeKey)
+
+	block, _ := pem.Decode(csrPEM)
+	require.NotNil(ca.t, block)
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	require.NoError(ca.t, err)
+	require.NoError(ca.t, csr.CheckSignature())
+
+	ca.serial++
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      csr.Subject,
+		NotBefore:    ca.now,
+		NotAfter:     ca.now.Add(ca.lifetime),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, csr.PublicKey, ca.key)
+	require.NoError(ca.t, err)
+
+	chain := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	return append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})...), nil
+}
+
+func setupDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "launcher.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	require.NoError(t, osquery.SetupLauncherKeys(db))
+	return db
+}
+
+func setNodeKey(t *testing.T, db *bbolt.DB, nodeKey string) {
+	require.NoError(t, db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte("config")).Put([]byte("nodeKey"), []byte(nodeKey))
+	}))
+}
+
+func TestManagerObtainsAndRenews(t *testing.T) {
+	t.Parallel()
+
+	db := setupDB(t)
+	ca := newTestCA(t)
+
+	var updates int
+	m, err := NewManager(db, ca, WithUpdateHook(func() { updates++ }))
+	require.NoError(t, err)
+
+	// Before enrollment there's no node key, so nothing is requested.
+	require.NoError(t, m.renewIfNeeded(context.Background()))
+	require.Empty(t, ca.nodeKeys)
+	require.Equal(t, 0, updates)
+	cert, err := m.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Empty(t, cert.Certificate)
+
+	setNodeKey(t, db, "node_key")
+	require.NoError(t, m.renewIfNeeded(context.Background()))
+	require.Equal(t, []string{"node_key"}, ca.nodeKeys)
+	require.Equal(t, 1, updates, "hook is called for the first certificate")
+
+	cert, err = m.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Len(t, cert.Certificate, 2)
+	identifier, err := osquery.IdentifierFromDB(db)
+	require.NoError(t, err)
+	require.Equal(t, identifier, cert.Leaf.Subject.CommonName)
+	first := cert.Leaf.SerialNumber
+
+	// Not yet due for renewal
+	m.now = func() time.Time { return ca.now.Add(time.Hour) }
+	require.NoError(t, m.renewIfNeeded(context.Background()))
+	require.Len(t, ca.nodeKeys, 1)
+	require.Equal(t, 1, updates)
+
+	// Two thirds of the way through its lifetime, it's renewed.
+	m.now = func() time.Time { return ca.now.Add(2*time.Hour + time.Minute) }
+	require.NoError(t, m.renewIfNeeded(context.Background()))
+	require.Len(t, ca.nodeKeys, 2)
+	require.Equal(t, 2, updates, "hook is called for the renewed certificate")
+	cert, err = m.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.NotEqual(t, first, cert.Leaf.SerialNumber)
+
+	// A new manager picks up the stored certificate.
+	m2, err := NewManager(db, ca)
+	require.NoError(t, err)
+	stored, err := m2.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Equal(t, cert.Leaf.SerialNumber, stored.Leaf.SerialNumber)
+}
+
+// wrongKeySigner issues certificates for a key other than the launcher
+// key.
+type wrongKeySigner struct {
+	*testCA
+}
+
+func (s wrongKeySigner) SignCSR(ctx context.Context, nodeKey string, csrPEM []byte) ([]byte, error) {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.cert.Raw}), nil
+}
+
+func TestManagerRejectsCertificateForOtherKey(t *testing.T) {
+	t.Parallel()
+
+	db := setupDB(t)
+	setNodeKey(t, db, "node_key")
+
+	m, err := NewManager(db, wrongKeySigner{newTestCA(t)})
+	require.NoError(t, err)
+
+	require.Error(t, m.renewIfNeeded(context.Background()))
+	cert, err := m.GetClientCertificate(nil)
+	require.NoError(t, err)
+	require.Empty(t, cert.Certificate)
+}
+
+func TestManagerInterrupt(t *testing.T) {
+	t.Parallel()
+
+	m, err := NewManager(setupDB(t), newTestCA(t), WithCheckInterval(time.Millisecond))
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- m.Execute() }()
+	time.Sleep(10 * time.Millisecond)
+	m.Interrupt(nil)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Execute did not return after Interrupt")
+	}
+}
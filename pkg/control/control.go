@@ -3,24 +3,71 @@ package control
 import (
 	"bytes"
 	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/krypto"
+	"github.com/kolide/launcher/pkg/proxy"
 	"github.com/pkg/errors"
 	"go.etcd.io/bbolt"
 )
 
+const (
+	// controlPath is the endpoint the subsystem list is fetched from
+	controlPath = "/api/v1/control"
+
+	defaultGetInterval = 60 * time.Second
+)
+
+// Consumer is implemented by the launcher subsystems which want to
+// receive data from the control server. Update is called with the raw
+// subsystem payload whenever it differs from the last one seen.
+type Consumer interface {
+	Update(io.Reader) error
+}
+
+// Response is the envelope returned by the control server. Data is the
+// base64 encoded JSON document describing the subsystems, and Sig is
+// the base64 encoded signature over the decoded Data.
+type Response struct {
+	Data string `json:"data"`
+	Sig  string `json:"sig,omitempty"`
+}
+
+// Subsystems is the document carried in Response.Data. It maps the
+// subsystem name to its current payload.
+type Subsystems map[string]json.RawMessage
+
 type Client struct {
-	addr       string
-	baseURL    *url.URL
+	addr        string
+	baseURL     *url.URL
+	client      *http.Client
+	db          *bbolt.DB
+	insecure    bool
+	disableTLS  bool
+	logger      log.Logger
+	getInterval time.Duration
+	serverKey   *rsa.PublicKey
+
+	cancelLock sync.Mutex
 	cancel     context.CancelFunc
-	client     *http.Client
-	db         *bbolt.DB
-	insecure   bool
-	disableTLS bool
-	logger     log.Logger
+	stopped    bool
+
+	consumersLock sync.RWMutex
+	consumers     map[string]Consumer
 }
 
 func NewControlClient(db *bbolt.DB, addr string, opts ...Option) (*Client, error) {
@@ -29,11 +76,13 @@ func NewControlClient(db *bbolt.DB, addr string, opts ...Option) (*Client, error
 		return nil, errors.Wrap(err, "parsing URL")
 	}
 	c := &Client{
-		logger:  log.NewNopLogger(),
-		baseURL: baseURL,
-		client:  http.DefaultClient,
-		db:      db,
-		addr:    addr,
+		logger:      log.NewNopLogger(),
+		baseURL:     baseURL,
+		client:      &http.Client{Transport: proxy.NewTransport()},
+		db:          db,
+		addr:        addr,
+		getInterval: defaultGetInterval,
+		consumers:   make(map[string]Consumer),
 	}
 
 	for _, opt := range opts {
@@ -44,29 +93,186 @@ func NewControlClient(db *bbolt.DB, addr string, opts ...Option) (*Client, error
 		c.baseURL.Scheme = "http"
 	}
 
+	// Subsystem data changes launcher's behavior, so it's never
+	// accepted without a signature
+	if c.serverKey == nil {
+		return nil, errors.New("no control server key configured")
+	}
+
+	if c.getInterval <= 0 {
+		return nil, errors.Errorf("invalid control request interval %s", c.getInterval)
+	}
+
+	if err := createBucket(c.db); err != nil {
+		return nil, errors.Wrap(err, "creating control bucket")
+	}
+
 	return c, nil
 }
 
+// RegisterConsumer registers a consumer for the named subsystem. Only
+// one consumer may be registered per subsystem.
+func (c *Client) RegisterConsumer(subsystem string, consumer Consumer) error {
+	c.consumersLock.Lock()
+	defer c.consumersLock.Unlock()
+
+	if _, ok := c.consumers[subsystem]; ok {
+		return fmt.Errorf("consumer already registered for subsystem %s", subsystem)
+	}
+
+	c.consumers[subsystem] = consumer
+	return nil
+}
+
+// Start polls the control server on the configured interval until the
+// context is canceled, or Stop is called.
 func (c *Client) Start(ctx context.Context) {
+	c.cancelLock.Lock()
+	if c.stopped {
+		c.cancelLock.Unlock()
+		return
+	}
 	ctx, c.cancel = context.WithCancel(ctx)
+	c.cancelLock.Unlock()
+
+	ticker := time.NewTicker(c.getInterval)
+	defer ticker.Stop()
+
 	for {
+		if err := c.Fetch(ctx); err != nil {
+			level.Info(c.logger).Log("msg", "failed to fetch data from control server", "err", err)
+		}
+
 		select {
 		case <-ctx.Done():
 			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Fetch retrieves the subsystem list from the control server, and
+// dispatches any changed subsystems to their consumers. A subsystem
+// whose consumer fails will be retried on the next fetch.
+func (c *Client) Fetch(ctx context.Context) error {
+	subsystems, err := c.getSubsystems(ctx)
+	if err != nil {
+		return errors.Wrap(err, "getting subsystems")
+	}
+
+	// Dispatch in a stable order, so that logs are comparable across runs
+	names := make([]string, 0, len(subsystems))
+	for name := range subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	c.consumersLock.RLock()
+	defer c.consumersLock.RUnlock()
+
+	for _, name := range names {
+		data := subsystems[name]
+		logger := log.With(c.logger, "subsystem", name)
+
+		consumer, ok := c.consumers[name]
+		if !ok {
+			level.Debug(logger).Log("msg", "no consumer registered for subsystem, ignoring")
+			continue
+		}
+
+		hash := hashData(data)
+		lastHash, err := getLastHash(c.db, name)
+		if err != nil {
+			level.Info(logger).Log("msg", "failed to read last seen subsystem state", "err", err)
 		}
+
+		if hash == lastHash {
+			continue
+		}
+
+		if err := consumer.Update(bytes.NewReader(data)); err != nil {
+			level.Info(logger).Log("msg", "consumer failed to update", "err", err)
+			continue
+		}
+
+		if err := setLastHash(c.db, name, hash); err != nil {
+			level.Info(logger).Log("msg", "failed to store last seen subsystem state", "err", err)
+			continue
+		}
+
+		level.Debug(logger).Log("msg", "dispatched subsystem update", "hash", hash)
+	}
+
+	return nil
+}
+
+// getSubsystems fetches, verifies and decodes the subsystem list
+func (c *Client) getSubsystems(ctx context.Context) (Subsystems, error) {
+	resp, err := c.do(ctx, http.MethodGet, controlPath, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "making request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response body")
+	}
+
+	var envelope Response
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling response")
+	}
+
+	data, err := base64.StdEncoding.DecodeString(envelope.Data)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding data")
+	}
+
+	if err := c.verify(data, envelope.Sig); err != nil {
+		return nil, errors.Wrap(err, "verifying signature")
+	}
+
+	var subsystems Subsystems
+	if err := json.Unmarshal(data, &subsystems); err != nil {
+		return nil, errors.Wrap(err, "unmarshaling subsystems")
+	}
+
+	return subsystems, nil
+}
+
+// verify checks the signature over data against the server key
+func (c *Client) verify(data []byte, b64sig string) error {
+	if c.serverKey == nil {
+		return errors.New("no control server key configured")
 	}
+
+	if b64sig == "" {
+		return errors.New("response is not signed")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(b64sig)
+	if err != nil {
+		return errors.Wrap(err, "decoding signature")
+	}
+
+	return krypto.RsaVerify(c.serverKey, data, sig)
 }
 
-func (c *Client) do(verb, path string, params interface{}) (*http.Response, error) {
+func (c *Client) do(ctx context.Context, verb, path string, params interface{}) (*http.Response, error) {
 	headers := map[string]string{
 		"Content-Type": "application/json",
 		"Accept":       "application/json",
 	}
 
-	return c.doWithHeaders(verb, path, params, headers)
+	return c.doWithHeaders(ctx, verb, path, params, headers)
 }
 
-func (c *Client) doWithHeaders(verb, path string, params interface{}, headers map[string]string) (*http.Response, error) {
+func (c *Client) doWithHeaders(ctx context.Context, verb, path string, params interface{}, headers map[string]string) (*http.Response, error) {
 	var bodyBytes []byte
 	var err error
 	if params != nil {
@@ -76,7 +282,8 @@ func (c *Client) doWithHeaders(verb, path string, params interface{}, headers ma
 		}
 	}
 
-	request, err := http.NewRequest(
+	request, err := http.NewRequestWithContext(
+		ctx,
 		verb,
 		c.url(path).String(),
 		bytes.NewBuffer(bodyBytes),
@@ -97,7 +304,19 @@ func (c *Client) url(path string) *url.URL {
 	return &u
 }
 
-// Stop stops the client
+// Stop stops the client. A client which has been stopped won't start
+// again.
 func (c *Client) Stop() {
-	c.cancel()
+	c.cancelLock.Lock()
+	defer c.cancelLock.Unlock()
+
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+}
+
+func hashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
 }
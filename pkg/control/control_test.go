@@ -0,0 +1,211 @@
+package control_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kolide/krypto"
+	"github.com/kolide/launcher/pkg/control"
+	"github.com/kolide/launcher/pkg/control/controltest"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/bbolt"
+)
+
+type mockConsumer struct {
+	sync.Mutex
+	updates []string
+	err     error
+}
+
+func (m *mockConsumer) Update(r io.Reader) error {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.err != nil {
+		return m.err
+	}
+
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.updates = append(m.updates, string(data))
+	return nil
+}
+
+func (m *mockConsumer) Updates() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string{}, m.updates...)
+}
+
+func makeTestDB(t *testing.T) *bbolt.DB {
+	db, err := bbolt.Open(filepath.Join(t.TempDir(), "control.db"), 0600, nil)
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// newTestServer returns a fake control server, and the options for a
+// client trusting it
+func newTestServer(t *testing.T) (*controltest.Server, []control.Option) {
+	key, err := krypto.RsaRandomKey()
+	require.NoError(t, err)
+
+	srv := controltest.New(key)
+	t.Cleanup(srv.Close)
+
+	return srv, []control.Option{control.WithDisableTLS(), control.WithServerKey(&key.PublicKey)}
+}
+
+func TestNewControlClient(t *testing.T) {
+	t.Parallel()
+
+	key, err := krypto.RsaRandomKey()
+	require.NoError(t, err)
+
+	_, err = control.NewControlClient(makeTestDB(t), "localhost:0")
+	require.Error(t, err, "no server key")
+
+	_, err = control.NewControlClient(makeTestDB(t), "localhost:0", control.WithServerKey(&key.PublicKey), control.WithGetInterval(0))
+	require.Error(t, err, "zero interval")
+
+	_, err = control.NewControlClient(makeTestDB(t), "localhost:0", control.WithServerKey(&key.PublicKey), control.WithGetInterval(-time.Second))
+	require.Error(t, err, "negative interval")
+
+	client, err := control.NewControlClient(makeTestDB(t), "localhost:0", control.WithServerKey(&key.PublicKey))
+	require.NoError(t, err)
+
+	// Stopping before starting doesn't leave Start running
+	client.Stop()
+	done := make(chan struct{})
+	go func() {
+		client.Start(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start didn't return after Stop")
+	}
+}
+
+func TestFetchDispatchesChanges(t *testing.T) {
+	t.Parallel()
+
+	srv, opts := newTestServer(t)
+
+	db := makeTestDB(t)
+	client, err := control.NewControlClient(db, srv.Addr(), opts...)
+	require.NoError(t, err)
+
+	desktop := &mockConsumer{}
+	require.NoError(t, client.RegisterConsumer("desktop", desktop))
+	require.Error(t, client.RegisterConsumer("desktop", &mockConsumer{}), "duplicate consumers are rejected")
+
+	require.NoError(t, srv.SetSubsystem("desktop", map[string]bool{"enabled": true}))
+	require.NoError(t, srv.SetSubsystem("unconsumed", "ignored"))
+
+	require.NoError(t, client.Fetch(context.Background()))
+	require.Equal(t, []string{`{"enabled":true}`}, desktop.Updates())
+
+	// Unchanged data is not dispatched again
+	require.NoError(t, client.Fetch(context.Background()))
+	require.Equal(t, []string{`{"enabled":true}`}, desktop.Updates())
+
+	require.NoError(t, srv.SetSubsystem("desktop", map[string]bool{"enabled": false}))
+	require.NoError(t, client.Fetch(context.Background()))
+	require.Equal(t, []string{`{"enabled":true}`, `{"enabled":false}`}, desktop.Updates())
+
+	// A new client on the same db remembers what was last seen
+	client2, err := control.NewControlClient(db, srv.Addr(), opts...)
+	require.NoError(t, err)
+	desktop2 := &mockConsumer{}
+	require.NoError(t, client2.RegisterConsumer("desktop", desktop2))
+	require.NoError(t, client2.Fetch(context.Background()))
+	require.Empty(t, desktop2.Updates())
+
+	require.Equal(t, 4, srv.Requests())
+}
+
+func TestFetchRetriesFailedConsumer(t *testing.T) {
+	t.Parallel()
+
+	srv, opts := newTestServer(t)
+
+	client, err := control.NewControlClient(makeTestDB(t), srv.Addr(), opts...)
+	require.NoError(t, err)
+
+	consumer := &mockConsumer{err: io.ErrUnexpectedEOF}
+	require.NoError(t, client.RegisterConsumer("flags", consumer))
+	require.NoError(t, srv.SetSubsystem("flags", []int{1, 2, 3}))
+
+	require.NoError(t, client.Fetch(context.Background()))
+	require.Empty(t, consumer.Updates())
+
+	consumer.Lock()
+	consumer.err = nil
+	consumer.Unlock()
+
+	require.NoError(t, client.Fetch(context.Background()))
+	require.Equal(t, []string{`[1,2,3]`}, consumer.Updates())
+}
+
+func TestFetchVerifiesSignature(t *testing.T) {
+	t.Parallel()
+
+	serverKey, err := krypto.RsaRandomKey()
+	require.NoError(t, err)
+
+	malloryKey, err := krypto.RsaRandomKey()
+	require.NoError(t, err)
+
+	var tests = []struct {
+		name       string
+		signingKey *rsa.PrivateKey
+		expectErr  bool
+	}{
+		{name: "signed", signingKey: serverKey},
+		{name: "unsigned", signingKey: nil, expectErr: true},
+		{name: "wrong key", signingKey: malloryKey, expectErr: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			srv := controltest.New(tt.signingKey)
+			defer srv.Close()
+			require.NoError(t, srv.SetSubsystem("desktop", "hello"))
+
+			client, err := control.NewControlClient(
+				makeTestDB(t),
+				srv.Addr(),
+				control.WithDisableTLS(),
+				control.WithServerKey(&serverKey.PublicKey),
+			)
+			require.NoError(t, err)
+
+			consumer := &mockConsumer{}
+			require.NoError(t, client.RegisterConsumer("desktop", consumer))
+
+			err = client.Fetch(context.Background())
+			if tt.expectErr {
+				require.Error(t, err)
+				require.Empty(t, consumer.Updates())
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, []string{`"hello"`}, consumer.Updates())
+		})
+	}
+}
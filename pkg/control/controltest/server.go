@@ -0,0 +1,102 @@
+// Package controltest provides an in-process fake control server, so
+// that the control client can be exercised without network access.
+package controltest
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+
+	"github.com/kolide/krypto"
+	"github.com/kolide/launcher/pkg/control"
+)
+
+// Server is a fake control server. Subsystem data can be changed at
+// any time with SetSubsystem, and is served (signed, if a key was
+// provided) on the next request.
+type Server struct {
+	*httptest.Server
+
+	lock       sync.Mutex
+	key        *rsa.PrivateKey
+	subsystems control.Subsystems
+	requests   int
+}
+
+// New starts a plain HTTP fake control server. If key is non-nil,
+// responses are signed with it. The caller must Close the server.
+func New(key *rsa.PrivateKey) *Server {
+	s := &Server{
+		key:        key,
+		subsystems: make(control.Subsystems),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+// Addr returns the host:port of the server, suitable for passing to
+// control.NewControlClient with control.WithDisableTLS.
+func (s *Server) Addr() string {
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// SetSubsystem sets the data served for the named subsystem
+func (s *Server) SetSubsystem(name string, data interface{}) error {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subsystems[name] = raw
+	return nil
+}
+
+// Requests returns the number of control requests served
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func (s *Server) handle(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/api/v1/control" || r.Method != http.MethodGet {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+
+	data, err := json.Marshal(s.subsystems)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := control.Response{
+		Data: base64.StdEncoding.EncodeToString(data),
+	}
+
+	if s.key != nil {
+		sig, err := krypto.RsaSign(s.key, data)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.Sig = base64.StdEncoding.EncodeToString(sig)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -1,10 +1,13 @@
 package control
 
 import (
+	"crypto/rsa"
 	"crypto/tls"
 	"net/http"
+	"time"
 
 	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/proxy"
 )
 
 type Option func(*Client)
@@ -17,11 +20,9 @@ func WithLogger(logger log.Logger) Option {
 
 func WithInsecureSkipVerify() Option {
 	return func(c *Client) {
-		c.client = &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		}
+		transport := proxy.NewTransport()
+		transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+		c.client = &http.Client{Transport: transport}
 		c.insecure = true
 	}
 }
@@ -31,3 +32,18 @@ func WithDisableTLS() Option {
 		c.disableTLS = true
 	}
 }
+
+// WithGetInterval sets the interval on which the control server is polled
+func WithGetInterval(interval time.Duration) Option {
+	return func(c *Client) {
+		c.getInterval = interval
+	}
+}
+
+// WithServerKey sets the public key used to verify the signature on
+// data returned from the control server.
+func WithServerKey(key *rsa.PublicKey) Option {
+	return func(c *Client) {
+		c.serverKey = key
+	}
+}
@@ -0,0 +1,42 @@
+package control
+
+import (
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// bucketName is the bucket used to persist the last seen state of
+// each subsystem, so that consumers are not re-sent unchanged data
+// across launcher restarts.
+const bucketName = "control_subsystems"
+
+func createBucket(db *bbolt.DB) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(bucketName))
+		return err
+	})
+}
+
+func getLastHash(db *bbolt.DB, subsystem string) (string, error) {
+	var hash []byte
+	err := db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return errors.New("control bucket does not exist")
+		}
+		hash = b.Get([]byte(subsystem))
+		return nil
+	})
+
+	return string(hash), err
+}
+
+func setLastHash(db *bbolt.DB, subsystem string, hash string) error {
+	return db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(bucketName))
+		if b == nil {
+			return errors.New("control bucket does not exist")
+		}
+		return b.Put([]byte(subsystem), []byte(hash))
+	})
+}
@@ -7,7 +7,8 @@
 // xpath, though simpler.
 //
 // This tool works primarily through string interfaces, so type
-// information may be lost.
+// information may be lost. WithTypes records each value's original type
+// alongside it.
 //
 // Query Syntax
 //
@@ -32,12 +33,16 @@
 //  *  data/users/#id        Return the users, and rewrite the users array to be a map with the id as the key
 //
 // See the test suite for extensive examples.
+//
+// WithQueryLanguage accepts queries in other languages, such as
+// JMESPath, for filtering the existing syntax can't express.
 package dataflatten
 
 import (
 	"bytes"
 	"encoding/base64"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -66,11 +71,20 @@ type Flattener struct {
 	expandNestedPlist bool
 	includeNestedRaw  bool
 	includeNils       bool
+	includeTypes      bool
 	logger            log.Logger
+	maxBytes          int64
 	query             []string
+	queryExpression   string
 	queryKeyDenoter   string
+	queryLanguage     QueryLanguage
 	queryWildcard     string
 	rows              []Row
+	rowCount          int
+
+	// emit, if set, is called with each row instead of collecting them
+	// in rows.
+	emit func(Row) error
 }
 
 type FlattenOpts func(*Flattener)
@@ -83,6 +97,14 @@ func IncludeNulls() FlattenOpts {
 	}
 }
 
+// WithTypes indicates that Flatten should set each row's Type to the
+// type of its original value.
+func WithTypes() FlattenOpts {
+	return func(fl *Flattener) {
+		fl.includeTypes = true
+	}
+}
+
 // WithNestedPlist indicates that nested plists should be expanded
 func WithNestedPlist() FlattenOpts {
 	return func(fl *Flattener) {
@@ -126,6 +148,10 @@ func WithQuery(q []string) FlattenOpts {
 
 // Flatten is the entry point to the Flattener functionality.
 func Flatten(data interface{}, opts ...FlattenOpts) ([]Row, error) {
+	return newFlattener(opts...).flatten(data)
+}
+
+func newFlattener(opts ...FlattenOpts) *Flattener {
 	fl := &Flattener{
 		rows:            []Row{},
 		logger:          log.NewNopLogger(),
@@ -141,6 +167,15 @@ func Flatten(data interface{}, opts ...FlattenOpts) ([]Row, error) {
 		fl.logger = level.NewFilter(fl.logger, level.AllowInfo())
 	}
 
+	return fl
+}
+
+func (fl *Flattener) flatten(data interface{}) ([]Row, error) {
+	data, err := fl.search(data)
+	if err != nil {
+		return nil, err
+	}
+
 	if err := fl.descend([]string{}, data, 0); err != nil {
 		return nil, err
 	}
@@ -155,7 +190,7 @@ func (fl *Flattener) descend(path []string, data interface{}, depth int) error {
 	logger := log.With(fl.logger,
 		"caller", "descend",
 		"depth", depth,
-		"rows-so-far", len(fl.rows),
+		"rows-so-far", fl.rowCount,
 		"query", queryTerm,
 		"path", strings.Join(path, "/"),
 	)
@@ -163,55 +198,8 @@ func (fl *Flattener) descend(path []string, data interface{}, depth int) error {
 	switch v := data.(type) {
 	case []interface{}:
 		for i, e := range v {
-			pathKey := strconv.Itoa(i)
-			level.Debug(logger).Log("msg", "checking an array", "indexStr", pathKey)
-
-			// If the queryTerm starts with
-			// queryKeyDenoter, then we want to rewrite
-			// the path based on it. Note that this does
-			// no sanity checking. Multiple values will
-			// re-write. If the value isn't there, you get
-			// nothing. Etc.
-			//
-			// keyName == "name"
-			// keyValue == "alex" (need to test this againsty queryTerm
-			// pathKey == What we descend with
-			if strings.HasPrefix(queryTerm, fl.queryKeyDenoter) {
-				keyQuery := strings.SplitN(strings.TrimPrefix(queryTerm, fl.queryKeyDenoter), "=>", 2)
-				keyName := keyQuery[0]
-
-				innerlogger := log.With(logger, "arraykeyname", keyName)
-				level.Debug(logger).Log("msg", "attempting to coerce array into map")
-
-				e, ok := e.(map[string]interface{})
-				if !ok {
-					level.Debug(innerlogger).Log("msg", "can't coerce into map")
-					continue
-				}
-
-				// Is keyName in this array?
-				val, ok := e[keyName]
-				if !ok {
-					level.Debug(innerlogger).Log("msg", "keyName not in map")
-					continue
-				}
-
-				pathKey, ok = val.(string)
-				if !ok {
-					level.Debug(innerlogger).Log("msg", "can't coerce pathKey val into string")
-					continue
-				}
-
-				// Looks good to descend. we're overwritten both e and pathKey. Exit this conditional.
-			}
-
-			if !(isQueryMatched || fl.queryMatchArrayElement(e, i, queryTerm)) {
-				level.Debug(logger).Log("msg", "query not matched")
-				continue
-			}
-
-			if err := fl.descend(append(path, pathKey), e, depth+1); err != nil {
-				return errors.Wrap(err, "flattening array")
+			if err := fl.descendArrayElement(logger, path, i, e, depth); err != nil {
+				return err
 			}
 		}
 	case map[string]interface{}:
@@ -240,24 +228,82 @@ func (fl *Flattener) descend(path []string, data interface{}, depth int) error {
 			level.Debug(logger).Log("msg", "query not matched")
 			return nil
 		}
-		fl.rows = append(fl.rows, NewRow(path, ""))
+		return fl.addRow(path, "", TypeNull)
 	case string:
-		return fl.descendMaybePlist(path, []byte(v), depth)
+		return fl.descendMaybePlist(path, []byte(v), TypeString, depth)
 	case []byte:
 		// Most string like data comes in this way
-		return fl.descendMaybePlist(path, v, depth)
+		return fl.descendMaybePlist(path, v, TypeData, depth)
 	default:
-		if err := fl.handleStringLike(logger, path, v, depth); err != nil {
+		if err := fl.handleStringLike(logger, path, v, valueType(v), depth); err != nil {
 			return errors.Wrapf(err, "flattening at path %v", path)
 		}
 	}
 	return nil
 }
 
+// descendArrayElement descends into the i'th element of the array at
+// path, if it matches the query.
+func (fl *Flattener) descendArrayElement(logger log.Logger, path []string, i int, e interface{}, depth int) error {
+	queryTerm, isQueryMatched := fl.queryAtDepth(depth)
+
+	pathKey := strconv.Itoa(i)
+	level.Debug(logger).Log("msg", "checking an array", "indexStr", pathKey)
+
+	// If the queryTerm starts with
+	// queryKeyDenoter, then we want to rewrite
+	// the path based on it. Note that this does
+	// no sanity checking. Multiple values will
+	// re-write. If the value isn't there, you get
+	// nothing. Etc.
+	//
+	// keyName == "name"
+	// keyValue == "alex" (need to test this againsty queryTerm
+	// pathKey == What we descend with
+	if strings.HasPrefix(queryTerm, fl.queryKeyDenoter) {
+		keyQuery := strings.SplitN(strings.TrimPrefix(queryTerm, fl.queryKeyDenoter), "=>", 2)
+		keyName := keyQuery[0]
+
+		innerlogger := log.With(logger, "arraykeyname", keyName)
+		level.Debug(logger).Log("msg", "attempting to coerce array into map")
+
+		e, ok := e.(map[string]interface{})
+		if !ok {
+			level.Debug(innerlogger).Log("msg", "can't coerce into map")
+			return nil
+		}
+
+		// Is keyName in this array?
+		val, ok := e[keyName]
+		if !ok {
+			level.Debug(innerlogger).Log("msg", "keyName not in map")
+			return nil
+		}
+
+		pathKey, ok = val.(string)
+		if !ok {
+			level.Debug(innerlogger).Log("msg", "can't coerce pathKey val into string")
+			return nil
+		}
+
+		// Looks good to descend. we're overwritten both e and pathKey. Exit this conditional.
+	}
+
+	if !(isQueryMatched || fl.queryMatchArrayElement(e, i, queryTerm)) {
+		level.Debug(logger).Log("msg", "query not matched")
+		return nil
+	}
+
+	if err := fl.descend(append(path, pathKey), e, depth+1); err != nil {
+		return errors.Wrap(err, "flattening array")
+	}
+	return nil
+}
+
 // handleStringLike is called when we finally have an object we think
 // can be converted to a string. It uses the depth to compare against
 // the query, and returns a stringify'ed value
-func (fl *Flattener) handleStringLike(logger log.Logger, path []string, v interface{}, depth int) error {
+func (fl *Flattener) handleStringLike(logger log.Logger, path []string, v interface{}, valueType ValueType, depth int) error {
 	queryTerm, isQueryMatched := fl.queryAtDepth(depth)
 
 	stringValue, err := stringify(v)
@@ -270,29 +316,44 @@ func (fl *Flattener) handleStringLike(logger log.Logger, path []string, v interf
 		return nil
 	}
 
-	fl.rows = append(fl.rows, NewRow(path, stringValue))
+	return fl.addRow(path, stringValue, valueType)
+}
+
+// addRow adds a row to the results, typed if types are wanted.
+func (fl *Flattener) addRow(path []string, value string, valueType ValueType) error {
+	if !fl.includeTypes {
+		valueType = ""
+	}
+
+	fl.rowCount++
+	row := NewTypedRow(path, value, valueType)
+	if fl.emit != nil {
+		return fl.emit(row)
+	}
+
+	fl.rows = append(fl.rows, row)
 	return nil
 }
 
 // descendMaybePlist optionally tries to decode []byte data as an
 // embedded plist. In the case of failures, it falls back to treating
 // it like a plain string.
-func (fl *Flattener) descendMaybePlist(path []string, data []byte, depth int) error {
+func (fl *Flattener) descendMaybePlist(path []string, data []byte, valueType ValueType, depth int) error {
 	logger := log.With(fl.logger,
 		"caller", "descendMaybePlist",
 		"depth", depth,
-		"rows-so-far", len(fl.rows),
+		"rows-so-far", fl.rowCount,
 		"path", strings.Join(path, "/"),
 	)
 
 	// Skip if we're not expanding nested plists
 	if !fl.expandNestedPlist {
-		return fl.handleStringLike(logger, path, data, depth)
+		return fl.handleStringLike(logger, path, data, valueType, depth)
 	}
 
 	// Skip if this doesn't look like a plist.
 	if !isPlist(data) {
-		return fl.handleStringLike(logger, path, data, depth)
+		return fl.handleStringLike(logger, path, data, valueType, depth)
 	}
 
 	// Looks like a plist. Try parsing it
@@ -302,12 +363,12 @@ func (fl *Flattener) descendMaybePlist(path []string, data []byte, depth int) er
 
 	if err := plist.Unmarshal(data, &innerData); err != nil {
 		level.Info(logger).Log("msg", "plist parsing failed", "err", err)
-		return fl.handleStringLike(logger, path, data, depth)
+		return fl.handleStringLike(logger, path, data, valueType, depth)
 	}
 
 	// have a parsed plist. Descend and return from here.
 	if fl.includeNestedRaw {
-		if err := fl.handleStringLike(logger, append(path, "_raw"), data, depth); err != nil {
+		if err := fl.handleStringLike(logger, append(path, "_raw"), data, valueType, depth); err != nil {
 			level.Error(logger).Log("msg", "Failed to add _raw key", "err", err)
 		}
 	}
@@ -335,7 +396,7 @@ func (fl *Flattener) queryMatchNil(queryTerm string) bool {
 func (fl *Flattener) queryMatchArrayElement(data interface{}, arrIndex int, queryTerm string) bool {
 	logger := log.With(fl.logger,
 		"caller", "queryMatchArrayElement",
-		"rows-so-far", len(fl.rows),
+		"rows-so-far", fl.rowCount,
 		"query", queryTerm,
 		"arrIndex", arrIndex,
 	)
@@ -503,6 +564,36 @@ func stringify(data interface{}) (string, error) {
 	}
 }
 
+// valueType returns the type of a value stringify can handle. Floats
+// that hold whole numbers are integers, as JSON doesn't tell them apart.
+func valueType(data interface{}) ValueType {
+	switch v := data.(type) {
+	case nil:
+		return TypeNull
+	case []byte:
+		return TypeData
+	case uint8, uint16, uint32, uint64, int, int8, int16, int32, int64, howett.UID:
+		return TypeInteger
+	case float32:
+		return floatType(float64(v))
+	case float64:
+		return floatType(v)
+	case bool:
+		return TypeBool
+	case time.Time:
+		return TypeDate
+	default:
+		return TypeString
+	}
+}
+
+func floatType(v float64) ValueType {
+	if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+		return TypeInteger
+	}
+	return TypeFloat
+}
+
 // isPlist returns whether or not something looks like it might be a
 // plist. It uses Contains, instead of HasPrefix, as some encodings
 // have a leading character.
@@ -337,6 +337,18 @@ func TestFlatten(t *testing.T) {
 			},
 			comment: "nested types",
 		},
+		{
+			in: `{"a": "x", "b": true, "c": 2, "d": 3.3, "e": null}`,
+			out: []Row{
+				Row{Path: []string{"a"}, Value: "x", Type: TypeString},
+				Row{Path: []string{"b"}, Value: "true", Type: TypeBool},
+				Row{Path: []string{"c"}, Value: "2", Type: TypeInteger},
+				Row{Path: []string{"d"}, Value: "3.3", Type: TypeFloat},
+				Row{Path: []string{"e"}, Value: "", Type: TypeNull},
+			},
+			options: []FlattenOpts{IncludeNulls(), WithTypes()},
+			comment: "with types",
+		},
 	}
 
 	for _, tt := range tests {
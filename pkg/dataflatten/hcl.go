@@ -0,0 +1,129 @@
+package dataflatten
+
+import (
+	"io/ioutil"
+	"math/big"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/pkg/errors"
+	"github.com/zclconf/go-cty/cty"
+)
+
+func HclFile(file string, opts ...FlattenOpts) ([]Row, error) {
+	rawdata, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Hcl(rawdata, opts...)
+}
+
+// Hcl flattens HCL data, as used by terraform. Blocks become arrays, as a
+// block may be repeated, so `resource "a" "b" { c = 1 }` flattens to the
+// path resource/0/a/0/b/0/c. Expressions that can't be evaluated outside
+// of terraform, like `var.region` or function calls, are returned as they
+// were written.
+func Hcl(rawdata []byte, opts ...FlattenOpts) ([]Row, error) {
+	file, diags := hclsyntax.ParseConfig(rawdata, "", hcl.InitialPos)
+	if diags.HasErrors() {
+		return nil, errors.Wrap(diags, "parsing hcl")
+	}
+
+	body, ok := file.Body.(*hclsyntax.Body)
+	if !ok {
+		return nil, errors.New("unexpected hcl body type")
+	}
+
+	return Flatten(hclBody(body, rawdata), opts...)
+}
+
+// hclBody converts a body's attributes and blocks to a map.
+func hclBody(body *hclsyntax.Body, rawdata []byte) map[string]interface{} {
+	data := make(map[string]interface{}, len(body.Attributes)+len(body.Blocks))
+
+	for name, attr := range body.Attributes {
+		data[name] = hclExpression(attr.Expr, rawdata)
+	}
+
+	for _, block := range body.Blocks {
+		// Each label nests the body a level further down
+		var nested interface{} = []interface{}{hclBody(block.Body, rawdata)}
+		for i := len(block.Labels) - 1; i >= 0; i-- {
+			nested = []interface{}{map[string]interface{}{block.Labels[i]: nested}}
+		}
+
+		existing, _ := data[block.Type].([]interface{})
+		data[block.Type] = append(existing, nested.([]interface{})...)
+	}
+
+	return data
+}
+
+// hclExpression evaluates an expression, falling back to its source text
+// if it depends on variables or functions. Lists and objects are walked,
+// so only the elements that can't be evaluated are left as text.
+func hclExpression(expr hclsyntax.Expression, rawdata []byte) interface{} {
+	switch e := expr.(type) {
+	case *hclsyntax.TupleConsExpr:
+		list := make([]interface{}, len(e.Exprs))
+		for i, elem := range e.Exprs {
+			list[i] = hclExpression(elem, rawdata)
+		}
+		return list
+	case *hclsyntax.ObjectConsExpr:
+		m := make(map[string]interface{}, len(e.Items))
+		for _, item := range e.Items {
+			key, ok := hclExpression(item.KeyExpr, rawdata).(string)
+			if !ok {
+				key = string(item.KeyExpr.Range().SliceBytes(rawdata))
+			}
+			m[key] = hclExpression(item.ValueExpr, rawdata)
+		}
+		return m
+	}
+
+	val, diags := expr.Value(nil)
+	if diags.HasErrors() || !val.IsWhollyKnown() {
+		r := expr.Range()
+		return string(r.SliceBytes(rawdata))
+	}
+	return ctyValue(val)
+}
+
+// ctyValue converts a cty value to the plain types Flatten handles.
+func ctyValue(val cty.Value) interface{} {
+	if val.IsNull() {
+		return nil
+	}
+
+	ty := val.Type()
+	switch {
+	case ty == cty.String:
+		return val.AsString()
+	case ty == cty.Bool:
+		return val.True()
+	case ty == cty.Number:
+		bf := val.AsBigFloat()
+		if i, accuracy := bf.Int64(); accuracy == big.Exact {
+			return i
+		}
+		f, _ := bf.Float64()
+		return f
+	case ty.IsListType() || ty.IsSetType() || ty.IsTupleType():
+		list := make([]interface{}, 0, val.LengthInt())
+		for it := val.ElementIterator(); it.Next(); {
+			_, v := it.Element()
+			list = append(list, ctyValue(v))
+		}
+		return list
+	case ty.IsMapType() || ty.IsObjectType():
+		m := make(map[string]interface{}, val.LengthInt())
+		for it := val.ElementIterator(); it.Next(); {
+			k, v := it.Element()
+			m[k.AsString()] = ctyValue(v)
+		}
+		return m
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+package dataflatten
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestHcl(t *testing.T) {
+	t.Parallel()
+
+	var tests = []flattenTestCase{
+		{
+			in:      "a = {",
+			err:     true,
+			comment: "invalid",
+		},
+		{
+			in:      "",
+			out:     []Row{},
+			comment: "empty",
+		},
+		{
+			in: "a = 1\nb = 1.5\nc = [\"x\", true]\nd = null\n",
+			out: []Row{
+				Row{Path: []string{"a"}, Value: "1"},
+				Row{Path: []string{"b"}, Value: "1.5"},
+				Row{Path: []string{"c", "0"}, Value: "x"},
+				Row{Path: []string{"c", "1"}, Value: "true"},
+			},
+			comment: "attributes",
+		},
+		{
+			in: "user \"abc\" {\n  id = 1\n}\n\nuser \"def\" {\n  id = 2\n}\n\nmetadata {\n  testing = true\n}\n",
+			out: []Row{
+				Row{Path: []string{"user", "0", "abc", "0", "id"}, Value: "1"},
+				Row{Path: []string{"user", "1", "def", "0", "id"}, Value: "2"},
+				Row{Path: []string{"metadata", "0", "testing"}, Value: "true"},
+			},
+			comment: "blocks",
+		},
+		{
+			in: "a = var.region\nb = \"x-${var.env}\"\nc = upper(\"x\")\nd = { k = local.v, l = 1 }\n",
+			out: []Row{
+				Row{Path: []string{"a"}, Value: "var.region"},
+				Row{Path: []string{"b"}, Value: `"x-${var.env}"`},
+				Row{Path: []string{"c"}, Value: `upper("x")`},
+				Row{Path: []string{"d", "k"}, Value: "local.v"},
+				Row{Path: []string{"d", "l"}, Value: "1"},
+			},
+			comment: "expressions",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.comment, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Hcl([]byte(tt.in), tt.options...)
+			testFlattenCase(t, tt, actual, err)
+		})
+	}
+}
+
+func TestHclTerraform(t *testing.T) {
+	t.Parallel()
+
+	actual, err := HclFile(filepath.Join("testdata", "main.tf"))
+	testFlattenCase(t, flattenTestCase{
+		comment: "terraform",
+		out: []Row{
+			Row{Path: []string{"terraform", "0", "required_version"}, Value: ">= 1.0"},
+			Row{Path: []string{"terraform", "0", "required_providers", "0", "aws", "source"}, Value: "hashicorp/aws"},
+			Row{Path: []string{"terraform", "0", "required_providers", "0", "aws", "version"}, Value: "~> 4.0"},
+			Row{Path: []string{"variable", "0", "region", "0", "type"}, Value: "string"},
+			Row{Path: []string{"variable", "0", "region", "0", "default"}, Value: "us-east-1"},
+			Row{Path: []string{"locals", "0", "name"}, Value: `"demo-${var.region}"`},
+			Row{Path: []string{"locals", "0", "tags", "Owner"}, Value: "platform"},
+			Row{Path: []string{"locals", "0", "tags", "Env"}, Value: "var.environment"},
+			Row{Path: []string{"provider", "0", "aws", "0", "region"}, Value: "var.region"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "count"}, Value: "2"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "ami"}, Value: "data.aws_ami.ubuntu.id"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "instance_type"}, Value: "t3.micro"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "tags"}, Value: `merge(local.tags, { Name = "web-${count.index}" })`},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "root_block_device", "0", "volume_size"}, Value: "20"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "root_block_device", "0", "encrypted"}, Value: "true"},
+			Row{Path: []string{"resource", "0", "aws_instance", "0", "web", "0", "user_data"}, Value: "#!/bin/sh\necho hello\n"},
+			Row{Path: []string{"resource", "1", "aws_security_group", "0", "web", "0", "name"}, Value: "local.name"},
+			Row{Path: []string{"resource", "1", "aws_security_group", "0", "web", "0", "dynamic", "0", "ingress", "0", "for_each", "0"}, Value: "80"},
+			Row{Path: []string{"resource", "1", "aws_security_group", "0", "web", "0", "dynamic", "0", "ingress", "0", "for_each", "1"}, Value: "443"},
+			Row{Path: []string{"resource", "1", "aws_security_group", "0", "web", "0", "dynamic", "0", "ingress", "0", "content", "0", "from_port"}, Value: "ingress.value"},
+			Row{Path: []string{"resource", "1", "aws_security_group", "0", "web", "0", "dynamic", "0", "ingress", "0", "content", "0", "to_port"}, Value: "ingress.value"},
+			Row{Path: []string{"output", "0", "ips", "0", "value"}, Value: "[for instance in aws_instance.web : instance.public_ip]"},
+		},
+	}, actual, err)
+}
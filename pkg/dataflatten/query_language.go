@@ -0,0 +1,131 @@
+package dataflatten
+
+import (
+	"math"
+	"strings"
+
+	"github.com/jmespath/go-jmespath"
+	"github.com/pkg/errors"
+	howett "howett.net/plist"
+)
+
+// QueryLanguage is the language a query is written in.
+type QueryLanguage string
+
+const (
+	// QueryLanguageDataflatten is the path based syntax described in
+	// the package docs. It's the default.
+	QueryLanguageDataflatten QueryLanguage = "dataflatten"
+
+	// QueryLanguageJmespath is JMESPath (https://jmespath.org). It
+	// allows infix wildcards, several predicates and numeric
+	// comparisons. Rows are flattened from the query's result, so
+	// their paths are relative to it.
+	QueryLanguageJmespath QueryLanguage = "jmespath"
+)
+
+// Valid returns whether the language is one Flatten understands.
+func (l QueryLanguage) Valid() bool {
+	switch l {
+	case QueryLanguageDataflatten, QueryLanguageJmespath:
+		return true
+	}
+	return false
+}
+
+// WithQueryLanguage specifies a query to flatten with, written in the
+// given language. For QueryLanguageDataflatten it's the same as
+// WithQuery, with the terms separated by `/`.
+func WithQueryLanguage(language QueryLanguage, query string) FlattenOpts {
+	if language == QueryLanguageDataflatten {
+		return WithQuery(strings.Split(query, "/"))
+	}
+
+	return func(fl *Flattener) {
+		fl.queryLanguage = language
+		fl.queryExpression = query
+	}
+}
+
+// search runs the flattener's query, when it's in a language other than
+// the default, and returns the result to flatten.
+func (fl *Flattener) search(data interface{}) (interface{}, error) {
+	switch fl.queryLanguage {
+	case "", QueryLanguageDataflatten:
+		return data, nil
+	case QueryLanguageJmespath:
+		if fl.queryExpression == "" {
+			return data, nil
+		}
+		result, err := jmespath.Search(fl.queryExpression, jmespathData(data))
+		if err != nil {
+			return nil, errors.Wrap(err, "jmespath query")
+		}
+		return result, nil
+	default:
+		return nil, errors.Errorf("unknown query language %q", fl.queryLanguage)
+	}
+}
+
+// jmespathData returns a copy of data in the types JMESPath expects.
+// Numbers are float64, so they can be compared, unless doing so would
+// lose precision.
+func jmespathData(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[string]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			converted[key] = jmespathData(value)
+		}
+		return converted
+	case []interface{}:
+		converted := make([]interface{}, len(v))
+		for i, value := range v {
+			converted[i] = jmespathData(value)
+		}
+		return converted
+	case []map[string]interface{}:
+		converted := make([]interface{}, len(v))
+		for i, value := range v {
+			converted[i] = jmespathData(value)
+		}
+		return converted
+	case int:
+		return jmespathInt(int64(v))
+	case int8:
+		return float64(v)
+	case int16:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return jmespathInt(v)
+	case uint8:
+		return float64(v)
+	case uint16:
+		return float64(v)
+	case uint32:
+		return float64(v)
+	case uint64:
+		if v > 1<<53 {
+			return v
+		}
+		return float64(v)
+	case howett.UID:
+		if v > 1<<53 {
+			return v
+		}
+		return float64(v)
+	case float32:
+		return float64(v)
+	default:
+		return data
+	}
+}
+
+func jmespathInt(v int64) interface{} {
+	if math.Abs(float64(v)) > 1<<53 {
+		return v
+	}
+	return float64(v)
+}
@@ -0,0 +1,71 @@
+package dataflatten
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithQueryLanguage(t *testing.T) {
+	t.Parallel()
+
+	// Integers, as parsed from plists and yaml, rather than json's floats
+	data := map[string]interface{}{
+		"users": []interface{}{
+			map[string]interface{}{"name": "alex", "id": int64(1)},
+			map[string]interface{}{"name": "bailey", "id": uint64(2)},
+			map[string]interface{}{"name": "cam", "id": 3},
+		},
+	}
+
+	var tests = []struct {
+		language QueryLanguage
+		query    string
+		out      []Row
+		err      bool
+	}{
+		{
+			language: QueryLanguageDataflatten,
+			query:    "users/name=>ba*/id",
+			out:      []Row{{Path: []string{"users", "1", "id"}, Value: "2"}},
+		},
+		{
+			language: QueryLanguageJmespath,
+			query:    "users[?id >= `2`].name",
+			out: []Row{
+				{Path: []string{"0"}, Value: "bailey"},
+				{Path: []string{"1"}, Value: "cam"},
+			},
+		},
+		{
+			language: QueryLanguageJmespath,
+			query:    "users[?name == 'nobody']",
+			out:      []Row{},
+		},
+		{
+			language: QueryLanguageJmespath,
+			query:    "users[?",
+			err:      true,
+		},
+		{
+			language: QueryLanguage("xpath"),
+			query:    "//users",
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(string(tt.language)+" "+tt.query, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Flatten(data, WithQueryLanguage(tt.language, tt.query))
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.out, actual)
+		})
+	}
+}
@@ -2,10 +2,25 @@ package dataflatten
 
 import "strings"
 
+// ValueType is the type a value had before it was flattened to a string.
+type ValueType string
+
+const (
+	TypeString  ValueType = "string"
+	TypeInteger ValueType = "integer"
+	TypeFloat   ValueType = "float"
+	TypeBool    ValueType = "bool"
+	TypeNull    ValueType = "null"
+	TypeDate    ValueType = "date"
+	TypeData    ValueType = "data"
+)
+
 // Row is the record type we return.
 type Row struct {
 	Path  []string
 	Value string
+	// Type is only set when flattening WithTypes.
+	Type ValueType
 }
 
 // NewRow does a copy of the path elements, and returns a row. We do
@@ -19,6 +34,13 @@ func NewRow(path []string, value string) Row {
 	}
 }
 
+// NewTypedRow is NewRow, for a value of the given type.
+func NewTypedRow(path []string, value string, valueType ValueType) Row {
+	row := NewRow(path, value)
+	row.Type = valueType
+	return row
+}
+
 func (r Row) StringPath(sep string) string {
 	return strings.Join(r.Path, sep)
 }
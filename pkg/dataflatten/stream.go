@@ -0,0 +1,470 @@
+package dataflatten
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// WithMaxBytes limits how much input the streaming flatteners read.
+// Larger inputs are an error, rather than being flattened in part.
+func WithMaxBytes(maxBytes int64) FlattenOpts {
+	return func(fl *Flattener) {
+		fl.maxBytes = maxBytes
+	}
+}
+
+// JsonFileStream is JsonFile, but streams the file with JsonStream.
+func JsonFileStream(file string, opts ...FlattenOpts) ([]Row, error) {
+	return collectRows(func(emit func(Row) error) error {
+		return JsonFileStreamRows(file, emit, opts...)
+	})
+}
+
+// JsonFileStreamRows is JsonFileStream, but calls emit with each row
+// as it's flattened, rather than returning them.
+func JsonFileStreamRows(file string, emit func(Row) error, opts ...FlattenOpts) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return JsonStreamRows(f, emit, opts...)
+}
+
+// JsonStream flattens the json read from r, without unmarshalling all
+// of it first. Parts of the document the query doesn't match are
+// skipped as they're read, so memory use depends on the matched data,
+// not the document's size. The one exception is an array element
+// matched by a `k=>v` or `#k` query term, which is read whole, as the
+// term applies to its contents.
+//
+// Queries in other languages can't be applied while streaming, so with
+// them the document is read whole, as by Json.
+func JsonStream(r io.Reader, opts ...FlattenOpts) ([]Row, error) {
+	return collectRows(func(emit func(Row) error) error {
+		return JsonStreamRows(r, emit, opts...)
+	})
+}
+
+// JsonStreamRows is JsonStream, but calls emit with each row as it's
+// flattened, so the rows needn't all be held in memory. An error from
+// emit stops the flattening, and is returned.
+func JsonStreamRows(r io.Reader, emit func(Row) error, opts ...FlattenOpts) error {
+	fl := newFlattener(opts...)
+	fl.emit = emit
+	dec := json.NewDecoder(fl.limitReader(r))
+
+	if !fl.canStream() {
+		var data interface{}
+		if err := dec.Decode(&data); err != nil {
+			return errors.Wrap(err, "unmarshalling json")
+		}
+		_, err := fl.flatten(data)
+		return err
+	}
+
+	if err := fl.streamJson(dec, []string{}, 0); err != nil {
+		return errors.Wrap(err, "streaming json")
+	}
+
+	if _, err := dec.Token(); err != io.EOF {
+		return errors.New("streaming json: unexpected data after the document")
+	}
+
+	return nil
+}
+
+// streamJson flattens the next json value from dec, which is found at
+// path.
+func (fl *Flattener) streamJson(dec *json.Decoder, path []string, depth int) error {
+	token, err := dec.Token()
+	if err != nil {
+		return err
+	}
+
+	delim, ok := token.(json.Delim)
+	if !ok {
+		return fl.descend(path, token, depth)
+	}
+
+	queryTerm, isQueryMatched := fl.queryAtDepth(depth)
+
+	switch delim {
+	case '{':
+		for dec.More() {
+			token, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			key, ok := token.(string)
+			if !ok {
+				return errors.Errorf("unexpected object key %v", token)
+			}
+
+			if !(isQueryMatched || fl.queryMatchString(key, queryTerm)) {
+				if err := skipJsonValue(dec); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := fl.streamJson(dec, append(path, key), depth+1); err != nil {
+				return err
+			}
+		}
+	case '[':
+		for i := 0; dec.More(); i++ {
+			if fl.queryNeedsArrayElement(queryTerm, isQueryMatched) {
+				var e interface{}
+				if err := dec.Decode(&e); err != nil {
+					return err
+				}
+				if err := fl.descendArrayElement(fl.logger, path, i, e, depth); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if !(isQueryMatched || fl.queryMatchArrayElement(nil, i, queryTerm)) {
+				if err := skipJsonValue(dec); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if err := fl.streamJson(dec, append(path, strconv.Itoa(i)), depth+1); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("unexpected delimiter %v", delim)
+	}
+
+	// Consume the closing delimiter
+	_, err = dec.Token()
+	return err
+}
+
+// skipJsonValue reads past the next json value from dec.
+func skipJsonValue(dec *json.Decoder) error {
+	nesting := 0
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			nesting++
+		case json.Delim('}'), json.Delim(']'):
+			nesting--
+		}
+
+		if nesting == 0 {
+			return nil
+		}
+	}
+}
+
+// XmlFileStream is XmlFile, but streams the file with XmlStream.
+func XmlFileStream(file string, opts ...FlattenOpts) ([]Row, error) {
+	return collectRows(func(emit func(Row) error) error {
+		return XmlFileStreamRows(file, emit, opts...)
+	})
+}
+
+// XmlFileStreamRows is XmlFileStream, but calls emit with each row as
+// it's flattened, rather than returning them.
+func XmlFileStreamRows(file string, emit func(Row) error, opts ...FlattenOpts) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return XmlStreamRows(f, emit, opts...)
+}
+
+// XmlStream flattens the xml read from r, like Xml, without reading all
+// of it into memory first. The root element's children are read one at
+// a time, and skipped if the query doesn't match their names. Each
+// matched child is read whole, so this helps with documents made of
+// many records, not with a single large one.
+//
+// Queries in other languages can't be applied while streaming, so with
+// them the document is read whole.
+func XmlStream(r io.Reader, opts ...FlattenOpts) ([]Row, error) {
+	return collectRows(func(emit func(Row) error) error {
+		return XmlStreamRows(r, emit, opts...)
+	})
+}
+
+// XmlStreamRows is XmlStream, but calls emit with each row as it's
+// flattened, so the rows needn't all be held in memory. An error from
+// emit stops the flattening, and is returned.
+func XmlStreamRows(r io.Reader, emit func(Row) error, opts ...FlattenOpts) error {
+	fl := newFlattener(opts...)
+	fl.emit = emit
+	dec := xml.NewDecoder(fl.limitReader(r))
+
+	root, err := nextXmlStart(dec)
+	if err != nil {
+		return errors.Wrap(err, "finding xml root element")
+	}
+
+	if !fl.canStream() {
+		value, err := xmlElementValue(dec, root)
+		if err != nil {
+			return errors.Wrap(err, "reading xml")
+		}
+		_, err = fl.flatten(map[string]interface{}{root.Name.Local: value})
+		return err
+	}
+
+	if err := fl.streamXmlRoot(dec, root); err != nil {
+		return errors.Wrap(err, "streaming xml")
+	}
+
+	return nil
+}
+
+// collectRows runs a flattener which emits rows, and returns them.
+func collectRows(flatten func(emit func(Row) error) error) ([]Row, error) {
+	rows := []Row{}
+	if err := flatten(func(row Row) error {
+		rows = append(rows, row)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// streamXmlRoot flattens the root element, reading its children one at
+// a time. Repeated children are flattened as arrays, but whether a child
+// repeats isn't known until the next one with its name turns up. So the
+// first child with each name is held back until then, or until the root
+// element ends.
+func (fl *Flattener) streamXmlRoot(dec *xml.Decoder, root xml.StartElement) error {
+	rootName := root.Name.Local
+	path := []string{rootName}
+
+	if queryTerm, isQueryMatched := fl.queryAtDepth(0); !(isQueryMatched || fl.queryMatchString(rootName, queryTerm)) {
+		return dec.Skip()
+	}
+
+	keyTerm, isKeyMatched := fl.queryAtDepth(1)
+	matchesKey := func(key string) bool {
+		return isKeyMatched || fl.queryMatchString(key, keyTerm)
+	}
+
+	for _, attr := range root.Attr {
+		if key := "-" + attr.Name.Local; matchesKey(key) {
+			if err := fl.descend(append(path, key), attr.Value, 2); err != nil {
+				return err
+			}
+		}
+	}
+
+	var (
+		text     strings.Builder
+		names    []string
+		counts   = make(map[string]int)
+		held     = make(map[string]interface{})
+		children bool
+	)
+
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			children = true
+			name := t.Name.Local
+
+			if !matchesKey(name) {
+				if err := dec.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+
+			value, err := xmlElementValue(dec, t)
+			if err != nil {
+				return err
+			}
+
+			childPath := append(path, name)
+			counts[name]++
+			switch counts[name] {
+			case 1:
+				names = append(names, name)
+				held[name] = value
+				continue
+			case 2:
+				if err := fl.descendArrayElement(fl.logger, childPath, 0, held[name], 2); err != nil {
+					return err
+				}
+				delete(held, name)
+			}
+
+			if err := fl.descendArrayElement(fl.logger, childPath, counts[name]-1, value, 2); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+
+			// An element with only text is just that text
+			if len(root.Attr) == 0 && !children {
+				return fl.descend(path, value, 1)
+			}
+
+			for _, name := range names {
+				if value, ok := held[name]; ok {
+					if err := fl.descend(append(path, name), value, 2); err != nil {
+						return err
+					}
+				}
+			}
+
+			if value != "" && matchesKey("#text") {
+				return fl.descend(append(path, "#text"), value, 2)
+			}
+			return nil
+		}
+	}
+}
+
+// nextXmlStart reads up to the next start element from dec.
+func nextXmlStart(dec *xml.Decoder) (xml.StartElement, error) {
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start, nil
+		}
+	}
+}
+
+// xmlElementValue reads the element started by start, and returns it in
+// the form mxj would. Attributes are keys prefixed with `-`, repeated
+// children are arrays, and text is under `#text`, or is the value itself
+// if the element has nothing else.
+func xmlElementValue(dec *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	value := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		value["-"+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		token, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.CharData:
+			text.Write(t)
+		case xml.StartElement:
+			child, err := xmlElementValue(dec, t)
+			if err != nil {
+				return nil, err
+			}
+
+			name := t.Name.Local
+			switch existing := value[name].(type) {
+			case nil:
+				value[name] = child
+			case []interface{}:
+				value[name] = append(existing, child)
+			default:
+				value[name] = []interface{}{existing, child}
+			}
+		case xml.EndElement:
+			trimmed := strings.TrimSpace(text.String())
+			if len(value) == 0 {
+				return trimmed, nil
+			}
+			if trimmed != "" {
+				value["#text"] = trimmed
+			}
+			return value, nil
+		}
+	}
+}
+
+// canStream returns whether the query can be applied while streaming.
+func (fl *Flattener) canStream() bool {
+	return fl.queryLanguage == "" || fl.queryLanguage == QueryLanguageDataflatten
+}
+
+// queryNeedsArrayElement returns whether matching an array element
+// against the query term needs the element's contents, rather than just
+// its index.
+func (fl *Flattener) queryNeedsArrayElement(queryTerm string, isQueryMatched bool) bool {
+	if isQueryMatched {
+		return false
+	}
+	if strings.HasPrefix(queryTerm, fl.queryKeyDenoter) {
+		return true
+	}
+	if queryTerm == fl.queryWildcard {
+		return false
+	}
+	if _, err := strconv.Atoi(queryTerm); err == nil {
+		return false
+	}
+	return true
+}
+
+// limitReader returns r, limited to the flattener's max bytes, if
+// there's a limit.
+func (fl *Flattener) limitReader(r io.Reader) io.Reader {
+	if fl.maxBytes <= 0 {
+		return r
+	}
+	return &maxBytesReader{r: r, remaining: fl.maxBytes, limit: fl.maxBytes}
+}
+
+// maxBytesReader is like io.LimitedReader, but errors when the limit is
+// exceeded, rather than returning a truncated input.
+type maxBytesReader struct {
+	r         io.Reader
+	remaining int64
+	limit     int64
+}
+
+func (m *maxBytesReader) Read(p []byte) (int, error) {
+	if m.remaining < 0 {
+		return 0, errors.Errorf("input is larger than %d bytes", m.limit)
+	}
+
+	// Read one byte past the limit, to tell an input of exactly the
+	// limit from a larger one.
+	if int64(len(p)) > m.remaining+1 {
+		p = p[:m.remaining+1]
+	}
+
+	n, err := m.r.Read(p)
+	m.remaining -= int64(n)
+	if m.remaining < 0 {
+		return 0, errors.Errorf("input is larger than %d bytes", m.limit)
+	}
+	return n, err
+}
@@ -0,0 +1,181 @@
+package dataflatten
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStreams checks that the streaming flatteners return the same rows
+// as their non-streaming counterparts.
+func TestStreams(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		file    string
+		flatten func([]byte, ...FlattenOpts) ([]Row, error)
+		stream  func(string, ...FlattenOpts) ([]Row, error)
+		queries []string
+	}{
+		{
+			file:    filepath.Join("testdata", "animals.json"),
+			flatten: Json,
+			stream:  JsonFileStream,
+			queries: []string{
+				"",
+				"metadata",
+				"metadata/testing",
+				"system",
+				"users/0",
+				"users/*/name",
+				"users/name=>*Aardvark/id",
+				"users/id=>2",
+				"users/#name",
+				"users/#uuid/favorites/1",
+				"users/favorites",
+				"nothing/here",
+			},
+		},
+		{
+			file:    filepath.Join("testdata", "complex2.json"),
+			flatten: Json,
+			stream:  JsonFileStream,
+			queries: []string{"", "addons/0/nest3", "addons/string1=>hello/nest2"},
+		},
+		{
+			file:    filepath.Join("testdata", "animals.xml"),
+			flatten: Xml,
+			stream:  XmlFileStream,
+			queries: []string{"", "plist", "plist/dict/key", "plist/dict/array/0/dict/key", "plist/-version", "other"},
+		},
+		{
+			file:    filepath.Join("testdata", "nested", "nested.xml"),
+			flatten: Xml,
+			stream:  XmlFileStream,
+			queries: []string{"", "plist/dict/string"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		rawdata, err := ioutil.ReadFile(tt.file)
+		require.NoError(t, err)
+
+		for _, query := range tt.queries {
+			query := query
+			t.Run(tt.file+" "+query, func(t *testing.T) {
+				t.Parallel()
+
+				opts := []FlattenOpts{WithTypes(), IncludeNulls(), WithQuery(strings.Split(query, "/"))}
+
+				expected, err := tt.flatten(rawdata, opts...)
+				require.NoError(t, err)
+
+				actual, err := tt.stream(tt.file, opts...)
+				require.NoError(t, err)
+
+				sortRows(expected)
+				sortRows(actual)
+				require.Equal(t, expected, actual)
+			})
+		}
+	}
+}
+
+func TestXmlStream(t *testing.T) {
+	t.Parallel()
+
+	var tests = []flattenTestCase{
+		{
+			in:  "<r><a>1</a>",
+			err: true,
+		},
+		{
+			in:      "<r>only</r>",
+			out:     []Row{{Path: []string{"r"}, Value: "only"}},
+			comment: "text root",
+		},
+		{
+			in: `<r v="1"> <a x="2">t</a><a>u</a><b/>text</r>`,
+			out: []Row{
+				{Path: []string{"r", "-v"}, Value: "1"},
+				{Path: []string{"r", "a", "0", "-x"}, Value: "2"},
+				{Path: []string{"r", "a", "0", "#text"}, Value: "t"},
+				{Path: []string{"r", "a", "1"}, Value: "u"},
+				{Path: []string{"r", "b"}, Value: ""},
+				{Path: []string{"r", "#text"}, Value: "text"},
+			},
+			comment: "attributes, repeats and text",
+		},
+		{
+			in: `<r><a>1</a><b>2</b><a>3</a></r>`,
+			out: []Row{
+				{Path: []string{"r", "a", "1"}, Value: "3"},
+			},
+			options: []FlattenOpts{WithQuery([]string{"r", "a", "1"})},
+			comment: "query",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.comment, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := XmlStream(strings.NewReader(tt.in), tt.options...)
+			testFlattenCase(t, tt, actual, err)
+		})
+	}
+}
+
+func TestStreamMaxBytes(t *testing.T) {
+	t.Parallel()
+
+	doc := `{"a": [1, 2, 3], "b": "hello"}`
+
+	_, err := JsonStream(strings.NewReader(doc), WithMaxBytes(int64(len(doc)-1)))
+	require.Error(t, err)
+
+	rows, err := JsonStream(strings.NewReader(doc), WithMaxBytes(int64(len(doc))))
+	require.NoError(t, err)
+	require.Len(t, rows, 4)
+
+	_, err = XmlStream(bytes.NewReader([]byte("<r><a>1</a></r>")), WithMaxBytes(10))
+	require.Error(t, err)
+
+	_, err = JsonStream(strings.NewReader(`{"a": 1} {"b": 2}`))
+	require.Error(t, err, "trailing data")
+}
+
+func TestStreamRows(t *testing.T) {
+	t.Parallel()
+
+	doc := `{"a": [1, 2, 3], "b": "hello"}`
+
+	var paths []string
+	require.NoError(t, JsonStreamRows(strings.NewReader(doc), func(row Row) error {
+		paths = append(paths, row.StringPath("/"))
+		return nil
+	}))
+	require.Equal(t, []string{"a/0", "a/1", "a/2", "b"}, paths)
+
+	// An error from emit stops the flattening
+	stop := errors.New("stop")
+	emitted := 0
+	err := XmlStreamRows(strings.NewReader("<r><a>1</a><b>2</b><c>3</c></r>"), func(Row) error {
+		emitted++
+		return stop
+	})
+	require.Equal(t, stop, errors.Cause(err))
+	require.Equal(t, 1, emitted)
+}
+
+func sortRows(rows []Row) {
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].StringPath("/") < rows[j].StringPath("/") })
+}
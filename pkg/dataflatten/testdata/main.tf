@@ -0,0 +1,60 @@
+terraform {
+  required_version = ">= 1.0"
+
+  required_providers {
+    aws = {
+      source  = "hashicorp/aws"
+      version = "~> 4.0"
+    }
+  }
+}
+
+variable "region" {
+  type    = string
+  default = "us-east-1"
+}
+
+locals {
+  name = "demo-${var.region}"
+  tags = {
+    Owner = "platform"
+    Env   = var.environment
+  }
+}
+
+provider "aws" {
+  region = var.region
+}
+
+resource "aws_instance" "web" {
+  count         = 2
+  ami           = data.aws_ami.ubuntu.id
+  instance_type = "t3.micro"
+  tags          = merge(local.tags, { Name = "web-${count.index}" })
+
+  root_block_device {
+    volume_size = 20
+    encrypted   = true
+  }
+
+  user_data = <<-EOT
+    #!/bin/sh
+    echo hello
+  EOT
+}
+
+resource "aws_security_group" "web" {
+  name = local.name
+
+  dynamic "ingress" {
+    for_each = [80, 443]
+    content {
+      from_port = ingress.value
+      to_port   = ingress.value
+    }
+  }
+}
+
+output "ips" {
+  value = [for instance in aws_instance.web : instance.public_ip]
+}
@@ -0,0 +1,28 @@
+package dataflatten
+
+import (
+	"io/ioutil"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+)
+
+func TomlFile(file string, opts ...FlattenOpts) ([]Row, error) {
+	rawdata, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Toml(rawdata, opts...)
+}
+
+// Toml flattens toml data. Offset date-times are reported as unix
+// timestamps, as other formats' times are. Local dates, times and
+// date-times have no zone to convert from, so are reported as written.
+func Toml(rawdata []byte, opts ...FlattenOpts) ([]Row, error) {
+	var data map[string]interface{}
+	if err := toml.Unmarshal(rawdata, &data); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling toml")
+	}
+
+	return Flatten(data, opts...)
+}
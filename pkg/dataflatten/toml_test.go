@@ -0,0 +1,76 @@
+package dataflatten
+
+import "testing"
+
+func TestToml(t *testing.T) {
+	t.Parallel()
+
+	var tests = []flattenTestCase{
+		{
+			in:      "a = [",
+			err:     true,
+			comment: "invalid",
+		},
+		{
+			in:      "",
+			out:     []Row{},
+			comment: "empty",
+		},
+		{
+			in: "a = 1\nb = [\"x\", \"y\"]\n\n[c]\nd = true\n",
+			out: []Row{
+				Row{Path: []string{"a"}, Value: "1"},
+				Row{Path: []string{"b", "0"}, Value: "x"},
+				Row{Path: []string{"b", "1"}, Value: "y"},
+				Row{Path: []string{"c", "d"}, Value: "true"},
+			},
+			comment: "tables",
+		},
+		{
+			in: "[[servers]]\nname = \"alpha\"\n\n[[servers]]\nname = \"beta\"\n",
+			out: []Row{
+				Row{Path: []string{"servers", "0", "name"}, Value: "alpha"},
+				Row{Path: []string{"servers", "1", "name"}, Value: "beta"},
+			},
+			comment: "array of tables",
+		},
+		{
+			in: "odt = 1979-05-27T07:32:00Z\n",
+			out: []Row{
+				Row{Path: []string{"odt"}, Value: "296638320"},
+			},
+			comment: "offset date-time",
+		},
+		{
+			in: "ld = 1979-05-27\n",
+			out: []Row{
+				Row{Path: []string{"ld"}, Value: "1979-05-27"},
+			},
+			comment: "local date",
+		},
+		{
+			in: "lt = 07:32:00.999999\n",
+			out: []Row{
+				Row{Path: []string{"lt"}, Value: "07:32:00.999999"},
+			},
+			comment: "local time",
+		},
+		{
+			in: "ldt = 1979-05-27T07:32:00\n",
+			out: []Row{
+				Row{Path: []string{"ldt"}, Value: "1979-05-27T07:32:00"},
+			},
+			comment: "local date-time",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.comment, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Toml([]byte(tt.in), tt.options...)
+			testFlattenCase(t, tt, actual, err)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package dataflatten
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func YamlFile(file string, opts ...FlattenOpts) ([]Row, error) {
+	rawdata, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return Yaml(rawdata, opts...)
+}
+
+// Yaml flattens yaml data. A stream of several documents, as found in
+// kubernetes manifests, is flattened as an array of those documents.
+func Yaml(rawdata []byte, opts ...FlattenOpts) ([]Row, error) {
+	var documents []interface{}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(rawdata))
+	for {
+		var document interface{}
+		if err := decoder.Decode(&document); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, errors.Wrap(err, "unmarshalling yaml")
+		}
+		documents = append(documents, yamlStringKeys(document))
+	}
+
+	switch len(documents) {
+	case 0:
+		return Flatten(nil, opts...)
+	case 1:
+		return Flatten(documents[0], opts...)
+	default:
+		return Flatten(documents, opts...)
+	}
+}
+
+// yamlStringKeys converts the maps yaml decodes with non-string keys
+// into maps with string keys, which the flattener descends through.
+func yamlStringKeys(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		converted := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			converted[fmt.Sprint(key)] = yamlStringKeys(value)
+		}
+		return converted
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = yamlStringKeys(value)
+		}
+		return v
+	case []interface{}:
+		for i, value := range v {
+			v[i] = yamlStringKeys(value)
+		}
+		return v
+	default:
+		return data
+	}
+}
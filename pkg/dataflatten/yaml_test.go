@@ -0,0 +1,55 @@
+package dataflatten
+
+import "testing"
+
+func TestYaml(t *testing.T) {
+	t.Parallel()
+
+	var tests = []flattenTestCase{
+		{
+			in:      "a: [b",
+			err:     true,
+			comment: "invalid",
+		},
+		{
+			in:      "",
+			out:     []Row{},
+			comment: "empty",
+		},
+		{
+			in: "a: 1\nb:\n  - x\n  - y\n",
+			out: []Row{
+				Row{Path: []string{"a"}, Value: "1"},
+				Row{Path: []string{"b", "0"}, Value: "x"},
+				Row{Path: []string{"b", "1"}, Value: "y"},
+			},
+			comment: "single document",
+		},
+		{
+			in: "kind: Service\n---\nkind: Deployment\n",
+			out: []Row{
+				Row{Path: []string{"0", "kind"}, Value: "Service"},
+				Row{Path: []string{"1", "kind"}, Value: "Deployment"},
+			},
+			comment: "multiple documents",
+		},
+		{
+			in: "1: a\ntrue: b\n",
+			out: []Row{
+				Row{Path: []string{"1"}, Value: "a"},
+				Row{Path: []string{"true"}, Value: "b"},
+			},
+			comment: "non-string keys",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.comment, func(t *testing.T) {
+			t.Parallel()
+
+			actual, err := Yaml([]byte(tt.in), tt.options...)
+			testFlattenCase(t, tt, actual, err)
+		})
+	}
+}
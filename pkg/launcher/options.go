@@ -28,9 +28,22 @@ type Options struct {
 	// CertPins are optional hashes of subject public key info to use for
 	// certificate pinning.
 	CertPins [][]byte
+	// CertPinsPath is the path of a file of cert pins, in the same
+	// format as the flag, separated by commas or newlines. It's reread
+	// when it changes.
+	CertPinsPath string
 	// RootPEM is the path to the pem file containing the certificate
 	// chain, if necessary for verification.
 	RootPEM string
+	// ClientCertPath and ClientKeyPath are the paths of a PEM encoded
+	// certificate and key to authenticate to the server with, for mutual
+	// TLS.
+	ClientCertPath string
+	ClientKeyPath  string
+	// GenerateClientCert has launcher obtain a client certificate for the
+	// launcher key from the server, instead of reading one from
+	// ClientCertPath.
+	GenerateClientCert bool
 	// LoggingInterval is the interval at which logs should be flushed to
 	// the server.
 	LoggingInterval time.Duration
@@ -44,11 +57,29 @@ type Options struct {
 	// of log. When blank, launcher will pick a value
 	// appropriate for the transport.
 	LogMaxBytesPerBatch int
+	// LogSinkFile is an optional path result logs are also written to.
+	LogSinkFile string
+	// LogSinkSyslog optionally sends result logs to syslog. Either
+	// "local", or the URL of a syslog server.
+	LogSinkSyslog string
+	// LogSinkWebhook is an optional URL result logs are also POSTed to.
+	LogSinkWebhook string
+	// LogSinkWebhookHeaders are headers sent with each webhook
+	// request, for example for authorization.
+	LogSinkWebhookHeaders map[string]string
+	// DisableServerLogs stops osquery logs being sent to the server,
+	// leaving the log sinks as the only destinations.
+	DisableServerLogs bool
 
 	// Control enables the remote control functionality.
 	Control bool
 	// ControlServerURL URL for control server.
 	ControlServerURL string
+	// ControlServerKeyPath is the path to the PEM encoded public key
+	// which control server responses must be signed with.
+	ControlServerKeyPath string
+	// ControlRequestInterval is the interval at which control client will check for updates from the control server.
+	ControlRequestInterval time.Duration
 
 	// Osquery TLS options
 	OsqueryTlsConfigEndpoint           string
@@ -72,6 +103,22 @@ type Options struct {
 	NotaryPrefix string
 	// AutoupdateInitialDelay set an initial startup delay on the autoupdater process.
 	AutoupdateInitialDelay time.Duration
+	// AutoupdateLauncherVersion pins launcher to a version. Downloads of other versions are held.
+	AutoupdateLauncherVersion string
+	// AutoupdateOsquerydVersion pins osqueryd to a version. Downloads of other versions are held.
+	AutoupdateOsquerydVersion string
+	// AutoupdateRolloutPercent is the percentage of hosts which use new versions.
+	AutoupdateRolloutPercent int
+	// AutoupdateTUFRepo is a TUF repository, as an http(s) URL or a local path, to update from instead of Notary.
+	AutoupdateTUFRepo string
+	// AutoupdateTUFRoot is the path to the trusted root metadata for AutoupdateTUFRepo.
+	AutoupdateTUFRoot string
+	// AutoupdatePeerCacheAddress is the address to serve downloaded updates to peers on. Empty disables serving.
+	AutoupdatePeerCacheAddress string
+	// AutoupdatePeers are peers to download updates from, before AutoupdateTUFRepo.
+	AutoupdatePeers []string
+	// AutoupdatePeerDiscovery enables finding peers, and advertising the peer cache, by mDNS.
+	AutoupdatePeerDiscovery bool
 
 	// Debug enables debug logging.
 	Debug bool
@@ -82,12 +129,61 @@ type Options struct {
 	// OsqueryFlags defines additional flags to pass to osquery (possibly
 	// overriding Launcher defaults)
 	OsqueryFlags []string
+	// OsqueryMaxCPUPercent and OsqueryMaxMemoryMB are limits on
+	// osqueryd's resource use. osqueryd is restarted when it stays over
+	// them. Zero is no limit.
+	OsqueryMaxCPUPercent int
+	OsqueryMaxMemoryMB   int
+	// OsqueryInstances are additional osqueryd instances to run
+	// alongside the primary one.
+	OsqueryInstances []OsqueryInstance
+	// CustomTablesPath is a JSON file of tables to declare, in addition
+	// to launcher's own.
+	CustomTablesPath string
+	// TableExecSandbox runs the commands launcher's tables exec in a
+	// sandbox, with resource limits and a cleared environment. It's only
+	// enforced on Linux.
+	TableExecSandbox bool
+	// TableExecUser is who sandboxed commands are run as, unless a
+	// table needs root.
+	TableExecUser string
+	// TableExecCacheTTL is how long tables cache the output of the
+	// commands they exec. Zero disables caching.
+	TableExecCacheTTL time.Duration
 	// DisableControlTLS disables TLS transport with the control server.
 	DisableControlTLS bool
 	// InsecureTLS disables TLS certificate verification.
 	InsecureTLS bool
 	// InsecureTransport disables TLS in the transport layer.
 	InsecureTransport bool
+	// ProxyURL is a proxy to send all outbound connections through. When
+	// blank, ProxyPAC or the environment decide.
+	ProxyURL string
+	// ProxyPAC is the path or URL of a PAC file choosing the proxy for
+	// outbound connections.
+	ProxyPAC string
 	// CompactDbMaxTx sets the max transaction size for bolt db compaction operations
 	CompactDbMaxTx int64
 }
+
+// OsqueryInstance is an additional osqueryd instance launcher runs and
+// supervises alongside the primary one. It has its own config and
+// schedule, rather than the server's, and its logs are sent with the
+// primary instance's, tagged with its name.
+type OsqueryInstance struct {
+	// Name identifies the instance in logs and history.
+	Name string `json:"name"`
+	// RootDirectory is the instance's own root directory, for its
+	// database, socket and pidfile. It defaults to a directory named
+	// after the instance in launcher's root directory.
+	RootDirectory string `json:"root_directory"`
+	// ConfigPath is the osquery config file, with the instance's
+	// schedule.
+	ConfigPath string `json:"config_path"`
+	// Flags are additional flags to pass to this osqueryd.
+	Flags []string `json:"flags"`
+	// MaxCPUPercent and MaxMemoryMB are limits on this osqueryd's
+	// resource use. Zero is no limit.
+	MaxCPUPercent int `json:"max_cpu_percent"`
+	MaxMemoryMB   int `json:"max_memory_mb"`
+}
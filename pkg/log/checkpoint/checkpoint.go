@@ -14,6 +14,7 @@ import (
 	"github.com/kolide/kit/version"
 	"github.com/kolide/launcher/pkg/agent"
 	"github.com/kolide/launcher/pkg/launcher"
+	"github.com/kolide/launcher/pkg/proxy"
 	"go.etcd.io/bbolt"
 )
 
@@ -66,6 +67,7 @@ func logCheckPoint(logger log.Logger, db *bbolt.DB, opts launcher.Options) {
 	logger.Log("notableFiles", fileNamesInDirs(notableFileDirs...))
 	logDbSize(logger, db)
 	logConnections(logger, opts)
+	logProxies(logger, opts)
 	logIpLookups(logger, opts)
 	logKolideServerVersion(logger, opts)
 	logNotaryVersions(logger, opts)
@@ -85,7 +87,7 @@ func logKolideServerVersion(logger logger, opts launcher.Options) {
 		return
 	}
 
-	httpClient := &http.Client{Timeout: requestTimeout}
+	httpClient := &http.Client{Timeout: requestTimeout, Transport: proxy.NewTransport()}
 
 	kolideServerUrl, err := parseUrl(fmt.Sprintf("%s/version", opts.KolideServerURL), opts)
 	if err != nil {
@@ -100,7 +102,7 @@ func logNotaryVersions(logger logger, opts launcher.Options) {
 		return
 	}
 
-	httpClient := &http.Client{Timeout: requestTimeout}
+	httpClient := &http.Client{Timeout: requestTimeout, Transport: proxy.NewTransport()}
 
 	notaryUrl, err := parseUrl(fmt.Sprintf("%s/v2/kolide/launcher/_trust/tuf/targets/releases.json", opts.NotaryServerURL), opts)
 	if err != nil {
@@ -115,6 +117,10 @@ func logConnections(logger logger, opts launcher.Options) {
 	logger.Log("connections", testConnections(dialer, urlsToTest(opts)...))
 }
 
+func logProxies(logger logger, opts launcher.Options) {
+	logger.Log("proxies", proxy.Check(urlsToTest(opts)...))
+}
+
 func logIpLookups(logger logger, opts launcher.Options) {
 	ipLookuper := &net.Resolver{}
 	logger.Log("ip loook ups", lookupHostsIpv4s(ipLookuper, urlsToTest(opts)...))
@@ -0,0 +1,73 @@
+// Package levellogger provides a go-kit compatible logger whose level
+// can be changed while launcher is running.
+package levellogger
+
+import (
+	"context"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+)
+
+// Logger switches between an info and a debug logger. It holds two
+// loggers, rather than a filter, so that each may keep its own level
+// handling (such as logutil's SIGUSR2 toggle).
+type Logger struct {
+	swap  log.SwapLogger
+	info  log.Logger
+	debug log.Logger
+
+	lock    sync.Mutex
+	isDebug bool
+}
+
+// New returns a Logger which logs to debug if debug is set, and to
+// info otherwise.
+func New(info, debug log.Logger, isDebug bool) *Logger {
+	l := &Logger{
+		info:  info,
+		debug: debug,
+	}
+	l.SetDebug(isDebug)
+	return l
+}
+
+func (l *Logger) Log(keyvals ...interface{}) error {
+	return l.swap.Log(keyvals...)
+}
+
+// SetDebug switches between the debug and info loggers
+func (l *Logger) SetDebug(isDebug bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.isDebug = isDebug
+	if isDebug {
+		l.swap.Swap(l.debug)
+	} else {
+		l.swap.Swap(l.info)
+	}
+}
+
+// Debug returns whether the debug logger is in use
+func (l *Logger) Debug() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.isDebug
+}
+
+type contextKey int
+
+const loggerKey contextKey = 0
+
+// NewContext returns a context carrying l, so that it can be adjusted
+// by code which only sees the wrapped logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the Logger in ctx, or nil if there isn't one.
+func FromContext(ctx context.Context) *Logger {
+	l, _ := ctx.Value(loggerKey).(*Logger)
+	return l
+}
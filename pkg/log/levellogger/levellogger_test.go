@@ -0,0 +1,43 @@
+package levellogger
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetDebug(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	base := log.NewLogfmtLogger(&buf)
+	l := New(level.NewFilter(base, level.AllowInfo()), level.NewFilter(base, level.AllowDebug()), false)
+	require.False(t, l.Debug())
+
+	level.Debug(l).Log("msg", "hidden")
+	level.Info(l).Log("msg", "shown")
+	require.NotContains(t, buf.String(), "hidden")
+	require.Contains(t, buf.String(), "shown")
+
+	l.SetDebug(true)
+	require.True(t, l.Debug())
+	level.Debug(l).Log("msg", "debugging")
+	require.Contains(t, buf.String(), "debugging")
+
+	l.SetDebug(false)
+	level.Debug(l).Log("msg", "quiet again")
+	require.NotContains(t, buf.String(), "quiet again")
+}
+
+func TestContext(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, FromContext(context.Background()))
+
+	l := New(log.NewNopLogger(), log.NewNopLogger(), false)
+	require.Equal(t, l, FromContext(NewContext(context.Background(), l)))
+}
@@ -12,6 +12,7 @@ import (
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-kit/kit/log"
@@ -43,6 +44,35 @@ type Extension struct {
 	wg            sync.WaitGroup
 	logger        log.Logger
 
+	// enrollSecretLock guards Opts.EnrollSecret, which may be replaced
+	// while an enrollment is in progress.
+	enrollSecretLock sync.Mutex
+
+	// loggingIntervalChanged carries logging interval changes to the
+	// log writing loop
+	loggingIntervalChanged chan time.Duration
+
+	// logSinks are the destinations buffered logs are written to
+	logSinks []LogSink
+
+	// droppedLogs counts logs discarded for being larger than
+	// Opts.MaxBytesPerLog
+	droppedLogs uint64
+
+	// resultsLock serializes retries of queued distributed query
+	// results
+	resultsLock sync.Mutex
+	// sendingResults holds the keys of queued distributed query results
+	// which WriteResults is sending, so they aren't retried at the same
+	// time
+	sendingResults sync.Map
+	// resultsBackoffOpts configure retries of queued distributed query
+	// results
+	resultsBackoffOpts []backoff.Opt
+	// droppedResults counts queued distributed query results discarded
+	// for exceeding Opts.MaxResultsAge
+	droppedResults uint64
+
 	osqueryClient Querier
 	initialRunner *initialRunner
 }
@@ -56,6 +86,12 @@ func (e *Extension) SetQuerier(client Querier) {
 	}
 }
 
+// DroppedLogs returns the number of buffered logs discarded for being too
+// large to send.
+func (e *Extension) DroppedLogs() uint64 {
+	return atomic.LoadUint64(&e.droppedLogs)
+}
+
 // Querier allows querying osquery.
 type Querier interface {
 	Query(sql string) ([]map[string]string, error)
@@ -95,6 +131,10 @@ const (
 	// Default maximum number of logs to buffer before purging oldest logs
 	// (applies per log type).
 	defaultMaxBufferedLogs = 500000
+	// Default maximum number of logs a log sink, other than the
+	// server, may fall behind by before its oldest logs are skipped
+	// (applies per log type).
+	defaultMaxLogSinkBacklog = 50000
 )
 
 // ExtensionOpts is options to be passed in NewExtension
@@ -103,8 +143,14 @@ type ExtensionOpts struct {
 	// enrolling with the server.
 	EnrollSecret string
 	// MaxBytesPerBatch is the maximum number of bytes that should be sent in
-	// one batch logging request. Any log larger than this will be dropped.
+	// one batch logging request. Logs larger than this are sent in a batch
+	// of their own.
 	MaxBytesPerBatch int
+	// MaxBytesPerLog is the largest log that will be sent. Any log larger
+	// than this will be dropped. It defaults to MaxBytesPerBatch, and
+	// should only be raised for clients that split oversized logs across
+	// requests.
+	MaxBytesPerLog int
 	// LoggingInterval is the interval at which logs should be flushed to
 	// the server.
 	LoggingInterval time.Duration
@@ -121,6 +167,23 @@ type ExtensionOpts struct {
 	// RunDifferentialQueriesImmediately allows the client to execute a new query the first time it sees it,
 	// bypassing the scheduler.
 	RunDifferentialQueriesImmediately bool
+	// LogSinks are additional destinations for buffered logs. Each
+	// sink receives logs independently of the server and of each
+	// other.
+	LogSinks []LogSink
+	// MaxLogSinkBacklog is the maximum number of logs one of LogSinks
+	// may fall behind by. Beyond that, its oldest logs are skipped, so
+	// that a failing sink doesn't hold the buffer for the others.
+	MaxLogSinkBacklog int
+	// DisableServerLogs stops buffered logs from being published to
+	// the server, leaving LogSinks as the only destinations.
+	DisableServerLogs bool
+	// MaxResultsAge is the maximum age of distributed query results
+	// waiting to be delivered to the server. Older results are dropped.
+	MaxResultsAge time.Duration
+	// ResultsRetryInterval is the interval at which distributed query
+	// results that failed to deliver are retried.
+	ResultsRetryInterval time.Duration
 }
 
 // NewExtension creates a new Extension from the provided service.KolideService
@@ -129,7 +192,7 @@ type ExtensionOpts struct {
 func NewExtension(client service.KolideService, db *bbolt.DB, opts ExtensionOpts) (*Extension, error) {
 	// bucketNames contains the names of buckets that should be created when the
 	// extension opens the DB. It should be treated as a constant.
-	var bucketNames = []string{configBucket, statusLogsBucket, resultLogsBucket, initialResultsBucket, ServerProvidedDataBucket}
+	var bucketNames = []string{configBucket, statusLogsBucket, resultLogsBucket, initialResultsBucket, ServerProvidedDataBucket, logSinkCursorsBucket, distributedResultsBucket}
 
 	if opts.EnrollSecret == "" {
 		return nil, errors.New("empty enroll secret")
@@ -139,10 +202,22 @@ func NewExtension(client service.KolideService, db *bbolt.DB, opts ExtensionOpts
 		opts.MaxBytesPerBatch = defaultMaxBytesPerBatch
 	}
 
+	if opts.MaxBytesPerLog < opts.MaxBytesPerBatch {
+		opts.MaxBytesPerLog = opts.MaxBytesPerBatch
+	}
+
 	if opts.LoggingInterval == 0 {
 		opts.LoggingInterval = defaultLoggingInterval
 	}
 
+	if opts.MaxResultsAge == 0 {
+		opts.MaxResultsAge = defaultMaxResultsAge
+	}
+
+	if opts.ResultsRetryInterval == 0 {
+		opts.ResultsRetryInterval = defaultResultsRetryInterval
+	}
+
 	if opts.Clock == nil {
 		opts.Clock = clock.DefaultClock{}
 	}
@@ -156,6 +231,10 @@ func NewExtension(client service.KolideService, db *bbolt.DB, opts ExtensionOpts
 		opts.MaxBufferedLogs = defaultMaxBufferedLogs
 	}
 
+	if opts.MaxLogSinkBacklog == 0 {
+		opts.MaxLogSinkBacklog = defaultMaxLogSinkBacklog
+	}
+
 	// Create Bolt buckets as necessary
 	err := db.Update(func(tx *bbolt.Tx) error {
 		for _, name := range bucketNames {
@@ -196,7 +275,7 @@ func NewExtension(client service.KolideService, db *bbolt.DB, opts ExtensionOpts
 		enabled:    opts.RunDifferentialQueriesImmediately,
 	}
 
-	return &Extension{
+	e := &Extension{
 		logger:        opts.Logger,
 		serviceClient: client,
 		db:            db,
@@ -204,15 +283,34 @@ func NewExtension(client service.KolideService, db *bbolt.DB, opts ExtensionOpts
 		Opts:          opts,
 		done:          make(chan struct{}),
 		initialRunner: initialRunner,
-	}, nil
+
+		loggingIntervalChanged: make(chan time.Duration, 1),
+		resultsBackoffOpts:     []backoff.Opt{backoff.MaxAttempts(resultsRetryAttempts)},
+	}
+
+	if !opts.DisableServerLogs {
+		e.logSinks = append(e.logSinks, serverLogSink{e: e})
+	}
+
+	sinkNames := make(map[string]bool)
+	for _, sink := range append(e.logSinks, opts.LogSinks...) {
+		if sinkNames[sink.Name()] {
+			return nil, errors.Errorf("duplicate log sink name %s", sink.Name())
+		}
+		sinkNames[sink.Name()] = true
+	}
+	e.logSinks = append(e.logSinks, opts.LogSinks...)
+
+	return e, nil
 }
 
 // Start begins the goroutines responsible for background processing (currently
 // just the log buffer flushing routine). It should be shut down by calling the
 // Shutdown() method.
 func (e *Extension) Start() {
-	e.wg.Add(1)
+	e.wg.Add(2)
 	go e.writeLogsLoopRunner()
+	go e.writeResultsLoopRunner()
 }
 
 // Shutdown should be called to cleanup the resources and goroutines associated
@@ -462,7 +560,7 @@ func (e *Extension) Enroll(ctx context.Context) (string, bool, error) {
 
 	// If no cached node key, enroll for new node key
 	// note that we set invalid two ways. Via the return, _or_ via isNodeInvaliderr
-	keyString, invalid, err := e.serviceClient.RequestEnrollment(ctx, e.Opts.EnrollSecret, identifier, enrollDetails)
+	keyString, invalid, err := e.serviceClient.RequestEnrollment(ctx, e.enrollSecret(), identifier, enrollDetails)
 	if isNodeInvalidErr(err) {
 		invalid = true
 	} else if err != nil {
@@ -636,10 +734,41 @@ func (e *Extension) writeAndPurgeLogs() {
 	}
 }
 
+// SetLoggingInterval changes the interval at which buffered logs are
+// written. It takes effect after the next write.
+func (e *Extension) SetLoggingInterval(interval time.Duration) {
+	for {
+		select {
+		case e.loggingIntervalChanged <- interval:
+			return
+		default:
+			// Drop any pending, unapplied, change in favor of this one
+			select {
+			case <-e.loggingIntervalChanged:
+			default:
+			}
+		}
+	}
+}
+
+// SetEnrollSecret replaces the enroll secret used for any later
+// enrollment. It doesn't affect an existing enrollment.
+func (e *Extension) SetEnrollSecret(secret string) {
+	e.enrollSecretLock.Lock()
+	defer e.enrollSecretLock.Unlock()
+	e.Opts.EnrollSecret = secret
+}
+
+func (e *Extension) enrollSecret() string {
+	e.enrollSecretLock.Lock()
+	defer e.enrollSecretLock.Unlock()
+	return e.Opts.EnrollSecret
+}
+
 func (e *Extension) writeLogsLoopRunner() {
 	defer e.wg.Done()
 	ticker := e.Opts.Clock.NewTicker(e.Opts.LoggingInterval)
-	defer ticker.Stop()
+	defer func() { ticker.Stop() }()
 	for {
 		e.writeAndPurgeLogs()
 
@@ -647,6 +776,10 @@ func (e *Extension) writeLogsLoopRunner() {
 		select {
 		case <-e.done:
 			return
+		case interval := <-e.loggingIntervalChanged:
+			level.Debug(e.logger).Log("msg", "logging interval changed", "interval", interval)
+			ticker.Stop()
+			ticker = e.Opts.Clock.NewTicker(interval)
 		case <-ticker.Chan():
 			// Resume loop
 		}
@@ -673,41 +806,118 @@ func (e *Extension) numberOfBufferedLogs(typ logger.LogType) (int, error) {
 	return count, nil
 }
 
-// writeBufferedLogs flushes the log buffers, writing up to
-// Opts.MaxBytesPerBatch bytes worth of logs in one run. If the logs write
-// successfully, they will be deleted from the buffer.
+// writeBufferedLogsForType flushes the log buffers, writing up to
+// Opts.MaxBytesPerBatch bytes worth of logs to each log sink in one
+// run. Once every sink accepting the type has delivered a log, it is
+// deleted from the buffer.
 func (e *Extension) writeBufferedLogsForType(typ logger.LogType) error {
 	bucketName, err := bucketNameFromLogType(typ)
 	if err != nil {
 		return err
 	}
 
+	var sinkErrs []error
+	var minCursor []byte
+	accepted := 0
+	for _, sink := range e.logSinks {
+		if !sink.Accepts(typ) {
+			continue
+		}
+		accepted++
+
+		cursor, err := e.writeBufferedLogsToSink(sink, typ, bucketName)
+		if err != nil {
+			sinkErrs = append(sinkErrs, errors.Wrapf(err, "sink %s", sink.Name()))
+		}
+
+		if accepted == 1 || bytes.Compare(cursor, minCursor) < 0 {
+			minCursor = cursor
+		}
+	}
+
+	// With no one to deliver to, there's no reason to keep buffering
+	if accepted == 0 {
+		return e.deleteDeliveredLogs(bucketName, byteKeyFromUint64(^uint64(0)))
+	}
+
+	// Delete logs that every sink has delivered
+	if minCursor != nil {
+		if err := e.deleteDeliveredLogs(bucketName, minCursor); err != nil {
+			return err
+		}
+	}
+
+	switch len(sinkErrs) {
+	case 0:
+		return nil
+	case 1:
+		return sinkErrs[0]
+	default:
+		return errors.Errorf("%d log sinks failed, first: %s", len(sinkErrs), sinkErrs[0])
+	}
+}
+
+// writeBufferedLogsToSink sends the next batch of logs the sink has not
+// yet received, and returns the sink's delivery cursor.
+func (e *Extension) writeBufferedLogsToSink(sink LogSink, typ logger.LogType, bucketName string) ([]byte, error) {
+	cursor, err := e.logSinkCursor(bucketName, sink)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err = e.trimLogSinkBacklog(sink, bucketName, cursor)
+	if err != nil {
+		return nil, err
+	}
+
 	// Collect up logs to be sent
 	var logs []string
-	var logIDs [][]byte
+	var lastLogID []byte
 	err = e.db.View(func(tx *bbolt.Tx) error {
 		b := tx.Bucket([]byte(bucketName))
 
 		c := b.Cursor()
-		k, v := c.First()
+		var k, v []byte
+		if cursor == nil {
+			k, v = c.First()
+		} else {
+			k, v = c.Seek(cursor)
+			if k != nil && bytes.Equal(k, cursor) {
+				k, v = c.Next()
+			}
+		}
+
 		for totalBytes := 0; k != nil; {
 			// A somewhat cumbersome if block...
 			//
 			// 1. If the log is too big, skip it and mark for deletion.
-			// 2. If the buffer would be too big with the log, break for
-			// 3. Else append it
+			// 2. If the log is too big for a batch, send it on its own,
+			// and leave it to the transport to split it up.
+			// 3. If the buffer would be too big with the log, break for
+			// 4. Else append it
 			//
-			// Note that (1) must come first, otherwise (2) will always trigger.
-			if len(v) > e.Opts.MaxBytesPerBatch {
+			// Note that (1) and (2) must come first, otherwise (3) will always trigger.
+			if len(v) > e.Opts.MaxBytesPerLog {
 				// Discard logs that are too big
+				atomic.AddUint64(&e.droppedLogs, 1)
 				logheadSize := minInt(len(v), 100)
 				level.Info(e.Opts.Logger).Log(
 					"msg", "dropped log",
+					"sink", sink.Name(),
 					"logID", k,
 					"size", len(v),
-					"limit", e.Opts.MaxBytesPerBatch,
+					"limit", e.Opts.MaxBytesPerLog,
 					"loghead", string(v)[0:logheadSize],
 				)
+			} else if len(v) > e.Opts.MaxBytesPerBatch {
+				// Send what we have first, and come back for this one
+				if len(logs) > 0 {
+					break
+				}
+				logs = append(logs, string(v))
+				lastLogID = make([]byte, len(k))
+				copy(lastLogID, k)
+				break
 			} else if totalBytes+len(v) > e.Opts.MaxBytesPerBatch {
 				// Buffer is filled. Break the loop and come back later.
 				break
@@ -716,48 +926,77 @@ func (e *Extension) writeBufferedLogsForType(typ logger.LogType) error {
 				totalBytes += len(v)
 			}
 
-			// Note the logID for deletion. We do this by
-			// making a copy of k. It is retained in
-			// logIDs after the transaction is closed,
-			// when the goroutine ticks it zeroes out some
-			// of the IDs to delete below, causing logs to
-			// remain in the buffer and be sent again to
-			// the server.
-			logID := make([]byte, len(k))
-			copy(logID, k)
-			logIDs = append(logIDs, logID)
+			// Note the logID to advance the cursor to. We
+			// do this by making a copy of k, as it is
+			// only valid for the life of the transaction.
+			lastLogID = make([]byte, len(k))
+			copy(lastLogID, k)
 
 			k, v = c.Next()
 		}
 		return nil
 	})
 	if err != nil {
-		return errors.Wrap(err, "reading buffered logs")
+		return cursor, errors.Wrap(err, "reading buffered logs")
 	}
 
-	if len(logs) == 0 {
+	if lastLogID == nil {
 		// Nothing to send
-		return nil
+		return cursor, nil
 	}
 
-	err = e.writeLogsWithReenroll(context.Background(), typ, logs, true)
-	if err != nil {
-		return errors.Wrap(err, "writing logs")
+	if len(logs) > 0 {
+		if err := sink.SendLogs(context.Background(), typ, logs); err != nil {
+			return cursor, errors.Wrap(err, "writing logs")
+		}
 	}
 
-	// Delete logs that were successfully sent
-	err = e.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(bucketName))
-		for _, k := range logIDs {
-			b.Delete(k)
-		}
+	if err := e.setLogSinkCursor(bucketName, sink, lastLogID); err != nil {
+		return cursor, err
+	}
+
+	return lastLogID, nil
+}
+
+// trimLogSinkBacklog advances the cursor of a sink which has fallen
+// more than Opts.MaxLogSinkBacklog logs behind, skipping its oldest
+// logs. The server sink is only bound by Opts.MaxBufferedLogs, as
+// before there were other sinks.
+func (e *Extension) trimLogSinkBacklog(sink LogSink, bucketName string, cursor []byte) ([]byte, error) {
+	if sink.Name() == serverLogSinkName {
+		return cursor, nil
+	}
+
+	var sequence uint64
+	if err := e.db.View(func(tx *bbolt.Tx) error {
+		sequence = tx.Bucket([]byte(bucketName)).Sequence()
 		return nil
-	})
-	if err != nil {
-		return errors.Wrap(err, "deleting sent logs")
+	}); err != nil {
+		return cursor, errors.Wrap(err, "reading buffer sequence")
 	}
 
-	return nil
+	var delivered uint64
+	if cursor != nil {
+		delivered = uint64FromByteKey(cursor)
+	}
+
+	limit := uint64(e.Opts.MaxLogSinkBacklog)
+	if sequence-delivered <= limit {
+		return cursor, nil
+	}
+
+	trimmed := byteKeyFromUint64(sequence - limit)
+	level.Info(e.Opts.Logger).Log(
+		"msg", "log sink backlog limit exceeded, skipping oldest logs",
+		"sink", sink.Name(),
+		"backlog", sequence-delivered,
+		"limit", limit,
+	)
+
+	if err := e.setLogSinkCursor(bucketName, sink, trimmed); err != nil {
+		return cursor, err
+	}
+	return trimmed, nil
 }
 
 // Helper to allow for a single attempt at re-enrollment
@@ -870,6 +1109,40 @@ func (e *Extension) LogString(ctx context.Context, typ logger.LogType, logText s
 	return nil
 }
 
+// instanceLogKey is the key logs from additional osquery instances are
+// tagged with.
+const instanceLogKey = "launcher_instance"
+
+// LogStringForInstance returns a LogString for an additional osquery
+// instance. Its logs are buffered and sent with the primary instance's,
+// tagged with the instance name.
+func (e *Extension) LogStringForInstance(name string) func(ctx context.Context, typ logger.LogType, logText string) error {
+	return func(ctx context.Context, typ logger.LogType, logText string) error {
+		return e.LogString(ctx, typ, tagLog(logText, name))
+	}
+}
+
+// tagLog adds the instance name to a JSON object log. Anything else is
+// returned unchanged.
+func tagLog(logText, name string) string {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(logText), &fields); err != nil || fields == nil {
+		return logText
+	}
+
+	nameJSON, err := json.Marshal(name)
+	if err != nil {
+		return logText
+	}
+	fields[instanceLogKey] = nameJSON
+
+	tagged, err := json.Marshal(fields)
+	if err != nil {
+		return logText
+	}
+	return string(tagged)
+}
+
 // GetQueries will request the distributed queries to execute from the server.
 func (e *Extension) GetQueries(ctx context.Context) (*distributed.GetQueriesResult, error) {
 	return e.getQueriesWithReenroll(ctx, true)
@@ -912,8 +1185,29 @@ func (e *Extension) getQueriesWithReenroll(ctx context.Context, reenroll bool) (
 
 // WriteResults will publish results of the executed distributed queries back
 // to the server.
+//
+// Results are queued before they are sent, so those that fail to send are
+// retried in the background until the server acknowledges them, or they
+// exceed Opts.MaxResultsAge. Once queued, a failure to send them isn't an
+// error.
 func (e *Extension) WriteResults(ctx context.Context, results []distributed.Result) error {
-	return e.writeResultsWithReenroll(ctx, results, true)
+	key, err := e.enqueueResults(results)
+	if err != nil {
+		level.Info(e.logger).Log("msg", "queueing results failed, sending directly", "err", err)
+		return e.writeResultsWithReenroll(ctx, results, true)
+	}
+
+	defer e.sendingResults.Delete(string(key))
+
+	if err := e.writeResultsWithReenroll(ctx, results, true); err != nil {
+		level.Debug(e.logger).Log("msg", "writing results failed, queued for retry", "err", err)
+		return nil
+	}
+
+	if err := e.deleteQueuedResults(key); err != nil {
+		level.Info(e.logger).Log("msg", "removing delivered results from the queue", "err", err)
+	}
+	return nil
 }
 
 // Helper to allow for a single attempt at re-enrollment
@@ -13,6 +13,7 @@ import (
 	"time"
 
 	"github.com/kolide/kit/testutil"
+	"github.com/kolide/launcher/pkg/backoff"
 	"github.com/kolide/launcher/pkg/service"
 	"github.com/kolide/launcher/pkg/service/mock"
 	"github.com/mixer/clock"
@@ -298,6 +299,28 @@ func TestExtensionGenerateConfigsCaching(t *testing.T) {
 	assert.Nil(t, err)
 }
 
+func TestExtensionSetEnrollSecret(t *testing.T) {
+	t.Parallel()
+
+	var gotEnrollSecret string
+	m := &mock.KolideService{
+		RequestEnrollmentFunc: func(ctx context.Context, enrollSecret, hostIdentifier string, details service.EnrollmentDetails) (string, bool, error) {
+			gotEnrollSecret = enrollSecret
+			return "node_key", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "old_secret"})
+	require.Nil(t, err)
+	e.SetQuerier(mockClient{})
+
+	e.SetEnrollSecret("new_secret")
+	_, _, err = e.Enroll(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, "new_secret", gotEnrollSecret)
+}
+
 func TestExtensionGenerateConfigsEnrollmentInvalid(t *testing.T) {
 	t.Parallel()
 
@@ -720,6 +743,213 @@ func TestExtensionWriteBufferedLogsDropsBigLog(t *testing.T) {
 	require.Equal(t, 0, finalLogCount, "no more queued logs")
 }
 
+func TestExtensionWriteBufferedLogsSendsBigLogAlone(t *testing.T) {
+	t.Parallel()
+
+	var gotBatches [][]string
+	m := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+			gotBatches = append(gotBatches, logs)
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret:     "enroll_secret",
+		MaxBytesPerBatch: 15,
+		MaxBytesPerLog:   40,
+	})
+	require.Nil(t, err)
+
+	e.LogString(context.Background(), logger.LogTypeString, "res1")
+	e.LogString(context.Background(), logger.LogTypeString, "this_result_is_big, but can be chunked")
+	e.LogString(context.Background(), logger.LogTypeString, "res2")
+	e.LogString(context.Background(), logger.LogTypeString, "this_result_is_tooooooo_big, even for chunking")
+	e.LogString(context.Background(), logger.LogTypeString, "res3")
+
+	for i := 0; i < 4; i++ {
+		require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	}
+
+	assert.Equal(t, [][]string{
+		{"res1"},
+		{"this_result_is_big, but can be chunked"},
+		{"res2", "res3"},
+	}, gotBatches)
+	assert.Equal(t, uint64(1), e.DroppedLogs())
+
+	finalLogCount, err := e.numberOfBufferedLogs(logger.LogTypeString)
+	require.NoError(t, err)
+	require.Equal(t, 0, finalLogCount, "no more queued logs")
+}
+
+type mockLogSink struct {
+	name string
+	logs []string
+	err  error
+}
+
+func (m *mockLogSink) Name() string { return m.name }
+
+func (m *mockLogSink) Accepts(typ logger.LogType) bool { return typ == logger.LogTypeString }
+
+func (m *mockLogSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.logs = append(m.logs, logs...)
+	return nil
+}
+
+func TestExtensionWriteBufferedLogsSinks(t *testing.T) {
+	t.Parallel()
+
+	var gotServerLogs []string
+	m := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+			gotServerLogs = append(gotServerLogs, logs...)
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+
+	good := &mockLogSink{name: "good"}
+	bad := &mockLogSink{name: "bad", err: errors.New("sink down")}
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret: "enroll_secret",
+		LogSinks:     []LogSink{good, bad},
+	})
+	require.Nil(t, err)
+
+	e.LogString(context.Background(), logger.LogTypeString, "result foo")
+	e.LogString(context.Background(), logger.LogTypeString, "result bar")
+	e.LogString(context.Background(), logger.LogTypeStatus, "status foo")
+
+	// A failing sink doesn't stop the others, but holds the logs in the buffer
+	require.Error(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	assert.Equal(t, []string{"result foo", "result bar"}, gotServerLogs)
+	assert.Equal(t, []string{"result foo", "result bar"}, good.logs)
+	count, err := e.numberOfBufferedLogs(logger.LogTypeString)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	// Status logs aren't accepted by the sinks, so only the server gates them
+	require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeStatus))
+	count, err = e.numberOfBufferedLogs(logger.LogTypeStatus)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	// Sinks that have delivered aren't sent the logs again
+	e.LogString(context.Background(), logger.LogTypeString, "result baz")
+	bad.err = nil
+	require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	assert.Equal(t, []string{"result foo", "result bar", "status foo", "result baz"}, gotServerLogs)
+	assert.Equal(t, []string{"result foo", "result bar", "result baz"}, good.logs)
+	assert.Equal(t, []string{"result foo", "result bar", "result baz"}, bad.logs)
+
+	count, err = e.numberOfBufferedLogs(logger.LogTypeString)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestExtensionLogSinkBacklog(t *testing.T) {
+	t.Parallel()
+
+	m := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+
+	good := &mockLogSink{name: "good"}
+	dead := &mockLogSink{name: "dead", err: errors.New("sink down")}
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret:      "enroll_secret",
+		LogSinks:          []LogSink{good, dead},
+		MaxLogSinkBacklog: 3,
+	})
+	require.Nil(t, err)
+
+	// A dead sink holds at most its backlog limit in the buffer
+	for i := 0; i < 10; i++ {
+		e.LogString(context.Background(), logger.LogTypeString, fmt.Sprintf("result %d", i))
+		require.Error(t, e.writeBufferedLogsForType(logger.LogTypeString))
+
+		count, err := e.numberOfBufferedLogs(logger.LogTypeString)
+		require.NoError(t, err)
+		require.LessOrEqual(t, count, 3)
+	}
+	require.Len(t, good.logs, 10)
+
+	// Once it recovers, it gets the newest logs
+	dead.err = nil
+	require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	assert.Equal(t, []string{"result 7", "result 8", "result 9"}, dead.logs)
+
+	count, err := e.numberOfBufferedLogs(logger.LogTypeString)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestExtensionDisableServerLogs(t *testing.T) {
+	t.Parallel()
+
+	m := &mock.KolideService{}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+
+	sink := &mockLogSink{name: "only"}
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret:      "enroll_secret",
+		LogSinks:          []LogSink{sink},
+		DisableServerLogs: true,
+	})
+	require.Nil(t, err)
+
+	e.LogString(context.Background(), logger.LogTypeString, "result foo")
+	require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	assert.Equal(t, []string{"result foo"}, sink.logs)
+	assert.False(t, m.PublishLogsFuncInvoked)
+
+	_, err = NewExtension(m, db, ExtensionOpts{
+		EnrollSecret: "enroll_secret",
+		LogSinks:     []LogSink{&mockLogSink{name: "dup"}, &mockLogSink{name: "dup"}},
+	})
+	require.Error(t, err, "sink names must be unique")
+}
+
+func TestExtensionLogStringForInstance(t *testing.T) {
+	t.Parallel()
+
+	var gotResultLogs []string
+	m := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+			gotResultLogs = logs
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "enroll_secret"})
+	require.Nil(t, err)
+
+	logString := e.LogStringForInstance("fim")
+	require.NoError(t, logString(context.Background(), logger.LogTypeString, `{"name":"pack:files","action":"added"}`))
+	require.NoError(t, logString(context.Background(), logger.LogTypeString, "not json"))
+	require.NoError(t, e.LogString(context.Background(), logger.LogTypeString, `{"name":"pack:primary"}`))
+
+	require.NoError(t, e.writeBufferedLogsForType(logger.LogTypeString))
+	assert.Equal(t, []string{
+		`{"action":"added","launcher_instance":"fim","name":"pack:files"}`,
+		"not json",
+		`{"name":"pack:primary"}`,
+	}, gotResultLogs)
+}
+
 func TestExtensionWriteLogsLoop(t *testing.T) {
 	t.Parallel()
 
@@ -819,6 +1049,56 @@ func TestExtensionWriteLogsLoop(t *testing.T) {
 	})
 }
 
+func TestExtensionSetLoggingInterval(t *testing.T) {
+	t.Parallel()
+
+	var done = make(chan struct{})
+	m := &mock.KolideService{
+		PublishLogsFunc: func(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+			done <- struct{}{}
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	mockClock := clock.NewMockClock()
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret:    "enroll_secret",
+		Clock:           mockClock,
+		LoggingInterval: 10 * time.Second,
+	})
+	require.Nil(t, err)
+
+	e.LogString(context.Background(), logger.LogTypeStatus, "first")
+	e.Start()
+	testutil.FatalAfterFunc(t, 1*time.Second, func() {
+		<-done
+	})
+
+	e.SetLoggingInterval(time.Hour)
+	// Give the loop a chance to pick up the new interval
+	time.Sleep(10 * time.Millisecond)
+
+	e.LogString(context.Background(), logger.LogTypeStatus, "second")
+
+	// The old interval no longer triggers a write
+	mockClock.AddTime(10*time.Second + 1)
+	select {
+	case <-done:
+		t.Fatal("logs written on the old interval")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	mockClock.AddTime(time.Hour)
+	testutil.FatalAfterFunc(t, 1*time.Second, func() {
+		<-done
+	})
+
+	testutil.FatalAfterFunc(t, 3*time.Second, func() {
+		e.Shutdown()
+	})
+}
+
 func TestExtensionPurgeBufferedLogs(t *testing.T) {
 	t.Parallel()
 
@@ -953,9 +1233,14 @@ func TestExtensionWriteResultsTransportError(t *testing.T) {
 	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "enroll_secret"})
 	require.Nil(t, err)
 
+	// The results are queued for retry, so the failure isn't an error
 	err = e.WriteResults(context.Background(), []distributed.Result{})
 	assert.True(t, m.PublishResultsFuncInvoked)
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
+
+	queued, err := e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
 }
 
 func TestExtensionWriteResultsEnrollmentInvalid(t *testing.T) {
@@ -982,8 +1267,12 @@ func TestExtensionWriteResultsEnrollmentInvalid(t *testing.T) {
 	err = e.WriteResults(context.Background(), []distributed.Result{})
 	assert.True(t, m.PublishResultsFuncInvoked)
 	assert.True(t, m.RequestEnrollmentFuncInvoked)
-	assert.NotNil(t, err)
+	assert.Nil(t, err)
 	assert.Equal(t, expectedNodeKey, gotNodeKey)
+
+	queued, err := e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
 }
 
 func TestExtensionWriteResults(t *testing.T) {
@@ -1015,6 +1304,135 @@ func TestExtensionWriteResults(t *testing.T) {
 	assert.Equal(t, expectedResults, gotResults)
 }
 
+func TestExtensionWriteResultsQueuedUntilAcknowledged(t *testing.T) {
+	t.Parallel()
+
+	offline := true
+	var gotResults []distributed.Result
+	m := &mock.KolideService{
+		PublishResultsFunc: func(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+			if offline {
+				return "", "", false, errors.New("transport")
+			}
+			gotResults = append(gotResults, results...)
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "enroll_secret"})
+	require.Nil(t, err)
+	e.resultsBackoffOpts = []backoff.Opt{backoff.MaxAttempts(1)}
+
+	first := distributed.Result{QueryName: "first", Rows: []map[string]string{{"foo": "bar"}}}
+	second := distributed.Result{QueryName: "second", Rows: []map[string]string{{"baz": "qux"}}}
+
+	require.NoError(t, e.WriteResults(context.Background(), []distributed.Result{first}))
+	require.NoError(t, e.WriteResults(context.Background(), []distributed.Result{second}))
+
+	queued, err := e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 2, queued)
+
+	// Still offline, so the retry fails and the results stay queued
+	require.Error(t, e.writeQueuedResults(context.Background(), true))
+	queued, err = e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 2, queued)
+
+	offline = false
+	require.NoError(t, e.writeQueuedResults(context.Background(), true))
+	assert.Equal(t, []distributed.Result{first, second}, gotResults)
+
+	queued, err = e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 0, queued)
+}
+
+func TestExtensionWriteResultsDropsExpired(t *testing.T) {
+	t.Parallel()
+
+	offline := true
+	var gotResults []distributed.Result
+	m := &mock.KolideService{
+		PublishResultsFunc: func(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+			if offline {
+				return "", "", false, errors.New("transport")
+			}
+			gotResults = append(gotResults, results...)
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	mockClock := clock.NewMockClock()
+	e, err := NewExtension(m, db, ExtensionOpts{
+		EnrollSecret:  "enroll_secret",
+		Clock:         mockClock,
+		MaxResultsAge: time.Hour,
+	})
+	require.Nil(t, err)
+	e.resultsBackoffOpts = []backoff.Opt{backoff.MaxAttempts(1)}
+
+	stale := distributed.Result{QueryName: "stale"}
+	fresh := distributed.Result{QueryName: "fresh"}
+
+	require.NoError(t, e.WriteResults(context.Background(), []distributed.Result{stale}))
+	mockClock.AddTime(2 * time.Hour)
+	require.NoError(t, e.WriteResults(context.Background(), []distributed.Result{fresh}))
+
+	offline = false
+	require.NoError(t, e.writeQueuedResults(context.Background(), true))
+	assert.Equal(t, []distributed.Result{fresh}, gotResults)
+	assert.Equal(t, uint64(1), e.DroppedResults())
+}
+
+func TestExtensionWriteResultsRequeuesFailingBatch(t *testing.T) {
+	t.Parallel()
+
+	var gotResults []distributed.Result
+	m := &mock.KolideService{
+		PublishResultsFunc: func(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+			if len(results) > 0 && results[0].QueryName == "rejected" {
+				return "", "", false, errors.New("rejected")
+			}
+			gotResults = append(gotResults, results...)
+			return "", "", false, nil
+		},
+	}
+	db, cleanup := makeTempDB(t)
+	defer cleanup()
+	e, err := NewExtension(m, db, ExtensionOpts{EnrollSecret: "enroll_secret"})
+	require.Nil(t, err)
+	e.resultsBackoffOpts = []backoff.Opt{backoff.MaxAttempts(1)}
+
+	rejected := distributed.Result{QueryName: "rejected"}
+	accepted := distributed.Result{QueryName: "accepted"}
+
+	// Queue the accepted results behind the rejected ones, as though
+	// they failed to send while the server was unreachable.
+	for _, result := range []distributed.Result{rejected, accepted} {
+		_, err = e.putQueuedResults(queuedResults{
+			QueuedAt: time.Now(),
+			Results:  []distributed.Result{result},
+		}, false)
+		require.NoError(t, err)
+	}
+
+	for i := 0; i < resultsRetriesBeforeRequeue; i++ {
+		require.Error(t, e.writeQueuedResults(context.Background(), true))
+		assert.Empty(t, gotResults)
+	}
+
+	// The rejected batch is now at the back of the queue
+	require.Error(t, e.writeQueuedResults(context.Background(), true))
+	assert.Equal(t, []distributed.Result{accepted}, gotResults)
+
+	queued, err := e.numberOfQueuedResults()
+	require.NoError(t, err)
+	require.Equal(t, 1, queued)
+}
+
 func TestLauncherKeys(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,101 @@
+package osquery
+
+import (
+	"bytes"
+	"context"
+
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+// Bucket name to use for the per sink delivery cursors into the log buffers
+const logSinkCursorsBucket = "log_sink_cursors"
+
+// serverLogSinkName is the name of the sink which publishes logs to the
+// server over the launcher transport.
+const serverLogSinkName = "kolide_server"
+
+// LogSink is a destination for buffered osquery logs. Each sink tracks
+// its own delivery cursor into the buffer, so a failing sink does not
+// block delivery to the others. Logs are only removed from the buffer
+// once every sink accepting them has delivered them.
+type LogSink interface {
+	// Name uniquely identifies the sink. It is used to key the sink's
+	// delivery cursor, so it should be stable across restarts.
+	Name() string
+	// Accepts reports whether the sink wants logs of the given type
+	Accepts(typ logger.LogType) bool
+	// SendLogs delivers a batch of logs
+	SendLogs(ctx context.Context, typ logger.LogType, logs []string) error
+}
+
+// serverLogSink publishes logs to the server, re-enrolling if needed
+type serverLogSink struct {
+	e *Extension
+}
+
+func (s serverLogSink) Name() string {
+	return serverLogSinkName
+}
+
+func (s serverLogSink) Accepts(typ logger.LogType) bool {
+	return true
+}
+
+func (s serverLogSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	return s.e.writeLogsWithReenroll(ctx, typ, logs, true)
+}
+
+func logSinkCursorKey(bucketName string, sink LogSink) []byte {
+	return []byte(bucketName + "/" + sink.Name())
+}
+
+// logSinkCursor returns the key of the last log in bucketName that was
+// delivered to sink, or nil if nothing has been.
+func (e *Extension) logSinkCursor(bucketName string, sink LogSink) ([]byte, error) {
+	var cursor []byte
+	err := e.db.View(func(tx *bbolt.Tx) error {
+		stored := tx.Bucket([]byte(logSinkCursorsBucket)).Get(logSinkCursorKey(bucketName, sink))
+		if stored == nil {
+			return nil
+		}
+
+		// A cursor past the buffer's sequence means the buffer was
+		// reset underneath us. Start over, rather than skip logs.
+		if uint64FromByteKey(stored) > tx.Bucket([]byte(bucketName)).Sequence() {
+			return nil
+		}
+
+		cursor = make([]byte, len(stored))
+		copy(cursor, stored)
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "reading log sink cursor")
+	}
+
+	return cursor, nil
+}
+
+func (e *Extension) setLogSinkCursor(bucketName string, sink LogSink, cursor []byte) error {
+	err := e.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(logSinkCursorsBucket)).Put(logSinkCursorKey(bucketName, sink), cursor)
+	})
+	return errors.Wrap(err, "storing log sink cursor")
+}
+
+// deleteDeliveredLogs removes the logs in bucketName up to, and
+// including, cursor.
+func (e *Extension) deleteDeliveredLogs(bucketName string, cursor []byte) error {
+	err := e.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket([]byte(bucketName)).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, cursor) <= 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return errors.Wrap(err, "deleting sent logs")
+}
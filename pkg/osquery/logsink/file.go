@@ -0,0 +1,77 @@
+package logsink
+
+import (
+	"context"
+	"sync"
+
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// FileSink writes logs, one per line, to a size rotated file. As
+// osquery logs are JSON, this produces a JSONL file.
+type FileSink struct {
+	typeFilter
+
+	lock sync.Mutex
+	lj   *lumberjack.Logger
+}
+
+type FileOption func(*FileSink)
+
+// WithFileLogTypes sets the log types written to the file
+func WithFileLogTypes(types ...logger.LogType) FileOption {
+	return func(f *FileSink) {
+		f.typeFilter = types
+	}
+}
+
+// WithRotation sets the size, in megabytes, at which the file is
+// rotated, and the number of rotated files kept.
+func WithRotation(maxSizeMB int, maxBackups int) FileOption {
+	return func(f *FileSink) {
+		f.lj.MaxSize = maxSizeMB
+		f.lj.MaxBackups = maxBackups
+	}
+}
+
+// NewFileSink returns a FileSink writing to path
+func NewFileSink(path string, opts ...FileOption) *FileSink {
+	f := &FileSink{
+		lj: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    100, // megabytes
+			MaxBackups: 5,
+			Compress:   true,
+		},
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+func (f *FileSink) Name() string {
+	return "file:" + f.lj.Filename
+}
+
+func (f *FileSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, l := range logs {
+		if _, err := f.lj.Write([]byte(l + "\n")); err != nil {
+			return errors.Wrap(err, "writing log to file")
+		}
+	}
+
+	return nil
+}
+
+// Close closes the underlying file
+func (f *FileSink) Close() error {
+	return f.lj.Close()
+}
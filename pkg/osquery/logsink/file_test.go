@@ -0,0 +1,38 @@
+package logsink
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileSink(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	f := NewFileSink(path)
+	defer f.Close()
+
+	require.True(t, f.Accepts(logger.LogTypeString))
+	require.True(t, f.Accepts(logger.LogTypeSnapshot))
+	require.False(t, f.Accepts(logger.LogTypeStatus))
+
+	require.NoError(t, f.SendLogs(context.Background(), logger.LogTypeString, []string{`{"a":1}`, `{"b":2}`}))
+	require.NoError(t, f.SendLogs(context.Background(), logger.LogTypeString, []string{`{"c":3}`}))
+
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "{\"a\":1}\n{\"b\":2}\n{\"c\":3}\n", string(contents))
+}
+
+func TestFileSinkLogTypes(t *testing.T) {
+	t.Parallel()
+
+	f := NewFileSink(filepath.Join(t.TempDir(), "status.jsonl"), WithFileLogTypes(logger.LogTypeStatus))
+	require.True(t, f.Accepts(logger.LogTypeStatus))
+	require.False(t, f.Accepts(logger.LogTypeString))
+}
@@ -0,0 +1,25 @@
+// Package logsink provides local and third party destinations for the
+// osquery logs buffered by the launcher extension. They implement
+// osquery.LogSink.
+package logsink
+
+import (
+	"github.com/osquery/osquery-go/plugin/logger"
+)
+
+// typeFilter selects which log types a sink accepts. When empty, only
+// result logs are accepted.
+type typeFilter []logger.LogType
+
+func (f typeFilter) Accepts(typ logger.LogType) bool {
+	if len(f) == 0 {
+		return typ == logger.LogTypeString || typ == logger.LogTypeSnapshot
+	}
+
+	for _, t := range f {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
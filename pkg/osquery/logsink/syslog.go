@@ -0,0 +1,125 @@
+//go:build !windows
+// +build !windows
+
+package logsink
+
+import (
+	"context"
+	"log/syslog"
+	"net/url"
+	"sync"
+
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+)
+
+// SyslogSink writes logs to syslog. By default this is the local
+// syslog socket, which journald also listens on.
+type SyslogSink struct {
+	typeFilter
+
+	network string
+	addr    string
+	tag     string
+
+	lock   sync.Mutex
+	writer *syslog.Writer
+}
+
+type SyslogOption func(*SyslogSink)
+
+// WithSyslogLogTypes sets the log types written to syslog
+func WithSyslogLogTypes(types ...logger.LogType) SyslogOption {
+	return func(s *SyslogSink) {
+		s.typeFilter = types
+	}
+}
+
+// WithSyslogTag sets the syslog tag. It defaults to osquery
+func WithSyslogTag(tag string) SyslogOption {
+	return func(s *SyslogSink) {
+		s.tag = tag
+	}
+}
+
+// NewSyslogSink returns a SyslogSink. The destination is either empty,
+// for the local syslog socket, or a URL such as udp://host:514 or
+// unix:///run/systemd/journal/syslog. The connection is made lazily, and
+// re-established after a failed write.
+func NewSyslogSink(destination string, opts ...SyslogOption) (*SyslogSink, error) {
+	s := &SyslogSink{
+		tag: "osquery",
+	}
+
+	if destination != "" {
+		u, err := url.Parse(destination)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing syslog destination")
+		}
+		switch u.Scheme {
+		case "udp", "tcp":
+			s.network, s.addr = u.Scheme, u.Host
+		case "unix", "unixgram":
+			s.network, s.addr = u.Scheme, u.Path
+		default:
+			return nil, errors.Errorf("unsupported syslog scheme %s", u.Scheme)
+		}
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+func (s *SyslogSink) Name() string {
+	if s.addr == "" {
+		return "syslog"
+	}
+	return "syslog:" + s.network + "://" + s.addr
+}
+
+func (s *SyslogSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer == nil {
+		w, err := syslog.Dial(s.network, s.addr, syslog.LOG_INFO|syslog.LOG_DAEMON, s.tag)
+		if err != nil {
+			return errors.Wrap(err, "connecting to syslog")
+		}
+		s.writer = w
+	}
+
+	for _, l := range logs {
+		var err error
+		if typ == logger.LogTypeStatus {
+			err = s.writer.Notice(l)
+		} else {
+			err = s.writer.Info(l)
+		}
+
+		if err != nil {
+			s.writer.Close()
+			s.writer = nil
+			return errors.Wrap(err, "writing to syslog")
+		}
+	}
+
+	return nil
+}
+
+// Close closes the syslog connection
+func (s *SyslogSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.writer == nil {
+		return nil
+	}
+
+	err := s.writer.Close()
+	s.writer = nil
+	return err
+}
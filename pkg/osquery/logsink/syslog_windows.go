@@ -0,0 +1,44 @@
+//go:build windows
+// +build windows
+
+package logsink
+
+import (
+	"context"
+
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+)
+
+// SyslogSink is not supported on windows
+type SyslogSink struct {
+	typeFilter
+}
+
+type SyslogOption func(*SyslogSink)
+
+func WithSyslogLogTypes(types ...logger.LogType) SyslogOption {
+	return func(s *SyslogSink) {
+		s.typeFilter = types
+	}
+}
+
+func WithSyslogTag(tag string) SyslogOption {
+	return func(s *SyslogSink) {}
+}
+
+func NewSyslogSink(destination string, opts ...SyslogOption) (*SyslogSink, error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
+
+func (s *SyslogSink) Name() string {
+	return "syslog"
+}
+
+func (s *SyslogSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	return errors.New("syslog is not supported on windows")
+}
+
+func (s *SyslogSink) Close() error {
+	return nil
+}
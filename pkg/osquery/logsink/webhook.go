@@ -0,0 +1,167 @@
+package logsink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kolide/launcher/pkg/proxy"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+)
+
+const defaultWebhookBatchSize = 500
+
+// WebhookSink POSTs logs to an HTTP(S) endpoint, in batches. Each
+// request body is a JSON object with the log type, and an array of the
+// logs.
+type WebhookSink struct {
+	typeFilter
+
+	url       string
+	name      string
+	client    *http.Client
+	headers   map[string]string
+	batchSize int
+}
+
+type webhookPayload struct {
+	LogType string            `json:"log_type"`
+	Logs    []json.RawMessage `json:"logs"`
+}
+
+type WebhookOption func(*WebhookSink)
+
+// WithWebhookLogTypes sets the log types sent to the webhook
+func WithWebhookLogTypes(types ...logger.LogType) WebhookOption {
+	return func(w *WebhookSink) {
+		w.typeFilter = types
+	}
+}
+
+// WithWebhookClient sets the http client used, for example to set TLS
+// options.
+func WithWebhookClient(client *http.Client) WebhookOption {
+	return func(w *WebhookSink) {
+		w.client = client
+	}
+}
+
+// WithWebhookHeader adds a header to each request, for example an
+// Authorization header.
+func WithWebhookHeader(key, value string) WebhookOption {
+	return func(w *WebhookSink) {
+		w.headers[key] = value
+	}
+}
+
+// WithWebhookBatchSize sets the maximum number of logs per request
+func WithWebhookBatchSize(size int) WebhookOption {
+	return func(w *WebhookSink) {
+		w.batchSize = size
+	}
+}
+
+// NewWebhookSink returns a WebhookSink posting to url
+func NewWebhookSink(url string, opts ...WebhookOption) *WebhookSink {
+	w := &WebhookSink{
+		url:       url,
+		name:      "webhook:" + redactURL(url),
+		client:    &http.Client{Timeout: 30 * time.Second, Transport: proxy.NewTransport()},
+		headers:   make(map[string]string),
+		batchSize: defaultWebhookBatchSize,
+	}
+
+	for _, opt := range opts {
+		opt(w)
+	}
+
+	return w
+}
+
+// Name identifies the sink by its URL's host and path. The name is
+// logged, and stored as the sink's cursor key, so any credentials or
+// tokens in the URL are left out.
+func (w *WebhookSink) Name() string {
+	return w.name
+}
+
+// redactURL returns rawURL without its user info, query or fragment
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "invalid-url"
+	}
+	return (&url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}).String()
+}
+
+// SendLogs posts the logs in batches. Batches are sent in order, and an
+// error stops the remaining batches. As the extension retries the whole
+// set, the receiver may see a batch more than once.
+func (w *WebhookSink) SendLogs(ctx context.Context, typ logger.LogType, logs []string) error {
+	for start := 0; start < len(logs); start += w.batchSize {
+		end := start + w.batchSize
+		if end > len(logs) {
+			end = len(logs)
+		}
+
+		if err := w.post(ctx, typ, logs[start:end]); err != nil {
+			return errors.Wrapf(err, "posting logs %d to %d", start, end)
+		}
+	}
+
+	return nil
+}
+
+func (w *WebhookSink) post(ctx context.Context, typ logger.LogType, logs []string) error {
+	payload := webhookPayload{
+		LogType: typ.String(),
+		Logs:    make([]json.RawMessage, len(logs)),
+	}
+	for i, l := range logs {
+		if json.Valid([]byte(l)) {
+			payload.Logs[i] = json.RawMessage(l)
+			continue
+		}
+
+		// Not expected from osquery, but keep the payload valid
+		quoted, err := json.Marshal(l)
+		if err != nil {
+			return errors.Wrap(err, "marshaling log")
+		}
+		payload.Logs[i] = quoted
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return errors.Wrap(err, "marshaling payload")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "making request")
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,247 @@
+package osquery
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/backoff"
+	"github.com/osquery/osquery-go/plugin/distributed"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+)
+
+const (
+	// Bucket name to use for distributed query results awaiting
+	// delivery to the server.
+	distributedResultsBucket = "distributed_results"
+
+	// Default maximum age of queued distributed query results. Older
+	// results are dropped rather than sent.
+	defaultMaxResultsAge = 24 * time.Hour
+	// Default interval at which queued distributed query results are
+	// retried.
+	defaultResultsRetryInterval = 30 * time.Second
+	// resultsRetryAttempts is the number of attempts made to deliver
+	// each queued batch of results, on each retry.
+	resultsRetryAttempts = 3
+	// resultsRetriesBeforeRequeue is the number of retries a batch of
+	// results may fail at the head of the queue, before it's moved to
+	// the back so that it doesn't hold up the batches behind it.
+	resultsRetriesBeforeRequeue = 3
+)
+
+// queuedResults are distributed query results waiting to be
+// acknowledged by the server.
+type queuedResults struct {
+	QueuedAt time.Time            `json:"queued_at"`
+	Failures int                  `json:"failures,omitempty"`
+	Results  []distributed.Result `json:"results"`
+}
+
+// enqueueResults buffers distributed query results until the server
+// acknowledges them, and returns their key. The results are marked as
+// being sent, so they aren't retried until the caller removes the key
+// from e.sendingResults.
+func (e *Extension) enqueueResults(results []distributed.Result) ([]byte, error) {
+	return e.putQueuedResults(queuedResults{
+		QueuedAt: e.Opts.Clock.Now(),
+		Results:  results,
+	}, true)
+}
+
+// putQueuedResults adds batch to the back of the queue.
+func (e *Extension) putQueuedResults(batch queuedResults, sending bool) ([]byte, error) {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return nil, errors.Wrap(err, "marshalling results")
+	}
+
+	var key []byte
+	err = e.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(distributedResultsBucket))
+		id, err := b.NextSequence()
+		if err != nil {
+			return errors.Wrap(err, "generating key")
+		}
+		key = byteKeyFromUint64(id)
+		if sending {
+			// Before the commit, so a retry never sees it unmarked
+			e.sendingResults.Store(string(key), struct{}{})
+		}
+		return b.Put(key, data)
+	})
+	if err != nil {
+		if key != nil {
+			e.sendingResults.Delete(string(key))
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// numberOfQueuedResults returns the number of batches of distributed
+// query results awaiting delivery.
+func (e *Extension) numberOfQueuedResults() (int, error) {
+	var count int
+	err := e.db.View(func(tx *bbolt.Tx) error {
+		count = tx.Bucket([]byte(distributedResultsBucket)).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return 0, errors.Wrap(err, "counting queued results")
+	}
+	return count, nil
+}
+
+// DroppedResults returns the number of batches of distributed query
+// results discarded for exceeding Opts.MaxResultsAge.
+func (e *Extension) DroppedResults() uint64 {
+	return atomic.LoadUint64(&e.droppedResults)
+}
+
+// writeQueuedResults delivers queued distributed query results to the
+// server, oldest first. Delivery stops at the first failure, so results
+// arrive in order. A batch which keeps failing is moved to the back of
+// the queue, so it can't hold up the rest until it expires. If retry is
+// set, each batch is attempted several times before giving up.
+func (e *Extension) writeQueuedResults(ctx context.Context, retry bool) error {
+	e.resultsLock.Lock()
+	defer e.resultsLock.Unlock()
+
+	type queued struct {
+		key   []byte
+		value []byte
+	}
+	var pending []queued
+	err := e.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(distributedResultsBucket)).ForEach(func(k, v []byte) error {
+			// Copy, as k and v are only valid for the life of the
+			// transaction.
+			pending = append(pending, queued{
+				key:   append([]byte(nil), k...),
+				value: append([]byte(nil), v...),
+			})
+			return nil
+		})
+	})
+	if err != nil {
+		return errors.Wrap(err, "reading queued results")
+	}
+
+	for _, q := range pending {
+		// WriteResults is still sending this batch itself
+		if _, sending := e.sendingResults.Load(string(q.key)); sending {
+			continue
+		}
+
+		var batch queuedResults
+		if err := json.Unmarshal(q.value, &batch); err != nil {
+			level.Info(e.logger).Log("msg", "dropping unreadable queued results", "err", err)
+			if err := e.deleteQueuedResults(q.key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if age := e.Opts.Clock.Now().Sub(batch.QueuedAt); age > e.Opts.MaxResultsAge {
+			atomic.AddUint64(&e.droppedResults, 1)
+			level.Info(e.logger).Log(
+				"msg", "dropping expired distributed query results",
+				"age", age,
+				"limit", e.Opts.MaxResultsAge,
+				"results", len(batch.Results),
+			)
+			if err := e.deleteQueuedResults(q.key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		send := func() error {
+			return e.writeResultsWithReenroll(ctx, batch.Results, true)
+		}
+		if retry {
+			err = backoff.New(e.resultsBackoffOpts...).Run(send)
+		} else {
+			err = send()
+		}
+		if err != nil {
+			if requeueErr := e.recordResultsFailure(q.key, batch); requeueErr != nil {
+				level.Info(e.logger).Log("msg", "recording failed results", "err", requeueErr)
+			}
+			return errors.Wrap(err, "results queued for retry")
+		}
+
+		if err := e.deleteQueuedResults(q.key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// recordResultsFailure counts a failed retry of the batch stored at key.
+// Once it has failed resultsRetriesBeforeRequeue times, it's moved to the
+// back of the queue.
+func (e *Extension) recordResultsFailure(key []byte, batch queuedResults) error {
+	batch.Failures++
+	if batch.Failures < resultsRetriesBeforeRequeue {
+		data, err := json.Marshal(batch)
+		if err != nil {
+			return errors.Wrap(err, "marshalling results")
+		}
+		err = e.db.Update(func(tx *bbolt.Tx) error {
+			return tx.Bucket([]byte(distributedResultsBucket)).Put(key, data)
+		})
+		return errors.Wrap(err, "updating queued results")
+	}
+
+	level.Info(e.logger).Log(
+		"msg", "moving repeatedly failing distributed query results to the back of the queue",
+		"failures", batch.Failures,
+		"results", len(batch.Results),
+	)
+	batch.Failures = 0
+	if _, err := e.putQueuedResults(batch, false); err != nil {
+		return errors.Wrap(err, "requeueing results")
+	}
+	return e.deleteQueuedResults(key)
+}
+
+func (e *Extension) deleteQueuedResults(key []byte) error {
+	err := e.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket([]byte(distributedResultsBucket)).Delete(key)
+	})
+	return errors.Wrap(err, "deleting queued results")
+}
+
+// writeResultsLoopRunner periodically retries queued distributed query
+// results, until Shutdown is called.
+func (e *Extension) writeResultsLoopRunner() {
+	defer e.wg.Done()
+	ticker := e.Opts.Clock.NewTicker(e.Opts.ResultsRetryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-e.done:
+			return
+		case <-ticker.Chan():
+		}
+
+		count, err := e.numberOfQueuedResults()
+		if err != nil {
+			level.Info(e.logger).Log("msg", "checking queued results", "err", err)
+			continue
+		}
+		if count == 0 {
+			continue
+		}
+
+		if err := e.writeQueuedResults(context.Background(), true); err != nil {
+			level.Debug(e.logger).Log("msg", "retrying queued results", "queued", count, "err", err)
+		}
+	}
+}
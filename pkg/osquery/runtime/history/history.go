@@ -2,6 +2,7 @@ package history
 
 import (
 	"os"
+	"sort"
 	"sync"
 	"time"
 
@@ -13,10 +14,27 @@ const maxInstances = 10
 
 var currentHistory *History = &History{}
 
+// secondaryHistory records the additional osquery instances, in a ring of
+// their own, so that they can't push the primary instance out of its
+// history.
+var secondaryHistory *History = &History{secondary: true}
+
 type History struct {
 	sync.Mutex
 	instances []*Instance
 	db        *bbolt.DB
+	// next is the sequence number of the next instance added
+	next uint64
+	// secondary is set for the history of additional osquery instances
+	secondary bool
+}
+
+// historyFor returns the history an instance named name is recorded in
+func historyFor(name string) *History {
+	if name == "" {
+		return currentHistory
+	}
+	return secondaryHistory
 }
 
 type NoInstancesError struct{}
@@ -27,56 +45,83 @@ func (c NoInstancesError) Error() string {
 
 // InitHistory loads the osquery instance history from bbolt DB if exists, sets up bucket if it does not
 func InitHistory(db *bbolt.DB) error {
-	currentHistory.Lock()
-	defer currentHistory.Unlock()
+	for _, h := range []*History{currentHistory, secondaryHistory} {
+		if err := h.init(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	err := createBboltBucketIfNotExists(db)
+func (h *History) init(db *bbolt.DB) error {
+	h.Lock()
+	defer h.Unlock()
+
+	err := createBboltBucketIfNotExists(db, h.bucketName())
 	if err != nil {
 		return err
 	}
 
-	currentHistory.db = db
+	h.db = db
 
-	if err := currentHistory.load(); err != nil {
+	if err := h.load(); err != nil {
 		return errors.Wrap(err, "error loading osquery_instance_history")
 	}
 
 	return nil
 }
 
-// GetHistory returns the last 10 instances of osquery started / restarted by launcher, each start / restart cycle is an entry
+// GetHistory returns the last 10 instances of osquery started / restarted by launcher, each start / restart cycle is an entry,
+// followed by the last 10 of any additional osquery instances, ordered by start time
 func GetHistory() ([]Instance, error) {
-	currentHistory.Lock()
-	defer currentHistory.Unlock()
+	var results []Instance
+	for _, h := range []*History{currentHistory, secondaryHistory} {
+		h.Lock()
+		for _, v := range h.instances {
+			results = append(results, *v)
+		}
+		h.Unlock()
+	}
 
-	if currentHistory.instances == nil {
+	if results == nil {
 		return nil, NoInstancesError{}
 	}
 
-	results := make([]Instance, len(currentHistory.instances))
-	for i, v := range currentHistory.instances {
-		results[i] = *v
-	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].StartTime < results[j].StartTime
+	})
 
 	return results, nil
 }
 
-// LatestInstance returns the latest osquery instance
+// LatestInstance returns the latest primary osquery instance
 func LatestInstance() (Instance, error) {
 	currentHistory.Lock()
 	defer currentHistory.Unlock()
 
-	if currentHistory.instances == nil || len(currentHistory.instances) == 0 {
-		return Instance{}, NoInstancesError{}
+	// History from before additional instances had a ring of their own
+	// may include them, so they're skipped.
+	for i := len(currentHistory.instances) - 1; i >= 0; i-- {
+		if currentHistory.instances[i].Name == "" {
+			return *currentHistory.instances[i], nil
+		}
 	}
 
-	return *currentHistory.instances[len(currentHistory.instances)-1], nil
+	return Instance{}, NoInstancesError{}
 }
 
 // NewInstance adds a new instance to the osquery instance history and returns it
 func NewInstance() (*Instance, error) {
-	currentHistory.Lock()
-	defer currentHistory.Unlock()
+	return NewNamedInstance("")
+}
+
+// NewNamedInstance adds a new instance of an additional osquery instance,
+// named name, to the history and returns it. The primary instance has no
+// name.
+func NewNamedInstance(name string) (*Instance, error) {
+	h := historyFor(name)
+	h.Lock()
+	defer h.Unlock()
 
 	hostname, err := os.Hostname()
 	if err != nil {
@@ -84,13 +129,14 @@ func NewInstance() (*Instance, error) {
 	}
 
 	newInstance := &Instance{
+		Name:      name,
 		StartTime: timeNow(),
 		Hostname:  hostname,
 	}
 
-	currentHistory.addInstanceToHistory(newInstance)
+	h.addInstanceToHistory(newInstance)
 
-	if err := currentHistory.save(); err != nil {
+	if err := h.save(); err != nil {
 		return newInstance, errors.Wrap(err, "error saving osquery_instance_history")
 	}
 
@@ -98,6 +144,7 @@ func NewInstance() (*Instance, error) {
 }
 
 func (h *History) addInstanceToHistory(instance *Instance) {
+	h.next++
 	if h.instances == nil {
 		h.instances = []*Instance{instance}
 		return
@@ -151,6 +151,21 @@ func TestLatestInstance(t *testing.T) { // nolint:paralleltest
 				StartTime: "second_expected_start_time",
 			},
 		},
+		{
+			name: "skips_additional_instances",
+			initialInstances: []*Instance{
+				{
+					StartTime: "primary_start_time",
+				},
+				{
+					Name:      "extra",
+					StartTime: "extra_start_time",
+				},
+			},
+			want: Instance{
+				StartTime: "primary_start_time",
+			},
+		},
 		{
 			name:      "no_instances_error",
 			errString: NoInstancesError{}.Error(),
@@ -184,6 +199,78 @@ func TestNoDbError(t *testing.T) { // nolint:paralleltest
 	assert.ErrorIs(t, err, NoDbError{})
 }
 
+func TestHistoryRing(t *testing.T) { // nolint:paralleltest
+	t.Cleanup(func() { currentHistory = &History{} })
+
+	// Seeded in the old, single list, format
+	db := newTestBoltDb(t, &Instance{StartTime: "legacy"})
+	require.NoError(t, InitHistory(db))
+
+	var last *Instance
+	for i := 0; i < 2*maxInstances; i++ {
+		instance, err := NewInstance()
+		require.NoError(t, err)
+		last = instance
+	}
+	require.NoError(t, last.Exited(nil))
+
+	want, err := GetHistory()
+	require.NoError(t, err)
+	require.Len(t, want, maxInstances)
+
+	// The old format is gone, and the ring holds only the newest
+	require.NoError(t, db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket([]byte(osqueryHistoryInstanceKey))
+		assert.Nil(t, b.Get([]byte(osqueryHistoryInstanceKey)))
+		assert.Equal(t, maxInstances+1, b.Stats().KeyN)
+		return nil
+	}))
+
+	// Reloading gives the same history, in the same order
+	currentHistory = &History{}
+	require.NoError(t, InitHistory(db))
+	got, err := GetHistory()
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+	assert.NotEmpty(t, got[len(got)-1].ExitTime)
+}
+
+func TestSecondaryHistory(t *testing.T) { // nolint:paralleltest
+	t.Cleanup(func() {
+		currentHistory = &History{}
+		secondaryHistory = &History{secondary: true}
+	})
+
+	db := newTestBoltDb(t)
+	require.NoError(t, InitHistory(db))
+
+	primary, err := NewInstance()
+	require.NoError(t, err)
+
+	// A crash looping additional instance doesn't push the primary out
+	for i := 0; i < 2*maxInstances; i++ {
+		_, err := NewNamedInstance("extra")
+		require.NoError(t, err)
+	}
+
+	latest, err := LatestInstance()
+	require.NoError(t, err)
+	assert.Equal(t, *primary, latest)
+
+	got, err := GetHistory()
+	require.NoError(t, err)
+	require.Len(t, got, maxInstances+1)
+	assert.Contains(t, got, *primary)
+
+	// Both rings are reloaded
+	currentHistory = &History{}
+	secondaryHistory = &History{secondary: true}
+	require.NoError(t, InitHistory(db))
+	reloaded, err := GetHistory()
+	require.NoError(t, err)
+	assert.Equal(t, got, reloaded)
+}
+
 // newTestBoltDb creates a new boltdb instance and seeds it with the given instances.
 func newTestBoltDb(t *testing.T, seedInstances ...*Instance) *bbolt.DB {
 	dir := t.TempDir()
@@ -1,10 +1,17 @@
 package history
 
 import (
+	"os"
+	"syscall"
+	"time"
+
 	"github.com/pkg/errors"
 )
 
 type Instance struct {
+	// Name is the name of an additional osquery instance, or empty
+	// for the primary instance.
+	Name        string
 	StartTime   string
 	ConnectTime string
 	ExitTime    string
@@ -12,6 +19,47 @@ type Instance struct {
 	InstanceId  string
 	Version     string
 	Error       string
+	// ExitCode is the osqueryd exit code, or nil if it's unknown
+	ExitCode *int
+	// ExitSignal is the signal that killed osqueryd, if any
+	ExitSignal string
+	// RestartTrigger is what caused launcher to restart this instance,
+	// if it did, and RestartReason describes it.
+	RestartTrigger RestartTrigger
+	RestartReason  string
+	// PeakMemoryBytes is the most resident memory osqueryd was seen
+	// using.
+	PeakMemoryBytes uint64
+}
+
+// RestartTrigger is what caused launcher to restart an instance
+type RestartTrigger string
+
+const (
+	// RestartExited is osqueryd, or an extension server, exiting
+	// unexpectedly
+	RestartExited       RestartTrigger = "exited"
+	RestartHealthCheck  RestartTrigger = "health_check"
+	RestartWatchdog     RestartTrigger = "watchdog"
+	RestartUpdater      RestartTrigger = "updater"
+	RestartConfigChange RestartTrigger = "config_change"
+	RestartRequested    RestartTrigger = "requested"
+)
+
+// ExitDetails describe how an instance exited
+type ExitDetails struct {
+	// Err is the error the instance exited with, if any
+	Err error
+	// ProcessState is the state of the exited osqueryd process, if it
+	// ran.
+	ProcessState *os.ProcessState
+	// Trigger and Reason are what caused launcher to restart the
+	// instance. They're empty if it wasn't restarted.
+	Trigger RestartTrigger
+	Reason  string
+	// PeakMemoryBytes is the most resident memory osqueryd was seen
+	// using.
+	PeakMemoryBytes uint64
 }
 
 type Querier interface {
@@ -26,8 +74,9 @@ func (e ExpectedAtLeastOneRowError) Error() string {
 
 // Connected sets the connect time and instance id of the current osquery instance
 func (i *Instance) Connected(querier Querier) error {
-	currentHistory.Lock()
-	defer currentHistory.Unlock()
+	h := historyFor(i.Name)
+	h.Lock()
+	defer h.Unlock()
 
 	results, err := querier.Query("select instance_id, version from osquery_info order by start_time limit 1")
 	if err != nil {
@@ -52,7 +101,7 @@ func (i *Instance) Connected(querier Querier) error {
 	i.InstanceId = instanceId
 	i.Version = version
 
-	if err := currentHistory.save(); err != nil {
+	if err := h.save(); err != nil {
 		return errors.Wrap(err, "error saving osquery_instance_history")
 	}
 
@@ -61,18 +110,58 @@ func (i *Instance) Connected(querier Querier) error {
 
 // InstanceExited sets the exit time and appends provided error (if any) to current osquery instance
 func (i *Instance) Exited(exitError error) error {
-	currentHistory.Lock()
-	defer currentHistory.Unlock()
+	return i.ExitedWithDetails(ExitDetails{Err: exitError})
+}
+
+// ExitedWithDetails sets the exit time, and records how the current
+// osquery instance exited.
+func (i *Instance) ExitedWithDetails(details ExitDetails) error {
+	h := historyFor(i.Name)
+	h.Lock()
+	defer h.Unlock()
+
+	if details.Err != nil {
+		i.Error = details.Err.Error()
+	}
 
-	if exitError != nil {
-		i.Error = exitError.Error()
+	if state := details.ProcessState; state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			i.ExitSignal = status.Signal().String()
+		} else {
+			exitCode := state.ExitCode()
+			i.ExitCode = &exitCode
+		}
+	}
+
+	i.RestartTrigger = details.Trigger
+	i.RestartReason = details.Reason
+	if details.PeakMemoryBytes > i.PeakMemoryBytes {
+		i.PeakMemoryBytes = details.PeakMemoryBytes
 	}
 
 	i.ExitTime = timeNow()
 
-	if err := currentHistory.save(); err != nil {
+	if err := h.save(); err != nil {
 		return errors.Wrap(err, "error saving osquery_instance_history")
 	}
 
 	return nil
 }
+
+// Uptime returns how long the instance ran for, or has been running for
+// if it hasn't exited.
+func (i Instance) Uptime() (time.Duration, error) {
+	start, err := time.Parse(time.RFC3339, i.StartTime)
+	if err != nil {
+		return 0, errors.Wrap(err, "parsing start time")
+	}
+
+	end := time.Now()
+	if i.ExitTime != "" {
+		if end, err = time.Parse(time.RFC3339, i.ExitTime); err != nil {
+			return 0, errors.Wrap(err, "parsing exit time")
+		}
+	}
+
+	return end.Sub(start), nil
+}
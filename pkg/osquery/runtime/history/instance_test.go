@@ -2,6 +2,8 @@ package history
 
 import (
 	"errors"
+	"os/exec"
+	"runtime"
 	"testing"
 	"time"
 
@@ -109,3 +111,62 @@ func TestInstance_Exited(t *testing.T) { // nolint:paralleltest
 		})
 	}
 }
+
+func TestInstance_ExitedWithDetails(t *testing.T) { // nolint:paralleltest
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	require.NoError(t, InitHistory(newTestBoltDb(t)))
+	t.Cleanup(func() { currentHistory = &History{} })
+
+	cmd := exec.Command("/bin/sh", "-c", "exit 3")
+	require.Error(t, cmd.Run())
+
+	i := &Instance{PeakMemoryBytes: 100}
+	require.NoError(t, i.ExitedWithDetails(ExitDetails{
+		Err:             errors.New("some error"),
+		ProcessState:    cmd.ProcessState,
+		Trigger:         RestartWatchdog,
+		Reason:          "watchdog: memory over limit",
+		PeakMemoryBytes: 200,
+	}))
+
+	assert.Equal(t, "some error", i.Error)
+	require.NotNil(t, i.ExitCode)
+	assert.Equal(t, 3, *i.ExitCode)
+	assert.Empty(t, i.ExitSignal)
+	assert.Equal(t, RestartWatchdog, i.RestartTrigger)
+	assert.Equal(t, "watchdog: memory over limit", i.RestartReason)
+	assert.Equal(t, uint64(200), i.PeakMemoryBytes)
+
+	// A killed process has a signal rather than an exit code
+	cmd = exec.Command("/bin/sh", "-c", "kill -9 $$")
+	require.Error(t, cmd.Run())
+
+	i = &Instance{}
+	require.NoError(t, i.ExitedWithDetails(ExitDetails{ProcessState: cmd.ProcessState}))
+	assert.Nil(t, i.ExitCode)
+	assert.Equal(t, "killed", i.ExitSignal)
+}
+
+func TestInstance_Uptime(t *testing.T) {
+	t.Parallel()
+
+	i := Instance{
+		StartTime: "2021-01-01T00:00:00Z",
+		ExitTime:  "2021-01-01T01:00:00Z",
+	}
+	uptime, err := i.Uptime()
+	require.NoError(t, err)
+	assert.Equal(t, time.Hour, uptime)
+
+	// Still running
+	i = Instance{StartTime: time.Now().Add(-time.Minute).UTC().Format(time.RFC3339)}
+	uptime, err = i.Uptime()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute.Seconds(), uptime.Seconds(), 5)
+
+	_, err = Instance{}.Uptime()
+	assert.Error(t, err)
+}
@@ -1,7 +1,9 @@
 package history
 
 import (
+	"encoding/binary"
 	"encoding/json"
+	"fmt"
 
 	"github.com/pkg/errors"
 	"go.etcd.io/bbolt"
@@ -9,6 +11,15 @@ import (
 
 const (
 	osqueryHistoryInstanceKey = "osquery_instance_history"
+	// secondaryHistoryBucket holds the history of additional osquery
+	// instances, in the same format.
+	secondaryHistoryBucket = "osquery_secondary_instance_history"
+
+	// Instances are stored as a ring of maxInstances slots, keyed by
+	// their sequence number modulo maxInstances. nextSequenceKey holds
+	// the sequence number of the next instance.
+	nextSequenceKey = "next_sequence"
+	slotKeyFormat   = "instance_%02d"
 )
 
 type NoDbError struct{}
@@ -22,23 +33,48 @@ func (h *History) load() error {
 		return NoDbError{}
 	}
 
-	var instancesBytes []byte
+	var instances []*Instance
 
 	if err := h.db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(osqueryHistoryInstanceKey))
-		instancesBytes = b.Get([]byte(osqueryHistoryInstanceKey))
+		b := tx.Bucket([]byte(h.bucketName()))
+
+		// History used to be stored as a single list, under the bucket
+		// name. It's read as is, and written as a ring on the next save.
+		if instancesBytes := b.Get([]byte(osqueryHistoryInstanceKey)); instancesBytes != nil {
+			if err := json.Unmarshal(instancesBytes, &instances); err != nil {
+				return errors.Wrap(err, "error unmarshalling osquery_instance_history")
+			}
+			h.next = uint64(len(instances))
+			return nil
+		}
+
+		nextBytes := b.Get([]byte(nextSequenceKey))
+		if nextBytes == nil {
+			return nil
+		}
+		h.next = binary.BigEndian.Uint64(nextBytes)
+
+		first := uint64(0)
+		if h.next > maxInstances {
+			first = h.next - maxInstances
+		}
+		for seq := first; seq < h.next; seq++ {
+			instanceBytes := b.Get(slotKey(seq))
+			if instanceBytes == nil {
+				continue
+			}
+			var instance Instance
+			if err := json.Unmarshal(instanceBytes, &instance); err != nil {
+				return errors.Wrapf(err, "error unmarshalling osquery_instance_history entry %d", seq)
+			}
+			instances = append(instances, &instance)
+		}
 		return nil
 	}); err != nil {
 		return errors.Wrap(err, "error reading osquery_instance_history from db")
 	}
 
-	var instances []*Instance
-
-	if instancesBytes != nil {
-		if err := json.Unmarshal(instancesBytes, &instances); err != nil {
-			return errors.Wrap(err, "error unmarshalling osquery_instance_history")
-		}
-	} else {
+	if len(instances) == 0 {
 		return nil
 	}
 
@@ -52,14 +88,28 @@ func (h *History) save() error {
 		return NoDbError{}
 	}
 
-	instancesBytes, err := json.Marshal(h.instances)
-	if err != nil {
-		return errors.Wrap(err, "error marshalling osquery_instance_history")
-	}
-
 	if err := h.db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(osqueryHistoryInstanceKey))
-		if err := b.Put([]byte(osqueryHistoryInstanceKey), instancesBytes); err != nil {
+		b := tx.Bucket([]byte(h.bucketName()))
+
+		if err := b.Delete([]byte(osqueryHistoryInstanceKey)); err != nil {
+			return errors.Wrap(err, "error removing old format osquery_instance_history")
+		}
+
+		// h.instances are the newest, ending at sequence number h.next-1
+		for i, instance := range h.instances {
+			seq := h.next - uint64(len(h.instances)) + uint64(i)
+			instanceBytes, err := json.Marshal(instance)
+			if err != nil {
+				return errors.Wrap(err, "error marshalling osquery_instance_history")
+			}
+			if err := b.Put(slotKey(seq), instanceBytes); err != nil {
+				return errors.Wrap(err, "error writing osquery_instance_history to db")
+			}
+		}
+
+		nextBytes := make([]byte, 8)
+		binary.BigEndian.PutUint64(nextBytes, h.next)
+		if err := b.Put([]byte(nextSequenceKey), nextBytes); err != nil {
 			return errors.Wrap(err, "error writing osquery_instance_history to db")
 		}
 		return nil
@@ -70,14 +120,25 @@ func (h *History) save() error {
 	return nil
 }
 
-func createBboltBucketIfNotExists(db *bbolt.DB) error {
+func (h *History) bucketName() string {
+	if h.secondary {
+		return secondaryHistoryBucket
+	}
+	return osqueryHistoryInstanceKey
+}
+
+func slotKey(seq uint64) []byte {
+	return []byte(fmt.Sprintf(slotKeyFormat, seq%maxInstances))
+}
+
+func createBboltBucketIfNotExists(db *bbolt.DB, bucketName string) error {
 	if db == nil {
 		return NoDbError{}
 	}
 
 	// Create Bolt buckets as necessary
 	if err := db.Update(func(tx *bbolt.Tx) error {
-		if _, err := tx.CreateBucketIfNotExists([]byte(osqueryHistoryInstanceKey)); err != nil {
+		if _, err := tx.CreateBucketIfNotExists([]byte(bucketName)); err != nil {
 			return err
 		}
 		return nil
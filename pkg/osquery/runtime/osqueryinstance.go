@@ -19,6 +19,8 @@ import (
 	"github.com/kolide/launcher/pkg/autoupdate"
 	"github.com/kolide/launcher/pkg/backoff"
 	"github.com/kolide/launcher/pkg/osquery/runtime/history"
+	"github.com/kolide/launcher/pkg/osquery/table"
+	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
 	"github.com/osquery/osquery-go"
 	"github.com/pkg/errors"
 	"golang.org/x/sync/errgroup"
@@ -199,6 +201,31 @@ func WithAugeasLensFunction(f func(dir string) error) OsqueryInstanceOption {
 	}
 }
 
+// WithExecCacheTTL sets how long launcher's tables which exec commands
+// cache their output. Zero disables caching.
+func WithExecCacheTTL(ttl time.Duration) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.execCacheTTL = ttl
+	}
+}
+
+// WithInstanceName names an additional osquery instance, to tell it
+// apart from the primary one in the instance history.
+func WithInstanceName(name string) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.name = name
+	}
+}
+
+// WithCustomTables is a functional option which serves the tables
+// declared by the specs that load returns. load is called each time
+// osqueryd starts, so changed specs take effect on restart.
+func WithCustomTables(load func() []dataflattentable.TableSpec) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.customTableSpecs = load
+	}
+}
+
 // WithAutoloadedExtensions defines a list of extensions to load
 // via the osquery autoloading.
 func WithAutoloadedExtensions(extensions ...string) OsqueryInstanceOption {
@@ -220,22 +247,37 @@ type OsqueryInstance struct {
 	cmd                     *exec.Cmd
 	emsLock                 sync.RWMutex
 	extensionManagerServers []*osquery.ExtensionManagerServer
+	tableServers            map[string]*osquery.ExtensionManagerServer
 	extensionManagerClient  *osquery.ExtensionManagerClient
 	clientLock              sync.Mutex
 	paths                   *osqueryFilePaths
 	rmRootDirectory         func()
 	usingTempDir            bool
 	stats                   *history.Instance
+	// restartRequested is why a restart was asked for, through
+	// Runner.Restart, rather than osqueryd exiting unexpectedly.
+	restartRequested history.RestartTrigger
+	// peakRSS is the most resident memory osqueryd has been seen
+	// using. It's accessed atomically.
+	peakRSS uint64
+	// osquerydPath is the osqueryd binary launched, which may be an
+	// update.
+	osquerydPath string
+	// tableCheckInterval is how often each table server's health is
+	// checked.
+	tableCheckInterval time.Duration
 }
 
 // Healthy will check to determine whether or not the osquery process that is
 // being managed by the current instantiation of this OsqueryInstance is
-// healthy. If the instance is healthy, it returns nil.
+// healthy. If the instance is healthy, it returns nil. Table servers aren't
+// checked here, as each is checked, and replaced, on its own, without
+// restarting osqueryd.
 func (o *OsqueryInstance) Healthy() error {
 	o.emsLock.RLock()
 	defer o.emsLock.RUnlock()
 
-	if len(o.extensionManagerServers) == 0 || o.extensionManagerClient == nil {
+	if len(o.extensionManagerServers) == 0 && len(o.tableServers) == 0 || o.extensionManagerClient == nil {
 		return errors.New("instance not started")
 	}
 
@@ -294,13 +336,18 @@ type osqueryOptions struct {
 	augeasLensFunc        func(dir string) error
 	binaryPath            string
 	configPluginFlag      string
+	customTableSpecs      func() []dataflattentable.TableSpec
 	distributedPluginFlag string
 	extensionPlugins      []osquery.OsqueryPlugin
 	autoloadedExtensions  []string
 	extensionSocketPath   string
 	enrollSecretPath      string
+	execCacheTTL          time.Duration
 	loggerPluginFlag      string
+	name                  string
 	osqueryFlags          []string
+	resourceLimits        ResourceLimits
+	restartPolicy         RestartPolicy
 	retries               uint
 	rootDirectory         string
 	stderr                io.Writer
@@ -324,7 +371,7 @@ func (o osqueryOptions) requiredExtensions() []string {
 
 	for _, extension := range append([]string{o.loggerPluginFlag, o.configPluginFlag, o.distributedPluginFlag}, o.autoloadedExtensions...) {
 		// skip the osquery build-ins, since requiring them will cause osquery to needlessly wait.
-		if extension == "tls" {
+		if extension == "tls" || extension == "filesystem" {
 			continue
 		}
 
@@ -347,6 +394,10 @@ func newInstance() *OsqueryInstance {
 	i.errgroup, i.doneCtx = errgroup.WithContext(ctx)
 
 	i.logger = log.NewNopLogger()
+	i.opts.restartPolicy = DefaultRestartPolicy()
+	i.opts.resourceLimits = defaultResourceLimits()
+	i.opts.execCacheTTL = table.DefaultExecCacheTTL
+	i.tableCheckInterval = healthCheckInterval
 
 	return i
 }
@@ -543,18 +594,9 @@ func (o *OsqueryInstance) StartOsqueryExtensionManagerServer(name string, socket
 
 	level.Debug(logger).Log("msg", "Starting startOsqueryExtensionManagerServer")
 
-	var extensionManagerServer *osquery.ExtensionManagerServer
-	if err := backoff.WaitFor(func() error {
-		var newErr error
-		extensionManagerServer, newErr = osquery.NewExtensionManagerServer(
-			name,
-			socketPath,
-			osquery.ServerTimeout(1*time.Minute),
-		)
-		return newErr
-	}, socketOpenTimeout, socketOpenInterval); err != nil {
-		level.Debug(logger).Log("msg", "could not create an extension server", "err", err)
-		return errors.Wrap(err, "could not create an extension server")
+	extensionManagerServer, err := o.newExtensionManagerServer(name, socketPath)
+	if err != nil {
+		return err
 	}
 
 	extensionManagerServer.RegisterPlugin(plugins...)
@@ -591,6 +633,25 @@ func (o *OsqueryInstance) StartOsqueryExtensionManagerServer(name string, socket
 	return nil
 }
 
+// newExtensionManagerServer creates an extension manager server, retrying
+// while osqueryd's extension socket isn't yet open.
+func (o *OsqueryInstance) newExtensionManagerServer(name string, socketPath string) (*osquery.ExtensionManagerServer, error) {
+	var extensionManagerServer *osquery.ExtensionManagerServer
+	if err := backoff.WaitFor(func() error {
+		var newErr error
+		extensionManagerServer, newErr = osquery.NewExtensionManagerServer(
+			name,
+			socketPath,
+			osquery.ServerTimeout(1*time.Minute),
+		)
+		return newErr
+	}, socketOpenTimeout, socketOpenInterval); err != nil {
+		level.Debug(o.logger).Log("msg", "could not create an extension server", "name", name, "err", err)
+		return nil, errors.Wrap(err, "could not create an extension server")
+	}
+	return extensionManagerServer, nil
+}
+
 func osqueryTempDir() (string, func(), error) {
 	tempPath, err := ioutil.TempDir("", "")
 	if err != nil {
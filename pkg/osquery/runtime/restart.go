@@ -0,0 +1,142 @@
+package runtime
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/autoupdate"
+	"github.com/kolide/launcher/pkg/osquery/runtime/history"
+	"github.com/pkg/errors"
+)
+
+// RestartPolicy controls how the runner restarts osqueryd when it exits
+// unexpectedly, or is found unhealthy.
+type RestartPolicy struct {
+	// InitialBackoff is the delay before the first restart. Each further
+	// restart within CrashLoopWindow doubles it.
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between restarts.
+	MaxBackoff time.Duration
+	// CrashLoopWindow is the period restarts are counted over.
+	CrashLoopWindow time.Duration
+	// CrashLoopThreshold is how many restarts within CrashLoopWindow are
+	// considered a crash loop. While crash looping, restarts are delayed
+	// by MaxBackoff.
+	CrashLoopThreshold int
+	// HealthCheckFailures is how many failed health checks in a row
+	// cause osqueryd to be restarted.
+	HealthCheckFailures int
+}
+
+// DefaultRestartPolicy returns the RestartPolicy used unless
+// WithRestartPolicy is given.
+func DefaultRestartPolicy() RestartPolicy {
+	return RestartPolicy{
+		InitialBackoff:      time.Second,
+		MaxBackoff:          5 * time.Minute,
+		CrashLoopWindow:     10 * time.Minute,
+		CrashLoopThreshold:  5,
+		HealthCheckFailures: 5,
+	}
+}
+
+// WithRestartPolicy is a functional option which sets the policy for
+// restarting osqueryd.
+func WithRestartPolicy(policy RestartPolicy) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		i.opts.restartPolicy = policy
+	}
+}
+
+// restartTracker remembers recent unexpected restarts, to work out how
+// long to wait before the next one.
+type restartTracker struct {
+	restarts []time.Time
+}
+
+// next records a restart at now, and returns how long to wait before
+// restarting, and whether osqueryd is crash looping.
+func (t *restartTracker) next(policy RestartPolicy, now time.Time) (time.Duration, bool) {
+	// Forget restarts that have aged out of the window
+	recent := t.restarts[:0]
+	for _, restart := range t.restarts {
+		if now.Sub(restart) < policy.CrashLoopWindow {
+			recent = append(recent, restart)
+		}
+	}
+	t.restarts = append(recent, now)
+
+	if len(t.restarts) > policy.CrashLoopThreshold {
+		return policy.MaxBackoff, true
+	}
+
+	backoff := policy.InitialBackoff
+	for i := 1; i < len(t.restarts) && backoff < policy.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > policy.MaxBackoff {
+		backoff = policy.MaxBackoff
+	}
+	return backoff, false
+}
+
+// healthCheckError is returned when osqueryd fails too many health
+// checks in a row.
+type healthCheckError struct {
+	err error
+}
+
+func (e healthCheckError) Error() string {
+	return "health check failed: " + e.err.Error()
+}
+
+// restartReason returns what caused an instance to exit with err, and
+// describes it, for the instance history.
+func restartReason(err error) (history.RestartTrigger, string) {
+	switch cause := errors.Cause(err).(type) {
+	case resourceLimitError:
+		return history.RestartWatchdog, cause.Error()
+	case healthCheckError:
+		return history.RestartHealthCheck, cause.Error()
+	case nil:
+		return history.RestartExited, "unexpected exit"
+	default:
+		return history.RestartExited, "unexpected exit: " + err.Error()
+	}
+}
+
+// exitDetails describes how the instance exited, for the instance
+// history. It's only valid once the instance's errgroup has finished.
+func (o *OsqueryInstance) exitDetails(err error, trigger history.RestartTrigger, reason string) history.ExitDetails {
+	details := history.ExitDetails{
+		Err:             err,
+		Trigger:         trigger,
+		Reason:          reason,
+		PeakMemoryBytes: atomic.LoadUint64(&o.peakRSS),
+	}
+	if o.cmd != nil && o.cmd.ProcessState != nil {
+		details.ProcessState = o.cmd.ProcessState
+		if peak := maxRSS(o.cmd.ProcessState); peak > details.PeakMemoryBytes {
+			details.PeakMemoryBytes = peak
+		}
+	}
+	return details
+}
+
+// recordCrash counts an unexpected exit, or a failed launch, against the
+// osqueryd update the instance ran, if it was one. Every instance
+// counts, so an update that only some crash loop on is still rolled
+// back: once it's failed, FindNewest returns the previous binary.
+func (o *OsqueryInstance) recordCrash() {
+	if o.osquerydPath == "" {
+		return
+	}
+
+	ok, err := autoupdate.RecordCrash(o.osquerydPath)
+	if err != nil {
+		level.Info(o.logger).Log("msg", "recording osqueryd crash", "err", err)
+	} else if !ok {
+		level.Info(o.logger).Log("msg", "osqueryd update is crash looping, rolling back", "binary", o.osquerydPath)
+	}
+}
@@ -0,0 +1,156 @@
+package runtime
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/osquery/runtime/history"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestartTrackerBackoff(t *testing.T) {
+	t.Parallel()
+
+	policy := RestartPolicy{
+		InitialBackoff:     time.Second,
+		MaxBackoff:         10 * time.Second,
+		CrashLoopWindow:    time.Minute,
+		CrashLoopThreshold: 5,
+	}
+
+	var tracker restartTracker
+	now := time.Now()
+
+	var backoffs []time.Duration
+	for i := 0; i < 5; i++ {
+		backoff, crashLooping := tracker.next(policy, now)
+		require.False(t, crashLooping)
+		backoffs = append(backoffs, backoff)
+	}
+	require.Equal(t, []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second}, backoffs)
+
+	// One more within the window is a crash loop
+	backoff, crashLooping := tracker.next(policy, now)
+	require.True(t, crashLooping)
+	require.Equal(t, 10*time.Second, backoff)
+
+	// Once the earlier restarts age out, the backoff starts over
+	backoff, crashLooping = tracker.next(policy, now.Add(2*time.Minute))
+	require.False(t, crashLooping)
+	require.Equal(t, time.Second, backoff)
+}
+
+func TestRestartReason(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		err         error
+		wantTrigger history.RestartTrigger
+		wantReason  string
+	}{
+		{
+			err:         errors.Wrap(resourceLimitError{reason: "memory over"}, "wrapped"),
+			wantTrigger: history.RestartWatchdog,
+			wantReason:  "watchdog: memory over",
+		},
+		{
+			err:         healthCheckError{err: errors.New("ping")},
+			wantTrigger: history.RestartHealthCheck,
+			wantReason:  "health check failed: ping",
+		},
+		{
+			err:         errors.Wrap(errors.New("exit status 1"), "running osqueryd command"),
+			wantTrigger: history.RestartExited,
+			wantReason:  "unexpected exit: running osqueryd command: exit status 1",
+		},
+		{
+			wantTrigger: history.RestartExited,
+			wantReason:  "unexpected exit",
+		},
+	}
+
+	for _, tt := range tests {
+		trigger, reason := restartReason(tt.err)
+		require.Equal(t, tt.wantTrigger, trigger)
+		require.Equal(t, tt.wantReason, reason)
+	}
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use
+type lockedBuffer struct {
+	sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.buf.String()
+}
+
+func TestRecordCrash(t *testing.T) {
+	t.Parallel()
+
+	updateDir := filepath.Join(t.TempDir(), "osqueryd-updates", "1")
+	require.NoError(t, os.MkdirAll(updateDir, 0755))
+	statePath := filepath.Join(updateDir, "update.json")
+	require.NoError(t, ioutil.WriteFile(statePath, []byte(`{"version":"1.2.3","status":"active"}`), 0644))
+
+	// Instances which haven't found a binary yet have nothing to count
+	// against
+	newInstance().recordCrash()
+
+	// Crashes count across instances
+	for i := 0; i < 6; i++ {
+		instance := newInstance()
+		instance.logger = log.NewNopLogger()
+		instance.osquerydPath = filepath.Join(updateDir, "osqueryd")
+		instance.recordCrash()
+	}
+
+	state, err := ioutil.ReadFile(statePath)
+	require.NoError(t, err)
+	require.Contains(t, string(state), `"status":"failed"`)
+}
+
+func TestSecondaryRunnerRetriesStart(t *testing.T) {
+	t.Parallel()
+
+	var logs lockedBuffer
+	rootDirectory := t.TempDir()
+	runner := newRunner(
+		WithInstanceName("extra"),
+		WithLogger(log.NewLogfmtLogger(&logs)),
+		WithRootDirectory(rootDirectory),
+		WithOsquerydBinary(filepath.Join(rootDirectory, "missing", "osqueryd")),
+		WithRestartPolicy(RestartPolicy{
+			InitialBackoff:     time.Millisecond,
+			MaxBackoff:         10 * time.Millisecond,
+			CrashLoopWindow:    time.Minute,
+			CrashLoopThreshold: 5,
+		}),
+	)
+
+	// The failure is reported, and then retried in the background
+	// rather than exiting
+	require.Error(t, runner.Start())
+	require.Eventually(t, func() bool {
+		return strings.Count(logs.String(), "will retry") >= 3
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, runner.Shutdown())
+}
@@ -35,6 +35,7 @@ type Runner struct {
 	instance     *OsqueryInstance
 	instanceLock sync.Mutex
 	shutdown     chan struct{}
+	restarts     restartTracker
 }
 
 // LaunchInstance will launch an instance of osqueryd via a very configurable
@@ -67,57 +68,170 @@ func LaunchUnstartedInstance(opts ...OsqueryInstanceOption) *Runner {
 	return runner
 }
 
+// Start launches the instance, and then restarts it whenever it exits,
+// until Shutdown is called.
+//
+// An additional instance, named by WithInstanceName, never stops
+// launcher. If it fails to start, or to restart, it's retried in the
+// background with backoff; Start still returns the first error.
 func (r *Runner) Start() error {
 	if err := r.launchOsqueryInstance(); err != nil {
+		r.instance.recordCrash()
+		if r.instance.opts.name != "" {
+			go func() {
+				if r.relaunchSecondary(err) {
+					r.supervise()
+				}
+			}()
+		}
 		return errors.Wrap(err, "starting instance")
 	}
-	go func() {
-		// This loop waits for the completion of the async routines,
-		// and either restarts the instance (if Shutdown was not
-		// called), or stops (if Shutdown was called).
-		for {
-			// Wait for async processes to exit
-			<-r.instance.doneCtx.Done()
+	go r.supervise()
+	return nil
+}
+
+// supervise waits for the completion of the async routines, and either
+// restarts the instance (if Shutdown was not called), or stops (if
+// Shutdown was called).
+func (r *Runner) supervise() {
+	for {
+		// Wait for async processes to exit
+		<-r.instance.doneCtx.Done()
+
+		select {
+		case <-r.shutdown:
+			// Intentional shutdown, this loop can exit
+			if err := r.instance.stats.ExitedWithDetails(r.instance.exitDetails(nil, "", "")); err != nil {
+				level.Info(r.instance.logger).Log("msg", "error recording osquery instance exit to history", "err", err)
+			}
+			return
+		default:
+			// Don't block
+		}
+
+		// Error case
+		err := r.instance.errgroup.Wait()
+
+		r.instanceLock.Lock()
+		trigger := r.instance.restartRequested
+		r.instanceLock.Unlock()
+		requested := trigger != ""
+		reason := string(trigger)
+		if !requested {
+			trigger, reason = restartReason(err)
+		}
+
+		level.Info(r.instance.logger).Log(
+			"msg", "unexpected restart of instance",
+			"err", err,
+			"trigger", trigger,
+			"reason", reason,
+		)
+
+		if err := r.instance.stats.ExitedWithDetails(r.instance.exitDetails(err, trigger, reason)); err != nil {
+			level.Info(r.instance.logger).Log("msg", "error recording osquery instance exit to history", "err", err)
+		}
+
+		// The watchdog's restarts are launcher enforcing its limits,
+		// rather than osqueryd failing.
+		if !requested && trigger != history.RestartWatchdog {
+			r.instance.recordCrash()
+		}
+
+		// Requested restarts happen straight away. Others back
+		// off, so a broken osqueryd doesn't spin.
+		if !requested {
+			backoff, crashLooping := r.restarts.next(r.instance.opts.restartPolicy, time.Now())
+			if crashLooping {
+				level.Info(r.instance.logger).Log("msg", "osqueryd is crash looping", "backoff", backoff)
+			} else {
+				level.Debug(r.instance.logger).Log("msg", "waiting to restart osqueryd", "backoff", backoff)
+			}
 
 			select {
 			case <-r.shutdown:
-				// Intentional shutdown, this loop can exit
-				if err := r.instance.stats.Exited(nil); err != nil {
-					level.Info(r.instance.logger).Log("msg", "error recording osquery instance exit to history", "err", err)
-				}
 				return
-			default:
-				// Don't block
+			case <-time.After(backoff):
+			}
+		}
+
+		r.instanceLock.Lock()
+		r.resetInstance()
+		if err := r.launchOsqueryInstance(); err != nil {
+			r.instance.recordCrash()
+			if r.instance.opts.name != "" {
+				r.instanceLock.Unlock()
+				if !r.relaunchSecondary(err) {
+					return
+				}
+				continue
 			}
 
-			// Error case
-			err := r.instance.errgroup.Wait()
 			level.Info(r.instance.logger).Log(
-				"msg", "unexpected restart of instance",
+				"msg", "fatal error restarting instance",
 				"err", err,
 			)
+			os.Exit(1)
+		}
 
-			if err := r.instance.stats.Exited(err); err != nil {
-				level.Info(r.instance.logger).Log("msg", "error recording osquery instance exit to history", "err", err)
-			}
+		r.instanceLock.Unlock()
 
-			r.instanceLock.Lock()
-			opts := r.instance.opts
-			r.instance = newInstance()
-			r.instance.opts = opts
-			if err := r.launchOsqueryInstance(); err != nil {
-				level.Info(r.instance.logger).Log(
-					"msg", "fatal error restarting instance",
-					"err", err,
-				)
-				os.Exit(1)
-			}
+	}
+}
 
+// resetInstance replaces the instance with a new one, with the same
+// options, ready to launch. The caller must hold instanceLock.
+func (r *Runner) resetInstance() {
+	opts := r.instance.opts
+	logger := r.instance.logger
+	r.instance = newInstance()
+	r.instance.opts = opts
+	r.instance.logger = logger
+}
+
+// relaunchSecondary retries launching an additional instance, which
+// failed with err, backing off between attempts. It returns true once
+// the instance is running, or false if Shutdown is called first.
+func (r *Runner) relaunchSecondary(err error) bool {
+	for {
+		r.instanceLock.Lock()
+		policy := r.instance.opts.restartPolicy
+		logger := r.instance.logger
+		r.instanceLock.Unlock()
+
+		backoff, _ := r.restarts.next(policy, time.Now())
+		level.Info(logger).Log(
+			"msg", "error launching osquery instance, will retry",
+			"err", err,
+			"backoff", backoff,
+		)
+
+		select {
+		case <-r.shutdown:
+			return false
+		case <-time.After(backoff):
+		}
+
+		r.instanceLock.Lock()
+		// Shutdown may have cancelled the failed instance while this
+		// waited for the lock, and won't see a new one.
+		select {
+		case <-r.shutdown:
 			r.instanceLock.Unlock()
+			return false
+		default:
+		}
+		r.resetInstance()
+		err = r.launchOsqueryInstance()
+		if err != nil {
+			r.instance.recordCrash()
+		}
+		r.instanceLock.Unlock()
 
+		if err == nil {
+			return true
 		}
-	}()
-	return nil
+	}
 }
 
 func (r *Runner) Query(query string) ([]map[string]string, error) {
@@ -142,9 +256,18 @@ func (r *Runner) Shutdown() error {
 // Restart allows you to cleanly shutdown the current instance and launch a new
 // instance with the same configurations.
 func (r *Runner) Restart() error {
-	level.Debug(r.instance.logger).Log("msg", "runner.Restart called")
+	return r.RestartWithTrigger(history.RestartRequested)
+}
+
+// RestartWithTrigger restarts the instance like Restart, recording
+// trigger as the reason in the instance history.
+func (r *Runner) RestartWithTrigger(trigger history.RestartTrigger) error {
+	level.Debug(r.instance.logger).Log("msg", "runner.Restart called", "trigger", trigger)
 	r.instanceLock.Lock()
 	defer r.instanceLock.Unlock()
+	if r.instance.restartRequested == "" {
+		r.instance.restartRequested = trigger
+	}
 	// Cancelling will cause all of the cleanup routines to execute, and a
 	// new instance will start.
 	r.instance.cancel()
@@ -153,6 +276,18 @@ func (r *Runner) Restart() error {
 	return nil
 }
 
+// RestartWithOptions applies opts on top of the current instance
+// configuration, and then restarts the instance so they take effect.
+func (r *Runner) RestartWithOptions(opts ...OsqueryInstanceOption) error {
+	r.instanceLock.Lock()
+	for _, opt := range opts {
+		opt(r.instance)
+	}
+	r.instanceLock.Unlock()
+
+	return r.RestartWithTrigger(history.RestartConfigChange)
+}
+
 // Healthy checks the health of the instance and returns an error describing
 // any problem.
 func (r *Runner) Healthy() error {
@@ -274,6 +409,11 @@ func (r *Runner) launchOsqueryInstance() error {
 		autoupdate.DeleteOldUpdates(),
 	)
 
+	// Crashes are counted against this binary, if it's an update. See
+	// recordCrash.
+	o.osquerydPath = currentOsquerydBinaryPath
+	started := time.Now()
+
 	// Now that we have accepted options from the caller and/or determined what
 	// they should be due to them not being set, we are ready to create and start
 	// the *exec.Cmd instance that will run osqueryd.
@@ -307,7 +447,7 @@ func (r *Runner) launchOsqueryInstance() error {
 		return errors.Wrap(err, "fatal error starting osqueryd process")
 	}
 
-	stats, err := history.NewInstance()
+	stats, err := history.NewNamedInstance(o.opts.name)
 	if err != nil {
 		level.Info(o.logger).Log("msg", fmt.Sprint("osquery instance history error: ", err.Error()))
 	}
@@ -383,25 +523,52 @@ func (r *Runner) launchOsqueryInstance() error {
 		level.Info(o.logger).Log("msg", "osquery instance history", "error", err)
 	}
 
-	// Now spawn an extension manage to for the tables. We need to
-	// start this one in the background, because the runner.Start
+	// Now spawn extension managers for the tables. We need to
+	// start these in the background, because the runner.Start
 	// function needs to return promptly enough for osquery to use
 	// it to enroll. Very racy
 	//
-	// TODO: Consider chunking, if we find we can only have so
-	// many tables per extension manager
-	o.errgroup.Go(func() error {
-		plugins := table.PlatformTables(o.extensionManagerClient, o.logger, currentOsquerydBinaryPath)
+	// Each group of tables gets its own extension manager, so a
+	// table that hangs only holds up its own group, and the group
+	// can be restarted without restarting osqueryd.
+	tableGroups := table.PlatformTableGroups(o.extensionManagerClient, o.logger, currentOsquerydBinaryPath, table.WithExecCacheTTL(o.opts.execCacheTTL))
+	if o.opts.customTableSpecs != nil {
+		// Custom tables can't take the names of launcher's own
+		existing := append([]osquery.OsqueryPlugin{}, o.opts.extensionPlugins...)
+		for _, group := range tableGroups {
+			existing = append(existing, group.Tables...)
+		}
+		tableGroups = append(tableGroups, table.CustomTableGroup(o.extensionManagerClient, o.logger, o.opts.customTableSpecs(), existing))
+	}
 
-		if len(plugins) == 0 {
-			return nil
+	for _, group := range tableGroups {
+		if len(group.Tables) == 0 {
+			continue
 		}
+		group := group
+		o.errgroup.Go(func() error {
+			return o.serveTableGroup(paths.extensionSocketPath, group)
+		})
+	}
+
+	// Sample resource use, and enforce limits, if any
+	pid := o.cmd.Process.Pid
+	o.errgroup.Go(func() error {
+		return o.watchResources(pid)
+	})
 
-		if err := o.StartOsqueryExtensionManagerServer("kolide", paths.extensionSocketPath, plugins); err != nil {
-			level.Info(o.logger).Log("msg", "Unable to create tables extension server. Stopping", "err", err)
-			return errors.Wrap(err, "could not create a table extension server")
+	// Once osqueryd has run for long enough, its update is healthy,
+	// unless another instance has crashed on it meanwhile
+	o.errgroup.Go(func() error {
+		select {
+		case <-o.doneCtx.Done():
+			return o.doneCtx.Err()
+		case <-time.After(autoupdate.HealthyAfter):
+			if err := autoupdate.MarkHealthySince(currentOsquerydBinaryPath, started); err != nil {
+				level.Info(o.logger).Log("msg", "marking osqueryd update healthy", "err", err)
+			}
+			return nil
 		}
-		return nil
 	})
 
 	// Health check on interval
@@ -415,15 +582,13 @@ func (r *Runner) launchOsqueryInstance() error {
 			case <-ticker.C:
 				// Health check! Allow a couple
 				// failures before we tear everything
-				// down. This is pretty simple, it
-				// hardcodes the timing. Might be
-				// better for a Limiter
-				maxHealthChecks := 5
+				// down.
+				maxHealthChecks := o.opts.restartPolicy.HealthCheckFailures
 				for i := 1; i <= maxHealthChecks; i++ {
 					if err := o.Healthy(); err != nil {
 						if i == maxHealthChecks {
 							level.Info(o.logger).Log("msg", "Health check failed. Giving up", "attempt", i, "err", err)
-							return errors.Wrap(err, "health check failed")
+							return healthCheckError{err: err}
 						}
 
 						level.Debug(o.logger).Log("msg", "Health check failed. Will retry", "attempt", i, "err", err)
@@ -0,0 +1,144 @@
+package runtime
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/osquery/table"
+	"github.com/osquery/osquery-go"
+	"github.com/pkg/errors"
+)
+
+// tableServerShutdownTimeout bounds how long we wait to deregister a
+// table server from osqueryd.
+const tableServerShutdownTimeout = 10 * time.Second
+
+// tableServerName is the name a table group's extension manager server
+// registers with osqueryd as.
+func tableServerName(group string) string {
+	return "kolide_" + group
+}
+
+// serveTableGroup serves a group of tables on their own extension
+// manager server, until the instance is done. If the server exits, or
+// stops answering osqueryd, the server is replaced, leaving osqueryd and
+// the other groups running.
+//
+// Slow tables don't hang the server, as the table middleware times their
+// calls out, so it's osqueryd's view of the server that's checked.
+func (o *OsqueryInstance) serveTableGroup(socketPath string, group table.TableGroup) error {
+	name := tableServerName(group.Name)
+	logger := log.With(o.logger, "extensionMangerServer", name)
+
+	var restarts restartTracker
+	for {
+		server, err := o.newExtensionManagerServer(name, socketPath)
+		if err != nil {
+			level.Info(logger).Log("msg", "Unable to create tables extension server. Stopping", "err", err)
+			return errors.Wrap(err, "could not create a table extension server")
+		}
+		server.RegisterPlugin(group.Tables...)
+		o.setTableServer(group.Name, server)
+
+		served := make(chan error, 1)
+		go func() {
+			served <- server.Start()
+		}()
+
+		reason := o.monitorTableServer(served, name)
+
+		ctx, cancel := context.WithTimeout(context.Background(), tableServerShutdownTimeout)
+		if err := server.Shutdown(ctx); err != nil {
+			level.Debug(logger).Log("msg", "Got error while shutting down extension server", "err", err)
+		}
+		cancel()
+
+		if o.doneCtx.Err() != nil {
+			return o.doneCtx.Err()
+		}
+
+		backoff, crashLooping := restarts.next(o.opts.restartPolicy, time.Now())
+		level.Info(logger).Log(
+			"msg", "restarting table extension server",
+			"reason", reason,
+			"backoff", backoff,
+			"crash_looping", crashLooping,
+		)
+
+		select {
+		case <-o.doneCtx.Done():
+			return o.doneCtx.Err()
+		case <-time.After(backoff):
+		}
+	}
+}
+
+// monitorTableServer waits for a table server to exit, or to fail
+// RestartPolicy.HealthCheckFailures health checks in a row, and returns
+// why the server needs replacing. It returns an empty reason when the
+// instance is done.
+func (o *OsqueryInstance) monitorTableServer(served <-chan error, name string) string {
+	ticker := time.NewTicker(o.tableCheckInterval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-o.doneCtx.Done():
+			return ""
+		case err := <-served:
+			if err != nil {
+				return "server exited: " + err.Error()
+			}
+			return "server exited"
+		case <-ticker.C:
+			err := o.tableServerHealthy(name)
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			level.Debug(o.logger).Log("msg", "table server health check failed", "name", name, "attempt", failures, "err", err)
+			if failures >= o.opts.restartPolicy.HealthCheckFailures {
+				return "health check failed: " + err.Error()
+			}
+		}
+	}
+}
+
+// tableServerHealthy checks that osqueryd still has the named table server
+// registered. osqueryd pings its extensions itself, and drops those that
+// stop answering, so this covers a server that's hung, as well as one
+// osqueryd has lost track of.
+func (o *OsqueryInstance) tableServerHealthy(name string) error {
+	o.clientLock.Lock()
+	defer o.clientLock.Unlock()
+
+	if o.extensionManagerClient == nil {
+		return errors.New("no osquery extension client")
+	}
+
+	extensions, err := o.extensionManagerClient.Extensions()
+	if err != nil {
+		return errors.Wrap(err, "listing osquery extensions")
+	}
+	for _, info := range extensions {
+		if info.Name == name {
+			return nil
+		}
+	}
+	return errors.Errorf("%s is not registered with osqueryd", name)
+}
+
+// setTableServer records the server currently serving a table group.
+func (o *OsqueryInstance) setTableServer(group string, server *osquery.ExtensionManagerServer) {
+	o.emsLock.Lock()
+	defer o.emsLock.Unlock()
+	if o.tableServers == nil {
+		o.tableServers = make(map[string]*osquery.ExtensionManagerServer)
+	}
+	o.tableServers[group] = server
+}
@@ -0,0 +1,153 @@
+package runtime
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/apache/thrift/lib/go/thrift"
+	"github.com/kolide/launcher/pkg/osquery/table"
+	"github.com/osquery/osquery-go"
+	osquerygen "github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/transport"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOsqueryd serves the extension manager API osqueryd does, enough for
+// extension servers to register with it.
+type fakeOsqueryd struct {
+	lock          sync.Mutex
+	next          osquerygen.ExtensionRouteUUID
+	extensions    osquerygen.InternalExtensionList
+	registrations int
+}
+
+func (f *fakeOsqueryd) Ping(ctx context.Context) (*osquerygen.ExtensionStatus, error) {
+	return &osquerygen.ExtensionStatus{}, nil
+}
+
+func (f *fakeOsqueryd) Call(ctx context.Context, registry, item string, request osquerygen.ExtensionPluginRequest) (*osquerygen.ExtensionResponse, error) {
+	return &osquerygen.ExtensionResponse{Status: &osquerygen.ExtensionStatus{}}, nil
+}
+
+func (f *fakeOsqueryd) Shutdown(ctx context.Context) error { return nil }
+
+func (f *fakeOsqueryd) Extensions(ctx context.Context) (osquerygen.InternalExtensionList, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	extensions := make(osquerygen.InternalExtensionList, len(f.extensions))
+	for uuid, info := range f.extensions {
+		extensions[uuid] = info
+	}
+	return extensions, nil
+}
+
+func (f *fakeOsqueryd) Options(ctx context.Context) (osquerygen.InternalOptionList, error) {
+	return osquerygen.InternalOptionList{}, nil
+}
+
+func (f *fakeOsqueryd) RegisterExtension(ctx context.Context, info *osquerygen.InternalExtensionInfo, registry osquerygen.ExtensionRegistry) (*osquerygen.ExtensionStatus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.next++
+	f.extensions[f.next] = info
+	f.registrations++
+	return &osquerygen.ExtensionStatus{UUID: f.next}, nil
+}
+
+func (f *fakeOsqueryd) DeregisterExtension(ctx context.Context, uuid osquerygen.ExtensionRouteUUID) (*osquerygen.ExtensionStatus, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.extensions, uuid)
+	return &osquerygen.ExtensionStatus{}, nil
+}
+
+func (f *fakeOsqueryd) Query(ctx context.Context, sql string) (*osquerygen.ExtensionResponse, error) {
+	return &osquerygen.ExtensionResponse{Status: &osquerygen.ExtensionStatus{}}, nil
+}
+
+func (f *fakeOsqueryd) GetQueryColumns(ctx context.Context, sql string) (*osquerygen.ExtensionResponse, error) {
+	return &osquerygen.ExtensionResponse{Status: &osquerygen.ExtensionStatus{}}, nil
+}
+
+// forget drops every registered extension, as osqueryd does with those
+// that stop answering its pings.
+func (f *fakeOsqueryd) forget() {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.extensions = make(osquerygen.InternalExtensionList)
+}
+
+func (f *fakeOsqueryd) registered() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.registrations
+}
+
+// startFakeOsqueryd serves a fakeOsqueryd on a socket in a temporary
+// directory, returning it and the socket path.
+func startFakeOsqueryd(t *testing.T) (*fakeOsqueryd, string) {
+	// Socket paths are length limited, so this is kept short
+	dir, err := ioutil.TempDir("", "osq")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	socketPath := filepath.Join(dir, "sock")
+
+	fake := &fakeOsqueryd{extensions: make(osquerygen.InternalExtensionList)}
+	serverTransport, err := transport.OpenServer(socketPath, time.Second)
+	require.NoError(t, err)
+	server := thrift.NewTSimpleServer2(osquerygen.NewExtensionManagerProcessor(fake), serverTransport)
+	go server.Serve()
+	t.Cleanup(func() { server.Stop() })
+
+	return fake, socketPath
+}
+
+func TestServeTableGroupRestartsUnhealthyServer(t *testing.T) {
+	t.Parallel()
+
+	fake, socketPath := startFakeOsqueryd(t)
+
+	o := newInstance()
+	o.tableCheckInterval = 10 * time.Millisecond
+	o.opts.restartPolicy.HealthCheckFailures = 3
+	o.opts.restartPolicy.InitialBackoff = time.Millisecond
+
+	client, err := osquery.NewClient(socketPath, time.Second)
+	require.NoError(t, err)
+	o.extensionManagerClient = client
+
+	served := make(chan error, 1)
+	go func() {
+		served <- o.serveTableGroup(socketPath, table.TableGroup{Name: "test"})
+	}()
+
+	// The fake only stops once its clients disconnect, so they're shut
+	// down first, whether or not the test passes.
+	t.Cleanup(func() {
+		o.cancel()
+		client.Close()
+		select {
+		case err := <-served:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Error("serveTableGroup didn't return once the instance was done")
+		}
+	})
+
+	// The server registers, and stays registered while osqueryd has it
+	require.Eventually(t, func() bool { return fake.registered() == 1 }, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, o.tableServerHealthy(tableServerName("test")))
+	time.Sleep(10 * o.tableCheckInterval)
+	require.Equal(t, 1, fake.registered())
+
+	// Once osqueryd drops it, the group is restarted, and registers again
+	fake.forget()
+	require.Error(t, o.tableServerHealthy(tableServerName("test")))
+	require.Eventually(t, func() bool { return fake.registered() == 2 }, 5*time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool { return o.tableServerHealthy(tableServerName("test")) == nil }, 5*time.Second, 10*time.Millisecond)
+}
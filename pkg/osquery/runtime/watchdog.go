@@ -0,0 +1,168 @@
+package runtime
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// watchdogStrikes is how many samples in a row must be over a limit
+// before osqueryd is restarted. A single sample over is often a
+// momentary spike, such as a large query.
+const watchdogStrikes = 3
+
+// errSamplingUnsupported is returned by sampleProcess on platforms where
+// launcher can't sample osqueryd's resource usage.
+var errSamplingUnsupported = errors.New("sampling process resources is not supported on this platform")
+
+// ResourceLimits are the limits the watchdog holds osqueryd to. A zero
+// limit is not enforced.
+type ResourceLimits struct {
+	// MaxCPUPercent is the CPU osqueryd may use, as a percentage of a
+	// single core.
+	MaxCPUPercent float64
+	// MaxRSSBytes is the resident memory osqueryd may use.
+	MaxRSSBytes uint64
+	// SampleInterval is how often osqueryd's usage is sampled.
+	SampleInterval time.Duration
+	// Cooldown is how long after osqueryd starts before the limits are
+	// enforced, as osqueryd is busy while it starts up.
+	Cooldown time.Duration
+}
+
+func (l ResourceLimits) enabled() bool {
+	return l.MaxCPUPercent > 0 || l.MaxRSSBytes > 0
+}
+
+// defaultResourceLimits sets no limits, but still has osqueryd sampled
+// for its peak memory use.
+func defaultResourceLimits() ResourceLimits {
+	return ResourceLimits{
+		SampleInterval: 10 * time.Second,
+		Cooldown:       time.Minute,
+	}
+}
+
+// WithResourceLimits is a functional option which has launcher restart
+// osqueryd when its CPU or memory use stays over the limits.
+func WithResourceLimits(limits ResourceLimits) OsqueryInstanceOption {
+	return func(i *OsqueryInstance) {
+		defaults := defaultResourceLimits()
+		if limits.SampleInterval == 0 {
+			limits.SampleInterval = defaults.SampleInterval
+		}
+		if limits.Cooldown == 0 {
+			limits.Cooldown = defaults.Cooldown
+		}
+		i.opts.resourceLimits = limits
+	}
+}
+
+// processSample is a point in time sample of a process' resource usage.
+type processSample struct {
+	at      time.Time
+	cpuTime time.Duration
+	rss     uint64
+}
+
+// resourceLimitError is returned by the watchdog when osqueryd stays
+// over a limit.
+type resourceLimitError struct {
+	reason string
+}
+
+func (e resourceLimitError) Error() string {
+	return "watchdog: " + e.reason
+}
+
+// watchResources samples the resource usage of the process pid until
+// the instance is done, recording its peak memory use. If limits are
+// set, it returns a resourceLimitError once it has been over a limit for
+// watchdogStrikes samples in a row.
+func (o *OsqueryInstance) watchResources(pid int) error {
+	limits := o.opts.resourceLimits
+	started := time.Now()
+
+	ticker := time.NewTicker(limits.SampleInterval)
+	defer ticker.Stop()
+
+	var last processSample
+	var cpuStrikes, memoryStrikes int
+	for {
+		select {
+		case <-o.doneCtx.Done():
+			return o.doneCtx.Err()
+		case <-ticker.C:
+		}
+
+		sample, err := sampleProcess(pid)
+		if err == errSamplingUnsupported {
+			if limits.enabled() {
+				level.Info(o.logger).Log("msg", "not enforcing osqueryd resource limits", "err", err)
+			}
+			return nil
+		}
+		if err != nil {
+			level.Debug(o.logger).Log("msg", "could not sample osqueryd resources", "err", err)
+			continue
+		}
+		o.recordPeakRSS(sample.rss)
+
+		previous := last
+		last = sample
+		if previous.at.IsZero() || time.Since(started) < limits.Cooldown {
+			continue
+		}
+
+		cpuPercent := cpuPercent(previous, sample)
+		if limits.MaxCPUPercent > 0 && cpuPercent > limits.MaxCPUPercent {
+			cpuStrikes++
+		} else {
+			cpuStrikes = 0
+		}
+		if limits.MaxRSSBytes > 0 && sample.rss > limits.MaxRSSBytes {
+			memoryStrikes++
+		} else {
+			memoryStrikes = 0
+		}
+
+		switch {
+		case cpuStrikes >= watchdogStrikes:
+			return resourceLimitError{reason: fmt.Sprintf("cpu %.1f%% over limit of %.1f%%", cpuPercent, limits.MaxCPUPercent)}
+		case memoryStrikes >= watchdogStrikes:
+			return resourceLimitError{reason: fmt.Sprintf("memory %d bytes over limit of %d bytes", sample.rss, limits.MaxRSSBytes)}
+		case cpuStrikes > 0 || memoryStrikes > 0:
+			level.Debug(o.logger).Log(
+				"msg", "osqueryd over resource limits",
+				"cpu_percent", cpuPercent,
+				"rss", sample.rss,
+				"cpu_strikes", cpuStrikes,
+				"memory_strikes", memoryStrikes,
+			)
+		}
+	}
+}
+
+// cpuPercent returns the CPU used between two samples, as a percentage
+// of a single core.
+func cpuPercent(previous, current processSample) float64 {
+	wall := current.at.Sub(previous.at)
+	if wall <= 0 {
+		return 0
+	}
+	return float64(current.cpuTime-previous.cpuTime) / float64(wall) * 100
+}
+
+// recordPeakRSS raises the instance's peak memory use to rss, if it's
+// higher.
+func (o *OsqueryInstance) recordPeakRSS(rss uint64) {
+	for {
+		peak := atomic.LoadUint64(&o.peakRSS)
+		if rss <= peak || atomic.CompareAndSwapUint64(&o.peakRSS, peak, rss) {
+			return
+		}
+	}
+}
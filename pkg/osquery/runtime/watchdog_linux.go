@@ -0,0 +1,72 @@
+//go:build linux
+// +build linux
+
+package runtime
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc. It's 100 on
+// every architecture Linux supports.
+const clockTicks = 100
+
+// sampleProcess reads the CPU time and resident memory of pid from
+// /proc/<pid>/stat.
+func sampleProcess(pid int) (processSample, error) {
+	contents, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return processSample{}, errors.Wrap(err, "reading process stat")
+	}
+	return parseProcStat(string(contents), time.Now())
+}
+
+func parseProcStat(stat string, at time.Time) (processSample, error) {
+	// The command name is in parentheses, and may itself contain spaces
+	// and parentheses, so fields are counted from the last one.
+	end := strings.LastIndex(stat, ")")
+	if end == -1 {
+		return processSample{}, errors.New("malformed process stat")
+	}
+	// fields[0] is the state, the third field of the stat file
+	fields := strings.Fields(stat[end+1:])
+	if len(fields) < 22 {
+		return processSample{}, errors.New("too few fields in process stat")
+	}
+
+	utime, err := strconv.ParseUint(fields[11], 10, 64)
+	if err != nil {
+		return processSample{}, errors.Wrap(err, "parsing utime")
+	}
+	stime, err := strconv.ParseUint(fields[12], 10, 64)
+	if err != nil {
+		return processSample{}, errors.Wrap(err, "parsing stime")
+	}
+	rssPages, err := strconv.ParseUint(fields[21], 10, 64)
+	if err != nil {
+		return processSample{}, errors.Wrap(err, "parsing rss")
+	}
+
+	return processSample{
+		at:      at,
+		cpuTime: time.Duration(utime+stime) * time.Second / clockTicks,
+		rss:     rssPages * uint64(os.Getpagesize()),
+	}, nil
+}
+
+// maxRSS returns the peak resident memory of an exited process.
+func maxRSS(state *os.ProcessState) uint64 {
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok && usage.Maxrss > 0 {
+		// Linux reports kilobytes
+		return uint64(usage.Maxrss) << 10
+	}
+	return 0
+}
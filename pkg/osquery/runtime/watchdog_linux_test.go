@@ -0,0 +1,58 @@
+//go:build linux
+// +build linux
+
+package runtime
+
+import (
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	t.Parallel()
+
+	// A command name with spaces and parentheses
+	stat := "1234 (osq (d) x) S 1 1234 1234 0 -1 4194560 5000 0 0 0 250 50 0 0 20 0 12 0 100 1000000 300 18446744073709551615"
+	at := time.Now()
+
+	sample, err := parseProcStat(stat, at)
+	require.NoError(t, err)
+	require.Equal(t, at, sample.at)
+	require.Equal(t, 3*time.Second, sample.cpuTime)
+	require.Equal(t, uint64(300*os.Getpagesize()), sample.rss)
+
+	_, err = parseProcStat("1234 (osqueryd) S 1 2 3", at)
+	require.Error(t, err)
+}
+
+func TestWatchResources(t *testing.T) {
+	t.Parallel()
+
+	// Any running process is over a one byte memory limit
+	i := newInstance()
+	WithResourceLimits(ResourceLimits{
+		MaxRSSBytes:    1,
+		SampleInterval: time.Millisecond,
+		Cooldown:       time.Nanosecond,
+	})(i)
+
+	err := i.watchResources(os.Getpid())
+	require.IsType(t, resourceLimitError{}, err)
+	require.Contains(t, err.Error(), "memory")
+	require.NotZero(t, atomic.LoadUint64(&i.peakRSS), "expect peak memory to be recorded")
+}
+
+func TestCPUPercent(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	require.Equal(t, 50.0, cpuPercent(
+		processSample{at: now, cpuTime: time.Second},
+		processSample{at: now.Add(2 * time.Second), cpuTime: 2 * time.Second},
+	))
+	require.Equal(t, 0.0, cpuPercent(processSample{at: now}, processSample{at: now}))
+}
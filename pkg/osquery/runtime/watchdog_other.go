@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package runtime
+
+import "os"
+
+func sampleProcess(pid int) (processSample, error) {
+	return processSample{}, errSamplingUnsupported
+}
+
+func maxRSS(state *os.ProcessState) uint64 {
+	return 0
+}
@@ -0,0 +1,47 @@
+package table
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kolide/launcher/pkg/autoupdate"
+	"github.com/osquery/osquery-go/plugin/table"
+	"go.etcd.io/bbolt"
+)
+
+const launcherAutoupdateHistoryTableName = "kolide_launcher_autoupdate_history"
+
+// LauncherAutoupdateHistoryTable lists the autoupdaters' decisions,
+// such as holding an update back, or rolling one back.
+func LauncherAutoupdateHistoryTable(db *bbolt.DB) *table.Plugin {
+	columns := []table.ColumnDefinition{
+		table.BigIntColumn("time"),
+		table.TextColumn("binary"),
+		table.TextColumn("version"),
+		table.TextColumn("action"),
+		table.TextColumn("reason"),
+	}
+
+	return table.NewPlugin(launcherAutoupdateHistoryTableName, columns, generateLauncherAutoupdateHistoryTable(db))
+}
+
+func generateLauncherAutoupdateHistoryTable(db *bbolt.DB) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		decisions, err := autoupdate.GetDecisions(db)
+		if err != nil {
+			return nil, err
+		}
+
+		results := make([]map[string]string, 0, len(decisions))
+		for _, d := range decisions {
+			results = append(results, map[string]string{
+				"time":    strconv.FormatInt(d.Time.Unix(), 10),
+				"binary":  d.Binary,
+				"version": d.Version,
+				"action":  string(d.Action),
+				"reason":  d.Reason,
+			})
+		}
+		return results, nil
+	}
+}
@@ -0,0 +1,36 @@
+package table
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// ExtensionStats is implemented by the launcher osquery extension, which
+// counts the logs and results it discards.
+type ExtensionStats interface {
+	DroppedLogs() uint64
+	DroppedResults() uint64
+}
+
+// LauncherExtensionStats reports the logs and distributed query results
+// the extension has discarded, since launcher started.
+func LauncherExtensionStats(stats ExtensionStats) *table.Plugin {
+	columns := []table.ColumnDefinition{
+		table.BigIntColumn("logs_dropped"),
+		table.BigIntColumn("results_dropped"),
+	}
+	return table.NewPlugin("kolide_launcher_extension_stats", columns, generateLauncherExtensionStats(stats))
+}
+
+func generateLauncherExtensionStats(stats ExtensionStats) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		return []map[string]string{
+			{
+				"logs_dropped":    strconv.FormatUint(stats.DroppedLogs(), 10),
+				"results_dropped": strconv.FormatUint(stats.DroppedResults(), 10),
+			},
+		}, nil
+	}
+}
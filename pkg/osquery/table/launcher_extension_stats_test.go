@@ -0,0 +1,24 @@
+package table
+
+import (
+	"context"
+	"testing"
+
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeExtensionStats struct {
+	logs, results uint64
+}
+
+func (f fakeExtensionStats) DroppedLogs() uint64    { return f.logs }
+func (f fakeExtensionStats) DroppedResults() uint64 { return f.results }
+
+func TestLauncherExtensionStats(t *testing.T) {
+	t.Parallel()
+
+	rows, err := generateLauncherExtensionStats(fakeExtensionStats{logs: 4, results: 2})(context.TODO(), table.QueryContext{})
+	require.NoError(t, err)
+	require.Equal(t, []map[string]string{{"logs_dropped": "4", "results_dropped": "2"}}, rows)
+}
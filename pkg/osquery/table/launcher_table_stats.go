@@ -0,0 +1,66 @@
+package table
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// LauncherTableStats reports the calls made into launcher's tables since
+// launcher started, and how often tables that cache their command output
+// used it.
+func LauncherTableStats() *table.Plugin {
+	columns := []table.ColumnDefinition{
+		table.TextColumn("name"),
+		table.BigIntColumn("calls"),
+		table.BigIntColumn("errors"),
+		table.BigIntColumn("timeouts"),
+		table.BigIntColumn("panics"),
+		table.BigIntColumn("rows"),
+		table.BigIntColumn("total_duration_ms"),
+		table.BigIntColumn("average_duration_ms"),
+		table.BigIntColumn("max_duration_ms"),
+		table.BigIntColumn("last_call_time"),
+		table.BigIntColumn("cache_hits"),
+		table.BigIntColumn("cache_misses"),
+	}
+	return table.NewPlugin("kolide_launcher_table_stats", columns, generateLauncherTableStats(defaultTableStats, tablehelpers.AllExecCacheStats))
+}
+
+func generateLauncherTableStats(recorder *tableStatsRecorder, cacheStats func() []tablehelpers.ExecCacheStats) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		names, stats := recorder.snapshot()
+
+		caches := make(map[string]tablehelpers.ExecCacheStats)
+		for _, cache := range cacheStats() {
+			caches[cache.Name] = cache
+		}
+
+		results := make([]map[string]string, len(names))
+		for i, name := range names {
+			var average int64
+			if stats[i].calls > 0 {
+				average = stats[i].totalDuration.Milliseconds() / int64(stats[i].calls)
+			}
+
+			results[i] = map[string]string{
+				"name":                name,
+				"calls":               strconv.FormatUint(stats[i].calls, 10),
+				"errors":              strconv.FormatUint(stats[i].errors, 10),
+				"timeouts":            strconv.FormatUint(stats[i].timeouts, 10),
+				"panics":              strconv.FormatUint(stats[i].panics, 10),
+				"rows":                strconv.FormatUint(stats[i].rows, 10),
+				"total_duration_ms":   strconv.FormatInt(stats[i].totalDuration.Milliseconds(), 10),
+				"average_duration_ms": strconv.FormatInt(average, 10),
+				"max_duration_ms":     strconv.FormatInt(stats[i].maxDuration.Milliseconds(), 10),
+				"last_call_time":      strconv.FormatInt(stats[i].lastCall.Unix(), 10),
+				"cache_hits":          strconv.FormatUint(caches[name].Hits, 10),
+				"cache_misses":        strconv.FormatUint(caches[name].Misses, 10),
+			}
+		}
+
+		return results, nil
+	}
+}
@@ -0,0 +1,44 @@
+package table
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/kolide/launcher/pkg/service"
+	"github.com/osquery/osquery-go/plugin/table"
+)
+
+// LauncherTransportStats reports the work launcher's transport has done
+// to fit logs and results into the server's request limits, since
+// launcher started.
+func LauncherTransportStats() *table.Plugin {
+	columns := []table.ColumnDefinition{
+		table.BigIntColumn("compressed_requests"),
+		table.BigIntColumn("logs_split"),
+		table.BigIntColumn("logs_dropped"),
+		table.BigIntColumn("results_split"),
+		table.BigIntColumn("chunks_sent"),
+		table.BigIntColumn("rows_dropped"),
+		table.BigIntColumn("chunks_reassembled"),
+		table.BigIntColumn("chunks_expired"),
+	}
+	return table.NewPlugin("kolide_launcher_transport_stats", columns, generateLauncherTransportStats(service.Stats))
+}
+
+func generateLauncherTransportStats(stats func() service.TransportStats) table.GenerateFunc {
+	return func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+		s := stats()
+		return []map[string]string{
+			{
+				"compressed_requests": strconv.FormatUint(s.CompressedRequests, 10),
+				"logs_split":          strconv.FormatUint(s.LogsSplit, 10),
+				"logs_dropped":        strconv.FormatUint(s.LogsDropped, 10),
+				"results_split":       strconv.FormatUint(s.ResultsSplit, 10),
+				"chunks_sent":         strconv.FormatUint(s.ChunksSent, 10),
+				"rows_dropped":        strconv.FormatUint(s.RowsDropped, 10),
+				"chunks_reassembled":  strconv.FormatUint(s.ChunksReassembled, 10),
+				"chunks_expired":      strconv.FormatUint(s.ChunksExpired, 10),
+			},
+		}, nil
+	}
+}
@@ -0,0 +1,27 @@
+package table
+
+import (
+	"context"
+	"testing"
+
+	"github.com/kolide/launcher/pkg/service"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLauncherTransportStats(t *testing.T) {
+	t.Parallel()
+
+	stats := func() service.TransportStats {
+		return service.TransportStats{CompressedRequests: 3, ChunksSent: 7, RowsDropped: 1, LogsDropped: 2}
+	}
+
+	rows, err := generateLauncherTransportStats(stats)(context.TODO(), table.QueryContext{})
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "3", rows[0]["compressed_requests"])
+	require.Equal(t, "7", rows[0]["chunks_sent"])
+	require.Equal(t, "1", rows[0]["rows_dropped"])
+	require.Equal(t, "2", rows[0]["logs_dropped"])
+	require.Equal(t, "0", rows[0]["logs_split"])
+}
@@ -0,0 +1,183 @@
+package table
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	osquery "github.com/osquery/osquery-go"
+	osquerygen "github.com/osquery/osquery-go/gen/osquery"
+)
+
+// defaultTableTimeout is how long a table may take to generate before
+// osquery is sent an error instead, so a single slow table doesn't hold up
+// the rest of its group's extension server.
+const defaultTableTimeout = 2 * time.Minute
+
+// defaultTableStats records the calls into every table wrapped by
+// withMiddleware. It backs the kolide_launcher_table_stats table.
+var defaultTableStats = newTableStatsRecorder()
+
+// tableStats are the counters kept for a single table.
+type tableStats struct {
+	calls         uint64
+	errors        uint64
+	timeouts      uint64
+	panics        uint64
+	rows          uint64
+	totalDuration time.Duration
+	maxDuration   time.Duration
+	lastCall      time.Time
+}
+
+type tableStatsRecorder struct {
+	lock   sync.Mutex
+	tables map[string]*tableStats
+}
+
+func newTableStatsRecorder() *tableStatsRecorder {
+	return &tableStatsRecorder{tables: make(map[string]*tableStats)}
+}
+
+// record adds the outcome of a single generate call to a table's stats.
+func (r *tableStatsRecorder) record(name string, outcome callOutcome, started time.Time, duration time.Duration) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	stats, ok := r.tables[name]
+	if !ok {
+		stats = &tableStats{}
+		r.tables[name] = stats
+	}
+
+	stats.calls++
+	stats.rows += uint64(outcome.rows)
+	stats.totalDuration += duration
+	if duration > stats.maxDuration {
+		stats.maxDuration = duration
+	}
+	stats.lastCall = started
+
+	switch {
+	case outcome.timedOut:
+		stats.errors++
+		stats.timeouts++
+	case outcome.panicked:
+		stats.errors++
+		stats.panics++
+	case outcome.failed:
+		stats.errors++
+	}
+}
+
+// snapshot returns a copy of every table's stats, sorted by table name.
+func (r *tableStatsRecorder) snapshot() ([]string, []tableStats) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	names := make([]string, 0, len(r.tables))
+	for name := range r.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	stats := make([]tableStats, len(names))
+	for i, name := range names {
+		stats[i] = *r.tables[name]
+	}
+	return names, stats
+}
+
+// callOutcome describes how a call into a table went.
+type callOutcome struct {
+	rows     int
+	failed   bool
+	timedOut bool
+	panicked bool
+}
+
+// withMiddleware wraps each table plugin, so that calls into it are
+// bounded by a timeout, panics are returned to osquery as errors, and
+// generate calls are counted in defaultTableStats. Other plugins are
+// returned unchanged.
+func withMiddleware(plugins []osquery.OsqueryPlugin) []osquery.OsqueryPlugin {
+	wrapped := make([]osquery.OsqueryPlugin, len(plugins))
+	for i, plugin := range plugins {
+		if plugin.RegistryName() != "table" {
+			wrapped[i] = plugin
+			continue
+		}
+		wrapped[i] = middlewarePlugin{
+			OsqueryPlugin: plugin,
+			timeout:       defaultTableTimeout,
+			stats:         defaultTableStats,
+		}
+	}
+	return wrapped
+}
+
+type middlewarePlugin struct {
+	osquery.OsqueryPlugin
+	timeout time.Duration
+	stats   *tableStatsRecorder
+}
+
+func (p middlewarePlugin) Call(ctx context.Context, request osquerygen.ExtensionPluginRequest) osquerygen.ExtensionResponse {
+	started := time.Now()
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	type callResult struct {
+		response osquerygen.ExtensionResponse
+		panicked bool
+	}
+
+	// Buffered, so an abandoned call can still finish
+	results := make(chan callResult, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				results <- callResult{
+					response: errorResponse(fmt.Sprintf("table %s panicked: %v", p.Name(), r)),
+					panicked: true,
+				}
+			}
+		}()
+		results <- callResult{response: p.OsqueryPlugin.Call(ctx, request)}
+	}()
+
+	var response osquerygen.ExtensionResponse
+	var outcome callOutcome
+	select {
+	case result := <-results:
+		response = result.response
+		outcome.panicked = result.panicked
+		if response.Status == nil || response.Status.Code != 0 {
+			outcome.failed = true
+		} else {
+			outcome.rows = len(response.Response)
+		}
+	case <-ctx.Done():
+		response = errorResponse(fmt.Sprintf("table %s timed out after %s", p.Name(), p.timeout))
+		outcome.timedOut = true
+	}
+
+	// Only generate calls are queries. The others describe the table.
+	if request["action"] == "generate" {
+		p.stats.record(p.Name(), outcome, started, time.Since(started))
+	}
+
+	return response
+}
+
+func errorResponse(message string) osquerygen.ExtensionResponse {
+	return osquerygen.ExtensionResponse{
+		Status: &osquerygen.ExtensionStatus{
+			Code:    1,
+			Message: message,
+		},
+	}
+}
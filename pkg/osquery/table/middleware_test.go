@@ -0,0 +1,128 @@
+package table
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMiddleware(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name             string
+		generate         table.GenerateFunc
+		expectedCode     int32
+		expectedRows     int
+		expectedOutcome  tableStats
+		expectedContains string
+	}{
+		{
+			name: "rows",
+			generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+				return []map[string]string{{"a": "1"}, {"a": "2"}}, nil
+			},
+			expectedRows:    2,
+			expectedOutcome: tableStats{calls: 1, rows: 2},
+		},
+		{
+			name: "error",
+			generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+				return nil, context.Canceled
+			},
+			expectedCode:    1,
+			expectedOutcome: tableStats{calls: 1, errors: 1},
+		},
+		{
+			name: "panic",
+			generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+				panic("oh no")
+			},
+			expectedCode:     1,
+			expectedOutcome:  tableStats{calls: 1, errors: 1, panics: 1},
+			expectedContains: "panicked: oh no",
+		},
+		{
+			name: "timeout",
+			generate: func(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+				time.Sleep(time.Second)
+				return nil, nil
+			},
+			expectedCode:     1,
+			expectedOutcome:  tableStats{calls: 1, errors: 1, timeouts: 1},
+			expectedContains: "timed out",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			recorder := newTableStatsRecorder()
+			plugin := middlewarePlugin{
+				OsqueryPlugin: table.NewPlugin("test_table", []table.ColumnDefinition{table.TextColumn("a")}, tt.generate),
+				timeout:       100 * time.Millisecond,
+				stats:         recorder,
+			}
+
+			resp := plugin.Call(context.TODO(), osquery.ExtensionPluginRequest{"action": "generate", "context": "{}"})
+			require.Equal(t, tt.expectedCode, resp.Status.Code, resp.Status.Message)
+			assert.Len(t, resp.Response, tt.expectedRows)
+			assert.Contains(t, resp.Status.Message, tt.expectedContains)
+
+			names, stats := recorder.snapshot()
+			require.Equal(t, []string{"test_table"}, names)
+			assert.Equal(t, tt.expectedOutcome.calls, stats[0].calls)
+			assert.Equal(t, tt.expectedOutcome.errors, stats[0].errors)
+			assert.Equal(t, tt.expectedOutcome.timeouts, stats[0].timeouts)
+			assert.Equal(t, tt.expectedOutcome.panics, stats[0].panics)
+			assert.Equal(t, tt.expectedOutcome.rows, stats[0].rows)
+
+			// Describing the table isn't counted as a call
+			resp = plugin.Call(context.TODO(), osquery.ExtensionPluginRequest{"action": "columns"})
+			require.Equal(t, int32(0), resp.Status.Code)
+			_, stats = recorder.snapshot()
+			assert.Equal(t, uint64(1), stats[0].calls)
+		})
+	}
+}
+
+func TestLauncherTableStats(t *testing.T) {
+	t.Parallel()
+
+	recorder := newTableStatsRecorder()
+	started := time.Unix(1600000000, 0)
+	recorder.record("b_table", callOutcome{rows: 3}, started, 10*time.Millisecond)
+	recorder.record("b_table", callOutcome{failed: true}, started, 30*time.Millisecond)
+	recorder.record("a_table", callOutcome{timedOut: true}, started, time.Second)
+
+	cacheStats := func() []tablehelpers.ExecCacheStats {
+		return []tablehelpers.ExecCacheStats{{Name: "b_table", Hits: 5, Misses: 2}}
+	}
+
+	rows, err := generateLauncherTableStats(recorder, cacheStats)(context.TODO(), table.QueryContext{})
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+
+	assert.Equal(t, "a_table", rows[0]["name"])
+	assert.Equal(t, "1", rows[0]["timeouts"])
+	assert.Equal(t, "1000", rows[0]["max_duration_ms"])
+	assert.Equal(t, "0", rows[0]["cache_hits"])
+
+	assert.Equal(t, "b_table", rows[1]["name"])
+	assert.Equal(t, "2", rows[1]["calls"])
+	assert.Equal(t, "1", rows[1]["errors"])
+	assert.Equal(t, "3", rows[1]["rows"])
+	assert.Equal(t, "40", rows[1]["total_duration_ms"])
+	assert.Equal(t, "20", rows[1]["average_duration_ms"])
+	assert.Equal(t, "1600000000", rows[1]["last_call_time"])
+	assert.Equal(t, "5", rows[1]["cache_hits"])
+	assert.Equal(t, "2", rows[1]["cache_misses"])
+}
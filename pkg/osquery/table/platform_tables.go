@@ -4,6 +4,8 @@
 package table
 
 import (
+	"time"
+
 	"github.com/go-kit/kit/log"
 	osquery "github.com/osquery/osquery-go"
 	"github.com/osquery/osquery-go/plugin/table"
@@ -11,6 +13,6 @@ import (
 
 // platformTables returns an empty set. It's here as a catchall for
 // unimplemented platforms.
-func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string) []*table.Plugin {
+func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string, execCacheTTL time.Duration) []*table.Plugin {
 	return []*table.Plugin{}
 }
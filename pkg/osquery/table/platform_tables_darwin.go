@@ -4,6 +4,8 @@
 package table
 
 import (
+	"time"
+
 	"github.com/go-kit/kit/log"
 	"github.com/knightsc/system_policy/osquery/table/kextpolicy"
 	"github.com/knightsc/system_policy/osquery/table/legacyexec"
@@ -30,7 +32,7 @@ const (
 	screenlockQuery    = "select enabled, grace_period from screenlock"
 )
 
-func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string) []osquery.OsqueryPlugin {
+func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string, execCacheTTL time.Duration) []osquery.OsqueryPlugin {
 	munki := munki.New()
 
 	// This table uses undocumented APIs, There is some discussion at the
@@ -42,7 +44,8 @@ func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, c
 		[]table.ColumnDefinition{
 			table.IntegerColumn("enabled"),
 			table.IntegerColumn("grace_period"),
-		})
+		},
+		osquery_user_exec_table.WithCacheTTL(execCacheTTL))
 
 	keychainAclsTable := osquery_user_exec_table.TablePlugin(
 		client, logger, "kolide_keychain_acls",
@@ -53,7 +56,8 @@ func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, c
 			table.TextColumn("path"),
 			table.TextColumn("description"),
 			table.TextColumn("label"),
-		})
+		},
+		osquery_user_exec_table.WithCacheTTL(execCacheTTL))
 
 	keychainItemsTable := osquery_user_exec_table.TablePlugin(
 		client, logger, "kolide_keychain_items",
@@ -66,7 +70,8 @@ func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, c
 			table.TextColumn("modified"),
 			table.TextColumn("type"),
 			table.TextColumn("path"),
-		})
+		},
+		osquery_user_exec_table.WithCacheTTL(execCacheTTL))
 
 	return []osquery.OsqueryPlugin{
 		keychainAclsTable,
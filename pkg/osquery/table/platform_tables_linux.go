@@ -4,6 +4,8 @@
 package table
 
 import (
+	"time"
+
 	"github.com/go-kit/kit/log"
 	"github.com/kolide/launcher/pkg/osquery/tables/cryptsetup"
 	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
@@ -14,21 +16,23 @@ import (
 	osquery "github.com/osquery/osquery-go"
 )
 
-func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string) []osquery.OsqueryPlugin {
+func platformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string, execCacheTTL time.Duration) []osquery.OsqueryPlugin {
 	return []osquery.OsqueryPlugin{
-		cryptsetup.TablePlugin(client, logger),
-		gsettings.Settings(client, logger),
-		gsettings.Metadata(client, logger),
+		cryptsetup.TablePlugin(client, logger, cryptsetup.WithCacheTTL(execCacheTTL)),
+		gsettings.Settings(client, logger, gsettings.WithCacheTTL(execCacheTTL)),
+		gsettings.Metadata(client, logger, gsettings.WithCacheTTL(execCacheTTL)),
 		secureboot.TablePlugin(client, logger),
 		xrdb.TablePlugin(client, logger),
 		fscrypt_info.TablePlugin(logger),
 		dataflattentable.TablePluginExec(client, logger,
 			"kolide_nmcli_wifi", dataflattentable.KeyValueType,
 			[]string{"/usr/bin/nmcli", "--mode=multiline", "--fields=all", "device", "wifi", "list"},
-			dataflattentable.WithKVSeparator(":")),
+			dataflattentable.WithKVSeparator(":"),
+			dataflattentable.WithCacheTTL(execCacheTTL)),
 		dataflattentable.TablePluginExec(client, logger, "kolide_lsblk", dataflattentable.JsonType,
 			[]string{"lsblk", "-J"},
 			dataflattentable.WithBinDirs("/usr/bin", "/bin"),
+			dataflattentable.WithCacheTTL(execCacheTTL),
 		),
 	}
 }
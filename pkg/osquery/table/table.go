@@ -1,6 +1,8 @@
 package table
 
 import (
+	"time"
+
 	"github.com/kolide/launcher/pkg/launcher"
 	"github.com/kolide/launcher/pkg/osquery/tables/cryptoinfotable"
 	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
@@ -10,6 +12,7 @@ import (
 	"github.com/kolide/launcher/pkg/osquery/tables/zfs"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	osquery "github.com/osquery/osquery-go"
 	"go.etcd.io/bbolt"
 )
@@ -17,46 +20,141 @@ import (
 // LauncherTables returns launcher-specific tables. They're based
 // around _launcher_ things thus do not make sense in tables.ext
 func LauncherTables(db *bbolt.DB, opts *launcher.Options) []osquery.OsqueryPlugin {
-	return []osquery.OsqueryPlugin{
+	return withMiddleware([]osquery.OsqueryPlugin{
 		LauncherConfigTable(db),
 		LauncherDbInfo(db),
 		LauncherInfoTable(db),
 		TargetMembershipTable(db),
 		LauncherAutoupdateConfigTable(opts),
+		LauncherAutoupdateHistoryTable(db),
+		LauncherTableStats(),
+		LauncherTransportStats(),
 		osquery_instance_history.TablePlugin(),
+	})
+}
+
+// DefaultExecCacheTTL is how long output is cached for tables which exec
+// commands that are slow, or whose output rarely changes, unless
+// WithExecCacheTTL says otherwise.
+const DefaultExecCacheTTL = 30 * time.Second
+
+// PlatformTablesOpt configures the tables returned by PlatformTables and
+// PlatformTableGroups.
+type PlatformTablesOpt func(*platformTablesOpts)
+
+type platformTablesOpts struct {
+	execCacheTTL time.Duration
+}
+
+// WithExecCacheTTL sets how long tables which exec commands cache their
+// output. Zero disables caching.
+func WithExecCacheTTL(ttl time.Duration) PlatformTablesOpt {
+	return func(o *platformTablesOpts) {
+		o.execCacheTTL = ttl
 	}
 }
 
+// TableGroup is a set of tables served together, by their own extension
+// manager server. A table that hangs only holds up the rest of its group.
+type TableGroup struct {
+	Name   string
+	Tables []osquery.OsqueryPlugin
+}
+
 // PlatformTables returns all tables for the launcher build platform.
-func PlatformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string) []osquery.OsqueryPlugin {
-	// Common tables to all platforms
-	tables := []osquery.OsqueryPlugin{
-		BestPractices(client),
-		ChromeLoginDataEmails(client, logger),
-		ChromeUserProfiles(client, logger),
-		EmailAddresses(client, logger),
-		KeyInfo(client, logger),
-		OnePasswordAccounts(client, logger),
-		SlackConfig(client, logger),
-		SshKeys(client, logger),
-		cryptoinfotable.TablePlugin(logger),
-		firefox_preferences.TablePlugin(logger),
-		dataflattentable.TablePluginExec(client, logger,
-			"kolide_zerotier_info", dataflattentable.JsonType, zerotierCli("info")),
-		dataflattentable.TablePluginExec(client, logger,
-			"kolide_zerotier_networks", dataflattentable.JsonType, zerotierCli("listnetworks")),
-		dataflattentable.TablePluginExec(client, logger,
-			"kolide_zerotier_peers", dataflattentable.JsonType, zerotierCli("listpeers")),
-		tdebug.LauncherGcInfo(client, logger),
-		zfs.ZfsPropertiesPlugin(client, logger),
-		zfs.ZpoolPropertiesPlugin(client, logger),
+func PlatformTables(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string, opts ...PlatformTablesOpt) []osquery.OsqueryPlugin {
+	var tables []osquery.OsqueryPlugin
+	for _, group := range PlatformTableGroups(client, logger, currentOsquerydBinaryPath, opts...) {
+		tables = append(tables, group.Tables...)
 	}
+	return tables
+}
 
-	// The dataflatten tables
-	tables = append(tables, dataflattentable.AllTablePlugins(client, logger)...)
+// PlatformTableGroups returns the tables from PlatformTables, grouped so
+// each group can be served independently. Tables which exec other
+// programs, and so are the likeliest to be slow, are kept apart from the
+// rest.
+func PlatformTableGroups(client *osquery.ExtensionManagerClient, logger log.Logger, currentOsquerydBinaryPath string, opts ...PlatformTablesOpt) []TableGroup {
+	o := platformTablesOpts{execCacheTTL: DefaultExecCacheTTL}
+	for _, opt := range opts {
+		opt(&o)
+	}
 
-	// add in the platform specific ones (as denoted by build tags)
-	tables = append(tables, platformTables(client, logger, currentOsquerydBinaryPath)...)
+	groups := []TableGroup{
+		{
+			// Common tables to all platforms
+			Name: "common",
+			Tables: []osquery.OsqueryPlugin{
+				BestPractices(client),
+				ChromeLoginDataEmails(client, logger),
+				ChromeUserProfiles(client, logger),
+				EmailAddresses(client, logger),
+				KeyInfo(client, logger),
+				OnePasswordAccounts(client, logger),
+				SlackConfig(client, logger),
+				SshKeys(client, logger),
+				cryptoinfotable.TablePlugin(logger),
+				firefox_preferences.TablePlugin(logger),
+				tdebug.LauncherGcInfo(client, logger),
+			},
+		},
+		{
+			// Common tables which exec other programs
+			Name: "exec",
+			Tables: []osquery.OsqueryPlugin{
+				dataflattentable.TablePluginExec(client, logger,
+					"kolide_zerotier_info", dataflattentable.JsonType, zerotierCli("info")),
+				dataflattentable.TablePluginExec(client, logger,
+					"kolide_zerotier_networks", dataflattentable.JsonType, zerotierCli("listnetworks")),
+				dataflattentable.TablePluginExec(client, logger,
+					"kolide_zerotier_peers", dataflattentable.JsonType, zerotierCli("listpeers")),
+				zfs.ZfsPropertiesPlugin(client, logger, zfs.WithCacheTTL(o.execCacheTTL)),
+				zfs.ZpoolPropertiesPlugin(client, logger, zfs.WithCacheTTL(o.execCacheTTL)),
+			},
+		},
+		{
+			// The dataflatten tables
+			Name:   "dataflatten",
+			Tables: dataflattentable.AllTablePlugins(client, logger),
+		},
+		{
+			// The platform specific ones (as denoted by build tags)
+			Name:   "platform",
+			Tables: platformTables(client, logger, currentOsquerydBinaryPath, o.execCacheTTL),
+		},
+	}
 
-	return tables
+	for i := range groups {
+		groups[i].Tables = withMiddleware(groups[i].Tables)
+	}
+	return groups
+}
+
+// CustomTableGroup returns the tables declared by specs, as a group of
+// their own. Invalid specs, and specs whose names are already taken by
+// existing or earlier specs' tables, are logged and skipped.
+func CustomTableGroup(client *osquery.ExtensionManagerClient, logger log.Logger, specs []dataflattentable.TableSpec, existing []osquery.OsqueryPlugin) TableGroup {
+	taken := make(map[string]bool, len(existing)+len(specs))
+	for _, plugin := range existing {
+		taken[plugin.Name()] = true
+	}
+
+	var tables []osquery.OsqueryPlugin
+	for _, spec := range specs {
+		if taken[spec.Name] {
+			level.Info(logger).Log("msg", "skipping custom table, name already in use", "table", spec.Name)
+			continue
+		}
+
+		plugin, err := dataflattentable.TablePluginFromSpec(client, logger, spec)
+		if err != nil {
+			level.Info(logger).Log("msg", "skipping invalid custom table", "table", spec.Name, "err", err)
+			continue
+		}
+
+		taken[spec.Name] = true
+		tables = append(tables, plugin)
+	}
+
+	return TableGroup{Name: "custom", Tables: withMiddleware(tables)}
 }
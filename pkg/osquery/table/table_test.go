@@ -0,0 +1,60 @@
+package table
+
+import (
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/osquery/tables/dataflattentable"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlatformTableGroups(t *testing.T) {
+	t.Parallel()
+
+	groups := PlatformTableGroups(nil, log.NewNopLogger(), "osqueryd")
+
+	groupNames := make(map[string]bool)
+	tableNames := make(map[string]bool)
+	for _, group := range groups {
+		require.NotEmpty(t, group.Name)
+		require.False(t, groupNames[group.Name], "duplicate group %s", group.Name)
+		groupNames[group.Name] = true
+
+		for _, plugin := range group.Tables {
+			require.False(t, tableNames[plugin.Name()], "table %s is in more than one group", plugin.Name())
+			tableNames[plugin.Name()] = true
+		}
+	}
+
+	// PlatformTables is every table from every group
+	tables := PlatformTables(nil, log.NewNopLogger(), "osqueryd")
+	require.Len(t, tables, len(tableNames))
+	for _, plugin := range tables {
+		require.True(t, tableNames[plugin.Name()], "table %s is not in a group", plugin.Name())
+	}
+}
+
+func TestCustomTableGroup(t *testing.T) {
+	t.Parallel()
+
+	existing := PlatformTables(nil, log.NewNopLogger(), "osqueryd")
+	specs := []dataflattentable.TableSpec{
+		{Name: "kolide_custom_one", Path: "/tmp/one.json", Parser: "json"},
+		// Taken by a launcher table
+		{Name: "kolide_json", Path: "/tmp/two.json", Parser: "json"},
+		// Taken by an earlier spec
+		{Name: "kolide_custom_one", Path: "/tmp/three.json", Parser: "json"},
+		// Invalid
+		{Name: "kolide_custom_invalid", Parser: "json"},
+		{Name: "kolide_custom_two", Exec: []string{"/bin/echo", "{}"}, Parser: "json"},
+	}
+
+	group := CustomTableGroup(nil, log.NewNopLogger(), specs, existing)
+	require.Equal(t, "custom", group.Name)
+
+	var names []string
+	for _, plugin := range group.Tables {
+		names = append(names, plugin.Name())
+	}
+	require.Equal(t, []string{"kolide_custom_one", "kolide_custom_two"}, names)
+}
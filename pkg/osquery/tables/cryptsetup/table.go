@@ -3,6 +3,7 @@ package cryptsetup
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -22,12 +23,23 @@ var cryptsetupPaths = []string{
 const allowedNameCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-/_"
 
 type Table struct {
-	client *osquery.ExtensionManagerClient
-	logger log.Logger
-	name   string
+	client    *osquery.ExtensionManagerClient
+	logger    log.Logger
+	name      string
+	execCache *tablehelpers.ExecCache
 }
 
-func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger) *table.Plugin {
+type Opt func(*Table)
+
+// WithCacheTTL caches cryptsetup's output for ttl, so frequent queries
+// don't re-run it.
+func WithCacheTTL(ttl time.Duration) Opt {
+	return func(t *Table) {
+		t.execCache = tablehelpers.NamedExecCache(t.name, ttl)
+	}
+}
+
+func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger, opts ...Opt) *table.Plugin {
 	columns := dataflattentable.Columns(
 		table.TextColumn("name"),
 	)
@@ -38,6 +50,10 @@ func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger) *tab
 		name:   "kolide_cryptsetup_status",
 	}
 
+	for _, opt := range opts {
+		opt(t)
+	}
+
 	return table.NewPlugin(t.name, columns, t.generate)
 }
 
@@ -54,7 +70,7 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 	}
 
 	for _, name := range requestedNames {
-		output, err := tablehelpers.Exec(ctx, t.logger, 15, cryptsetupPaths, []string{"--readonly", "status", name})
+		output, err := t.execCache.Exec(ctx, t.logger, 15, cryptsetupPaths, []string{"--readonly", "status", name}, tablehelpers.WithRoot())
 		if err != nil {
 			level.Debug(t.logger).Log("msg", "Error execing for status", "name", name, "err", err)
 			continue
@@ -12,6 +12,7 @@ import (
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/kolide/launcher/pkg/dataflatten"
+	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
 	"github.com/osquery/osquery-go"
 	"github.com/osquery/osquery-go/plugin/table"
 	"github.com/pkg/errors"
@@ -33,6 +34,14 @@ func WithBinDirs(binDirs ...string) ExecTableOpt {
 	}
 }
 
+// WithCacheTTL caches the command's output for ttl, so frequent queries
+// don't re-run it.
+func WithCacheTTL(ttl time.Duration) ExecTableOpt {
+	return func(t *Table) {
+		t.execCache = tablehelpers.NamedExecCache(t.tableName, ttl)
+	}
+}
+
 func TablePluginExec(client *osquery.ExtensionManagerClient, logger log.Logger, tableName string, dataSourceType DataSourceType, execArgs []string, opts ...ExecTableOpt) *table.Plugin {
 	columns := Columns()
 
@@ -53,6 +62,8 @@ func TablePluginExec(client *osquery.ExtensionManagerClient, logger log.Logger,
 		t.execDataFunc = dataflatten.Plist
 	case JsonType:
 		t.execDataFunc = dataflatten.Json
+	case YamlType:
+		t.execDataFunc = dataflatten.Yaml
 	case KeyValueType:
 		// TODO: allow callers of TablePluginExec to specify the record
 		// splitting strategy
@@ -83,10 +94,10 @@ func (t *Table) generateExec(ctx context.Context, queryContext table.QueryContex
 	if q, ok := queryContext.Constraints["query"]; ok && len(q.Constraints) != 0 {
 		for _, constraint := range q.Constraints {
 			dataQuery := constraint.Expression
-			results = append(results, t.getRowsFromOutput(dataQuery, execBytes)...)
+			results = append(results, t.getRowsFromOutput(dataQuery, execBytes, nil)...)
 		}
 	} else {
-		results = append(results, t.getRowsFromOutput("", execBytes)...)
+		results = append(results, t.getRowsFromOutput("", execBytes, nil)...)
 	}
 
 	return results, nil
@@ -106,6 +117,12 @@ func (t *Table) exec(ctx context.Context) ([]byte, error) {
 		}
 	}
 
+	return t.execCache.Cached(possibleBinaries, t.execArgs[1:], func() ([]byte, error) {
+		return t.execBinaries(ctx, possibleBinaries)
+	})
+}
+
+func (t *Table) execBinaries(ctx context.Context, possibleBinaries []string) ([]byte, error) {
 	for _, execPath := range possibleBinaries {
 		var stdout bytes.Buffer
 		var stderr bytes.Buffer
@@ -131,9 +148,10 @@ func (t *Table) exec(ctx context.Context) ([]byte, error) {
 	return nil, errors.Errorf("Unable to exec '%s'. No binary found is specified paths", t.execArgs[0])
 }
 
-func (t *Table) getRowsFromOutput(dataQuery string, execOutput []byte) []map[string]string {
+func (t *Table) getRowsFromOutput(dataQuery string, execOutput []byte, rowData map[string]string) []map[string]string {
 	flattenOpts := []dataflatten.FlattenOpts{
 		dataflatten.WithLogger(t.logger),
+		dataflatten.WithTypes(),
 		dataflatten.WithQuery(strings.Split(dataQuery, "/")),
 	}
 
@@ -143,5 +161,8 @@ func (t *Table) getRowsFromOutput(dataQuery string, execOutput []byte) []map[str
 		return nil
 	}
 
-	return ToMap(data, dataQuery, nil)
+	if len(t.pivotColumns) > 0 {
+		return ToPivotMap(data, dataQuery, t.pivotColumns, rowData)
+	}
+	return ToMap(data, dataQuery, rowData)
 }
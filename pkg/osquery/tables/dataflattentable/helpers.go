@@ -1,6 +1,9 @@
 package dataflattentable
 
 import (
+	"math"
+	"strconv"
+
 	"github.com/kolide/launcher/pkg/dataflatten"
 	"github.com/osquery/osquery-go/plugin/table"
 )
@@ -11,20 +14,86 @@ func ToMap(rows []dataflatten.Row, query string, rowData map[string]string) []ma
 	results := make([]map[string]string, len(rows))
 
 	for i, row := range rows {
-		res := make(map[string]string, len(rowData)+5)
-		for k, v := range rowData {
-			res[k] = v
-		}
+		results[i] = rowToMap(row, query, rowData)
+	}
 
+	return results
+}
+
+// rowToMap converts a single flattened row, as ToMap does.
+func rowToMap(row dataflatten.Row, query string, rowData map[string]string) map[string]string {
+	res := make(map[string]string, len(rowData)+8)
+	for k, v := range rowData {
+		res[k] = v
+	}
+
+	p, k := row.ParentKey("/")
+
+	res["fullkey"] = row.StringPath("/")
+	res["parent"] = p
+	res["key"] = k
+	res["value"] = row.Value
+	res["query"] = query
+
+	// Only rows flattened WithTypes know their types
+	if row.Type != "" {
+		res["type"] = string(row.Type)
+		res["value_integer"], res["value_float"] = numericValues(row)
+	}
+
+	return res
+}
+
+// numericValues returns a row's value as an integer and as a float, for
+// the typed value columns. Either is empty if the value isn't that kind
+// of number. Text that parses as a number counts, as XML and INI values
+// are always text.
+func numericValues(row dataflatten.Row) (string, string) {
+	switch row.Type {
+	case dataflatten.TypeBool, dataflatten.TypeNull, dataflatten.TypeData:
+		return "", ""
+	}
+
+	if i, err := strconv.ParseInt(row.Value, 10, 64); err == nil {
+		return strconv.FormatInt(i, 10), strconv.FormatInt(i, 10)
+	}
+
+	f, err := strconv.ParseFloat(row.Value, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return "", ""
+	}
+	return "", strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// ToPivotMap is like ToMap, but returns a row per parent key, with the
+// values of the child keys named in columns as columns of their own.
+func ToPivotMap(rows []dataflatten.Row, query string, columns []string, rowData map[string]string) []map[string]string {
+	wanted := make(map[string]bool, len(columns))
+	for _, column := range columns {
+		wanted[column] = true
+	}
+
+	var results []map[string]string
+	byParent := make(map[string]map[string]string)
+	for _, row := range rows {
 		p, k := row.ParentKey("/")
+		if !wanted[k] {
+			continue
+		}
 
-		res["fullkey"] = row.StringPath("/")
-		res["parent"] = p
-		res["key"] = k
-		res["value"] = row.Value
-		res["query"] = query
+		res, ok := byParent[p]
+		if !ok {
+			res = make(map[string]string, len(rowData)+len(columns)+2)
+			for k, v := range rowData {
+				res[k] = v
+			}
+			res["parent"] = p
+			res["query"] = query
 
-		results[i] = res
+			byParent[p] = res
+			results = append(results, res)
+		}
+		res[k] = row.Value
 	}
 
 	return results
@@ -39,6 +108,9 @@ func Columns(additional ...table.ColumnDefinition) []table.ColumnDefinition {
 		table.TextColumn("parent"),
 		table.TextColumn("key"),
 		table.TextColumn("value"),
+		table.TextColumn("type"),
+		table.BigIntColumn("value_integer"),
+		table.DoubleColumn("value_float"),
 		table.TextColumn("query"),
 	}
 
@@ -0,0 +1,46 @@
+package dataflattentable
+
+import (
+	"testing"
+
+	"github.com/kolide/launcher/pkg/dataflatten"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToMapTypes(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		row      dataflatten.Row
+		expected map[string]string
+	}{
+		{
+			row:      dataflatten.NewRow([]string{"a"}, "1"),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "1", "query": "q"},
+		},
+		{
+			row:      dataflatten.NewTypedRow([]string{"a"}, "12", dataflatten.TypeInteger),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "12", "type": "integer", "value_integer": "12", "value_float": "12", "query": "q"},
+		},
+		{
+			row:      dataflatten.NewTypedRow([]string{"a"}, "1.5", dataflatten.TypeFloat),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "1.5", "type": "float", "value_integer": "", "value_float": "1.5", "query": "q"},
+		},
+		{
+			row:      dataflatten.NewTypedRow([]string{"a"}, "42", dataflatten.TypeString),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "42", "type": "string", "value_integer": "42", "value_float": "42", "query": "q"},
+		},
+		{
+			row:      dataflatten.NewTypedRow([]string{"a"}, "NaN", dataflatten.TypeString),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "NaN", "type": "string", "value_integer": "", "value_float": "", "query": "q"},
+		},
+		{
+			row:      dataflatten.NewTypedRow([]string{"a"}, "1", dataflatten.TypeBool),
+			expected: map[string]string{"fullkey": "a", "parent": "", "key": "a", "value": "1", "type": "bool", "value_integer": "", "value_float": "", "query": "q"},
+		},
+	}
+
+	for _, tt := range tests {
+		require.Equal(t, []map[string]string{tt.expected}, ToMap([]dataflatten.Row{tt.row}, "q", nil))
+	}
+}
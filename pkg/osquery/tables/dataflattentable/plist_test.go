@@ -64,10 +64,14 @@ func TestPlist(t *testing.T) {
 		}
 		require.NoError(t, err)
 
-		// delete the path and query keys, so we don't need to enumerate them in the test case
+		// delete the path, query, and type keys, so we don't need to enumerate them in the test case
 		for _, row := range rows {
 			delete(row, "path")
 			delete(row, "query")
+			delete(row, "query_language")
+			delete(row, "type")
+			delete(row, "value_integer")
+			delete(row, "value_float")
 		}
 
 		// Despite being an array. data is returned unordered. Sort it.
@@ -0,0 +1,195 @@
+package dataflattentable
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/kolide/launcher/pkg/dataflatten"
+	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
+	"github.com/osquery/osquery-go"
+	"github.com/osquery/osquery-go/plugin/table"
+	"github.com/pkg/errors"
+)
+
+var (
+	specNameRegexp   = regexp.MustCompile(`^kolide_[a-z0-9_]+$`)
+	specColumnRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+)
+
+// TableSpec declares a dataflatten table, so tables can be added through
+// configuration rather than code. A table reads its data either from the
+// output of a command, Exec, or from the files matching Path.
+type TableSpec struct {
+	// Name is the table name. It must start with kolide_.
+	Name string `json:"name"`
+	// Exec is the command to run, and its arguments.
+	Exec []string `json:"exec,omitempty"`
+	// BinDirs are the directories to look for Exec's command in. If
+	// empty, the command must be an absolute path.
+	BinDirs []string `json:"bin_dirs,omitempty"`
+	// Path is the file to read, and may be a glob.
+	Path string `json:"path,omitempty"`
+	// Parser is the data's format: json, xml, ini, plist, yaml, toml,
+	// hcl or kv.
+	Parser string `json:"parser"`
+	// KVSeparator separates keys and values for the kv parser. It
+	// defaults to ":".
+	KVSeparator string `json:"kv_separator,omitempty"`
+	// Columns, if set, pivots the table, so it returns a row per parent
+	// key, with the values of these child keys as columns. Otherwise,
+	// the table has the standard dataflatten columns.
+	Columns []string `json:"columns,omitempty"`
+	// CacheTTLSeconds caches Exec's output, so frequent queries don't
+	// re-run it.
+	CacheTTLSeconds int `json:"cache_ttl_seconds,omitempty"`
+}
+
+// Validate checks that a spec describes a usable table.
+func (s TableSpec) Validate() error {
+	if !specNameRegexp.MatchString(s.Name) {
+		return errors.Errorf("invalid table name %q, expected kolide_ followed by lowercase letters, numbers and underscores", s.Name)
+	}
+
+	switch {
+	case len(s.Exec) == 0 && s.Path == "":
+		return errors.Errorf("table %s needs one of exec or path", s.Name)
+	case len(s.Exec) > 0 && s.Path != "":
+		return errors.Errorf("table %s can't have both exec and path", s.Name)
+	case len(s.Exec) > 0 && len(s.BinDirs) == 0 && !filepath.IsAbs(s.Exec[0]):
+		return errors.Errorf("table %s must exec an absolute path, or set bin_dirs", s.Name)
+	case len(s.Exec) > 0 && len(s.BinDirs) > 0 && strings.ContainsRune(s.Exec[0], filepath.Separator):
+		return errors.Errorf("table %s must exec a bare command name with bin_dirs", s.Name)
+	case s.Path != "" && s.CacheTTLSeconds != 0:
+		return errors.Errorf("table %s can only cache exec output", s.Name)
+	case s.CacheTTLSeconds < 0:
+		return errors.Errorf("table %s has a negative cache_ttl_seconds", s.Name)
+	}
+
+	for _, dir := range s.BinDirs {
+		if !filepath.IsAbs(dir) {
+			return errors.Errorf("table %s has a relative bin_dir %s", s.Name, dir)
+		}
+	}
+
+	if _, err := s.dataFunc(); err != nil {
+		return err
+	}
+	if s.KVSeparator != "" && s.Parser != "kv" {
+		return errors.Errorf("table %s has a kv_separator, but isn't using the kv parser", s.Name)
+	}
+
+	seen := make(map[string]bool, len(s.Columns))
+	for _, column := range s.Columns {
+		if !specColumnRegexp.MatchString(column) {
+			return errors.Errorf("table %s has an invalid column name %q", s.Name, column)
+		}
+		if column == "parent" || column == "query" || column == "path" {
+			return errors.Errorf("table %s can't redefine the %s column", s.Name, column)
+		}
+		if seen[column] {
+			return errors.Errorf("table %s has a duplicate column %s", s.Name, column)
+		}
+		seen[column] = true
+	}
+
+	return nil
+}
+
+func (s TableSpec) dataFunc() (func([]byte, ...dataflatten.FlattenOpts) ([]dataflatten.Row, error), error) {
+	switch s.Parser {
+	case "json":
+		return dataflatten.Json, nil
+	case "xml":
+		return dataflatten.Xml, nil
+	case "ini":
+		return dataflatten.Ini, nil
+	case "plist":
+		return dataflatten.Plist, nil
+	case "yaml":
+		return dataflatten.Yaml, nil
+	case "toml":
+		return dataflatten.Toml, nil
+	case "hcl":
+		return dataflatten.Hcl, nil
+	case "kv":
+		separator := s.KVSeparator
+		if separator == "" {
+			separator = ":"
+		}
+		return dataflatten.StringDelimitedFunc(separator, dataflatten.DuplicateKeys), nil
+	default:
+		return nil, errors.Errorf("table %s has unknown parser %q", s.Name, s.Parser)
+	}
+}
+
+// TablePluginFromSpec returns the table a spec declares.
+func TablePluginFromSpec(client *osquery.ExtensionManagerClient, logger log.Logger, spec TableSpec) (*table.Plugin, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+	dataFunc, err := spec.dataFunc()
+	if err != nil {
+		return nil, err
+	}
+
+	t := &Table{
+		client:       client,
+		logger:       level.NewFilter(logger, level.AllowInfo()),
+		tableName:    spec.Name,
+		execDataFunc: dataFunc,
+		pivotColumns: spec.Columns,
+	}
+
+	var columns []table.ColumnDefinition
+	if len(spec.Columns) > 0 {
+		columns = append(columns, table.TextColumn("parent"), table.TextColumn("query"))
+		for _, column := range spec.Columns {
+			columns = append(columns, table.TextColumn(column))
+		}
+	} else {
+		columns = Columns()
+	}
+
+	if spec.Path != "" {
+		t.filePath = spec.Path
+		columns = append(columns, table.TextColumn("path"))
+		return table.NewPlugin(t.tableName, columns, t.generateFileSpec), nil
+	}
+
+	t.execArgs = spec.Exec
+	t.binDirs = spec.BinDirs
+	t.execCache = tablehelpers.NamedExecCache(t.tableName, time.Duration(spec.CacheTTLSeconds)*time.Second)
+	return table.NewPlugin(t.tableName, columns, t.generateExec), nil
+}
+
+// generateFileSpec returns the rows for each file matching the spec's
+// path.
+func (t *Table) generateFileSpec(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
+	var results []map[string]string
+
+	filePaths, err := filepath.Glob(t.filePath)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad glob")
+	}
+
+	for _, filePath := range filePaths {
+		contents, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			level.Info(t.logger).Log("msg", "failed to read file", "path", filePath, "err", err)
+			continue
+		}
+
+		rowData := map[string]string{"path": filePath}
+		for _, dataQuery := range tablehelpers.GetConstraints(queryContext, "query", tablehelpers.WithDefaults("")) {
+			results = append(results, t.getRowsFromOutput(dataQuery, contents, rowData)...)
+		}
+	}
+
+	return results, nil
+}
@@ -0,0 +1,169 @@
+package dataflattentable
+
+import (
+	"context"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/dataflatten"
+	"github.com/osquery/osquery-go/gen/osquery"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTableSpecValidate(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		spec TableSpec
+		err  bool
+	}{
+		{
+			name: "exec",
+			spec: TableSpec{Name: "kolide_lsblk", Exec: []string{"lsblk", "-J"}, BinDirs: []string{"/usr/bin", "/bin"}, Parser: "json"},
+		},
+		{
+			name: "kv exec",
+			spec: TableSpec{Name: "kolide_nmcli", Exec: []string{"/usr/bin/nmcli", "device"}, Parser: "kv", KVSeparator: ":", CacheTTLSeconds: 30},
+		},
+		{
+			name: "file with columns",
+			spec: TableSpec{Name: "kolide_animals", Path: "/tmp/*.json", Parser: "json", Columns: []string{"name", "id"}},
+		},
+		{
+			name: "bad name",
+			spec: TableSpec{Name: "processes", Path: "/tmp/a.json", Parser: "json"},
+			err:  true,
+		},
+		{
+			name: "no source",
+			spec: TableSpec{Name: "kolide_nothing", Parser: "json"},
+			err:  true,
+		},
+		{
+			name: "both sources",
+			spec: TableSpec{Name: "kolide_both", Exec: []string{"/bin/cat"}, Path: "/tmp/a.json", Parser: "json"},
+			err:  true,
+		},
+		{
+			name: "relative exec",
+			spec: TableSpec{Name: "kolide_relative", Exec: []string{"lsblk"}, Parser: "json"},
+			err:  true,
+		},
+		{
+			name: "path with bin dirs",
+			spec: TableSpec{Name: "kolide_bindir", Exec: []string{"/usr/bin/lsblk"}, BinDirs: []string{"/bin"}, Parser: "json"},
+			err:  true,
+		},
+		{
+			name: "unknown parser",
+			spec: TableSpec{Name: "kolide_csv", Path: "/tmp/a.csv", Parser: "csv"},
+			err:  true,
+		},
+		{
+			name: "separator without kv",
+			spec: TableSpec{Name: "kolide_sep", Path: "/tmp/a.json", Parser: "json", KVSeparator: "="},
+			err:  true,
+		},
+		{
+			name: "cached file",
+			spec: TableSpec{Name: "kolide_cached", Path: "/tmp/a.json", Parser: "json", CacheTTLSeconds: 10},
+			err:  true,
+		},
+		{
+			name: "reserved column",
+			spec: TableSpec{Name: "kolide_reserved", Path: "/tmp/a.json", Parser: "json", Columns: []string{"path"}},
+			err:  true,
+		},
+		{
+			name: "duplicate column",
+			spec: TableSpec{Name: "kolide_dupe", Path: "/tmp/a.json", Parser: "json", Columns: []string{"id", "id"}},
+			err:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.spec.Validate()
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestTablePluginFromSpec(t *testing.T) {
+	t.Parallel()
+
+	testFile := filepath.Join("testdata", "animals.json")
+
+	var tests = []struct {
+		name     string
+		spec     TableSpec
+		expected []map[string]string
+	}{
+		{
+			name: "flattened",
+			spec: TableSpec{Name: "kolide_test_flat", Path: testFile, Parser: "json"},
+			expected: []map[string]string{
+				{"fullkey": "metadata/testing", "parent": "metadata", "key": "testing", "value": "true", "type": "bool", "value_integer": "", "value_float": "", "query": "metadata", "path": testFile},
+				{"fullkey": "metadata/version", "parent": "metadata", "key": "version", "value": "1.0.1", "type": "string", "value_integer": "", "value_float": "", "query": "metadata", "path": testFile},
+			},
+		},
+		{
+			name: "pivoted",
+			spec: TableSpec{Name: "kolide_test_pivot", Path: testFile, Parser: "json", Columns: []string{"testing", "version"}},
+			expected: []map[string]string{
+				{"parent": "metadata", "testing": "true", "version": "1.0.1", "query": "metadata", "path": testFile},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			plugin, err := TablePluginFromSpec(nil, log.NewNopLogger(), tt.spec)
+			require.NoError(t, err)
+			require.Equal(t, tt.spec.Name, plugin.Name())
+
+			resp := plugin.Call(context.TODO(), osquery.ExtensionPluginRequest{
+				"action":  "generate",
+				"context": `{"constraints":[{"name":"query","list":[{"op":2,"expr":"metadata"}]}]}`,
+			})
+			require.Equal(t, int32(0), resp.Status.Code, resp.Status.Message)
+
+			rows := []map[string]string(resp.Response)
+			sort.SliceStable(rows, func(i, j int) bool { return rows[i]["fullkey"] < rows[j]["fullkey"] })
+			require.Equal(t, tt.expected, rows)
+		})
+	}
+
+	_, err := TablePluginFromSpec(nil, log.NewNopLogger(), TableSpec{Name: "kolide_invalid", Parser: "json"})
+	require.Error(t, err)
+}
+
+func TestToPivotMap(t *testing.T) {
+	t.Parallel()
+
+	rows := []dataflatten.Row{
+		dataflatten.NewRow([]string{"0", "name"}, "sda"),
+		dataflatten.NewRow([]string{"0", "size"}, "10G"),
+		dataflatten.NewRow([]string{"0", "type"}, "disk"),
+		dataflatten.NewRow([]string{"1", "name"}, "sdb"),
+		dataflatten.NewRow([]string{"2", "type"}, "rom"),
+	}
+
+	require.Equal(t, []map[string]string{
+		{"parent": "0", "query": "", "name": "sda", "size": "10G", "extra": "x"},
+		{"parent": "1", "query": "", "name": "sdb", "extra": "x"},
+	}, ToPivotMap(rows, "", []string{"name", "size"}, map[string]string{"extra": "x"}))
+}
@@ -2,6 +2,7 @@ package dataflattentable
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"strings"
 
@@ -23,6 +24,18 @@ const (
 	XmlType
 	IniType
 	KeyValueType
+	YamlType
+	TomlType
+	HclType
+)
+
+const (
+	// streamingThreshold is the size above which files are streamed,
+	// rather than read into memory whole, by tables that can.
+	streamingThreshold = 16 << 20
+
+	// maxStreamingBytes is the most a streamed file may hold.
+	maxStreamingBytes = 1 << 30
 )
 
 type Table struct {
@@ -32,9 +45,20 @@ type Table struct {
 
 	dataFunc func(string, ...dataflatten.FlattenOpts) ([]dataflatten.Row, error)
 
+	// streamFunc, if set, flattens files larger than
+	// streamingThreshold instead of dataFunc. It emits rows as they're
+	// flattened, so they needn't all be held at once.
+	streamFunc func(string, func(dataflatten.Row) error, ...dataflatten.FlattenOpts) error
+
 	execDataFunc func([]byte, ...dataflatten.FlattenOpts) ([]dataflatten.Row, error)
 	execArgs     []string
 	binDirs      []string
+	execCache    *tablehelpers.ExecCache
+
+	// filePath and pivotColumns are set for tables declared by a
+	// TableSpec.
+	filePath     string
+	pivotColumns []string
 
 	keyValueSeparator string
 }
@@ -46,12 +70,15 @@ func AllTablePlugins(client *osquery.ExtensionManagerClient, logger log.Logger)
 		TablePlugin(client, logger, XmlType),
 		TablePlugin(client, logger, IniType),
 		TablePlugin(client, logger, PlistType),
+		TablePlugin(client, logger, YamlType),
+		TablePlugin(client, logger, TomlType),
+		TablePlugin(client, logger, HclType),
 	}
 }
 
 func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger, dataSourceType DataSourceType) osquery.OsqueryPlugin {
 
-	columns := Columns(table.TextColumn("path"))
+	columns := Columns(table.TextColumn("path"), table.TextColumn("query_language"))
 
 	t := &Table{
 		client: client,
@@ -64,13 +91,24 @@ func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger, data
 		t.tableName = "kolide_plist"
 	case JsonType:
 		t.dataFunc = dataflatten.JsonFile
+		t.streamFunc = dataflatten.JsonFileStreamRows
 		t.tableName = "kolide_json"
 	case XmlType:
 		t.dataFunc = dataflatten.XmlFile
+		t.streamFunc = dataflatten.XmlFileStreamRows
 		t.tableName = "kolide_xml"
 	case IniType:
 		t.dataFunc = dataflatten.IniFile
 		t.tableName = "kolide_ini"
+	case YamlType:
+		t.dataFunc = dataflatten.YamlFile
+		t.tableName = "kolide_yaml"
+	case TomlType:
+		t.dataFunc = dataflatten.TomlFile
+		t.tableName = "kolide_toml"
+	case HclType:
+		t.dataFunc = dataflatten.HclFile
+		t.tableName = "kolide_hcl"
 	default:
 		panic("Unknown data source type")
 	}
@@ -79,6 +117,12 @@ func TablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger, data
 
 }
 
+// shouldStream returns whether file is larger than streamingThreshold.
+func shouldStream(file string) bool {
+	info, err := os.Stat(file)
+	return err == nil && info.Size() > streamingThreshold
+}
+
 func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
 	var results []map[string]string
 
@@ -87,6 +131,14 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 		return results, errors.Errorf("The %s table requires that you specify a single constraint for path", t.tableName)
 	}
 
+	var languages []dataflatten.QueryLanguage
+	for _, language := range tablehelpers.GetConstraints(queryContext, "query_language", tablehelpers.WithDefaults(string(dataflatten.QueryLanguageDataflatten))) {
+		if !dataflatten.QueryLanguage(language).Valid() {
+			return results, errors.Errorf("The %s table doesn't support the query language %q", t.tableName, language)
+		}
+		languages = append(languages, dataflatten.QueryLanguage(language))
+	}
+
 	for _, requestedPath := range requestedPaths {
 
 		// We take globs in via the sql %, but glob needs *. So convert.
@@ -96,29 +148,58 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 		}
 
 		for _, filePath := range filePaths {
-			for _, dataQuery := range tablehelpers.GetConstraints(queryContext, "query", tablehelpers.WithDefaults("*")) {
-				subresults, err := t.generatePath(filePath, dataQuery)
-				if err != nil {
-					level.Info(t.logger).Log(
-						"msg", "failed to get data for path",
-						"path", filePath,
-						"err", err,
-					)
-					continue
+			for _, language := range languages {
+				for _, dataQuery := range tablehelpers.GetConstraints(queryContext, "query", tablehelpers.WithDefaults(defaultQuery(language))) {
+					subresults, err := t.generatePath(filePath, language, dataQuery)
+					if err != nil {
+						level.Info(t.logger).Log(
+							"msg", "failed to get data for path",
+							"path", filePath,
+							"err", err,
+						)
+						continue
+					}
+
+					results = append(results, subresults...)
 				}
-
-				results = append(results, subresults...)
 			}
 		}
 	}
 	return results, nil
 }
 
-func (t *Table) generatePath(filePath string, dataQuery string) ([]map[string]string, error) {
+// defaultQuery is the query that returns everything, in a language.
+func defaultQuery(language dataflatten.QueryLanguage) string {
+	if language == dataflatten.QueryLanguageJmespath {
+		return "@"
+	}
+	return "*"
+}
+
+func (t *Table) generatePath(filePath string, language dataflatten.QueryLanguage, dataQuery string) ([]map[string]string, error) {
 	flattenOpts := []dataflatten.FlattenOpts{
 		dataflatten.WithLogger(t.logger),
 		dataflatten.WithNestedPlist(),
-		dataflatten.WithQuery(strings.Split(dataQuery, "/")),
+		dataflatten.WithTypes(),
+		dataflatten.WithQueryLanguage(language, dataQuery),
+	}
+
+	rowData := map[string]string{
+		"path":           filePath,
+		"query_language": string(language),
+	}
+
+	if t.streamFunc != nil && shouldStream(filePath) {
+		var results []map[string]string
+		err := t.streamFunc(filePath, func(row dataflatten.Row) error {
+			results = append(results, rowToMap(row, dataQuery, rowData))
+			return nil
+		}, append(flattenOpts, dataflatten.WithMaxBytes(maxStreamingBytes))...)
+		if err != nil {
+			level.Info(t.logger).Log("msg", "failure streaming file", "file", filePath)
+			return nil, errors.Wrap(err, "streaming data")
+		}
+		return results, nil
 	}
 
 	data, err := t.dataFunc(filePath, flattenOpts...)
@@ -127,9 +208,5 @@ func (t *Table) generatePath(filePath string, dataQuery string) ([]map[string]st
 		return nil, errors.Wrap(err, "parsing data")
 	}
 
-	rowData := map[string]string{
-		"path": filePath,
-	}
-
 	return ToMap(data, dataQuery, rowData), nil
 }
@@ -3,9 +3,12 @@ package dataflattentable
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
+	"os"
 	"path"
 	"path/filepath"
 	"sort"
+	"strings"
 	"testing"
 
 	"github.com/go-kit/kit/log"
@@ -27,6 +30,8 @@ func TestDataFlattenTablePlist_Animals(t *testing.T) {
 		"plist": Table{logger: logger, dataFunc: dataflatten.PlistFile},
 		"xml":   Table{logger: logger, dataFunc: dataflatten.PlistFile},
 		"json":  Table{logger: logger, dataFunc: dataflatten.JsonFile},
+		"yaml":  Table{logger: logger, dataFunc: dataflatten.YamlFile},
+		"toml":  Table{logger: logger, dataFunc: dataflatten.TomlFile},
 	}
 
 	var tests = []struct {
@@ -66,10 +71,14 @@ func TestDataFlattenTablePlist_Animals(t *testing.T) {
 
 			require.NoError(t, err)
 
-			// delete the path and query keys, so we don't need to enumerate them in the test case
+			// delete the path, query, and type keys, so we don't need to enumerate them in the test case
 			for _, row := range rows {
 				delete(row, "path")
 				delete(row, "query")
+				delete(row, "query_language")
+				delete(row, "type")
+				delete(row, "value_integer")
+				delete(row, "value_float")
 			}
 
 			// Despite being an array. data is returned unordered. Sort it.
@@ -82,6 +91,80 @@ func TestDataFlattenTablePlist_Animals(t *testing.T) {
 
 }
 
+func TestDataFlattenTable_QueryLanguage(t *testing.T) {
+	t.Parallel()
+
+	testTable := Table{logger: log.NewNopLogger(), tableName: "kolide_json", dataFunc: dataflatten.JsonFile}
+	testFile := filepath.Join("testdata", "animals.json")
+
+	var tests = []struct {
+		name     string
+		language []string
+		queries  []string
+		expected []string
+		err      bool
+	}{
+		{
+			name:     "default language",
+			queries:  []string{"users/name=>*Bobcat/id"},
+			expected: []string{"users/1/id=2"},
+		},
+		{
+			name:     "jmespath comparison",
+			language: []string{"jmespath"},
+			queries:  []string{"users[?id > `1`].name"},
+			expected: []string{"0=Bailey Bobcat", "1=Cam Chipmunk"},
+		},
+		{
+			name:     "jmespath infix and predicates",
+			language: []string{"jmespath"},
+			queries:  []string{"users[?contains(name, 'a') && length(favorites) > `1`].uuid"},
+			expected: []string{"0=def456"},
+		},
+		{
+			name:     "jmespath without query",
+			language: []string{"jmespath"},
+			expected: []string{"metadata/testing=true", "metadata/version=1.0.1"},
+		},
+		{
+			name:     "unknown language",
+			language: []string{"xpath"},
+			queries:  []string{"//users"},
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mockQC := tablehelpers.MockQueryContext(map[string][]string{
+				"path":           []string{testFile},
+				"query":          tt.queries,
+				"query_language": tt.language,
+			})
+
+			rows, err := testTable.generate(context.TODO(), mockQC)
+			if tt.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var actual []string
+			for _, row := range rows {
+				if len(tt.queries) == 0 && !strings.HasPrefix(row["fullkey"], "metadata/") {
+					continue
+				}
+				actual = append(actual, row["fullkey"]+"="+row["value"])
+			}
+			sort.Strings(actual)
+			require.Equal(t, tt.expected, actual)
+		})
+	}
+}
+
 func TestDataFlattenTables(t *testing.T) {
 	t.Parallel()
 
@@ -131,6 +214,19 @@ func TestDataFlattenTables(t *testing.T) {
 			queries:      []string{"this/does/not/exist"},
 			expectNoData: true,
 		},
+
+		// hcl
+		{
+			testTables:   map[string]Table{"hcl": Table{logger: logger, dataFunc: dataflatten.HclFile}},
+			testFile:     path.Join("testdata", "animals.hcl"),
+			expectedRows: 13,
+		},
+		{
+			testTables:   map[string]Table{"hcl": Table{logger: logger, dataFunc: dataflatten.HclFile}},
+			testFile:     path.Join("testdata", "animals.hcl"),
+			queries:      []string{"user/*/def456"},
+			expectedRows: 4,
+		},
 	}
 
 	for testN, tt := range tests {
@@ -160,3 +256,41 @@ func TestDataFlattenTables(t *testing.T) {
 	}
 
 }
+
+func TestStreamLargeFiles(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.json")
+	large := filepath.Join(dir, "large.json")
+	require.NoError(t, ioutil.WriteFile(small, []byte("{}"), 0644))
+	require.NoError(t, ioutil.WriteFile(large, nil, 0644))
+	require.NoError(t, os.Truncate(large, streamingThreshold+1))
+
+	testTable := Table{
+		logger: log.NewNopLogger(),
+		dataFunc: func(string, ...dataflatten.FlattenOpts) ([]dataflatten.Row, error) {
+			return []dataflatten.Row{dataflatten.NewRow([]string{"read"}, "1")}, nil
+		},
+		streamFunc: func(_ string, emit func(dataflatten.Row) error, _ ...dataflatten.FlattenOpts) error {
+			for _, key := range []string{"a", "b"} {
+				if err := emit(dataflatten.NewRow([]string{"stream", key}, "1")); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+
+	rows, err := testTable.generatePath(small, dataflatten.QueryLanguageDataflatten, "*")
+	require.NoError(t, err)
+	require.Len(t, rows, 1)
+	require.Equal(t, "read", rows[0]["fullkey"])
+
+	rows, err = testTable.generatePath(large, dataflatten.QueryLanguageDataflatten, "*")
+	require.NoError(t, err)
+	require.Len(t, rows, 2)
+	require.Equal(t, "stream/a", rows[0]["fullkey"])
+	require.Equal(t, "stream/b", rows[1]["fullkey"])
+	require.Equal(t, large, rows[1]["path"])
+}
@@ -0,0 +1,24 @@
+system = "users demo"
+
+metadata {
+  testing = true
+  version = "1.0.1"
+}
+
+user "abc123" {
+  favorites = ["ants"]
+  name      = "Alex Aardvark"
+  id        = 1
+}
+
+user "def456" {
+  favorites = ["mice", "birds"]
+  name      = "Bailey Bobcat"
+  id        = 2
+}
+
+user "ghi789" {
+  favorites = ["seeds"]
+  name      = "Cam Chipmunk"
+  id        = 3
+}
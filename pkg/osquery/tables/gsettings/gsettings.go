@@ -38,9 +38,32 @@ type GsettingsValues struct {
 	getBytes gsettingsExecer
 }
 
+// Opt configures the gsettings tables
+type Opt func(*tableOpts)
+
+type tableOpts struct {
+	cacheTTL time.Duration
+}
+
+// WithCacheTTL caches gsettings' output for ttl, so frequent queries
+// don't re-run it.
+func WithCacheTTL(ttl time.Duration) Opt {
+	return func(o *tableOpts) {
+		o.cacheTTL = ttl
+	}
+}
+
+func applyOpts(opts []Opt) tableOpts {
+	var o tableOpts
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
 // Settings returns a table plugin for querying setting values from the
 // gsettings command.
-func Settings(client *osquery.ExtensionManagerClient, logger log.Logger) *table.Plugin {
+func Settings(client *osquery.ExtensionManagerClient, logger log.Logger, opts ...Opt) *table.Plugin {
 	columns := []table.ColumnDefinition{
 		table.TextColumn("schema"),
 		table.TextColumn("key"),
@@ -54,6 +77,21 @@ func Settings(client *osquery.ExtensionManagerClient, logger log.Logger) *table.
 		getBytes: execGsettings,
 	}
 
+	if cache := tablehelpers.NamedExecCache("kolide_gsettings", applyOpts(opts).cacheTTL); cache != nil {
+		t.getBytes = func(ctx context.Context, username string, buf *bytes.Buffer) error {
+			output, err := cache.Cached([]string{gsettingsPath}, []string{username, "list-recursively"}, func() ([]byte, error) {
+				var output bytes.Buffer
+				err := execGsettings(ctx, username, &output)
+				return output.Bytes(), err
+			})
+			if err != nil {
+				return err
+			}
+			_, err = buf.Write(output)
+			return err
+		}
+	}
+
 	return table.NewPlugin("kolide_gsettings", columns, t.generate)
 }
 
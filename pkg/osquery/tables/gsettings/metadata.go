@@ -30,7 +30,7 @@ type GsettingsMetadata struct {
 
 // Metadata returns a table plugin for querying metadata about specific keys in
 // specific schemas
-func Metadata(client *osquery.ExtensionManagerClient, logger log.Logger) *table.Plugin {
+func Metadata(client *osquery.ExtensionManagerClient, logger log.Logger, opts ...Opt) *table.Plugin {
 
 	columns := []table.ColumnDefinition{
 		// TODO: maybe need to add 'path' for relocatable schemas..
@@ -46,6 +46,23 @@ func Metadata(client *osquery.ExtensionManagerClient, logger log.Logger) *table.
 		cmdRunner: execGsettingsCommand,
 	}
 
+	// The temporary directory is only the command's working directory,
+	// so it's left out of the cache key.
+	if cache := tablehelpers.NamedExecCache("kolide_gsettings_metadata", applyOpts(opts).cacheTTL); cache != nil {
+		t.cmdRunner = func(ctx context.Context, args []string, tmpdir string, output *bytes.Buffer) error {
+			out, err := cache.Cached([]string{gsettingsPath}, args, func() ([]byte, error) {
+				var out bytes.Buffer
+				err := execGsettingsCommand(ctx, args, tmpdir, &out)
+				return out.Bytes(), err
+			})
+			if err != nil {
+				return err
+			}
+			_, err = output.Write(out)
+			return err
+		}
+	}
+
 	return table.NewPlugin("kolide_gsettings_metadata", columns, t.generate)
 }
 
@@ -2,6 +2,7 @@ package osquery_instance_history
 
 import (
 	"context"
+	"strconv"
 
 	"github.com/kolide/launcher/pkg/osquery/runtime/history"
 	"github.com/osquery/osquery-go/plugin/table"
@@ -9,6 +10,7 @@ import (
 
 func TablePlugin() *table.Plugin {
 	columns := []table.ColumnDefinition{
+		table.TextColumn("name"),
 		table.TextColumn("start_time"),
 		table.TextColumn("connect_time"),
 		table.TextColumn("exit_time"),
@@ -16,6 +18,12 @@ func TablePlugin() *table.Plugin {
 		table.TextColumn("instance_id"),
 		table.TextColumn("version"),
 		table.TextColumn("errors"),
+		table.TextColumn("exit_code"),
+		table.TextColumn("exit_signal"),
+		table.TextColumn("restart_trigger"),
+		table.TextColumn("restart_reason"),
+		table.BigIntColumn("peak_memory_bytes"),
+		table.BigIntColumn("uptime_seconds"),
 	}
 	return table.NewPlugin("kolide_launcher_osquery_instance_history", columns, generate())
 }
@@ -29,16 +37,42 @@ func generate() table.GenerateFunc {
 			return nil, err
 		}
 
-		for _, instance := range history {
+		// Only the latest run of each osquery instance can still be
+		// running.
+		latest := make(map[string]int)
+		for i, instance := range history {
+			latest[instance.Name] = i
+		}
+
+		for i, instance := range history {
+			var exitCode string
+			if instance.ExitCode != nil {
+				exitCode = strconv.Itoa(*instance.ExitCode)
+			}
+
+			// Earlier runs without an exit time stopped when launcher did.
+			var uptime string
+			if instance.ExitTime != "" || i == latest[instance.Name] {
+				if d, err := instance.Uptime(); err == nil {
+					uptime = strconv.FormatInt(int64(d.Seconds()), 10)
+				}
+			}
 
 			results = append(results, map[string]string{
-				"start_time":   instance.StartTime,
-				"connect_time": instance.ConnectTime,
-				"exit_time":    instance.ExitTime,
-				"instance_id":  instance.InstanceId,
-				"version":      instance.Version,
-				"hostname":     instance.Hostname,
-				"errors":       instance.Error,
+				"name":              instance.Name,
+				"start_time":        instance.StartTime,
+				"connect_time":      instance.ConnectTime,
+				"exit_time":         instance.ExitTime,
+				"instance_id":       instance.InstanceId,
+				"version":           instance.Version,
+				"hostname":          instance.Hostname,
+				"errors":            instance.Error,
+				"exit_code":         exitCode,
+				"exit_signal":       instance.ExitSignal,
+				"restart_trigger":   string(instance.RestartTrigger),
+				"restart_reason":    instance.RestartReason,
+				"peak_memory_bytes": strconv.FormatUint(instance.PeakMemoryBytes, 10),
+				"uptime_seconds":    uptime,
 			})
 		}
 
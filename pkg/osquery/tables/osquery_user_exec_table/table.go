@@ -19,6 +19,8 @@ package osquery_user_exec_table
 
 import (
 	"context"
+	"encoding/json"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/kolide/launcher/pkg/osquery/tables/tablehelpers"
@@ -37,11 +39,23 @@ type Table struct {
 	osqueryd  string
 	query     string
 	tablename string
+	execCache *tablehelpers.ExecCache
+}
+
+type Opt func(*Table)
+
+// WithCacheTTL caches each user's results for ttl, so frequent queries
+// don't re-run osqueryd.
+func WithCacheTTL(ttl time.Duration) Opt {
+	return func(t *Table) {
+		t.execCache = tablehelpers.NamedExecCache(t.tablename, ttl)
+	}
 }
 
 func TablePlugin(
 	client *osquery.ExtensionManagerClient, logger log.Logger,
 	tablename string, osqueryd string, osqueryQuery string, columns []table.ColumnDefinition,
+	opts ...Opt,
 ) *table.Plugin {
 
 	columns = append(columns, table.TextColumn("user"))
@@ -54,6 +68,10 @@ func TablePlugin(
 		tablename: tablename,
 	}
 
+	for _, opt := range opts {
+		opt(t)
+	}
+
 	return table.NewPlugin(t.tablename, columns, t.generate)
 
 }
@@ -70,7 +88,7 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 	}
 
 	for _, user := range users {
-		osqueryResults, err := tablehelpers.ExecOsqueryLaunchctlParsed(ctx, t.logger, 5, user, t.osqueryd, t.query)
+		osqueryResults, err := t.execOsquery(ctx, user)
 		if err != nil {
 			continue
 		}
@@ -82,3 +100,25 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 	}
 	return results, nil
 }
+
+// execOsquery runs the query as user, through the cache. The parsed
+// results are cached, re-encoded, so that only output which parses is
+// kept.
+func (t *Table) execOsquery(ctx context.Context, user string) ([]map[string]string, error) {
+	output, err := t.execCache.Cached([]string{t.osqueryd}, []string{user, t.query}, func() ([]byte, error) {
+		results, err := tablehelpers.ExecOsqueryLaunchctlParsed(ctx, t.logger, 5, user, t.osqueryd, t.query)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(results)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var results []map[string]string
+	if err := json.Unmarshal(output, &results); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling cached results")
+	}
+	return results, nil
+}
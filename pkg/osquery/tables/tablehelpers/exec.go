@@ -12,6 +12,21 @@ import (
 	"github.com/pkg/errors"
 )
 
+type execOptions struct {
+	root bool
+}
+
+// ExecOps are options for Exec.
+type ExecOps func(*execOptions)
+
+// WithRoot marks a command as needing root, so a Sandbox won't drop its
+// privileges.
+func WithRoot() ExecOps {
+	return func(eo *execOptions) {
+		eo.root = true
+	}
+}
+
 // Exec is a wrapper over exec.CommandContext. It does a couple of
 // additional things to help with table usage:
 // 1. It enforces a timeout.
@@ -20,7 +35,16 @@ import (
 // 3. It moves the stderr into the return error, if needed.
 //
 // This is not suitable for high performance work -- it allocates new buffers each time.
-func Exec(ctx context.Context, logger log.Logger, timeoutSeconds int, possibleBins []string, args []string) ([]byte, error) {
+//
+// If a Sandbox has been set, the command is run in it.
+func Exec(ctx context.Context, logger log.Logger, timeoutSeconds int, possibleBins []string, args []string, opts ...ExecOps) ([]byte, error) {
+	var options execOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	sandbox := currentSandbox()
+
 	ctx, cancel := context.WithTimeout(ctx, time.Duration(timeoutSeconds)*time.Second)
 	defer cancel()
 
@@ -40,7 +64,14 @@ func Exec(ctx context.Context, logger log.Logger, timeoutSeconds int, possibleBi
 			"cmd", cmd.String(),
 		)
 
-		switch err := cmd.Run(); {
+		var err error
+		if sandbox != nil {
+			err = sandbox.run(ctx, cmd, options)
+		} else {
+			err = cmd.Run()
+		}
+
+		switch {
 		case err == nil:
 			return stdout.Bytes(), nil
 		case os.IsNotExist(err):
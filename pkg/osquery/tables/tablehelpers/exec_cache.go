@@ -0,0 +1,158 @@
+package tablehelpers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+var (
+	execCachesLock sync.Mutex
+	execCaches     = make(map[string]*ExecCache)
+)
+
+// ExecCache caches command output for a table, so frequent queries don't
+// re-run the same command each time. Output is keyed by the possible
+// binaries and the arguments, which include any constraints the table
+// passed along. Only successful output is cached.
+//
+// A nil *ExecCache caches nothing, so tables can hold one unconditionally.
+type ExecCache struct {
+	ttl     time.Duration
+	lock    sync.Mutex
+	entries map[string]execCacheEntry
+	hits    uint64
+	misses  uint64
+}
+
+type execCacheEntry struct {
+	output  []byte
+	expires time.Time
+}
+
+// ExecCacheStats are the counters for a single ExecCache.
+type ExecCacheStats struct {
+	Name    string
+	Hits    uint64
+	Misses  uint64
+	Entries int
+}
+
+// NamedExecCache returns the cache registered under name, usually the
+// table name, creating it if needed. Tables are recreated whenever
+// osqueryd restarts, so sharing caches by name keeps their counters and
+// contents across restarts. A ttl of zero disables caching.
+func NamedExecCache(name string, ttl time.Duration) *ExecCache {
+	if ttl <= 0 {
+		return nil
+	}
+
+	execCachesLock.Lock()
+	defer execCachesLock.Unlock()
+
+	cache, ok := execCaches[name]
+	if !ok {
+		cache = &ExecCache{entries: make(map[string]execCacheEntry)}
+		execCaches[name] = cache
+	}
+
+	cache.lock.Lock()
+	cache.ttl = ttl
+	cache.lock.Unlock()
+
+	return cache
+}
+
+// AllExecCacheStats returns the counters of every named cache, sorted by
+// name.
+func AllExecCacheStats() []ExecCacheStats {
+	execCachesLock.Lock()
+	defer execCachesLock.Unlock()
+
+	stats := make([]ExecCacheStats, 0, len(execCaches))
+	for name, cache := range execCaches {
+		cache.lock.Lock()
+		entries := len(cache.entries)
+		cache.lock.Unlock()
+
+		stats = append(stats, ExecCacheStats{
+			Name:    name,
+			Hits:    atomic.LoadUint64(&cache.hits),
+			Misses:  atomic.LoadUint64(&cache.misses),
+			Entries: entries,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Name < stats[j].Name })
+	return stats
+}
+
+// Exec runs a command like the package level Exec, but returns cached
+// output when there is some.
+func (c *ExecCache) Exec(ctx context.Context, logger log.Logger, timeoutSeconds int, possibleBins []string, args []string, opts ...ExecOps) ([]byte, error) {
+	return c.Cached(possibleBins, args, func() ([]byte, error) {
+		return Exec(ctx, logger, timeoutSeconds, possibleBins, args, opts...)
+	})
+}
+
+// Cached returns the cached output for possibleBins and args, if there's
+// any, and otherwise calls exec and caches its output. It's for tables
+// which run commands without Exec.
+func (c *ExecCache) Cached(possibleBins []string, args []string, exec func() ([]byte, error)) ([]byte, error) {
+	if c == nil {
+		return exec()
+	}
+
+	key := execCacheKey(possibleBins, args)
+	if output, ok := c.get(key, time.Now()); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return output, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	output, err := exec()
+	if err != nil {
+		return nil, err
+	}
+	c.set(key, output, time.Now())
+	return output, nil
+}
+
+func (c *ExecCache) get(key string, now time.Time) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok || now.After(entry.expires) {
+		return nil, false
+	}
+	return entry.output, true
+}
+
+func (c *ExecCache) set(key string, output []byte, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// Constraints vary between queries, so drop expired entries
+	// rather than letting them pile up.
+	for k, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, k)
+		}
+	}
+
+	c.entries[key] = execCacheEntry{
+		output:  output,
+		expires: now.Add(c.ttl),
+	}
+}
+
+// execCacheKey joins the binaries and arguments with a separator that
+// can't appear in either.
+func execCacheKey(possibleBins []string, args []string) string {
+	return strings.Join(possibleBins, "\x00") + "\x01" + strings.Join(args, "\x00")
+}
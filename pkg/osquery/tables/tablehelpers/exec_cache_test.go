@@ -0,0 +1,83 @@
+package tablehelpers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExecCache(t *testing.T) {
+	t.Parallel()
+
+	cache := NamedExecCache("test_exec_cache", time.Hour)
+	require.NotNil(t, cache)
+
+	var runs int
+	exec := func() ([]byte, error) {
+		runs++
+		return []byte("output"), nil
+	}
+
+	for i := 0; i < 3; i++ {
+		output, err := cache.Cached([]string{"/bin/true"}, []string{"a"}, exec)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("output"), output)
+	}
+	assert.Equal(t, 1, runs, "repeated calls should be cached")
+
+	// Different arguments are cached separately
+	_, err := cache.Cached([]string{"/bin/true"}, []string{"b"}, exec)
+	require.NoError(t, err)
+	assert.Equal(t, 2, runs)
+
+	// Errors aren't cached
+	failures := 0
+	fail := func() ([]byte, error) {
+		failures++
+		return nil, errors.New("failed")
+	}
+	for i := 0; i < 2; i++ {
+		_, err := cache.Cached([]string{"/bin/false"}, nil, fail)
+		require.Error(t, err)
+	}
+	assert.Equal(t, 2, failures)
+
+	// Expired entries are run again
+	cache.set(execCacheKey([]string{"/bin/true"}, []string{"a"}), []byte("stale"), time.Now().Add(-2*time.Hour))
+	output, err := cache.Cached([]string{"/bin/true"}, []string{"a"}, exec)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("output"), output)
+	assert.Equal(t, 3, runs)
+
+	// The cache is shared by name, along with its counters
+	require.Same(t, cache, NamedExecCache("test_exec_cache", time.Minute))
+	var found bool
+	for _, stats := range AllExecCacheStats() {
+		if stats.Name == "test_exec_cache" {
+			found = true
+			assert.Equal(t, uint64(2), stats.Hits)
+			assert.Equal(t, uint64(5), stats.Misses)
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestExecCacheDisabled(t *testing.T) {
+	t.Parallel()
+
+	cache := NamedExecCache("test_exec_cache_disabled", 0)
+	require.Nil(t, cache)
+
+	var runs int
+	for i := 0; i < 2; i++ {
+		_, err := cache.Cached([]string{"/bin/true"}, nil, func() ([]byte, error) {
+			runs++
+			return nil, nil
+		})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 2, runs)
+}
@@ -0,0 +1,54 @@
+package tablehelpers
+
+import "sync"
+
+var (
+	sandboxLock sync.RWMutex
+	sandbox     *Sandbox
+)
+
+// Sandbox hardens the commands run by Exec, so a misbehaving helper
+// binary can't exhaust the host. It's only enforced on Linux. Commands
+// are run with a cleared environment, in a process group of their own
+// which is killed on timeout. A zero limit is not enforced.
+type Sandbox struct {
+	// User is who commands are run as, unless they need root. It's
+	// only used when launcher runs as root. Empty leaves commands
+	// running as launcher's user.
+	User string
+	// MaxCPUSeconds is the CPU time a command may use.
+	MaxCPUSeconds uint64
+	// MaxMemoryBytes is the address space a command may use.
+	MaxMemoryBytes uint64
+	// MaxOpenFiles is how many files a command may have open.
+	MaxOpenFiles uint64
+	// MaxOutputBytes is how much a command may write to each of stdout
+	// and stderr. A command that writes more is killed.
+	MaxOutputBytes int
+}
+
+// DefaultSandbox returns a Sandbox with limits generous enough for the
+// commands launcher's tables run, which drops privileges to user.
+func DefaultSandbox(user string) Sandbox {
+	return Sandbox{
+		User:           user,
+		MaxCPUSeconds:  60,
+		MaxMemoryBytes: 2 << 30,
+		MaxOpenFiles:   256,
+		MaxOutputBytes: 64 << 20,
+	}
+}
+
+// SetSandbox has Exec run commands in sandbox. A nil sandbox turns the
+// sandbox off.
+func SetSandbox(s *Sandbox) {
+	sandboxLock.Lock()
+	defer sandboxLock.Unlock()
+	sandbox = s
+}
+
+func currentSandbox() *Sandbox {
+	sandboxLock.RLock()
+	defer sandboxLock.RUnlock()
+	return sandbox
+}
@@ -0,0 +1,157 @@
+//go:build linux
+// +build linux
+
+package tablehelpers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// sandboxPath replaces the environment of sandboxed commands, so they
+// can still find the binaries they run themselves.
+const sandboxPath = "PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// sandboxShell is the shell used to set rlimits for sandboxed commands.
+const sandboxShell = "/bin/sh"
+
+// run runs cmd in the sandbox, and waits for it to exit.
+func (s *Sandbox) run(ctx context.Context, cmd *exec.Cmd, options execOptions) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stdout := &limitedWriter{w: cmd.Stdout, limit: s.MaxOutputBytes, onExceeded: cancel}
+	stderr := &limitedWriter{w: cmd.Stderr, limit: s.MaxOutputBytes, onExceeded: cancel}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = []string{sandboxPath}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if !options.root && s.User != "" && os.Geteuid() == 0 {
+		credential, err := lookupCredential(s.User)
+		if err != nil {
+			return errors.Wrapf(err, "looking up sandbox user %s", s.User)
+		}
+		cmd.SysProcAttr.Credential = credential
+	}
+
+	// Check for the binary before it's wrapped in a shell, so Exec can
+	// still tell a missing binary from one that failed.
+	if _, err := os.Stat(cmd.Path); err != nil {
+		return err
+	}
+	s.setLimits(cmd)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// exec.CommandContext only kills the command itself, so kill the
+	// whole group, including anything it started, when the context
+	// is done.
+	exited := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			killProcessGroup(cmd)
+		case <-exited:
+		}
+	}()
+
+	err := cmd.Wait()
+	close(exited)
+
+	if stdout.exceeded || stderr.exceeded {
+		return errors.Errorf("output exceeded limit of %d bytes", s.MaxOutputBytes)
+	}
+	return err
+}
+
+// setLimits wraps cmd in a shell which sets the sandbox's rlimits, then
+// execs the command in its place. exec.Cmd has no way to set them in the
+// child, and setting them once the command has started is too late for
+// commands that are quick to misbehave.
+func (s *Sandbox) setLimits(cmd *exec.Cmd) {
+	var script []string
+	if s.MaxCPUSeconds > 0 {
+		script = append(script, fmt.Sprintf("ulimit -t %d", s.MaxCPUSeconds))
+	}
+	if s.MaxMemoryBytes > 0 {
+		// ulimit takes kilobytes
+		script = append(script, fmt.Sprintf("ulimit -v %d", s.MaxMemoryBytes>>10))
+	}
+	if s.MaxOpenFiles > 0 {
+		script = append(script, fmt.Sprintf("ulimit -n %d", s.MaxOpenFiles))
+	}
+	if len(script) == 0 {
+		return
+	}
+
+	// If a limit can't be set, the command isn't run
+	script = append(script, `exec "$0" "$@"`)
+	cmd.Args = append([]string{sandboxShell, "-c", strings.Join(script, " && "), cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = sandboxShell
+}
+
+func lookupCredential(username string) (*syscall.Credential, error) {
+	u, err := user.Lookup(username)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing uid")
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing gid")
+	}
+
+	// An empty Groups drops launcher's supplementary groups
+	return &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: []uint32{}}, nil
+}
+
+func killProcessGroup(cmd *exec.Cmd) {
+	// A negative pid signals the whole process group
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
+
+// limitedWriter passes writes through to w until limit bytes have been
+// written. After that, it discards them, and calls onExceeded once. A
+// zero limit is unlimited.
+type limitedWriter struct {
+	w          io.Writer
+	limit      int
+	written    int
+	exceeded   bool
+	onExceeded func()
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.limit == 0 {
+		return l.w.Write(p)
+	}
+	if l.exceeded {
+		return len(p), nil
+	}
+
+	if l.written+len(p) > l.limit {
+		l.w.Write(p[:l.limit-l.written])
+		l.written = l.limit
+		l.exceeded = true
+		l.onExceeded()
+		return len(p), nil
+	}
+
+	l.written += len(p)
+	return l.w.Write(p)
+}
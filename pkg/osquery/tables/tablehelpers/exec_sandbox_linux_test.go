@@ -0,0 +1,106 @@
+//go:build linux
+// +build linux
+
+package tablehelpers
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSandboxRun(t *testing.T) {
+	t.Parallel()
+
+	sandbox := DefaultSandbox("")
+	sandbox.MaxOutputBytes = 1024
+
+	var tests = []struct {
+		name   string
+		args   []string
+		err    string
+		output string
+	}{
+		{
+			// The shell setting the limits adds PWD
+			name:   "environment is cleared",
+			args:   []string{"-c", "env | grep -v ^PWD="},
+			output: sandboxPath,
+		},
+		{
+			name:   "open files are limited",
+			args:   []string{"-c", "ulimit -n"},
+			output: "256",
+		},
+		{
+			name: "output is limited",
+			args: []string{"-c", "while true; do echo hello; done"},
+			err:  "output exceeded limit of 1024 bytes",
+		},
+		{
+			name: "process group is killed on timeout",
+			args: []string{"-c", "sleep 30 & wait"},
+			err:  "killed",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			var stdout, stderr bytes.Buffer
+			cmd := exec.CommandContext(ctx, "/bin/sh", tt.args...)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			started := time.Now()
+			err := sandbox.run(ctx, cmd, execOptions{})
+			assert.Less(t, int64(time.Since(started)), int64(10*time.Second))
+
+			if tt.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.err)
+				assert.LessOrEqual(t, stdout.Len(), sandbox.MaxOutputBytes)
+				return
+			}
+
+			require.NoError(t, err, stderr.String())
+			assert.Equal(t, tt.output, strings.TrimSpace(stdout.String()))
+		})
+	}
+}
+
+func TestLimitedWriter(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	var exceeded int
+	w := &limitedWriter{w: &buf, limit: 5, onExceeded: func() { exceeded++ }}
+
+	for _, write := range []string{"ab", "cde", "f", "gh"} {
+		n, err := w.Write([]byte(write))
+		require.NoError(t, err)
+		require.Equal(t, len(write), n)
+	}
+
+	assert.Equal(t, "abcde", buf.String())
+	assert.True(t, w.exceeded)
+	assert.Equal(t, 1, exceeded)
+
+	// No limit passes everything through
+	buf.Reset()
+	w = &limitedWriter{w: &buf}
+	_, err := w.Write([]byte("hello world"))
+	require.NoError(t, err)
+	assert.Equal(t, "hello world", buf.String())
+}
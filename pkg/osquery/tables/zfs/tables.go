@@ -6,6 +6,7 @@ import (
 	"context"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
@@ -23,9 +24,21 @@ const (
 const allowedCharacters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789_-.@/"
 
 type Table struct {
-	client *osquery.ExtensionManagerClient
-	logger log.Logger
-	cmd    string
+	client    *osquery.ExtensionManagerClient
+	logger    log.Logger
+	cmd       string
+	cacheTTL  time.Duration
+	execCache *tablehelpers.ExecCache
+}
+
+type Opt func(*Table)
+
+// WithCacheTTL caches the command's output for ttl, so frequent queries
+// don't re-run it.
+func WithCacheTTL(ttl time.Duration) Opt {
+	return func(t *Table) {
+		t.cacheTTL = ttl
+	}
 }
 
 func columns() []table.ColumnDefinition {
@@ -37,24 +50,28 @@ func columns() []table.ColumnDefinition {
 	}
 }
 
-func ZfsPropertiesPlugin(client *osquery.ExtensionManagerClient, logger log.Logger) *table.Plugin {
-	t := &Table{
-		client: client,
-		logger: logger,
-		cmd:    zfsPath,
-	}
+func ZfsPropertiesPlugin(client *osquery.ExtensionManagerClient, logger log.Logger, opts ...Opt) *table.Plugin {
+	return tablePlugin(client, logger, "kolide_zfs_properties", zfsPath, opts)
+}
 
-	return table.NewPlugin("kolide_zfs_properties", columns(), t.generate)
+func ZpoolPropertiesPlugin(client *osquery.ExtensionManagerClient, logger log.Logger, opts ...Opt) *table.Plugin {
+	return tablePlugin(client, logger, "kolide_zpool_properties", zpoolPath, opts)
 }
 
-func ZpoolPropertiesPlugin(client *osquery.ExtensionManagerClient, logger log.Logger) *table.Plugin {
+func tablePlugin(client *osquery.ExtensionManagerClient, logger log.Logger, tableName string, cmd string, opts []Opt) *table.Plugin {
 	t := &Table{
 		client: client,
 		logger: logger,
-		cmd:    zpoolPath,
+		cmd:    cmd,
 	}
 
-	return table.NewPlugin("kolide_zpool_properties", columns(), t.generate)
+	for _, opt := range opts {
+		opt(t)
+	}
+
+	t.execCache = tablehelpers.NamedExecCache(tableName, t.cacheTTL)
+
+	return table.NewPlugin(tableName, columns(), t.generate)
 }
 
 func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) ([]map[string]string, error) {
@@ -78,7 +95,7 @@ func (t *Table) generate(ctx context.Context, queryContext table.QueryContext) (
 
 	args = append(args, names...)
 
-	output, err := tablehelpers.Exec(ctx, t.logger, 15, []string{t.cmd}, args)
+	output, err := t.execCache.Exec(ctx, t.logger, 15, []string{t.cmd}, args)
 	if err != nil {
 		// exec will error if there's no binary, so we never want to record that
 		if os.IsNotExist(errors.Cause(err)) {
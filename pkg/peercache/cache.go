@@ -0,0 +1,229 @@
+// Package peercache shares update downloads between launchers on a
+// LAN. Each launcher keeps the update tarballs it has downloaded and
+// verified in a Cache, named by their sha512 or sha256 hash, and may
+// serve them to its peers. Peers are configured, or discovered by mDNS.
+//
+// Peers aren't trusted. A consumer asks for a blob by the hash in its
+// own TUF metadata, and must verify what it gets against that metadata,
+// as it would a download from the mirror.
+package peercache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// blobPath is the path blobs are served under, followed by their hash
+const blobPath = "/v1/blobs/"
+
+// blobName matches a hex encoded sha512 or sha256 hash
+var blobName = regexp.MustCompile(`^([0-9a-f]{128}|[0-9a-f]{64})$`)
+
+// Cache is a directory of update tarballs, and the peers which may have
+// others.
+type Cache struct {
+	dir       string
+	logger    log.Logger
+	client    *http.Client
+	peers     []string
+	discover  bool
+	discovery time.Duration
+}
+
+type Option func(*Cache)
+
+// WithLogger sets the logger
+func WithLogger(logger log.Logger) Option {
+	return func(c *Cache) {
+		c.logger = log.With(logger, "component", "peercache")
+	}
+}
+
+// WithHTTPClient sets the client used to fetch blobs from peers
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *Cache) {
+		c.client = client
+	}
+}
+
+// WithPeers sets peers to fetch blobs from, as http URLs or host:port
+// addresses.
+func WithPeers(peers ...string) Option {
+	return func(c *Cache) {
+		for _, peer := range peers {
+			if !strings.HasPrefix(peer, "http://") && !strings.HasPrefix(peer, "https://") {
+				peer = "http://" + peer
+			}
+			c.peers = append(c.peers, strings.TrimSuffix(peer, "/"))
+		}
+	}
+}
+
+// WithDiscovery enables finding peers by mDNS, in addition to any
+// configured.
+func WithDiscovery() Option {
+	return func(c *Cache) {
+		c.discover = true
+	}
+}
+
+// New returns a Cache stored in dir.
+func New(dir string, opts ...Option) (*Cache, error) {
+	c := &Cache{
+		dir:       dir,
+		logger:    log.NewNopLogger(),
+		client:    &http.Client{Timeout: 10 * time.Minute},
+		discovery: time.Second,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "mkdir %s", dir)
+	}
+
+	return c, nil
+}
+
+// Add copies the verified blob at path into the cache, named by its
+// hash.
+func (c *Cache) Add(hash, path string) error {
+	if !blobName.MatchString(hash) {
+		return errors.Errorf("invalid blob hash %s", hash)
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "opening blob")
+	}
+	defer src.Close()
+
+	dest, err := ioutil.TempFile(c.dir, hash+".*.tmp")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary blob")
+	}
+	defer os.Remove(dest.Name())
+
+	_, err = io.Copy(dest, src)
+	if closeErr := dest.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "copying blob")
+	}
+
+	return errors.Wrap(os.Rename(dest.Name(), filepath.Join(c.dir, hash)), "renaming blob")
+}
+
+// Remove removes a blob, if it's in the cache.
+func (c *Cache) Remove(hash string) error {
+	if !blobName.MatchString(hash) {
+		return errors.Errorf("invalid blob hash %s", hash)
+	}
+
+	err := os.Remove(filepath.Join(c.dir, hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ServeHTTP serves the cached blobs.
+func (c *Cache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	hash := strings.TrimPrefix(r.URL.Path, blobPath)
+	if !strings.HasPrefix(r.URL.Path, blobPath) || !blobName.MatchString(hash) {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(filepath.Join(c.dir, hash))
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		level.Info(c.logger).Log("msg", "opening blob", "hash", hash, "err", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	level.Debug(c.logger).Log("msg", "serving blob", "hash", hash, "peer", r.RemoteAddr)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, hash, info.ModTime(), f)
+}
+
+// Peers returns the configured peers, and any discovered other than
+// this host.
+func (c *Cache) Peers(ctx context.Context) []string {
+	peers := append([]string{}, c.peers...)
+
+	if c.discover {
+		discovered, err := discoverPeers(ctx, c.discovery)
+		if err != nil {
+			level.Info(c.logger).Log("msg", "discovering peers", "err", err)
+		}
+		peers = append(peers, discovered...)
+	}
+
+	return peers
+}
+
+// Get requests a blob from peer. It returns an error if the peer
+// doesn't have it, or it's longer than maxLength. The blob must still
+// be verified.
+func (c *Cache) Get(ctx context.Context, peer, hash string, maxLength int64) (io.ReadCloser, error) {
+	if !blobName.MatchString(hash) {
+		return nil, errors.Errorf("invalid blob hash %s", hash)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, peer+blobPath+hash, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating request")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "requesting blob")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("peer returned %s", resp.Status)
+	}
+	if resp.ContentLength > maxLength {
+		resp.Body.Close()
+		return nil, errors.Errorf("blob is %d bytes, expected at most %d", resp.ContentLength, maxLength)
+	}
+
+	return &limitedBody{Reader: io.LimitReader(resp.Body, maxLength), Closer: resp.Body}, nil
+}
+
+type limitedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,124 @@
+package peercache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCache(t *testing.T) {
+	t.Parallel()
+
+	cache, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	blob := []byte("an update tarball")
+	hash := hashOf(blob)
+	blobFile := filepath.Join(t.TempDir(), "blob")
+	require.NoError(t, ioutil.WriteFile(blobFile, blob, 0644))
+	require.NoError(t, cache.Add(hash, blobFile))
+
+	server := httptest.NewServer(cache)
+	defer server.Close()
+
+	client, err := New(t.TempDir(), WithPeers(server.URL+"/"))
+	require.NoError(t, err)
+
+	peers := client.Peers(context.TODO())
+	require.Equal(t, []string{server.URL}, peers)
+
+	body, err := client.Get(context.TODO(), peers[0], hash, int64(len(blob)))
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(body)
+	require.NoError(t, err)
+	require.NoError(t, body.Close())
+	require.Equal(t, blob, got)
+
+	// Longer than expected
+	_, err = client.Get(context.TODO(), peers[0], hash, int64(len(blob)-1))
+	require.Error(t, err)
+
+	// Missing
+	_, err = client.Get(context.TODO(), peers[0], hashOf([]byte("missing")), 100)
+	require.Error(t, err)
+
+	// Not a hash
+	_, err = client.Get(context.TODO(), peers[0], "../../etc/passwd", 100)
+	require.Error(t, err)
+
+	require.NoError(t, cache.Remove(hash))
+	require.NoError(t, cache.Remove(hash), "removing a missing blob")
+	_, err = client.Get(context.TODO(), peers[0], hash, int64(len(blob)))
+	require.Error(t, err)
+}
+
+func TestServeHTTP(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cache, err := New(dir)
+	require.NoError(t, err)
+
+	// Files in the cache directory which aren't blobs aren't served
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "secret"), []byte("secret"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "sub"), 0755))
+
+	blob := []byte("blob")
+	hash := hashOf(blob)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, hash), blob, 0644))
+
+	var tests = []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: http.MethodGet, path: blobPath + hash, status: http.StatusOK},
+		{method: http.MethodHead, path: blobPath + hash, status: http.StatusOK},
+		{method: http.MethodPost, path: blobPath + hash, status: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, path: blobPath + "secret", status: http.StatusNotFound},
+		{method: http.MethodGet, path: blobPath + "../secret", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/" + hash, status: http.StatusNotFound},
+		{method: http.MethodGet, path: blobPath + hashOf([]byte("missing")), status: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		resp := httptest.NewRecorder()
+		cache.ServeHTTP(resp, req)
+		require.Equal(t, tt.status, resp.Code, tt.method+" "+tt.path)
+	}
+}
+
+func TestServer(t *testing.T) {
+	t.Parallel()
+
+	cache, err := New(t.TempDir())
+	require.NoError(t, err)
+
+	server, err := NewServer(cache, "127.0.0.1:0", false)
+	require.NoError(t, err)
+
+	done := make(chan error)
+	go func() { done <- server.Execute() }()
+
+	resp, err := http.Get("http://" + server.Addr().String() + blobPath + hashOf([]byte("missing")))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	server.Interrupt(nil)
+	require.NoError(t, <-done)
+}
+
+func hashOf(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,110 @@
+package peercache
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/hashicorp/mdns"
+	"github.com/pkg/errors"
+)
+
+// mdnsService is the service launchers advertise their caches as
+const mdnsService = "_kolide-launcher-cache._tcp"
+
+// advertise advertises a cache served on port, until the returned
+// function is called.
+func advertise(port int) (func() error, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "getting hostname")
+	}
+
+	service, err := mdns.NewMDNSService(host, mdnsService, "", "", port, nil, []string{"path=" + blobPath})
+	if err != nil {
+		return nil, errors.Wrap(err, "creating mdns service")
+	}
+
+	server, err := mdns.NewServer(&mdns.Config{Zone: service})
+	if err != nil {
+		return nil, errors.Wrap(err, "starting mdns server")
+	}
+
+	return server.Shutdown, nil
+}
+
+// discoverPeers returns the caches advertised on the LAN, waiting at
+// most timeout for them to reply. This host's own cache, which answers
+// too, is left out.
+func discoverPeers(ctx context.Context, timeout time.Duration) ([]string, error) {
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < timeout {
+		timeout = time.Until(deadline)
+	}
+
+	entries := make(chan *mdns.ServiceEntry, 32)
+	params := mdns.DefaultParams(mdnsService)
+	params.Entries = entries
+	params.Timeout = timeout
+
+	local := localAddrs()
+
+	var peers []string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for entry := range entries {
+			if peer := peerURL(entry, local); peer != "" {
+				peers = append(peers, peer)
+			}
+		}
+	}()
+
+	err := mdns.Query(params)
+
+	close(entries)
+	<-done
+
+	return peers, errors.Wrap(err, "querying mdns")
+}
+
+// peerURL returns the URL of the cache entry advertises, or "" if it
+// has no address, or is at one of the local addresses.
+func peerURL(entry *mdns.ServiceEntry, local map[string]bool) string {
+	for _, addr := range []net.IP{entry.AddrV4, entry.AddrV6} {
+		if addr != nil && local[addr.String()] {
+			return ""
+		}
+	}
+
+	var addr net.IP
+	switch {
+	case entry.AddrV4 != nil:
+		addr = entry.AddrV4
+	case entry.AddrV6 != nil:
+		addr = entry.AddrV6
+	default:
+		return ""
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(addr.String(), strconv.Itoa(entry.Port)))
+}
+
+// localAddrs returns the addresses of this host's interfaces. If they
+// can't be listed, nothing is filtered.
+func localAddrs() map[string]bool {
+	local := make(map[string]bool)
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return local
+	}
+
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			local[ipnet.IP.String()] = true
+		}
+	}
+	return local
+}
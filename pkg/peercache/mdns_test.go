@@ -0,0 +1,67 @@
+package peercache
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hashicorp/mdns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPeerURL(t *testing.T) {
+	t.Parallel()
+
+	local := map[string]bool{"192.168.1.10": true, "fe80::1": true}
+
+	var tests = []struct {
+		entry    mdns.ServiceEntry
+		expected string
+		comment  string
+	}{
+		{
+			entry:    mdns.ServiceEntry{AddrV4: net.ParseIP("192.168.1.20"), Port: 8080},
+			expected: "http://192.168.1.20:8080",
+			comment:  "ipv4",
+		},
+		{
+			entry:    mdns.ServiceEntry{AddrV6: net.ParseIP("fe80::2"), Port: 8080},
+			expected: "http://[fe80::2]:8080",
+			comment:  "ipv6",
+		},
+		{
+			entry:   mdns.ServiceEntry{Port: 8080},
+			comment: "no address",
+		},
+		{
+			entry:   mdns.ServiceEntry{AddrV4: net.ParseIP("192.168.1.10"), Port: 8080},
+			comment: "local ipv4",
+		},
+		{
+			entry:   mdns.ServiceEntry{AddrV4: net.ParseIP("10.0.0.1"), AddrV6: net.ParseIP("fe80::1"), Port: 8080},
+			comment: "local ipv6",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.comment, func(t *testing.T) {
+			t.Parallel()
+
+			require.Equal(t, tt.expected, peerURL(&tt.entry, local))
+		})
+	}
+}
+
+func TestLocalAddrs(t *testing.T) {
+	t.Parallel()
+
+	addrs, err := net.InterfaceAddrs()
+	require.NoError(t, err)
+
+	local := localAddrs()
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok {
+			require.True(t, local[ipnet.IP.String()], ipnet.IP.String())
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package peercache
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// Server serves a Cache to peers, optionally advertising it by mDNS.
+type Server struct {
+	cache     *Cache
+	listener  net.Listener
+	srv       *http.Server
+	advertise bool
+}
+
+// NewServer returns a Server for cache, listening on addr. If advertise
+// is set, the cache is advertised to peers by mDNS.
+func NewServer(cache *Cache, addr string, advertise bool) (*Server, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.Wrapf(err, "listening on %s", addr)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", http.NotFound)
+	mux.Handle(blobPath, cache)
+
+	return &Server{
+		cache:    cache,
+		listener: listener,
+		srv: &http.Server{
+			Handler:           mux,
+			ReadHeaderTimeout: 5 * time.Second,
+			IdleTimeout:       time.Minute,
+			MaxHeaderBytes:    4096,
+		},
+		advertise: advertise,
+	}, nil
+}
+
+// Addr returns the address the server is listening on.
+func (s *Server) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Execute serves the cache until Interrupt is called.
+func (s *Server) Execute() error {
+	logger := s.cache.logger
+
+	if s.advertise {
+		shutdown, err := advertise(s.listener.Addr().(*net.TCPAddr).Port)
+		if err != nil {
+			// Configured peers can still use the cache
+			level.Info(logger).Log("msg", "advertising update cache", "err", err)
+		} else {
+			defer shutdown()
+		}
+	}
+
+	level.Info(logger).Log("msg", "serving update cache", "addr", s.listener.Addr())
+
+	if err := s.srv.Serve(s.listener); err != nil && err != http.ErrServerClosed {
+		return errors.Wrap(err, "serving update cache")
+	}
+	return nil
+}
+
+// Interrupt stops the server.
+func (s *Server) Interrupt(err error) {
+	level.Info(s.cache.logger).Log("msg", "update cache server interrupted", "err", err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.srv.Shutdown(ctx); err != nil {
+		level.Info(s.cache.logger).Log("msg", "shutting down update cache server", "err", err)
+	}
+}
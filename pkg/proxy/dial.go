@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	xproxy "golang.org/x/net/proxy"
+)
+
+// dialThrough connects to addr through proxy, or directly when proxy is
+// nil.
+func dialThrough(ctx context.Context, proxy *url.URL, network, addr string) (net.Conn, error) {
+	direct := &net.Dialer{}
+	if proxy == nil {
+		return direct.DialContext(ctx, network, addr)
+	}
+
+	switch proxy.Scheme {
+	case "http", "https":
+		return dialConnect(ctx, direct, proxy, network, addr)
+
+	case "socks5", "socks5h":
+		var auth *xproxy.Auth
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			auth = &xproxy.Auth{User: proxy.User.Username(), Password: password}
+		}
+		dialer, err := xproxy.SOCKS5("tcp", proxyAddr(proxy), auth, direct)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating socks5 dialer")
+		}
+		conn, err := dialer.(xproxy.ContextDialer).DialContext(ctx, network, addr)
+		return conn, errors.Wrapf(err, "dialing %s through socks5 proxy %s", addr, proxy.Host)
+	}
+
+	return nil, errors.Errorf("unsupported proxy scheme %s", proxy.Scheme)
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request.
+func dialConnect(ctx context.Context, direct *net.Dialer, proxy *url.URL, network, addr string) (net.Conn, error) {
+	conn, err := direct.DialContext(ctx, network, proxyAddr(proxy))
+	if err != nil {
+		return nil, errors.Wrapf(err, "dialing proxy %s", proxy.Host)
+	}
+
+	// The deadline bounds the handshake with the proxy, and is cleared
+	// once the tunnel is up.
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if proxy.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+		if err := tlsConn.Handshake(); err != nil {
+			conn.Close()
+			return nil, errors.Wrapf(err, "tls handshake with proxy %s", proxy.Host)
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "sending CONNECT to proxy %s", proxy.Host)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		conn.Close()
+		return nil, errors.Wrapf(err, "reading CONNECT response from proxy %s", proxy.Host)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, errors.Errorf("proxy %s refused CONNECT to %s: %s", proxy.Host, addr, resp.Status)
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	// Anything the server sent after the response belongs to the tunnel.
+	if br.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: br}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a net.Conn that first returns the data already read
+// into r.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// proxyAddr returns the host:port of proxy, filling in the scheme's
+// default port.
+func proxyAddr(proxy *url.URL) string {
+	if proxy.Port() != "" {
+		return proxy.Host
+	}
+	port := "80"
+	switch proxy.Scheme {
+	case "https":
+		port = "443"
+	case "socks5", "socks5h":
+		port = "1080"
+	}
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
@@ -0,0 +1,212 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dop251/goja"
+	"github.com/pkg/errors"
+)
+
+// pacTimeout bounds a single run of FindProxyForURL, so that a script
+// which loops forever can't hang every connection.
+const pacTimeout = 5 * time.Second
+
+// pacScript is a parsed proxy auto-config file, run by an embedded
+// javascript engine. The standard PAC helper functions are provided,
+// other than the date and time ones; scripts calling those fail when
+// they reach the call.
+type pacScript struct {
+	// lock serializes runs, as the runtime isn't safe for concurrent use.
+	lock            sync.Mutex
+	vm              *goja.Runtime
+	findProxyForURL goja.Callable
+	timeout         time.Duration
+
+	// lookup resolves hostnames for dnsResolve, isResolvable and isInNet.
+	lookup func(host string) ([]net.IP, error)
+}
+
+// parsePAC parses the source of a PAC file.
+func parsePAC(src string) (*pacScript, error) {
+	program, err := goja.Compile("pac", src, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing PAC file")
+	}
+
+	script := &pacScript{
+		vm:      goja.New(),
+		timeout: pacTimeout,
+		lookup:  net.LookupIP,
+	}
+	for name, fn := range script.helpers() {
+		if err := script.vm.Set(name, fn); err != nil {
+			return nil, errors.Wrapf(err, "defining %s", name)
+		}
+	}
+
+	if _, err := script.run(func() (goja.Value, error) { return script.vm.RunProgram(program) }); err != nil {
+		return nil, errors.Wrap(err, "running PAC file")
+	}
+
+	findProxyForURL, ok := goja.AssertFunction(script.vm.Get("FindProxyForURL"))
+	if !ok {
+		return nil, errors.New("no FindProxyForURL function in PAC file")
+	}
+	script.findProxyForURL = findProxyForURL
+
+	return script, nil
+}
+
+// FindProxyForURL runs the script for target, returning the proxies it
+// chose in order of preference. A nil entry means a direct connection.
+func (s *pacScript) FindProxyForURL(target *url.URL) ([]*url.URL, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	result, err := s.run(func() (goja.Value, error) {
+		return s.findProxyForURL(goja.Undefined(), s.vm.ToValue(target.String()), s.vm.ToValue(target.Hostname()))
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "running FindProxyForURL")
+	}
+	if result == nil || goja.IsUndefined(result) || goja.IsNull(result) {
+		return []*url.URL{nil}, nil
+	}
+
+	return parsePACResult(result.String())
+}
+
+// run calls fn, interrupting it if it takes longer than s.timeout.
+func (s *pacScript) run(fn func() (goja.Value, error)) (goja.Value, error) {
+	timer := time.AfterFunc(s.timeout, func() {
+		s.vm.Interrupt("timed out")
+	})
+	defer func() {
+		timer.Stop()
+		s.vm.ClearInterrupt()
+	}()
+
+	return fn()
+}
+
+// parsePACResult parses a FindProxyForURL return value, such as
+// "PROXY proxy:3128; SOCKS5 socks:1080; DIRECT".
+func parsePACResult(result string) ([]*url.URL, error) {
+	var proxies []*url.URL
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.ToUpper(fields[0]) == "DIRECT" {
+			proxies = append(proxies, nil)
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf("malformed PAC result %q", entry)
+		}
+
+		var scheme string
+		switch strings.ToUpper(fields[0]) {
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			return nil, errors.Errorf("unsupported PAC proxy type %s", fields[0])
+		}
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: fields[1]})
+	}
+
+	if len(proxies) == 0 {
+		return []*url.URL{nil}, nil
+	}
+	return proxies, nil
+}
+
+// helpers returns the PAC helper functions, by name.
+func (s *pacScript) helpers() map[string]interface{} {
+	return map[string]interface{}{
+		"isPlainHostName": func(host string) bool {
+			return !strings.Contains(host, ".")
+		},
+		"dnsDomainIs": func(host, domain string) bool {
+			return strings.HasSuffix(strings.ToLower(host), strings.ToLower(domain))
+		},
+		"localHostOrDomainIs": func(host, hostdom string) bool {
+			host, hostdom = strings.ToLower(host), strings.ToLower(hostdom)
+			if strings.Contains(host, ".") {
+				return host == hostdom
+			}
+			return strings.SplitN(hostdom, ".", 2)[0] == host
+		},
+		"isResolvable": func(host string) bool {
+			_, err := s.resolve(host)
+			return err == nil
+		},
+		"dnsResolve": func(host string) interface{} {
+			ip, err := s.resolve(host)
+			if err != nil {
+				return nil
+			}
+			return ip.String()
+		},
+		"myIpAddress": myIPAddress,
+		"isInNet": func(host, pattern, mask string) bool {
+			ip, err := s.resolve(host)
+			if err != nil {
+				return false
+			}
+			network, netmask := net.ParseIP(pattern).To4(), net.ParseIP(mask).To4()
+			if network == nil || netmask == nil {
+				return false
+			}
+			return ip.Mask(net.IPMask(netmask)).Equal(network.Mask(net.IPMask(netmask)))
+		},
+		"dnsDomainLevels": func(host string) int {
+			return strings.Count(host, ".")
+		},
+		"shExpMatch": func(str, pattern string) bool {
+			// path.Match's * doesn't match /, unlike the shell expressions
+			// PAC files use, so the separator is swapped out first.
+			matched, err := path.Match(strings.ReplaceAll(pattern, "/", "\x00"), strings.ReplaceAll(str, "/", "\x00"))
+			return err == nil && matched
+		},
+	}
+}
+
+// resolve returns the first IPv4 address of host.
+func (s *pacScript) resolve(host string) (net.IP, error) {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		var err error
+		if ips, err = s.lookup(host); err != nil {
+			return nil, err
+		}
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	return nil, errors.Errorf("no IPv4 address for %s", host)
+}
+
+// myIPAddress returns the address of the interface used for outbound
+// connections. No packets are sent, as UDP sockets don't connect.
+func myIPAddress() string {
+	conn, err := net.Dial("udp", "198.51.100.1:80")
+	if err != nil {
+		return "127.0.0.1"
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.String()
+}
@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testPAC = `
+// Route internal traffic directly, and everything else through the proxy.
+function FindProxyForURL(url, host) {
+	var lowerHost = host.toLowerCase();
+
+	if (isPlainHostName(lowerHost) || dnsDomainIs(lowerHost, ".corp.example.com"))
+		return "DIRECT";
+
+	/* Private networks */
+	if (isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0")) {
+		return "DIRECT";
+	} else if (shExpMatch(url, "http://*/downloads/*")) {
+		return "PROXY cache.example.com:8080; DIRECT";
+	}
+
+	if (url.substring(0, 6) == "https:" && host.indexOf("kolide") >= 0)
+		return 'SOCKS5 socks.example.com:1080';
+
+	return "PROXY proxy.example.com:3128";
+}
+`
+
+func TestPAC(t *testing.T) {
+	t.Parallel()
+
+	script, err := parsePAC(testPAC)
+	require.NoError(t, err)
+	script.lookup = func(host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("10.1.2.3")}, nil
+	}
+
+	var tests = []struct {
+		target string
+		want   []string
+	}{
+		{target: "https://intranet/", want: []string{""}},
+		{target: "https://build.corp.example.com/", want: []string{""}},
+		{target: "https://internal.example.net/", want: []string{""}},
+		{target: "http://203.0.113.1/downloads/osqueryd", want: []string{"http://cache.example.com:8080", ""}},
+		{target: "https://203.0.113.1/", want: []string{"http://proxy.example.com:3128"}},
+		{target: "https://198.51.100.1/kolide", want: []string{"http://proxy.example.com:3128"}},
+	}
+
+	for _, tt := range tests {
+		target, err := url.Parse(tt.target)
+		require.NoError(t, err)
+
+		proxies, err := script.FindProxyForURL(target)
+		require.NoError(t, err, tt.target)
+
+		var got []string
+		for _, proxy := range proxies {
+			if proxy == nil {
+				got = append(got, "")
+			} else {
+				got = append(got, proxy.String())
+			}
+		}
+		require.Equal(t, tt.want, got, tt.target)
+	}
+}
+
+func TestPACSocks(t *testing.T) {
+	t.Parallel()
+
+	script, err := parsePAC(`function FindProxyForURL(url, host) {
+		if (url.substring(0, 6) == "https:" && host.indexOf("kolide") >= 0) return "SOCKS5 socks.example.com:1080";
+		return "DIRECT";
+	}`)
+	require.NoError(t, err)
+
+	proxies, err := script.FindProxyForURL(&url.URL{Scheme: "https", Host: "k2device.kolide.com:443"})
+	require.NoError(t, err)
+	require.Equal(t, []*url.URL{{Scheme: "socks5", Host: "socks.example.com:1080"}}, proxies)
+
+	proxies, err = script.FindProxyForURL(&url.URL{Scheme: "http", Host: "k2device.kolide.com"})
+	require.NoError(t, err)
+	require.Equal(t, []*url.URL{nil}, proxies)
+}
+
+func TestParsePACErrors(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		src  string
+	}{
+		{name: "empty", src: ""},
+		{name: "other function", src: `function helper() { return "DIRECT"; }`},
+		{name: "not a function", src: `var FindProxyForURL = "DIRECT";`},
+		{name: "unterminated string", src: `function FindProxyForURL(url, host) { return "DIRECT; }`},
+		{name: "unbalanced", src: `function FindProxyForURL(url, host) { return "DIRECT";`},
+		{name: "throws", src: `throw new Error("broken");`},
+	}
+
+	for _, tt := range tests {
+		_, err := parsePAC(tt.src)
+		require.Error(t, err, tt.name)
+	}
+}
+
+func TestPACResultErrors(t *testing.T) {
+	t.Parallel()
+
+	script, err := parsePAC(`function FindProxyForURL(url, host) { return "FTP ftp.example.com:21"; }`)
+	require.NoError(t, err)
+
+	_, err = script.FindProxyForURL(&url.URL{Scheme: "https", Host: "example.com"})
+	require.Error(t, err)
+}
+
+func TestPACRuntimeErrors(t *testing.T) {
+	t.Parallel()
+
+	var tests = []struct {
+		name string
+		src  string
+	}{
+		{name: "unsupported function", src: `function FindProxyForURL(url, host) { if (timeRange(8, 18)) return "DIRECT"; }`},
+		{name: "throws", src: `function FindProxyForURL(url, host) { throw new Error("broken"); }`},
+		{name: "loops forever", src: `function FindProxyForURL(url, host) { for (;;) {} }`},
+	}
+
+	for _, tt := range tests {
+		script, err := parsePAC(tt.src)
+		require.NoError(t, err, tt.name)
+		script.timeout = 100 * time.Millisecond
+
+		_, err = script.FindProxyForURL(&url.URL{Scheme: "https", Host: "example.com"})
+		require.Error(t, err, tt.name)
+	}
+
+	// The runtime is still usable after an interrupted run
+	script, err := parsePAC(`function FindProxyForURL(url, host) {
+		if (host == "loop.example.com") for (;;) {}
+		return "DIRECT";
+	}`)
+	require.NoError(t, err)
+	script.timeout = 100 * time.Millisecond
+
+	_, err = script.FindProxyForURL(&url.URL{Scheme: "https", Host: "loop.example.com"})
+	require.Error(t, err)
+	proxies, err := script.FindProxyForURL(&url.URL{Scheme: "https", Host: "example.com"})
+	require.NoError(t, err)
+	require.Equal(t, []*url.URL{nil}, proxies)
+}
@@ -0,0 +1,244 @@
+// Package proxy routes launcher's outbound connections through a proxy.
+//
+// The proxy configuration is process wide, so that every dialer takes the
+// same route. It comes from, in order of preference, an explicit proxy
+// URL, a PAC (proxy auto-config) file, or the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables. HTTP proxies are used with CONNECT
+// tunnels, and SOCKS5 proxies are supported too.
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http/httpproxy"
+)
+
+// checkTimeout bounds the connections made by Check.
+const checkTimeout = 5 * time.Second
+
+// routeFunc returns the proxies to reach target through, in order of
+// preference. A nil entry means a direct connection, as does an empty
+// list.
+type routeFunc func(target *url.URL) ([]*url.URL, error)
+
+var (
+	mu    sync.RWMutex
+	route = fromEnvironment()
+)
+
+// Configure sets the proxy configuration used by every launcher
+// connection. proxyURL is a proxy to send all traffic through, such as
+// http://proxy:3128 or socks5://proxy:1080. Otherwise pacLocation is the
+// path or URL of a PAC file to pick proxies with. When both are empty the
+// environment is used.
+func Configure(proxyURL, pacLocation string, logger log.Logger) error {
+	switch {
+	case proxyURL != "":
+		u, err := parseProxyURL(proxyURL)
+		if err != nil {
+			return err
+		}
+		level.Info(logger).Log("msg", "using proxy", "proxy", u.Redacted())
+		setRoute(fromURL(u))
+
+	case pacLocation != "":
+		script, err := loadPAC(pacLocation)
+		if err != nil {
+			return errors.Wrapf(err, "loading PAC file %s", pacLocation)
+		}
+		level.Info(logger).Log("msg", "using proxy auto-config", "pac", pacLocation)
+		setRoute(script.FindProxyForURL)
+
+	default:
+		setRoute(fromEnvironment())
+	}
+
+	return nil
+}
+
+// FromRequest returns the proxy to send req through, or nil to send it
+// directly. It's intended for http.Transport's Proxy field.
+func FromRequest(req *http.Request) (*url.URL, error) {
+	return ForURL(req.URL)
+}
+
+// NewTransport returns a copy of http.DefaultTransport, with its timeouts
+// and connection limits, which sends requests through the configured
+// proxy.
+func NewTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = FromRequest
+	return transport
+}
+
+// ForURL returns the proxy to reach target through, or nil to connect
+// directly.
+func ForURL(target *url.URL) (*url.URL, error) {
+	proxies, err := currentRoute()(target)
+	if err != nil || len(proxies) == 0 {
+		return nil, err
+	}
+	return proxies[0], nil
+}
+
+// DialContext connects to addr through the configured proxy, falling back
+// to any alternatives a PAC file lists. HTTP proxies are asked for a
+// CONNECT tunnel, so the connection can carry any protocol. It has the
+// signature used by grpc.WithContextDialer and websocket.Dialer.
+func DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	// Raw connections are always tunnelled, so they take the route
+	// HTTPS requests would.
+	proxies, err := currentRoute()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil {
+		return nil, errors.Wrap(err, "choosing proxy")
+	}
+	if len(proxies) == 0 {
+		proxies = []*url.URL{nil}
+	}
+
+	var lastErr error
+	for _, proxy := range proxies {
+		conn, err := dialThrough(ctx, proxy, network, addr)
+		if err == nil {
+			return conn, nil
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// DialGRPC is DialContext with the signature grpc.WithContextDialer
+// expects.
+func DialGRPC(ctx context.Context, addr string) (net.Conn, error) {
+	return DialContext(ctx, "tcp", addr)
+}
+
+// Check reports, for each of targets, the proxy it will be reached through
+// and whether that proxy accepts connections. It's intended for the
+// connectivity checks in the log checkpoint.
+func Check(targets ...*url.URL) map[string]string {
+	results := make(map[string]string)
+
+	for _, target := range targets {
+		proxy, err := ForURL(target)
+		switch {
+		case err != nil:
+			results[target.Host] = err.Error()
+		case proxy == nil:
+			results[target.Host] = "direct"
+		default:
+			conn, err := net.DialTimeout("tcp", proxyAddr(proxy), checkTimeout)
+			if err != nil {
+				results[target.Host] = fmt.Sprintf("via %s: %s", proxy.Redacted(), err)
+				continue
+			}
+			conn.Close()
+			results[target.Host] = fmt.Sprintf("via %s: reachable", proxy.Redacted())
+		}
+	}
+
+	return results
+}
+
+func setRoute(r routeFunc) {
+	mu.Lock()
+	defer mu.Unlock()
+	route = r
+}
+
+func currentRoute() routeFunc {
+	mu.RLock()
+	defer mu.RUnlock()
+	return route
+}
+
+// fromEnvironment routes by the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// environment variables.
+func fromEnvironment() routeFunc {
+	return fromConfig(httpproxy.FromEnvironment())
+}
+
+// fromURL routes everything through proxy, except the hosts listed in
+// NO_PROXY.
+func fromURL(proxy *url.URL) routeFunc {
+	env := httpproxy.FromEnvironment()
+	return fromConfig(&httpproxy.Config{
+		HTTPProxy:  proxy.String(),
+		HTTPSProxy: proxy.String(),
+		NoProxy:    env.NoProxy,
+	})
+}
+
+func fromConfig(config *httpproxy.Config) routeFunc {
+	proxyFunc := config.ProxyFunc()
+	return func(target *url.URL) ([]*url.URL, error) {
+		proxy, err := proxyFunc(target)
+		if err != nil || proxy == nil {
+			return nil, err
+		}
+		return []*url.URL{proxy}, nil
+	}
+}
+
+// parseProxyURL parses an explicitly configured proxy, which defaults to
+// being an HTTP proxy.
+func parseProxyURL(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing proxy URL %s", raw)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.Errorf("unsupported proxy scheme %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, errors.Errorf("proxy URL %s has no host", raw)
+	}
+
+	return u, nil
+}
+
+// loadPAC reads and parses a PAC file from a path or an http(s) URL. The
+// file is fetched directly, as the proxy can't be known until it's read.
+func loadPAC(location string) (*pacScript, error) {
+	var src []byte
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Proxy = nil
+		client := &http.Client{Timeout: 30 * time.Second, Transport: transport}
+		resp, err := client.Get(location)
+		if err != nil {
+			return nil, errors.Wrap(err, "fetching PAC file")
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.Errorf("fetching PAC file returned status %d", resp.StatusCode)
+		}
+		if src, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, errors.Wrap(err, "reading PAC file")
+		}
+	} else {
+		var err error
+		if src, err = ioutil.ReadFile(location); err != nil {
+			return nil, errors.Wrap(err, "reading PAC file")
+		}
+	}
+
+	return parsePAC(string(src))
+}
@@ -0,0 +1,188 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+// connectProxy is a minimal HTTP proxy supporting CONNECT.
+func connectProxy(t *testing.T) (addr string, requests chan *http.Request) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	requests = make(chan *http.Request, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				req, err := http.ReadRequest(bufio.NewReader(conn))
+				if err != nil {
+					return
+				}
+				requests <- req
+
+				if req.Method != "CONNECT" || req.Header.Get("Proxy-Authorization") == "Basic YmFkOnVzZXI=" {
+					io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+					return
+				}
+				upstream, err := net.Dial("tcp", req.Host)
+				if err != nil {
+					io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+					return
+				}
+				defer upstream.Close()
+				io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+				go io.Copy(upstream, conn)
+				io.Copy(conn, upstream)
+			}()
+		}
+	}()
+
+	return listener.Addr().String(), requests
+}
+
+// echoServer echoes back a line at a time.
+func echoServer(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func TestDialConnect(t *testing.T) {
+	t.Parallel()
+
+	proxyAddr, requests := connectProxy(t)
+	echoAddr := echoServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	conn, err := dialThrough(ctx, &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "pass")}, "tcp", echoAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	req := <-requests
+	require.Equal(t, "CONNECT", req.Method)
+	require.Equal(t, echoAddr, req.Host)
+	require.Equal(t, "Basic dXNlcjpwYXNz", req.Header.Get("Proxy-Authorization"))
+
+	_, err = io.WriteString(conn, "hello\n")
+	require.NoError(t, err)
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", line)
+
+	_, err = dialThrough(ctx, &url.URL{Scheme: "http", Host: proxyAddr, User: url.UserPassword("bad", "user")}, "tcp", echoAddr)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "407")
+}
+
+func TestDialContextFallsBack(t *testing.T) { // nolint:paralleltest
+	defer setRoute(fromEnvironment())
+
+	echoAddr := echoServer(t)
+
+	// The first proxy isn't listening, so the PAC file's DIRECT fallback
+	// is used.
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	deadProxy := unused.Addr().String()
+	unused.Close()
+
+	script, err := parsePAC(`function FindProxyForURL(url, host) { return "PROXY ` + deadProxy + `; DIRECT"; }`)
+	require.NoError(t, err)
+	setRoute(script.FindProxyForURL)
+
+	conn, err := DialContext(context.Background(), "tcp", echoAddr)
+	require.NoError(t, err)
+	conn.Close()
+}
+
+func TestConfigure(t *testing.T) { // nolint:paralleltest
+	defer setRoute(fromEnvironment())
+
+	target := &url.URL{Scheme: "https", Host: "k2device.kolide.com:443"}
+
+	require.NoError(t, Configure("proxy.example.com:3128", "", log.NewNopLogger()))
+	proxy, err := ForURL(target)
+	require.NoError(t, err)
+	require.Equal(t, "http://proxy.example.com:3128", proxy.String())
+
+	require.NoError(t, Configure("socks5://socks.example.com", "", log.NewNopLogger()))
+	proxy, err = FromRequest(&http.Request{URL: target})
+	require.NoError(t, err)
+	require.Equal(t, "socks5://socks.example.com", proxy.String())
+	require.Equal(t, "socks.example.com:1080", proxyAddr(proxy))
+
+	require.Error(t, Configure("ftp://proxy.example.com", "", log.NewNopLogger()))
+
+	pacPath := filepath.Join(t.TempDir(), "proxy.pac")
+	require.NoError(t, ioutil.WriteFile(pacPath, []byte(`function FindProxyForURL(url, host) { return "HTTPS secure.example.com:8443"; }`), 0644))
+	require.NoError(t, Configure("", pacPath, log.NewNopLogger()))
+	proxy, err = ForURL(target)
+	require.NoError(t, err)
+	require.Equal(t, "https://secure.example.com:8443", proxy.String())
+
+	require.Error(t, Configure("", filepath.Join(t.TempDir(), "missing.pac"), log.NewNopLogger()))
+}
+
+func TestCheck(t *testing.T) { // nolint:paralleltest
+	defer setRoute(fromEnvironment())
+
+	proxyAddr, _ := connectProxy(t)
+	setRoute(func(target *url.URL) ([]*url.URL, error) {
+		if strings.HasPrefix(target.Host, "direct") {
+			return nil, nil
+		}
+		return []*url.URL{{Scheme: "http", Host: proxyAddr, User: url.UserPassword("user", "secret")}}, nil
+	})
+
+	results := Check(
+		&url.URL{Scheme: "https", Host: "direct.example.com:443"},
+		&url.URL{Scheme: "https", Host: "proxied.example.com:443"},
+	)
+	require.Equal(t, "direct", results["direct.example.com:443"])
+	require.Equal(t, "via http://user:xxxxx@"+proxyAddr+": reachable", results["proxied.example.com:443"])
+}
+
+func TestNewTransport(t *testing.T) {
+	t.Parallel()
+
+	transport := NewTransport()
+	require.NotNil(t, transport.Proxy)
+	require.Equal(t, http.DefaultTransport.(*http.Transport).TLSHandshakeTimeout, transport.TLSHandshakeTimeout)
+	require.Equal(t, http.DefaultTransport.(*http.Transport).IdleConnTimeout, transport.IdleConnTimeout)
+	require.NotSame(t, http.DefaultTransport, transport)
+}
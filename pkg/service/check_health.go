@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -69,6 +70,27 @@ func decodeJSONRPCHealthCheckResponse(_ context.Context, res jsonrpc.Response) (
 	return result, nil
 }
 
+func decodeHTTPHealthCheckRequest(_ context.Context, _ *http.Request) (interface{}, error) {
+	return healthcheckRequest{}, nil
+}
+
+func encodeHTTPHealthCheckRequest(_ context.Context, _ *http.Request, _ interface{}) error {
+	return nil
+}
+
+func encodeHTTPHealthCheckResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(healthcheckResponse)
+	return encodeHTTPResponse(w, res, res.Err)
+}
+
+func decodeHTTPHealthCheckResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var res healthcheckResponse
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "CheckHealth")
+	}
+	return res, nil
+}
+
 func MakeCheckHealthEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		status, err := svc.CheckHealth(ctx)
@@ -0,0 +1,587 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	grpctransport "github.com/go-kit/kit/transport/grpc"
+	"github.com/google/uuid"
+	"github.com/osquery/osquery-go/plugin/distributed"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc/metadata"
+)
+
+// Payloads larger than the transport's request limit are split into chunks,
+// each sent as its own request. Every chunk carries a chunkHeader of the
+// form "<id>/<index>/<count>", which the server uses to reassemble the
+// original payload once all the chunks have arrived.
+//
+// Like compression, chunking is negotiated rather than assumed. A server
+// which reassembles chunks sets chunkAcceptHeader on its responses, and a
+// client only splits payloads once it has seen it. Until then, payloads are
+// sent whole, as they were before chunking, so a server which predates it
+// is never sent fragments it would take for complete logs.
+const (
+	chunkHeader       = "launcher-chunk"
+	chunkAcceptHeader = "launcher-accept-chunks"
+
+	// chunkTimeout is how long a server waits for the rest of a chunked
+	// payload before discarding the chunks it has.
+	chunkTimeout = 10 * time.Minute
+
+	// resultOverheadBytes approximates the encoding overhead of a
+	// distributed.Result, excluding its rows.
+	resultOverheadBytes = 64
+)
+
+type chunkInfo struct {
+	ID    string
+	Index int
+	Count int
+}
+
+func (c chunkInfo) String() string {
+	return fmt.Sprintf("%s/%d/%d", c.ID, c.Index, c.Count)
+}
+
+func parseChunkInfo(s string) (chunkInfo, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 3 || parts[0] == "" {
+		return chunkInfo{}, errors.Errorf("malformed chunk header: %s", s)
+	}
+	index, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return chunkInfo{}, errors.Wrap(err, "parsing chunk index")
+	}
+	count, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return chunkInfo{}, errors.Wrap(err, "parsing chunk count")
+	}
+	if count < 1 || index < 0 || index >= count {
+		return chunkInfo{}, errors.Errorf("chunk %d out of range of %d", index, count)
+	}
+	return chunkInfo{ID: parts[0], Index: index, Count: count}, nil
+}
+
+type chunkContextKey struct{}
+
+func contextWithChunk(ctx context.Context, c chunkInfo) context.Context {
+	return context.WithValue(ctx, chunkContextKey{}, c)
+}
+
+func chunkFromContext(ctx context.Context) (chunkInfo, bool) {
+	c, ok := ctx.Value(chunkContextKey{}).(chunkInfo)
+	return c, ok
+}
+
+// withoutChunk hides chunk information in the context, for passing on a
+// reassembled payload.
+func withoutChunk(ctx context.Context) context.Context {
+	return context.WithValue(ctx, chunkContextKey{}, nil)
+}
+
+// chunkNegotiation records whether the server has advertised that it
+// reassembles chunks. It's shared between a client's transport, which sees
+// the responses, and its chunking middleware.
+type chunkNegotiation struct {
+	accepted int32
+}
+
+func (n *chunkNegotiation) accepts() bool {
+	return atomic.LoadInt32(&n.accepted) == 1
+}
+
+func (n *chunkNegotiation) observe(advertised bool) {
+	if advertised {
+		atomic.StoreInt32(&n.accepted, 1)
+	}
+}
+
+// readGRPCChunkAccept notes whether a gRPC response advertises chunking.
+func (n *chunkNegotiation) readGRPCChunkAccept() grpctransport.ClientOption {
+	return grpctransport.ClientAfter(
+		func(ctx context.Context, header metadata.MD, trailer metadata.MD) context.Context {
+			n.observe(len(header[chunkAcceptHeader]) > 0)
+			return ctx
+		},
+	)
+}
+
+// readHTTPChunkAccept notes whether an HTTP response advertises chunking.
+// It's used by the HTTP based transports.
+func (n *chunkNegotiation) readHTTPChunkAccept(ctx context.Context, resp *http.Response) context.Context {
+	n.observe(resp.Header.Get(chunkAcceptHeader) != "")
+	return ctx
+}
+
+type chunkAdvertContextKey struct{}
+
+// advertiseChunks marks the response to the request in ctx as coming from
+// a server which reassembles chunks. The server transports set
+// chunkAcceptHeader on marked responses.
+func advertiseChunks(ctx context.Context) {
+	if advert, ok := ctx.Value(chunkAdvertContextKey{}).(*int32); ok {
+		atomic.StoreInt32(advert, 1)
+	}
+}
+
+func chunksAdvertised(ctx context.Context) bool {
+	advert, ok := ctx.Value(chunkAdvertContextKey{}).(*int32)
+	return ok && atomic.LoadInt32(advert) == 1
+}
+
+// advertiseGRPCChunks sets chunkAcceptHeader on the gRPC response metadata
+// if the service reassembles chunks.
+func advertiseGRPCChunks() grpctransport.ServerOption {
+	return grpctransport.ServerAfter(
+		func(ctx context.Context, header *metadata.MD, trailer *metadata.MD) context.Context {
+			if chunksAdvertised(ctx) {
+				if *header == nil {
+					*header = metadata.MD{}
+				}
+				(*header)[chunkAcceptHeader] = []string{"1"}
+			}
+			return ctx
+		},
+	)
+}
+
+// advertiseHTTPChunks sets chunkAcceptHeader on the HTTP response if the
+// service reassembles chunks. It's used by the HTTP based transports.
+func advertiseHTTPChunks(ctx context.Context, w http.ResponseWriter) context.Context {
+	if chunksAdvertised(ctx) {
+		w.Header().Set(chunkAcceptHeader, "1")
+	}
+	return ctx
+}
+
+// attachGRPCChunk copies chunk information from the context into the gRPC
+// request metadata.
+func attachGRPCChunk() grpctransport.ClientOption {
+	return grpctransport.ClientBefore(
+		func(ctx context.Context, md *metadata.MD) context.Context {
+			if c, ok := chunkFromContext(ctx); ok {
+				(*md)[chunkHeader] = []string{c.String()}
+			}
+			return ctx
+		},
+	)
+}
+
+// parseGRPCChunk copies chunk information from the gRPC request metadata
+// into the context, and makes room for the service to advertise chunking.
+func parseGRPCChunk() grpctransport.ServerOption {
+	return grpctransport.ServerBefore(
+		func(ctx context.Context, md metadata.MD) context.Context {
+			ctx = context.WithValue(ctx, chunkAdvertContextKey{}, new(int32))
+			hdr, ok := md[chunkHeader]
+			if !ok || len(hdr) == 0 {
+				return ctx
+			}
+			if c, err := parseChunkInfo(hdr[len(hdr)-1]); err == nil {
+				ctx = contextWithChunk(ctx, c)
+			}
+			return ctx
+		},
+	)
+}
+
+// attachHTTPChunk copies chunk information from the context into the HTTP
+// request headers. It's used by the HTTP based transports.
+func attachHTTPChunk(ctx context.Context, r *http.Request) context.Context {
+	if c, ok := chunkFromContext(ctx); ok {
+		r.Header.Set(chunkHeader, c.String())
+	}
+	return ctx
+}
+
+// parseHTTPChunk copies chunk information from the HTTP request headers
+// into the context, and makes room for the service to advertise chunking.
+// It's used by the HTTP based transports.
+func parseHTTPChunk(ctx context.Context, r *http.Request) context.Context {
+	ctx = context.WithValue(ctx, chunkAdvertContextKey{}, new(int32))
+	hdr := r.Header.Get(chunkHeader)
+	if hdr == "" {
+		return ctx
+	}
+	if c, err := parseChunkInfo(hdr); err == nil {
+		ctx = contextWithChunk(ctx, c)
+	}
+	return ctx
+}
+
+// chunkingMiddleware splits PublishLogs and PublishResults calls whose
+// payloads are larger than maxBytes across multiple requests, once the
+// server has advertised that it reassembles them. Until then, logs
+// larger than maxBytes are dropped, as the server couldn't take them.
+func chunkingMiddleware(maxBytes int, negotiation *chunkNegotiation, logger log.Logger) Middleware {
+	return func(next KolideService) KolideService {
+		return chunkmw{
+			KolideService: next,
+			next:          next,
+			maxBytes:      maxBytes,
+			negotiation:   negotiation,
+			logger:        logger,
+		}
+	}
+}
+
+type chunkmw struct {
+	KolideService
+	next        KolideService
+	maxBytes    int
+	negotiation *chunkNegotiation
+	logger      log.Logger
+}
+
+func (mw chunkmw) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (message, errcode string, reauth bool, err error) {
+	batch := make([]string, 0, len(logs))
+	var oversized []string
+	for _, l := range logs {
+		if len(l) > mw.maxBytes {
+			oversized = append(oversized, l)
+		} else {
+			batch = append(batch, l)
+		}
+	}
+
+	if !mw.negotiation.accepts() {
+		for _, l := range oversized {
+			atomic.AddUint64(&transportStats.LogsDropped, 1)
+			level.Info(mw.logger).Log(
+				"msg", "dropped log larger than the request limit, the server doesn't accept chunks",
+				"size", len(l),
+				"limit", mw.maxBytes,
+			)
+		}
+		if len(batch) == 0 && len(oversized) > 0 {
+			return "", "", false, nil
+		}
+		return mw.next.PublishLogs(ctx, nodeKey, logType, batch)
+	}
+
+	if len(batch) > 0 || len(oversized) == 0 {
+		message, errcode, reauth, err = mw.next.PublishLogs(ctx, nodeKey, logType, batch)
+		if err != nil || reauth {
+			return message, errcode, reauth, err
+		}
+	}
+
+	for _, l := range oversized {
+		chunks := splitString(l, mw.maxBytes)
+		id := uuid.New().String()
+		atomic.AddUint64(&transportStats.LogsSplit, 1)
+		level.Debug(mw.logger).Log(
+			"msg", "splitting oversized log",
+			"size", len(l),
+			"chunks", len(chunks),
+			"chunk_id", id,
+		)
+
+		for i, chunk := range chunks {
+			cctx := contextWithChunk(ctx, chunkInfo{ID: id, Index: i, Count: len(chunks)})
+			message, errcode, reauth, err = mw.next.PublishLogs(cctx, nodeKey, logType, []string{chunk})
+			if err != nil || reauth {
+				return message, errcode, reauth, err
+			}
+			atomic.AddUint64(&transportStats.ChunksSent, 1)
+		}
+	}
+
+	return message, errcode, reauth, err
+}
+
+func (mw chunkmw) PublishResults(ctx context.Context, nodeKey string, results []distributed.Result) (message, errcode string, reauth bool, err error) {
+	if !mw.negotiation.accepts() {
+		return mw.next.PublishResults(ctx, nodeKey, results)
+	}
+
+	// Results that fit are batched together, up to maxBytes per request.
+	var batches [][]distributed.Result
+	var oversized []distributed.Result
+	var batch []distributed.Result
+	batchBytes := 0
+	for _, result := range results {
+		size := resultSize(result)
+		if size > mw.maxBytes {
+			oversized = append(oversized, result)
+			continue
+		}
+		if batchBytes+size > mw.maxBytes && len(batch) > 0 {
+			batches = append(batches, batch)
+			batch = nil
+			batchBytes = 0
+		}
+		batch = append(batch, result)
+		batchBytes += size
+	}
+	if len(batch) > 0 || (len(batches) == 0 && len(oversized) == 0) {
+		batches = append(batches, batch)
+	}
+
+	for _, batch := range batches {
+		message, errcode, reauth, err = mw.next.PublishResults(ctx, nodeKey, batch)
+		if err != nil || reauth {
+			return message, errcode, reauth, err
+		}
+	}
+
+	for _, result := range oversized {
+		chunks, dropped := splitResult(result, mw.maxBytes)
+		id := uuid.New().String()
+		atomic.AddUint64(&transportStats.ResultsSplit, 1)
+		if dropped > 0 {
+			atomic.AddUint64(&transportStats.RowsDropped, uint64(dropped))
+			level.Info(mw.logger).Log(
+				"msg", "dropped result rows larger than the request limit",
+				"query", result.QueryName,
+				"dropped", dropped,
+				"limit", mw.maxBytes,
+			)
+		}
+		level.Debug(mw.logger).Log(
+			"msg", "splitting oversized result",
+			"query", result.QueryName,
+			"rows", len(result.Rows),
+			"chunks", len(chunks),
+			"chunk_id", id,
+		)
+
+		for i, chunk := range chunks {
+			cctx := contextWithChunk(ctx, chunkInfo{ID: id, Index: i, Count: len(chunks)})
+			message, errcode, reauth, err = mw.next.PublishResults(cctx, nodeKey, []distributed.Result{chunk})
+			if err != nil || reauth {
+				return message, errcode, reauth, err
+			}
+			atomic.AddUint64(&transportStats.ChunksSent, 1)
+		}
+	}
+
+	return message, errcode, reauth, err
+}
+
+// splitString splits s into pieces of at most maxBytes, without breaking
+// up any multi-byte characters. Both transports require valid UTF-8.
+func splitString(s string, maxBytes int) []string {
+	var chunks []string
+	for len(s) > maxBytes {
+		end := maxBytes
+		for end > 0 && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		if end == 0 {
+			end = maxBytes
+		}
+		chunks = append(chunks, s[:end])
+		s = s[end:]
+	}
+	return append(chunks, s)
+}
+
+// splitResult splits a result's rows into results of at most maxBytes.
+// Rows that are too large to send on their own are dropped, and counted.
+func splitResult(result distributed.Result, maxBytes int) ([]distributed.Result, int) {
+	overhead := resultOverheadBytes + len(result.QueryName)
+
+	var chunks []distributed.Result
+	dropped := 0
+	chunk := distributed.Result{QueryName: result.QueryName, Status: result.Status}
+	chunkBytes := overhead
+	for _, row := range result.Rows {
+		size := rowSize(row)
+		if size+overhead > maxBytes {
+			dropped++
+			continue
+		}
+		if chunkBytes+size > maxBytes && len(chunk.Rows) > 0 {
+			chunks = append(chunks, chunk)
+			chunk = distributed.Result{QueryName: result.QueryName, Status: result.Status}
+			chunkBytes = overhead
+		}
+		chunk.Rows = append(chunk.Rows, row)
+		chunkBytes += size
+	}
+	if len(chunk.Rows) > 0 || len(chunks) == 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks, dropped
+}
+
+func resultSize(result distributed.Result) int {
+	size := resultOverheadBytes + len(result.QueryName)
+	for _, row := range result.Rows {
+		size += rowSize(row)
+	}
+	return size
+}
+
+func rowSize(row map[string]string) int {
+	b, err := json.Marshal(row)
+	if err != nil {
+		// Only possible for unencodable values, which would fail to
+		// send regardless. Fall back to a rough count.
+		size := 0
+		for k, v := range row {
+			size += len(k) + len(v)
+		}
+		return size
+	}
+	return len(b)
+}
+
+// ChunkReassemblyMiddleware returns server middleware that collects the
+// chunks of payloads split by a launcher client, and passes the reassembled
+// payload on once all of its chunks have arrived. Every response is marked
+// for the transport to advertise chunking to the client.
+func ChunkReassemblyMiddleware(logger log.Logger) Middleware {
+	return func(next KolideService) KolideService {
+		return &reassemblymw{
+			KolideService: next,
+			next:          next,
+			logger:        logger,
+			partials:      make(map[string]*partialPayload),
+			now:           time.Now,
+		}
+	}
+}
+
+type reassemblymw struct {
+	KolideService
+	next   KolideService
+	logger log.Logger
+
+	mu       sync.Mutex
+	partials map[string]*partialPayload
+	now      func() time.Time
+}
+
+type partialPayload struct {
+	created time.Time
+	count   int
+	logs    map[int]string
+	results map[int]distributed.Result
+}
+
+// add records a chunk, and returns the partial payload once all of its
+// chunks have arrived.
+func (mw *reassemblymw) add(c chunkInfo, logData *string, result *distributed.Result) *partialPayload {
+	mw.mu.Lock()
+	defer mw.mu.Unlock()
+
+	now := mw.now()
+	for id, p := range mw.partials {
+		if now.Sub(p.created) > chunkTimeout {
+			delete(mw.partials, id)
+			atomic.AddUint64(&transportStats.ChunksExpired, 1)
+			level.Info(mw.logger).Log("msg", "discarding incomplete chunked payload", "chunk_id", id)
+		}
+	}
+
+	p, ok := mw.partials[c.ID]
+	if !ok {
+		p = &partialPayload{
+			created: now,
+			count:   c.Count,
+			logs:    make(map[int]string),
+			results: make(map[int]distributed.Result),
+		}
+		mw.partials[c.ID] = p
+	}
+
+	if logData != nil {
+		p.logs[c.Index] = *logData
+	}
+	if result != nil {
+		p.results[c.Index] = *result
+	}
+
+	if len(p.logs)+len(p.results) < p.count {
+		return nil
+	}
+
+	delete(mw.partials, c.ID)
+	atomic.AddUint64(&transportStats.ChunksReassembled, 1)
+	return p
+}
+
+func (mw *reassemblymw) RequestEnrollment(ctx context.Context, enrollSecret, hostIdentifier string, details EnrollmentDetails) (string, bool, error) {
+	advertiseChunks(ctx)
+	return mw.next.RequestEnrollment(ctx, enrollSecret, hostIdentifier, details)
+}
+
+func (mw *reassemblymw) RequestConfig(ctx context.Context, nodeKey string) (string, bool, error) {
+	advertiseChunks(ctx)
+	return mw.next.RequestConfig(ctx, nodeKey)
+}
+
+func (mw *reassemblymw) RequestQueries(ctx context.Context, nodeKey string) (*distributed.GetQueriesResult, bool, error) {
+	advertiseChunks(ctx)
+	return mw.next.RequestQueries(ctx, nodeKey)
+}
+
+func (mw *reassemblymw) CheckHealth(ctx context.Context) (int32, error) {
+	advertiseChunks(ctx)
+	return mw.next.CheckHealth(ctx)
+}
+
+func (mw *reassemblymw) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+	advertiseChunks(ctx)
+	c, ok := chunkFromContext(ctx)
+	if !ok {
+		return mw.next.PublishLogs(ctx, nodeKey, logType, logs)
+	}
+	if len(logs) != 1 {
+		return "", "", false, errors.Errorf("chunked request must have exactly one log, got %d", len(logs))
+	}
+
+	p := mw.add(c, &logs[0], nil)
+	if p == nil {
+		return "", "", false, nil
+	}
+
+	var sb strings.Builder
+	for i := 0; i < p.count; i++ {
+		sb.WriteString(p.logs[i])
+	}
+	return mw.next.PublishLogs(withoutChunk(ctx), nodeKey, logType, []string{sb.String()})
+}
+
+func (mw *reassemblymw) PublishResults(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+	advertiseChunks(ctx)
+	c, ok := chunkFromContext(ctx)
+	if !ok {
+		return mw.next.PublishResults(ctx, nodeKey, results)
+	}
+	if len(results) != 1 {
+		return "", "", false, errors.Errorf("chunked request must have exactly one result, got %d", len(results))
+	}
+
+	p := mw.add(c, nil, &results[0])
+	if p == nil {
+		return "", "", false, nil
+	}
+
+	indexes := make([]int, 0, len(p.results))
+	for i := range p.results {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+
+	merged := distributed.Result{QueryName: results[0].QueryName, Status: results[0].Status}
+	for _, i := range indexes {
+		merged.Rows = append(merged.Rows, p.results[i].Rows...)
+	}
+	return mw.next.PublishResults(withoutChunk(ctx), nodeKey, []distributed.Result{merged})
+}
@@ -0,0 +1,198 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/osquery/osquery-go/plugin/distributed"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingService struct {
+	KolideService
+	logs    [][]string
+	results [][]distributed.Result
+}
+
+func (r *recordingService) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+	r.logs = append(r.logs, logs)
+	return "", "", false, nil
+}
+
+func (r *recordingService) PublishResults(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+	r.results = append(r.results, results)
+	return "", "", false, nil
+}
+
+// chunkPipe connects the chunking client middleware to the reassembling
+// server middleware, as the transports would.
+type chunkPipe struct {
+	KolideService
+	server   KolideService
+	requests *int
+}
+
+func (p chunkPipe) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+	*p.requests++
+	return p.server.PublishLogs(passChunk(ctx), nodeKey, logType, logs)
+}
+
+func (p chunkPipe) PublishResults(ctx context.Context, nodeKey string, results []distributed.Result) (string, string, bool, error) {
+	*p.requests++
+	return p.server.PublishResults(passChunk(ctx), nodeKey, results)
+}
+
+// passChunk round trips chunk information through its header encoding.
+func passChunk(ctx context.Context) context.Context {
+	c, ok := chunkFromContext(ctx)
+	if !ok {
+		return context.Background()
+	}
+	parsed, err := parseChunkInfo(c.String())
+	if err != nil {
+		panic(err)
+	}
+	return contextWithChunk(context.Background(), parsed)
+}
+
+func TestChunkedLogs(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingService{}
+	server := ChunkReassemblyMiddleware(log.NewNopLogger())(recorder)
+	var requests int
+	client := chunkingMiddleware(10, &chunkNegotiation{accepted: 1}, log.NewNopLogger())(chunkPipe{server: server, requests: &requests})
+
+	big := strings.Repeat("ab", 12) + "ünïcödé"
+	_, _, _, err := client.PublishLogs(context.Background(), "", logger.LogTypeString, []string{"small", big, "tiny"})
+	require.NoError(t, err)
+
+	require.Equal(t, [][]string{{"small", "tiny"}, {big}}, recorder.logs)
+	require.Greater(t, requests, 3, "big log is sent in several requests")
+}
+
+func TestChunkedResults(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingService{}
+	server := ChunkReassemblyMiddleware(log.NewNopLogger())(recorder)
+	var requests int
+	client := chunkingMiddleware(200, &chunkNegotiation{accepted: 1}, log.NewNopLogger())(chunkPipe{server: server, requests: &requests})
+
+	var rows []map[string]string
+	for i := 0; i < 20; i++ {
+		rows = append(rows, map[string]string{"name": strings.Repeat("x", 20)})
+	}
+	big := distributed.Result{QueryName: "big", Rows: rows}
+	tooBig := map[string]string{"name": strings.Repeat("y", 300)}
+	bigWithHugeRow := distributed.Result{QueryName: "huge", Rows: append([]map[string]string{tooBig}, rows[:2]...)}
+	small := distributed.Result{QueryName: "small", Rows: rows[:1]}
+
+	before := Stats()
+	_, _, _, err := client.PublishResults(context.Background(), "", []distributed.Result{small, big, bigWithHugeRow})
+	require.NoError(t, err)
+
+	require.Equal(t, [][]distributed.Result{
+		{small},
+		{big},
+		{{QueryName: "huge", Rows: rows[:2]}},
+	}, recorder.results)
+
+	after := Stats()
+	require.GreaterOrEqual(t, after.ResultsSplit-before.ResultsSplit, uint64(2))
+	require.GreaterOrEqual(t, after.RowsDropped-before.RowsDropped, uint64(1))
+}
+
+func TestChunkingRequiresNegotiation(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingService{}
+	var requests int
+	negotiation := &chunkNegotiation{}
+	client := chunkingMiddleware(10, negotiation, log.NewNopLogger())(chunkPipe{server: recorder, requests: &requests})
+
+	// Until the server advertises chunking, logs it couldn't take are
+	// dropped rather than sent whole
+	big := strings.Repeat("x", 25)
+	before := Stats()
+	_, _, _, err := client.PublishLogs(context.Background(), "", logger.LogTypeString, []string{"small", big})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"small"}}, recorder.logs)
+	require.Equal(t, 1, requests)
+	require.GreaterOrEqual(t, Stats().LogsDropped-before.LogsDropped, uint64(1))
+
+	// A request of only oversized logs isn't sent at all
+	_, _, _, err = client.PublishLogs(context.Background(), "", logger.LogTypeString, []string{big})
+	require.NoError(t, err)
+	require.Equal(t, 1, requests)
+
+	// A response which doesn't advertise it doesn't change that
+	negotiation.observe(false)
+	require.False(t, negotiation.accepts())
+
+	negotiation.observe(true)
+	require.True(t, negotiation.accepts())
+	_, _, _, err = client.PublishLogs(context.Background(), "", logger.LogTypeString, []string{big})
+	require.NoError(t, err)
+	require.Greater(t, requests, 2, "big log is sent in several requests")
+}
+
+func TestChunkReassemblyAdvertises(t *testing.T) {
+	t.Parallel()
+
+	server := ChunkReassemblyMiddleware(log.NewNopLogger())(&fakeHTTPService{})
+
+	// Contexts without room for the advertisement are left alone
+	_, err := server.CheckHealth(context.Background())
+	require.NoError(t, err)
+
+	ctx := parseHTTPChunk(context.Background(), httptest.NewRequest(http.MethodPost, "/", nil))
+	require.False(t, chunksAdvertised(ctx))
+	_, err = server.CheckHealth(ctx)
+	require.NoError(t, err)
+	require.True(t, chunksAdvertised(ctx))
+
+	w := httptest.NewRecorder()
+	advertiseHTTPChunks(ctx, w)
+	require.NotEmpty(t, w.Header().Get(chunkAcceptHeader))
+}
+
+func TestChunkReassemblyExpires(t *testing.T) {
+	t.Parallel()
+
+	recorder := &recordingService{}
+	mw := ChunkReassemblyMiddleware(log.NewNopLogger())(recorder).(*reassemblymw)
+	now := time.Now()
+	mw.now = func() time.Time { return now }
+
+	ctx := contextWithChunk(context.Background(), chunkInfo{ID: "stale", Index: 0, Count: 2})
+	_, _, _, err := mw.PublishLogs(ctx, "", logger.LogTypeString, []string{"first half"})
+	require.NoError(t, err)
+
+	now = now.Add(chunkTimeout + time.Second)
+	ctx = contextWithChunk(context.Background(), chunkInfo{ID: "fresh", Index: 0, Count: 1})
+	_, _, _, err = mw.PublishLogs(ctx, "", logger.LogTypeString, []string{"whole"})
+	require.NoError(t, err)
+
+	require.Equal(t, [][]string{{"whole"}}, recorder.logs)
+	require.Empty(t, mw.partials)
+}
+
+func TestParseChunkInfo(t *testing.T) {
+	t.Parallel()
+
+	c, err := parseChunkInfo("abc/1/3")
+	require.NoError(t, err)
+	require.Equal(t, chunkInfo{ID: "abc", Index: 1, Count: 3}, c)
+
+	for _, bad := range []string{"", "abc", "abc/3/3", "abc/-1/3", "/0/1", "abc/x/1"} {
+		_, err := parseChunkInfo(bad)
+		require.Error(t, err, bad)
+	}
+}
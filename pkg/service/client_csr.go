@@ -0,0 +1,104 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/kolide/launcher/pkg/proxy"
+	"github.com/pkg/errors"
+)
+
+// httpCSRPath is where certificate signing requests are sent. It's part
+// of the REST API, but serves launcher whichever transport it uses.
+const httpCSRPath = "/api/v1/agent/csr"
+
+type csrRequest struct {
+	NodeKey string `json:"node_key"`
+	CSR     string `json:"csr"`
+}
+
+type csrResponse struct {
+	Certificate string `json:"certificate"`
+	NodeInvalid bool   `json:"node_invalid"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+// CSRSigner has the server sign certificate requests, to obtain client
+// certificates for mutual TLS. The node key authenticates the request.
+type CSRSigner struct {
+	client *http.Client
+	url    string
+}
+
+// NewCSRSigner creates a CSRSigner for the server at serverURL. Its
+// connections never present a client certificate, as they're how one is
+// obtained.
+func NewCSRSigner(
+	serverURL string,
+	insecureTLS bool,
+	insecureTransport bool,
+	tlsSettings *TLSSettings,
+	logger log.Logger,
+) *CSRSigner {
+	csrURL := &url.URL{
+		Scheme: "https",
+		Host:   serverURL,
+		Path:   httpCSRPath,
+	}
+
+	if insecureTransport {
+		csrURL.Scheme = "http"
+	}
+
+	transport := proxy.NewTransport()
+	if !insecureTransport {
+		transport.TLSClientConfig = makeTLSConfig(csrURL.Hostname(), insecureTLS, tlsSettings, nil, logger)
+	}
+
+	return &CSRSigner{
+		client: &http.Client{
+			Timeout:   time.Second * 30,
+			Transport: transport,
+		},
+		url: csrURL.String(),
+	}
+}
+
+// SignCSR sends a PEM encoded certificate request, returning the PEM
+// encoded certificate chain the server issued.
+func (s *CSRSigner) SignCSR(ctx context.Context, nodeKey string, csrPEM []byte) ([]byte, error) {
+	body, err := json.Marshal(csrRequest{NodeKey: nodeKey, CSR: string(csrPEM)})
+	if err != nil {
+		return nil, errors.Wrap(err, "encoding csr request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "creating csr request")
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "sending csr")
+	}
+	defer resp.Body.Close()
+
+	var csrResp csrResponse
+	if err := decodeHTTPJSONResponse(resp, &csrResp); err != nil {
+		return nil, err
+	}
+	if csrResp.NodeInvalid {
+		return nil, errors.New("csr refused, node key invalid")
+	}
+	if csrResp.Certificate == "" {
+		return nil, errors.Errorf("no certificate issued: %s", csrResp.ErrorCode)
+	}
+
+	return []byte(csrResp.Certificate), nil
+}
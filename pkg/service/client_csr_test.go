@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRSigner(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, httpCSRPath, r.URL.Path)
+
+		var req csrRequest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		if req.NodeKey != "node_key" {
+			json.NewEncoder(w).Encode(csrResponse{NodeInvalid: true})
+			return
+		}
+		json.NewEncoder(w).Encode(csrResponse{Certificate: "signed " + req.CSR})
+	}))
+	defer server.Close()
+
+	signer := NewCSRSigner(strings.TrimPrefix(server.URL, "http://"), false, true, nil, log.NewNopLogger())
+
+	cert, err := signer.SignCSR(context.Background(), "node_key", []byte("csr"))
+	require.NoError(t, err)
+	require.Equal(t, "signed csr", string(cert))
+
+	_, err = signer.SignCSR(context.Background(), "stale_key", []byte("csr"))
+	require.Error(t, err)
+}
+
+func TestClientCertificatePresented(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"status": 1})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(server.Certificate())
+	serverURL := strings.TrimPrefix(server.URL, "https://")
+
+	// Without a client certificate the server refuses the request.
+	client := NewHTTPClient(serverURL, false, false, NewTLSSettings(nil, rootPool), nil, log.NewNopLogger())
+	_, err := client.CheckHealth(context.Background())
+	require.Error(t, err)
+
+	// The test server's own certificate serves as a client certificate.
+	serverCert := server.TLS.Certificates[0]
+	client = NewHTTPClient(serverURL, false, false, NewTLSSettings(nil, rootPool), func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		return &serverCert, nil
+	}, log.NewNopLogger())
+	status, err := client.CheckHealth(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), status)
+}
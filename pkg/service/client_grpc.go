@@ -2,15 +2,16 @@ package service
 
 import (
 	"context"
-	"crypto/x509"
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	grpctransport "github.com/go-kit/kit/transport/grpc"
 	"github.com/kolide/kit/contexts/uuid"
+	"github.com/kolide/launcher/pkg/proxy"
 	"github.com/pkg/errors"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/credentials"
@@ -18,9 +19,17 @@ import (
 	pb "github.com/kolide/launcher/pkg/pb/launcher"
 )
 
+// grpcMaxChunkBytes keeps requests under gRPC's default 4MB message limit.
+// The limit applies after decompression, so compression doesn't raise it.
+const grpcMaxChunkBytes = 3 << 20
+
 // New creates a new Kolide Client (implementation of the KolideService
 // interface) using the provided gRPC client connection.
 func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
+	// Shared by the endpoints, which see whether the server reassembles
+	// chunks, and the chunking middleware
+	chunks := &chunkNegotiation{}
+
 	requestEnrollmentEndpoint := grpctransport.NewClient(
 		conn,
 		"kolide.agent.Api",
@@ -29,6 +38,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCEnrollmentResponse,
 		pb.EnrollmentResponse{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
 	).Endpoint()
 
 	requestConfigEndpoint := grpctransport.NewClient(
@@ -39,6 +49,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCConfigResponse,
 		pb.ConfigResponse{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
 	).Endpoint()
 
 	publishLogsEndpoint := grpctransport.NewClient(
@@ -49,6 +60,8 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCPublishLogsResponse,
 		pb.AgentApiResponse{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
+		attachGRPCChunk(),
 	).Endpoint()
 
 	requestQueriesEndpoint := grpctransport.NewClient(
@@ -59,6 +72,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCQueryCollection,
 		pb.QueryCollection{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
 	).Endpoint()
 
 	publishResultsEndpoint := grpctransport.NewClient(
@@ -69,6 +83,8 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCPublishResultsResponse,
 		pb.AgentApiResponse{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
+		attachGRPCChunk(),
 	).Endpoint()
 
 	checkHealthEndpoint := grpctransport.NewClient(
@@ -79,6 +95,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		decodeGRPCHealthCheckResponse,
 		pb.HealthCheckResponse{},
 		uuid.Attach(),
+		chunks.readGRPCChunkAccept(),
 	).Endpoint()
 
 	var client KolideService = Endpoints{
@@ -90,6 +107,7 @@ func NewGRPCClient(conn *grpc.ClientConn, logger log.Logger) KolideService {
 		CheckHealthEndpoint:       checkHealthEndpoint,
 	}
 
+	client = chunkingMiddleware(grpcMaxChunkBytes, chunks, logger)(client)
 	client = LoggingMiddleware(logger)(client)
 	// Wrap with UUID middleware after logger so that UUID is available in
 	// the logger context.
@@ -103,8 +121,8 @@ func DialGRPC(
 	serverURL string,
 	insecureTLS bool,
 	insecureTransport bool,
-	certPins [][]byte,
-	rootPool *x509.CertPool,
+	tlsSettings *TLSSettings,
+	clientCert ClientCertificateFunc,
 	logger log.Logger,
 	opts ...grpc.DialOption, // Used for overrides in testing
 ) (*grpc.ClientConn, error) {
@@ -113,10 +131,12 @@ func DialGRPC(
 		"server", serverURL,
 		"tls_secure", insecureTLS == false,
 		"transport_secure", insecureTransport == false,
-		"cert_pinning", len(certPins) > 0,
+		"client_cert", clientCert != nil,
 	)
 	grpcOpts := []grpc.DialOption{
 		grpc.WithTimeout(time.Second),
+		grpc.WithUnaryInterceptor((&compressionInterceptor{}).intercept),
+		grpc.WithContextDialer(proxy.DialGRPC),
 	}
 	if insecureTransport {
 		grpcOpts = append(grpcOpts, grpc.WithInsecure())
@@ -126,7 +146,12 @@ func DialGRPC(
 			return nil, errors.Wrapf(err, "split grpc server host and port: %s", serverURL)
 		}
 
-		creds := &tlsCreds{credentials.NewTLS(makeTLSConfig(host, insecureTLS, certPins, rootPool, logger))}
+		var creds credentials.TransportCredentials = &tlsCreds{credentials.NewTLS(makeTLSConfig(host, insecureTLS, tlsSettings, clientCert, logger))}
+		if tlsSettings != nil {
+			reconnecting := &reconnectingCreds{TransportCredentials: creds, conns: &connSet{}}
+			tlsSettings.onUpdate(reconnecting.conns.closeAll)
+			creds = reconnecting
+		}
 		grpcOpts = append(grpcOpts, grpc.WithTransportCredentials(creds))
 	}
 
@@ -165,3 +190,72 @@ func (t *tlsCreds) ClientHandshake(ctx context.Context, s string, c net.Conn) (n
 
 	return conn, info, err
 }
+
+// reconnectingCreds keeps track of the connections it secures, so that
+// they can be closed when the TLS settings change. gRPC then reconnects,
+// verifying the server with the new settings. Calls in flight on a closed
+// connection fail, and are retried by their callers as usual.
+type reconnectingCreds struct {
+	credentials.TransportCredentials
+	conns *connSet
+}
+
+func (r *reconnectingCreds) ClientHandshake(ctx context.Context, s string, c net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := r.TransportCredentials.ClientHandshake(ctx, s, c)
+	if err != nil {
+		return conn, info, err
+	}
+	return r.conns.add(conn), info, nil
+}
+
+func (r *reconnectingCreds) Clone() credentials.TransportCredentials {
+	return &reconnectingCreds{TransportCredentials: r.TransportCredentials.Clone(), conns: r.conns}
+}
+
+// connSet is a set of open connections.
+type connSet struct {
+	lock  sync.Mutex
+	conns map[*trackedConn]struct{}
+}
+
+func (s *connSet) add(conn net.Conn) net.Conn {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conns == nil {
+		s.conns = make(map[*trackedConn]struct{})
+	}
+	tracked := &trackedConn{Conn: conn, set: s}
+	s.conns[tracked] = struct{}{}
+	return tracked
+}
+
+func (s *connSet) remove(conn *trackedConn) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.conns, conn)
+}
+
+func (s *connSet) closeAll() {
+	s.lock.Lock()
+	conns := make([]*trackedConn, 0, len(s.conns))
+	for conn := range s.conns {
+		conns = append(conns, conn)
+	}
+	s.lock.Unlock()
+
+	for _, conn := range conns {
+		conn.Close()
+	}
+}
+
+// trackedConn removes itself from its set when closed.
+type trackedConn struct {
+	net.Conn
+	set *connSet
+}
+
+func (c *trackedConn) Close() error {
+	c.set.remove(c)
+	return c.Conn.Close()
+}
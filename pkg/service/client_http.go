@@ -0,0 +1,124 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/kolide/launcher/pkg/proxy"
+	"github.com/pkg/errors"
+)
+
+// httpMaxChunkBytes is the largest payload sent in a single REST request.
+const httpMaxChunkBytes = 5 << 20
+
+// NewHTTPClient creates a new Kolide Client (implementation of the
+// KolideService interface) using the REST transport. See NewHTTPServer.
+func NewHTTPClient(
+	serverURL string,
+	insecureTLS bool,
+	insecureTransport bool,
+	tlsSettings *TLSSettings,
+	clientCert ClientCertificateFunc,
+	logger log.Logger,
+	options ...httptransport.ClientOption,
+) KolideService {
+	baseURL := &url.URL{
+		Scheme: "https",
+		Host:   serverURL,
+	}
+
+	if insecureTransport {
+		baseURL.Scheme = "http"
+	}
+
+	transport := proxy.NewTransport()
+	if !insecureTransport {
+		transport.TLSClientConfig = makeTLSConfig(baseURL.Hostname(), insecureTLS, tlsSettings, clientCert, logger)
+		if tlsSettings != nil {
+			tlsSettings.onUpdate(transport.CloseIdleConnections)
+		}
+	}
+
+	httpClient := &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: newCompressingTransport(transport),
+	}
+
+	// Shared by the endpoints, which see whether the server reassembles
+	// chunks, and the chunking middleware
+	chunks := &chunkNegotiation{}
+
+	commonOpts := []httptransport.ClientOption{
+		httptransport.SetClient(httpClient),
+		httptransport.ClientBefore(attachHTTPChunk),
+		httptransport.ClientAfter(chunks.readHTTPChunkAccept),
+	}
+
+	commonOpts = append(commonOpts, options...)
+
+	newClient := func(method, path string, enc httptransport.EncodeRequestFunc, dec httptransport.DecodeResponseFunc) endpoint.Endpoint {
+		return httptransport.NewClient(
+			method,
+			baseURL.ResolveReference(&url.URL{Path: path}),
+			enc,
+			dec,
+			commonOpts...,
+		).Endpoint()
+	}
+
+	var client KolideService = Endpoints{
+		RequestEnrollmentEndpoint: newClient("POST", httpEnrollPath, encodeHTTPEnrollmentRequest, decodeHTTPEnrollmentResponse),
+		RequestConfigEndpoint:     newClient("POST", httpConfigPath, encodeHTTPConfigRequest, decodeHTTPConfigResponse),
+		PublishLogsEndpoint:       newClient("POST", httpLogsPath, encodeHTTPLogCollection, decodeHTTPPublishLogsResponse),
+		RequestQueriesEndpoint:    newClient("POST", httpQueriesPath, encodeHTTPQueriesRequest, decodeHTTPQueryCollection),
+		PublishResultsEndpoint:    newClient("POST", httpResultsPath, encodeHTTPResultCollection, decodeHTTPPublishResultsResponse),
+		CheckHealthEndpoint:       newClient("GET", httpHealthPath, encodeHTTPHealthCheckRequest, decodeHTTPHealthCheckResponse),
+	}
+
+	client = chunkingMiddleware(httpMaxChunkBytes, chunks, logger)(client)
+	client = LoggingMiddleware(logger)(client)
+	// Wrap with UUID middleware after logger so that UUID is available in
+	// the logger context.
+	client = uuidMiddleware(client)
+
+	return client
+}
+
+// encodeHTTPJSONRequest sets v as a REST request body. The content length
+// is always set, so the body is never sent with chunked encoding.
+func encodeHTTPJSONRequest(r *http.Request, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return errors.Wrap(err, "encoding request body")
+	}
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	r.ContentLength = int64(len(b))
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	return nil
+}
+
+// decodeHTTPJSONResponse unmarshals a REST response body into v, or
+// returns the error the server sent in its place.
+func decodeHTTPJSONResponse(r *http.Response, v interface{}) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading response body")
+	}
+
+	if r.StatusCode != http.StatusOK {
+		var herr httpError
+		if err := json.Unmarshal(body, &herr); err != nil || herr.Error == "" {
+			return errors.Errorf("request failed with status %d", r.StatusCode)
+		}
+		return errors.Errorf("request failed with status %d: %s", r.StatusCode, herr.Error)
+	}
+
+	return errors.Wrap(json.Unmarshal(body, v), "unmarshalling response body")
+}
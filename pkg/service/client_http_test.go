@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ghodss/yaml"
+	"github.com/go-kit/kit/log"
+	"github.com/osquery/osquery-go/plugin/distributed"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHTTPService struct {
+	recordingService
+	enrollDetails EnrollmentDetails
+	logTypes      []logger.LogType
+}
+
+func (f *fakeHTTPService) RequestEnrollment(ctx context.Context, enrollSecret, hostIdentifier string, details EnrollmentDetails) (string, bool, error) {
+	f.enrollDetails = details
+	if enrollSecret != "secret" {
+		return "", true, nil
+	}
+	return "node_key", false, nil
+}
+
+func (f *fakeHTTPService) RequestConfig(ctx context.Context, nodeKey string) (string, bool, error) {
+	if nodeKey != "node_key" {
+		return "", false, errors.New("config unavailable")
+	}
+	return `{"schedule":{}}`, false, nil
+}
+
+func (f *fakeHTTPService) PublishLogs(ctx context.Context, nodeKey string, logType logger.LogType, logs []string) (string, string, bool, error) {
+	f.logTypes = append(f.logTypes, logType)
+	return f.recordingService.PublishLogs(ctx, nodeKey, logType, logs)
+}
+
+func (f *fakeHTTPService) RequestQueries(ctx context.Context, nodeKey string) (*distributed.GetQueriesResult, bool, error) {
+	return &distributed.GetQueriesResult{Queries: map[string]string{"id1": "select 1"}}, false, nil
+}
+
+func (f *fakeHTTPService) CheckHealth(ctx context.Context) (int32, error) {
+	return 1, nil
+}
+
+func TestHTTPTransport(t *testing.T) {
+	t.Parallel()
+
+	svc := &fakeHTTPService{}
+	handler := NewHTTPServer(MakeServerEndpoints(ChunkReassemblyMiddleware(log.NewNopLogger())(svc)), log.NewNopLogger())
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	client := NewHTTPClient(strings.TrimPrefix(server.URL, "http://"), false, true, nil, nil, log.NewNopLogger())
+	ctx := context.Background()
+
+	nodeKey, invalid, err := client.RequestEnrollment(ctx, "secret", "host", EnrollmentDetails{Hostname: "myhost"})
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.Equal(t, "node_key", nodeKey)
+	require.Equal(t, "myhost", svc.enrollDetails.Hostname)
+
+	_, invalid, err = client.RequestEnrollment(ctx, "wrong", "host", EnrollmentDetails{})
+	require.NoError(t, err)
+	require.True(t, invalid)
+
+	config, _, err := client.RequestConfig(ctx, nodeKey)
+	require.NoError(t, err)
+	require.Equal(t, `{"schedule":{}}`, config)
+
+	_, _, err = client.RequestConfig(ctx, "other")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "config unavailable")
+
+	bigLog := strings.Repeat("a", httpMaxChunkBytes+10)
+	_, _, _, err = client.PublishLogs(ctx, nodeKey, logger.LogTypeStatus, []string{"status", bigLog})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"status"}, {bigLog}}, svc.logs)
+	require.Equal(t, logger.LogTypeStatus, svc.logTypes[0])
+
+	queries, _, err := client.RequestQueries(ctx, nodeKey)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"id1": "select 1"}, queries.Queries)
+
+	results := []distributed.Result{{QueryName: "id1", Rows: []map[string]string{{"1": "1"}}}}
+	_, _, _, err = client.PublishResults(ctx, nodeKey, results)
+	require.NoError(t, err)
+	require.Equal(t, [][]distributed.Result{results}, svc.results)
+
+	status, err := client.CheckHealth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), status)
+}
+
+func TestHTTPTransportWithoutReassembly(t *testing.T) {
+	t.Parallel()
+
+	svc := &fakeHTTPService{}
+	server := httptest.NewServer(NewHTTPServer(MakeServerEndpoints(svc), log.NewNopLogger()))
+	defer server.Close()
+
+	client := NewHTTPClient(strings.TrimPrefix(server.URL, "http://"), false, true, nil, nil, log.NewNopLogger())
+	ctx := context.Background()
+
+	_, err := client.CheckHealth(ctx)
+	require.NoError(t, err)
+
+	// The server never advertised chunking, so the big log is dropped
+	bigLog := strings.Repeat("a", httpMaxChunkBytes+10)
+	_, _, _, err = client.PublishLogs(ctx, "node_key", logger.LogTypeStatus, []string{"status", bigLog})
+	require.NoError(t, err)
+	require.Equal(t, [][]string{{"status"}}, svc.logs)
+}
+
+func TestHTTPServerErrors(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewServer(NewHTTPServer(MakeServerEndpoints(&fakeHTTPService{}), log.NewNopLogger()))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+httpLogsPath, "application/json", strings.NewReader(`{"log_type": "bogus"}`))
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + httpLogsPath)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
+
+func TestOpenAPISchema(t *testing.T) {
+	t.Parallel()
+
+	var schema struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	require.NoError(t, yaml.Unmarshal(OpenAPISchema, &schema))
+
+	for _, path := range []string{httpEnrollPath, httpConfigPath, httpLogsPath, httpQueriesPath, httpResultsPath, httpHealthPath, httpOpenAPIPath, httpCSRPath} {
+		require.Contains(t, schema.Paths, path)
+	}
+}
@@ -3,7 +3,6 @@ package service
 import (
 	"bytes"
 	"context"
-	"crypto/x509"
 	"io"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +11,7 @@ import (
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport/http/jsonrpc"
+	"github.com/kolide/launcher/pkg/proxy"
 )
 
 // forceNoChunkedEncoding forces the connection not to use chunked
@@ -38,14 +38,18 @@ func forceNoChunkedEncoding(ctx context.Context, r *http.Request) context.Contex
 	return ctx
 }
 
+// jsonrpcMaxChunkBytes is the largest payload sent in a single JSON-RPC
+// request.
+const jsonrpcMaxChunkBytes = 5 << 20
+
 // New creates a new Kolide Client (implementation of the KolideService
 // interface) using a JSONRPC client connection.
 func NewJSONRPCClient(
 	serverURL string,
 	insecureTLS bool,
 	insecureTransport bool,
-	certPins [][]byte,
-	rootPool *x509.CertPool,
+	tlsSettings *TLSSettings,
+	clientCert ClientCertificateFunc,
 	logger log.Logger,
 	options ...jsonrpc.ClientOption,
 ) KolideService {
@@ -58,24 +62,32 @@ func NewJSONRPCClient(
 		serviceURL.Scheme = "http"
 	}
 
-	httpClient := &http.Client{
-		Timeout: time.Second * 30,
-		Transport: &http.Transport{
-			DisableKeepAlives: true,
-		},
-	}
+	transport := proxy.NewTransport()
+	transport.DisableKeepAlives = true
 	if !insecureTransport {
-		tlsConfig := makeTLSConfig(serverURL, insecureTLS, certPins, rootPool, logger)
-		httpClient.Transport = &http.Transport{
-			TLSClientConfig:   tlsConfig,
-			DisableKeepAlives: true,
+		transport.TLSClientConfig = makeTLSConfig(serverURL, insecureTLS, tlsSettings, clientCert, logger)
+		if tlsSettings != nil {
+			tlsSettings.onUpdate(transport.CloseIdleConnections)
 		}
 	}
 
+	httpClient := &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: newCompressingTransport(transport),
+	}
+
+	// Shared by the endpoints, which see whether the server reassembles
+	// chunks, and the chunking middleware
+	chunks := &chunkNegotiation{}
+
 	commonOpts := []jsonrpc.ClientOption{
 		jsonrpc.SetClient(httpClient),
 		jsonrpc.ClientBefore(
 			forceNoChunkedEncoding,
+			attachHTTPChunk,
+		),
+		jsonrpc.ClientAfter(
+			chunks.readHTTPChunkAccept,
 		),
 	}
 
@@ -126,6 +138,7 @@ func NewJSONRPCClient(
 		CheckHealthEndpoint:       checkHealthEndpoint,
 	}
 
+	client = chunkingMiddleware(jsonrpcMaxChunkBytes, chunks, logger)(client)
 	client = LoggingMiddleware(logger)(client)
 	// Wrap with UUID middleware after logger so that UUID is available in
 	// the logger context.
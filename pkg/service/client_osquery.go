@@ -0,0 +1,319 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-kit/kit/endpoint"
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/kolide/launcher/pkg/proxy"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/pkg/errors"
+)
+
+// OsqueryTLSEndpoints are the paths of the osquery TLS remote API, as
+// would otherwise be passed to osqueryd's --*_tls_endpoint flags.
+type OsqueryTLSEndpoints struct {
+	Enroll           string
+	Config           string
+	Logger           string
+	DistributedRead  string
+	DistributedWrite string
+}
+
+// NewOsqueryTLSClient creates a new Kolide Client (implementation of the
+// KolideService interface) that speaks the osquery TLS remote API. See
+// https://osquery.readthedocs.io/en/stable/deployment/remote/
+func NewOsqueryTLSClient(
+	serverURL string,
+	insecureTLS bool,
+	insecureTransport bool,
+	tlsSettings *TLSSettings,
+	clientCert ClientCertificateFunc,
+	endpoints OsqueryTLSEndpoints,
+	logger log.Logger,
+) KolideService {
+	baseURL := &url.URL{
+		Scheme: "https",
+		Host:   serverURL,
+	}
+
+	if insecureTransport {
+		baseURL.Scheme = "http"
+	}
+
+	transport := proxy.NewTransport()
+	if !insecureTransport {
+		host := serverURL
+		if h, _, err := net.SplitHostPort(serverURL); err == nil {
+			host = h
+		}
+		transport.TLSClientConfig = makeTLSConfig(host, insecureTLS, tlsSettings, clientCert, logger)
+		if tlsSettings != nil {
+			tlsSettings.onUpdate(transport.CloseIdleConnections)
+		}
+	}
+
+	httpClient := &http.Client{
+		Timeout:   time.Second * 30,
+		Transport: newCompressingTransport(transport),
+	}
+
+	newClient := func(path string, dec httptransport.DecodeResponseFunc) endpoint.Endpoint {
+		return httptransport.NewClient(
+			"POST",
+			baseURL.ResolveReference(&url.URL{Path: path}),
+			encodeOsqueryTLSRequest,
+			dec,
+			httptransport.SetClient(httpClient),
+		).Endpoint()
+	}
+
+	var client KolideService = Endpoints{
+		RequestEnrollmentEndpoint: newClient(endpoints.Enroll, decodeOsqueryTLSEnrollmentResponse),
+		RequestConfigEndpoint:     newClient(endpoints.Config, decodeOsqueryTLSConfigResponse),
+		PublishLogsEndpoint:       newClient(endpoints.Logger, decodeOsqueryTLSPublishLogsResponse),
+		RequestQueriesEndpoint:    newClient(endpoints.DistributedRead, decodeOsqueryTLSQueryCollection),
+		PublishResultsEndpoint:    newClient(endpoints.DistributedWrite, decodeOsqueryTLSPublishResultsResponse),
+		CheckHealthEndpoint: httptransport.NewClient(
+			"HEAD",
+			baseURL,
+			encodeOsqueryTLSHealthCheckRequest,
+			decodeOsqueryTLSHealthCheckResponse,
+			httptransport.SetClient(httpClient),
+		).Endpoint(),
+	}
+
+	client = LoggingMiddleware(logger)(client)
+	// Wrap with UUID middleware after logger so that UUID is available in
+	// the logger context.
+	client = uuidMiddleware(client)
+
+	return client
+}
+
+// osqueryTLSEnrollmentRequest is the body of an osquery TLS enroll
+// request. host_details mirrors the tables osqueryd would send.
+type osqueryTLSEnrollmentRequest struct {
+	EnrollSecret   string                       `json:"enroll_secret"`
+	HostIdentifier string                       `json:"host_identifier"`
+	HostDetails    map[string]map[string]string `json:"host_details"`
+}
+
+type osqueryTLSNodeRequest struct {
+	NodeKey string `json:"node_key"`
+}
+
+type osqueryTLSLogRequest struct {
+	NodeKey string            `json:"node_key"`
+	LogType string            `json:"log_type"`
+	Data    []json.RawMessage `json:"data"`
+}
+
+type osqueryTLSDistributedWriteRequest struct {
+	NodeKey  string                         `json:"node_key"`
+	Queries  map[string][]map[string]string `json:"queries"`
+	Statuses map[string]int                 `json:"statuses"`
+}
+
+// osqueryTLSResponse holds the fields common to osquery TLS responses.
+type osqueryTLSResponse struct {
+	NodeKey     string `json:"node_key"`
+	NodeInvalid bool   `json:"node_invalid"`
+	Error       string `json:"error"`
+}
+
+// encodeOsqueryTLSRequest translates the launcher's request types into
+// their osquery TLS equivalents.
+func encodeOsqueryTLSRequest(ctx context.Context, r *http.Request, request interface{}) error {
+	var body interface{}
+	switch req := request.(type) {
+	case enrollmentRequest:
+		d := req.EnrollmentDetails
+		body = osqueryTLSEnrollmentRequest{
+			EnrollSecret:   req.EnrollSecret,
+			HostIdentifier: req.HostIdentifier,
+			HostDetails: map[string]map[string]string{
+				"os_version": {
+					"version":       d.OSVersion,
+					"build":         d.OSBuildID,
+					"platform":      d.OSPlatform,
+					"name":          d.OSName,
+					"platform_like": d.OSPlatformLike,
+				},
+				"osquery_info": {
+					"version": d.OsqueryVersion,
+				},
+				"system_info": {
+					"hostname":        d.Hostname,
+					"hardware_vendor": d.HardwareVendor,
+					"hardware_model":  d.HardwareModel,
+					"hardware_serial": d.HardwareSerial,
+					"uuid":            d.HardwareUUID,
+				},
+				"launcher_info": {
+					"version": d.LauncherVersion,
+					"goos":    d.GOOS,
+					"goarch":  d.GOARCH,
+				},
+			},
+		}
+	case configRequest:
+		body = osqueryTLSNodeRequest{NodeKey: req.NodeKey}
+	case queriesRequest:
+		body = osqueryTLSNodeRequest{NodeKey: req.NodeKey}
+	case logCollection:
+		logType := "result"
+		if req.LogType == logger.LogTypeStatus {
+			logType = "status"
+		}
+		data := make([]json.RawMessage, 0, len(req.Logs))
+		for _, l := range req.Logs {
+			// osqueryd logs are JSON, and are sent as objects
+			// rather than strings. Anything else is quoted.
+			if json.Valid([]byte(l)) {
+				data = append(data, json.RawMessage(l))
+				continue
+			}
+			quoted, err := json.Marshal(l)
+			if err != nil {
+				return errors.Wrap(err, "quoting log")
+			}
+			data = append(data, quoted)
+		}
+		body = osqueryTLSLogRequest{NodeKey: req.NodeKey, LogType: logType, Data: data}
+	case resultCollection:
+		write := osqueryTLSDistributedWriteRequest{
+			NodeKey:  req.NodeKey,
+			Queries:  make(map[string][]map[string]string, len(req.Results)),
+			Statuses: make(map[string]int, len(req.Results)),
+		}
+		for _, result := range req.Results {
+			rows := result.Rows
+			if rows == nil {
+				rows = []map[string]string{}
+			}
+			write.Queries[result.QueryName] = rows
+			write.Statuses[result.QueryName] = result.Status
+		}
+		body = write
+	default:
+		return errors.Errorf("unsupported osquery tls request %T", request)
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		return errors.Wrap(err, "encoding osquery tls request")
+	}
+	r.Header.Set("Content-Type", "application/json")
+	r.ContentLength = int64(buf.Len())
+	r.Body = ioutil.NopCloser(&buf)
+	return nil
+}
+
+// readOsqueryTLSResponse reads the body of an osquery TLS response, and
+// the fields common to every response. Servers may signal an invalid node
+// key either with node_invalid, or with a 401.
+func readOsqueryTLSResponse(r *http.Response) ([]byte, osqueryTLSResponse, error) {
+	var common osqueryTLSResponse
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, common, errors.Wrap(err, "reading osquery tls response")
+	}
+
+	if r.StatusCode == http.StatusUnauthorized {
+		common.NodeInvalid = true
+		return body, common, nil
+	}
+	if r.StatusCode < 200 || r.StatusCode >= 300 {
+		return nil, common, errors.Errorf("osquery tls request failed with status %d: %s", r.StatusCode, truncate(body, 100))
+	}
+
+	if err := json.Unmarshal(body, &common); err != nil {
+		return nil, common, errors.Wrap(err, "unmarshalling osquery tls response")
+	}
+	return body, common, nil
+}
+
+func decodeOsqueryTLSEnrollmentResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	_, common, err := readOsqueryTLSResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	return enrollmentResponse{
+		NodeKey:     common.NodeKey,
+		NodeInvalid: common.NodeInvalid || common.NodeKey == "",
+	}, nil
+}
+
+func decodeOsqueryTLSConfigResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	body, common, err := readOsqueryTLSResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	if common.NodeInvalid {
+		return configResponse{NodeInvalid: true}, nil
+	}
+	// The entire response is the osquery config
+	return configResponse{ConfigJSONBlob: string(body)}, nil
+}
+
+func decodeOsqueryTLSPublishLogsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	_, common, err := readOsqueryTLSResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	return publishLogsResponse{NodeInvalid: common.NodeInvalid, Message: common.Error}, nil
+}
+
+func decodeOsqueryTLSQueryCollection(_ context.Context, r *http.Response) (interface{}, error) {
+	body, common, err := readOsqueryTLSResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	if common.NodeInvalid {
+		return queryCollectionResponse{NodeInvalid: true}, nil
+	}
+
+	var queries queryCollectionResponse
+	if err := json.Unmarshal(body, &queries.Queries); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling distributed queries")
+	}
+	return queries, nil
+}
+
+func decodeOsqueryTLSPublishResultsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	_, common, err := readOsqueryTLSResponse(r)
+	if err != nil {
+		return nil, err
+	}
+	return publishResultsResponse{NodeInvalid: common.NodeInvalid, Message: common.Error}, nil
+}
+
+func encodeOsqueryTLSHealthCheckRequest(_ context.Context, _ *http.Request, _ interface{}) error {
+	return nil
+}
+
+// decodeOsqueryTLSHealthCheckResponse treats any response from the server
+// as healthy. The osquery TLS API has no health endpoint.
+func decodeOsqueryTLSHealthCheckResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	if r.StatusCode >= 500 {
+		return healthcheckResponse{Status: 0}, nil
+	}
+	return healthcheckResponse{Status: 1}, nil
+}
+
+func truncate(b []byte, n int) string {
+	if len(b) > n {
+		return string(b[:n])
+	}
+	return string(b)
+}
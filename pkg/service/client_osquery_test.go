@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/osquery/osquery-go/plugin/distributed"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOsqueryTLSClient(t *testing.T) {
+	t.Parallel()
+
+	bodies := make(map[string]map[string]interface{})
+	mux := http.NewServeMux()
+	handle := func(path string, response interface{}) {
+		mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]interface{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			bodies[path] = body
+
+			if body["node_key"] == "stale_key" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			require.NoError(t, json.NewEncoder(w).Encode(response))
+		})
+	}
+	handle("/enroll", map[string]interface{}{"node_key": "node_key"})
+	handle("/config", map[string]interface{}{"schedule": map[string]interface{}{}})
+	handle("/log", map[string]interface{}{})
+	handle("/read", map[string]interface{}{"queries": map[string]string{"id1": "select 1"}})
+	handle("/write", map[string]interface{}{})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := NewOsqueryTLSClient(
+		strings.TrimPrefix(server.URL, "http://"), false, true, nil, nil,
+		OsqueryTLSEndpoints{
+			Enroll:           "/enroll",
+			Config:           "/config",
+			Logger:           "/log",
+			DistributedRead:  "/read",
+			DistributedWrite: "/write",
+		},
+		log.NewNopLogger(),
+	)
+	ctx := context.Background()
+
+	nodeKey, invalid, err := client.RequestEnrollment(ctx, "secret", "host", EnrollmentDetails{Hostname: "myhost"})
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.Equal(t, "node_key", nodeKey)
+	require.Equal(t, "secret", bodies["/enroll"]["enroll_secret"])
+	require.Equal(t, "myhost", bodies["/enroll"]["host_details"].(map[string]interface{})["system_info"].(map[string]interface{})["hostname"])
+
+	config, invalid, err := client.RequestConfig(ctx, nodeKey)
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.JSONEq(t, `{"schedule":{}}`, config)
+
+	_, _, invalid, err = client.PublishLogs(ctx, nodeKey, logger.LogTypeSnapshot, []string{`{"name":"q"}`, "not json"})
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.Equal(t, "result", bodies["/log"]["log_type"])
+	require.Equal(t, []interface{}{map[string]interface{}{"name": "q"}, "not json"}, bodies["/log"]["data"])
+
+	queries, invalid, err := client.RequestQueries(ctx, nodeKey)
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.Equal(t, map[string]string{"id1": "select 1"}, queries.Queries)
+
+	_, _, invalid, err = client.PublishResults(ctx, nodeKey, []distributed.Result{
+		{QueryName: "id1", Status: 0, Rows: []map[string]string{{"1": "1"}}},
+	})
+	require.NoError(t, err)
+	require.False(t, invalid)
+	require.Equal(t, map[string]interface{}{"id1": []interface{}{map[string]interface{}{"1": "1"}}}, bodies["/write"]["queries"])
+	require.Equal(t, map[string]interface{}{"id1": float64(0)}, bodies["/write"]["statuses"])
+
+	_, invalid, err = client.RequestConfig(ctx, "stale_key")
+	require.NoError(t, err)
+	require.True(t, invalid)
+
+	status, err := client.CheckHealth(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int32(1), status)
+}
@@ -0,0 +1,305 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor
+	"google.golang.org/grpc/status"
+)
+
+// Compression is negotiated with the server, rather than assumed, so that
+// launcher keeps working against servers that predate it. zstd is preferred,
+// and gzip is used with servers that only support that.
+const (
+	contentEncodingGzip = "gzip"
+	contentEncodingZstd = "zstd"
+
+	// minCompressBytes is the smallest request body worth compressing.
+	minCompressBytes = 1024
+
+	// maxZstdDecodedBytes bounds the memory a single zstd request can
+	// decompress to.
+	maxZstdDecodedBytes = 128 << 20
+)
+
+// compressionEncodings are the supported encodings, in order of preference.
+var compressionEncodings = []string{contentEncodingZstd, contentEncodingGzip}
+
+// zstdEncoder and zstdDecoder are shared, as EncodeAll and DecodeAll are safe
+// for concurrent use, and creating them is expensive.
+var (
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+func init() {
+	var err error
+	if zstdEncoder, err = zstd.NewWriter(nil); err != nil {
+		panic(errors.Wrap(err, "creating zstd encoder"))
+	}
+	if zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderMaxMemory(maxZstdDecodedBytes)); err != nil {
+		panic(errors.Wrap(err, "creating zstd decoder"))
+	}
+
+	// gRPC only ships a gzip compressor. Registering this one lets both
+	// the client and server side use zstd.
+	encoding.RegisterCompressor(zstdCompressor{})
+}
+
+// zstdCompressor is a gRPC encoding.Compressor for zstd.
+type zstdCompressor struct{}
+
+func (zstdCompressor) Name() string { return contentEncodingZstd }
+
+func (zstdCompressor) Compress(w io.Writer) (io.WriteCloser, error) {
+	return &zstdWriter{w: w}, nil
+}
+
+func (zstdCompressor) Decompress(r io.Reader) (io.Reader, error) {
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	data, err := zstdDecoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(data), nil
+}
+
+// zstdWriter buffers a message, and writes it compressed when closed.
+type zstdWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+func (z *zstdWriter) Write(p []byte) (int, error) {
+	return z.buf.Write(p)
+}
+
+func (z *zstdWriter) Close() error {
+	_, err := z.w.Write(zstdEncoder.EncodeAll(z.buf.Bytes(), nil))
+	return err
+}
+
+// compressedMethods are the gRPC methods whose payloads are compressed. The
+// rest are small enough that it isn't worth it.
+var compressedMethods = map[string]bool{
+	"/kolide.agent.Api/PublishLogs":    true,
+	"/kolide.agent.Api/PublishResults": true,
+}
+
+// compressionInterceptor is a grpc.UnaryClientInterceptor that compresses
+// PublishLogs and PublishResults requests. The gRPC protocol has no way to
+// ask the server what it supports up front, so the first compressed request
+// is the negotiation: if the server answers that it has no decompressor,
+// the request is retried with the next encoding in compressionEncodings,
+// and then uncompressed. The encoding settled on is used for the life of
+// the connection.
+type compressionInterceptor struct {
+	// next is the index, in compressionEncodings, of the encoding to try.
+	// Once it's past the end, requests aren't compressed.
+	next int32
+}
+
+func (c *compressionInterceptor) intercept(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if !compressedMethods[method] {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+
+	for {
+		i := atomic.LoadInt32(&c.next)
+		if int(i) >= len(compressionEncodings) {
+			return invoker(ctx, method, req, reply, cc, opts...)
+		}
+
+		err := invoker(ctx, method, req, reply, cc, append(opts, grpc.UseCompressor(compressionEncodings[i]))...)
+		if !isUnsupportedCompression(err) {
+			if err == nil {
+				recordCompressedRequest()
+			}
+			return err
+		}
+
+		atomic.CompareAndSwapInt32(&c.next, i, i+1)
+	}
+}
+
+// isUnsupportedCompression returns true if err is the error a gRPC server
+// returns when it can't decompress a request.
+func isUnsupportedCompression(err error) bool {
+	if err == nil {
+		return false
+	}
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unimplemented {
+		return false
+	}
+	return strings.Contains(st.Message(), "Decompressor is not installed")
+}
+
+// compressingTransport is a http.RoundTripper that compresses JSON-RPC
+// request bodies once the server has said it can accept them. Servers
+// advertise support with an Accept-Encoding response header (see RFC 7694),
+// and the most preferred of compressionEncodings they list is used. A server
+// that later refuses a compressed body with a 415 causes the request to be
+// retried uncompressed, and compression to be turned off until the server
+// advertises it again.
+type compressingTransport struct {
+	base http.RoundTripper
+
+	// accepted is one more than the index, in compressionEncodings, of the
+	// encoding the server advertised, or 0 if it hasn't advertised one.
+	accepted int32
+}
+
+func newCompressingTransport(base http.RoundTripper) *compressingTransport {
+	return &compressingTransport{base: base}
+}
+
+func (t *compressingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	accepted := atomic.LoadInt32(&t.accepted)
+	if accepted == 0 || r.Body == nil || r.ContentLength < minCompressBytes {
+		return t.roundTrip(r)
+	}
+	contentEncoding := compressionEncodings[accepted-1]
+
+	body, err := ioutil.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		return nil, errors.Wrap(err, "reading request body")
+	}
+
+	compressed, err := compressBytes(contentEncoding, body)
+	if err != nil {
+		return nil, errors.Wrap(err, "compressing request body")
+	}
+
+	creq := r.Clone(r.Context())
+	creq.Header.Set("Content-Encoding", contentEncoding)
+	creq.ContentLength = int64(len(compressed))
+	creq.Body = ioutil.NopCloser(bytes.NewReader(compressed))
+
+	resp, err := t.roundTrip(creq)
+	if err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		if err == nil {
+			recordCompressedRequest()
+		}
+		return resp, err
+	}
+	resp.Body.Close()
+
+	atomic.StoreInt32(&t.accepted, 0)
+	r.ContentLength = int64(len(body))
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return t.roundTrip(r)
+}
+
+func (t *compressingTransport) roundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	for i, e := range compressionEncodings {
+		if acceptsEncoding(resp.Header, e) {
+			atomic.StoreInt32(&t.accepted, int32(i+1))
+			break
+		}
+	}
+	return resp, nil
+}
+
+// acceptsEncoding returns true if the Accept-Encoding header lists the given
+// encoding.
+func acceptsEncoding(h http.Header, encoding string) bool {
+	for _, value := range h.Values("Accept-Encoding") {
+		for _, e := range strings.Split(value, ",") {
+			if i := strings.Index(e, ";"); i != -1 {
+				e = e[:i]
+			}
+			if strings.EqualFold(strings.TrimSpace(e), encoding) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// compressBytes compresses data with the given content encoding.
+func compressBytes(contentEncoding string, data []byte) ([]byte, error) {
+	switch contentEncoding {
+	case contentEncodingZstd:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case contentEncodingGzip:
+		return gzipBytes(data)
+	default:
+		return nil, errors.Errorf("unsupported content encoding %s", contentEncoding)
+	}
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// NewDecompressionHandler wraps a JSON-RPC server handler so that it
+// advertises, and accepts, zstd and gzip compressed requests. Requests with
+// any other Content-Encoding are refused with a 415.
+func NewDecompressionHandler(next http.Handler) http.Handler {
+	acceptEncoding := strings.Join(compressionEncodings, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Accept-Encoding", acceptEncoding)
+
+		switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+		case "", "identity":
+		case contentEncodingGzip:
+			zr, err := gzip.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, "invalid gzip body", http.StatusBadRequest)
+				return
+			}
+			defer zr.Close()
+			r.Body = ioutil.NopCloser(zr)
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = -1
+		case contentEncodingZstd:
+			compressed, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "reading body", http.StatusBadRequest)
+				return
+			}
+			body, err := zstdDecoder.DecodeAll(compressed, nil)
+			if err != nil {
+				http.Error(w, "invalid zstd body", http.StatusBadRequest)
+				return
+			}
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			r.Header.Del("Content-Encoding")
+			r.ContentLength = int64(len(body))
+		default:
+			http.Error(w, "unsupported content encoding", http.StatusUnsupportedMediaType)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
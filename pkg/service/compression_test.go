@@ -0,0 +1,215 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/osquery/osquery-go/plugin/logger"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+func TestCompressingTransport(t *testing.T) {
+	t.Parallel()
+
+	var gotBodies []string
+	var gotEncodings []string
+	handler := NewDecompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		gotBodies = append(gotBodies, string(body))
+		w.WriteHeader(http.StatusOK)
+	}))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncodings = append(gotEncodings, r.Header.Get("Content-Encoding"))
+		handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newCompressingTransport(http.DefaultTransport)}
+	payload := strings.Repeat("compress me ", 200)
+
+	// The first request is sent uncompressed, the server's response
+	// advertises zstd and gzip, and the rest use zstd.
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL, "application/json", bytes.NewBufferString(payload))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, []string{"", "zstd"}, gotEncodings)
+	require.Equal(t, []string{payload, payload}, gotBodies)
+}
+
+func TestCompressingTransportGzipOnly(t *testing.T) {
+	t.Parallel()
+
+	var gotBodies []string
+	var gotEncodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncodings = append(gotEncodings, r.Header.Get("Content-Encoding"))
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+		data, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		gotBodies = append(gotBodies, string(data))
+
+		w.Header().Set("Accept-Encoding", "gzip")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	client := &http.Client{Transport: newCompressingTransport(http.DefaultTransport)}
+	payload := strings.Repeat("compress me ", 200)
+
+	// A server that only advertises gzip gets gzip
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL, "application/json", bytes.NewBufferString(payload))
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, []string{"", "gzip"}, gotEncodings)
+	require.Equal(t, []string{payload, payload}, gotBodies)
+}
+
+func TestCompressingTransportFallback(t *testing.T) {
+	t.Parallel()
+
+	var gotEncodings []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotEncodings = append(gotEncodings, r.Header.Get("Content-Encoding"))
+		if r.Header.Get("Content-Encoding") != "" {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	transport := newCompressingTransport(http.DefaultTransport)
+	transport.accepted = 1
+	client := &http.Client{Transport: transport}
+
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL, "application/json", bytes.NewBufferString(strings.Repeat("a", 2*minCompressBytes)))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		resp.Body.Close()
+	}
+
+	require.Equal(t, []string{"zstd", "", ""}, gotEncodings)
+}
+
+func TestDecompressionHandlerRejectsUnknownEncoding(t *testing.T) {
+	t.Parallel()
+
+	handler := NewDecompressionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler should not be called")
+	}))
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte("data"))
+	zw.Close()
+
+	req := httptest.NewRequest("POST", "/", &buf)
+	req.Header.Set("Content-Encoding", "br")
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusUnsupportedMediaType, rec.Code)
+	require.Equal(t, "zstd, gzip", rec.Header().Get("Accept-Encoding"))
+}
+
+func TestZstdCompressor(t *testing.T) {
+	t.Parallel()
+
+	c := encoding.GetCompressor("zstd")
+	require.NotNil(t, c, "zstd compressor is registered with grpc")
+
+	payload := strings.Repeat("compress me ", 200)
+	var buf bytes.Buffer
+	wc, err := c.Compress(&buf)
+	require.NoError(t, err)
+	_, err = wc.Write([]byte(payload))
+	require.NoError(t, err)
+	require.NoError(t, wc.Close())
+	require.Less(t, buf.Len(), len(payload))
+
+	r, err := c.Decompress(&buf)
+	require.NoError(t, err)
+	got, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, payload, string(got))
+}
+
+func TestCompressionInterceptor(t *testing.T) {
+	t.Parallel()
+
+	// The server only has a gzip decompressor
+	var gotEncodings []string
+	invoker := func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+		compressor := ""
+		for _, opt := range opts {
+			if c, ok := opt.(grpc.CompressorCallOption); ok {
+				compressor = c.CompressorType
+			}
+		}
+		gotEncodings = append(gotEncodings, compressor)
+		if compressor == "zstd" {
+			return status.Errorf(codes.Unimplemented, "grpc: Decompressor is not installed for grpc-encoding %q", compressor)
+		}
+		return nil
+	}
+
+	interceptor := &compressionInterceptor{}
+	for i := 0; i < 2; i++ {
+		require.NoError(t, interceptor.intercept(context.Background(), "/kolide.agent.Api/PublishLogs", nil, nil, nil, invoker))
+	}
+	require.NoError(t, interceptor.intercept(context.Background(), "/kolide.agent.Api/RequestConfig", nil, nil, nil, invoker))
+
+	// zstd is tried first, the server refuses it, and gzip is used from then on
+	require.Equal(t, []string{"zstd", "gzip", "gzip", ""}, gotEncodings)
+}
+
+func TestGRPCCompressedRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	svc := &fakeHTTPService{}
+	grpcServer := grpc.NewServer()
+	RegisterGRPCServer(grpcServer, NewGRPCServer(MakeServerEndpoints(svc), log.NewNopLogger()))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go grpcServer.Serve(listener)
+	defer grpcServer.Stop()
+
+	conn, err := DialGRPC(listener.Addr().String(), false, true, nil, nil, log.NewNopLogger())
+	require.NoError(t, err)
+	defer conn.Close()
+	client := NewGRPCClient(conn, log.NewNopLogger())
+
+	before := Stats()
+	payload := strings.Repeat("compress me ", 200)
+	_, _, _, err = client.PublishLogs(context.Background(), "node_key", logger.LogTypeString, []string{payload})
+	require.NoError(t, err)
+
+	// The server decompresses zstd, so the request is sent compressed
+	require.Equal(t, [][]string{{payload}}, svc.logs)
+	require.GreaterOrEqual(t, Stats().CompressedRequests-before.CompressedRequests, uint64(1))
+}
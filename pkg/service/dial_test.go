@@ -215,8 +215,7 @@ func TestCertPinning(t *testing.T) { // nolint:paralleltest
 			certPins, err := parseCertPins(tt.pins)
 			require.NoError(t, err)
 
-			tlsconf := makeTLSConfig("localhost", false, certPins, nil, log.NewNopLogger())
-			tlsconf.RootCAs = pool
+			tlsconf := makeTLSConfig("localhost", false, NewTLSSettings(certPins, pool), nil, log.NewNopLogger())
 
 			conn, err := DialGRPC("localhost:8443", false, false, nil, nil, log.NewNopLogger(),
 				grpc.WithTransportCredentials(&tlsCreds{credentials.NewTLS(tlsconf)}),
@@ -279,7 +278,7 @@ func TestRootCAs(t *testing.T) { // nolint:paralleltest
 
 	for _, tt := range testCases { // nolint:paralleltest
 		t.Run("", func(t *testing.T) {
-			conn, err := DialGRPC("localhost:8443", false, false, nil, tt.pool, log.NewNopLogger())
+			conn, err := DialGRPC("localhost:8443", false, false, NewTLSSettings(nil, tt.pool), nil, log.NewNopLogger())
 			require.NoError(t, err)
 			defer conn.Close()
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -135,6 +136,61 @@ func decodeJSONRPCPublishLogsResponse(_ context.Context, res jsonrpc.Response) (
 	return result, nil
 }
 
+// httpLogCollection is the REST form of logCollection. The log type is
+// named, as by osquery, rather than numbered.
+type httpLogCollection struct {
+	NodeKey string   `json:"node_key"`
+	LogType string   `json:"log_type"`
+	Logs    []string `json:"logs"`
+}
+
+// httpLogTypes are the log type names accepted by the REST transport.
+var httpLogTypes = map[string]logger.LogType{
+	logger.LogTypeString.String():   logger.LogTypeString,
+	logger.LogTypeSnapshot.String(): logger.LogTypeSnapshot,
+	logger.LogTypeHealth.String():   logger.LogTypeHealth,
+	logger.LogTypeInit.String():     logger.LogTypeInit,
+	logger.LogTypeStatus.String():   logger.LogTypeStatus,
+}
+
+func decodeHTTPLogCollection(_ context.Context, r *http.Request) (interface{}, error) {
+	var req httpLogCollection
+	if err := decodeHTTPJSONRequest(r, &req); err != nil {
+		return nil, err
+	}
+	typ, ok := httpLogTypes[req.LogType]
+	if !ok {
+		return nil, httpBadRequest{errors.Errorf("unknown log_type %q", req.LogType)}
+	}
+	return logCollection{
+		NodeKey: req.NodeKey,
+		LogType: typ,
+		Logs:    req.Logs,
+	}, nil
+}
+
+func encodeHTTPLogCollection(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(logCollection)
+	return encodeHTTPJSONRequest(r, httpLogCollection{
+		NodeKey: req.NodeKey,
+		LogType: req.LogType.String(),
+		Logs:    req.Logs,
+	})
+}
+
+func encodeHTTPPublishLogsResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(publishLogsResponse)
+	return encodeHTTPResponse(w, res, res.Err)
+}
+
+func decodeHTTPPublishLogsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var res publishLogsResponse
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "PublishLogs")
+	}
+	return res, nil
+}
+
 func MakePublishLogsEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(logCollection)
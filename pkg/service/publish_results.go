@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -145,6 +146,43 @@ func encodeJSONRPCPublishResultsResponse(_ context.Context, obj interface{}) (js
 	return encodeJSONResponse(b, errors.Wrap(err, "marshal json response"))
 }
 
+type httpResultCollection struct {
+	NodeKey string               `json:"node_key"`
+	Results []distributed.Result `json:"results"`
+}
+
+func decodeHTTPResultCollection(_ context.Context, r *http.Request) (interface{}, error) {
+	var req httpResultCollection
+	if err := decodeHTTPJSONRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return resultCollection{
+		NodeKey: req.NodeKey,
+		Results: req.Results,
+	}, nil
+}
+
+func encodeHTTPResultCollection(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(resultCollection)
+	return encodeHTTPJSONRequest(r, httpResultCollection{
+		NodeKey: req.NodeKey,
+		Results: req.Results,
+	})
+}
+
+func encodeHTTPPublishResultsResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(publishResultsResponse)
+	return encodeHTTPResponse(w, res, res.Err)
+}
+
+func decodeHTTPPublishResultsResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var res publishResultsResponse
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "PublishResults")
+	}
+	return res, nil
+}
+
 func MakePublishResultsEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(resultCollection)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -92,6 +93,31 @@ func decodeJSONRPCConfigResponse(_ context.Context, res jsonrpc.Response) (inter
 	return result, nil
 }
 
+func decodeHTTPConfigRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req configRequest
+	if err := decodeHTTPJSONRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func encodeHTTPConfigRequest(_ context.Context, r *http.Request, request interface{}) error {
+	return encodeHTTPJSONRequest(r, request.(configRequest))
+}
+
+func encodeHTTPConfigResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(configResponse)
+	return encodeHTTPResponse(w, res, res.Err)
+}
+
+func decodeHTTPConfigResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var res configResponse
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "RequestConfig")
+	}
+	return res, nil
+}
+
 func MakeRequestConfigEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(configRequest)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -146,6 +147,46 @@ func encodeGRPCEnrollmentResponse(_ context.Context, request interface{}) (inter
 	return encodeResponse(resp, req.Err)
 }
 
+type httpEnrollmentRequest struct {
+	EnrollSecret      string            `json:"enroll_secret"`
+	HostIdentifier    string            `json:"host_identifier"`
+	EnrollmentDetails EnrollmentDetails `json:"enrollment_details"`
+}
+
+func decodeHTTPEnrollmentRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req httpEnrollmentRequest
+	if err := decodeHTTPJSONRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return enrollmentRequest{
+		EnrollSecret:      req.EnrollSecret,
+		HostIdentifier:    req.HostIdentifier,
+		EnrollmentDetails: req.EnrollmentDetails,
+	}, nil
+}
+
+func encodeHTTPEnrollmentRequest(_ context.Context, r *http.Request, request interface{}) error {
+	req := request.(enrollmentRequest)
+	return encodeHTTPJSONRequest(r, httpEnrollmentRequest{
+		EnrollSecret:      req.EnrollSecret,
+		HostIdentifier:    req.HostIdentifier,
+		EnrollmentDetails: req.EnrollmentDetails,
+	})
+}
+
+func encodeHTTPEnrollmentResponse(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(enrollmentResponse)
+	return encodeHTTPResponse(w, res, res.Err)
+}
+
+func decodeHTTPEnrollmentResponse(_ context.Context, r *http.Response) (interface{}, error) {
+	var res enrollmentResponse
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "RequestEnrollment")
+	}
+	return res, nil
+}
+
 func MakeRequestEnrollmentEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(enrollmentRequest)
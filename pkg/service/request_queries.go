@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/go-kit/kit/endpoint"
@@ -109,6 +110,47 @@ func encodeJSONRPCQueryCollection(_ context.Context, obj interface{}) (json.RawM
 	return encodeJSONResponse(b, errors.Wrap(err, "marshal json response"))
 }
 
+// httpQueryCollection is the REST form of queryCollectionResponse, with
+// the queries inlined.
+type httpQueryCollection struct {
+	distributed.GetQueriesResult
+	NodeInvalid bool   `json:"node_invalid"`
+	ErrorCode   string `json:"error_code,omitempty"`
+}
+
+func decodeHTTPQueriesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	var req queriesRequest
+	if err := decodeHTTPJSONRequest(r, &req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+func encodeHTTPQueriesRequest(_ context.Context, r *http.Request, request interface{}) error {
+	return encodeHTTPJSONRequest(r, request.(queriesRequest))
+}
+
+func encodeHTTPQueryCollection(_ context.Context, w http.ResponseWriter, response interface{}) error {
+	res := response.(queryCollectionResponse)
+	return encodeHTTPResponse(w, httpQueryCollection{
+		GetQueriesResult: res.Queries,
+		NodeInvalid:      res.NodeInvalid,
+		ErrorCode:        res.ErrorCode,
+	}, res.Err)
+}
+
+func decodeHTTPQueryCollection(_ context.Context, r *http.Response) (interface{}, error) {
+	var res httpQueryCollection
+	if err := decodeHTTPJSONResponse(r, &res); err != nil {
+		return nil, errors.Wrap(err, "RequestQueries")
+	}
+	return queryCollectionResponse{
+		Queries:     res.GetQueriesResult,
+		NodeInvalid: res.NodeInvalid,
+		ErrorCode:   res.ErrorCode,
+	}, nil
+}
+
 func MakeRequestQueriesEndpoint(svc KolideService) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (response interface{}, err error) {
 		req := request.(queriesRequest)
@@ -50,7 +50,7 @@ func MakeServerEndpoints(svc KolideService) Endpoints {
 }
 
 func NewGRPCServer(endpoints Endpoints, logger log.Logger, options ...grpctransport.ServerOption) pb.ApiServer {
-	options = append(options, parseUUID())
+	options = append(options, parseUUID(), parseGRPCChunk(), advertiseGRPCChunks())
 	return &grpcServer{
 		enrollment: grpctransport.NewServer(
 			endpoints.RequestEnrollmentEndpoint,
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"net/http"
+
+	"github.com/go-kit/kit/log"
+	httptransport "github.com/go-kit/kit/transport/http"
+	"github.com/pkg/errors"
+)
+
+// Paths of the REST transport. Every request and response body is JSON,
+// as described by the OpenAPI schema served at httpOpenAPIPath.
+const (
+	httpEnrollPath  = "/api/v1/agent/enroll"
+	httpConfigPath  = "/api/v1/agent/config"
+	httpLogsPath    = "/api/v1/agent/logs"
+	httpQueriesPath = "/api/v1/agent/queries"
+	httpResultsPath = "/api/v1/agent/results"
+	httpHealthPath  = "/api/v1/agent/health"
+	httpOpenAPIPath = "/api/v1/agent/openapi.yaml"
+)
+
+// OpenAPISchema is the OpenAPI 3 description of the REST transport.
+//
+//go:embed openapi.yaml
+var OpenAPISchema []byte
+
+// httpError is the body of a REST transport error response.
+type httpError struct {
+	Error string `json:"error"`
+}
+
+// httpBadRequest marks errors caused by a malformed request.
+type httpBadRequest struct {
+	error
+}
+
+// NewHTTPServer returns a handler serving the REST transport. It accepts
+// zstd and gzip compressed requests, and reassembly of chunked payloads is left to
+// ChunkReassemblyMiddleware on the service.
+func NewHTTPServer(endpoints Endpoints, logger log.Logger, options ...httptransport.ServerOption) http.Handler {
+	options = append(options,
+		httptransport.ServerErrorLogger(logger),
+		httptransport.ServerErrorEncoder(encodeHTTPError),
+		httptransport.ServerBefore(parseHTTPChunk),
+		httptransport.ServerAfter(advertiseHTTPChunks),
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(httpEnrollPath, allowMethod("POST", httptransport.NewServer(
+		endpoints.RequestEnrollmentEndpoint,
+		decodeHTTPEnrollmentRequest,
+		encodeHTTPEnrollmentResponse,
+		options...,
+	)))
+	mux.Handle(httpConfigPath, allowMethod("POST", httptransport.NewServer(
+		endpoints.RequestConfigEndpoint,
+		decodeHTTPConfigRequest,
+		encodeHTTPConfigResponse,
+		options...,
+	)))
+	mux.Handle(httpLogsPath, allowMethod("POST", httptransport.NewServer(
+		endpoints.PublishLogsEndpoint,
+		decodeHTTPLogCollection,
+		encodeHTTPPublishLogsResponse,
+		options...,
+	)))
+	mux.Handle(httpQueriesPath, allowMethod("POST", httptransport.NewServer(
+		endpoints.RequestQueriesEndpoint,
+		decodeHTTPQueriesRequest,
+		encodeHTTPQueryCollection,
+		options...,
+	)))
+	mux.Handle(httpResultsPath, allowMethod("POST", httptransport.NewServer(
+		endpoints.PublishResultsEndpoint,
+		decodeHTTPResultCollection,
+		encodeHTTPPublishResultsResponse,
+		options...,
+	)))
+	mux.Handle(httpHealthPath, allowMethod("GET", httptransport.NewServer(
+		endpoints.CheckHealthEndpoint,
+		decodeHTTPHealthCheckRequest,
+		encodeHTTPHealthCheckResponse,
+		options...,
+	)))
+	mux.Handle(httpOpenAPIPath, allowMethod("GET", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(OpenAPISchema)
+	})))
+
+	return NewDecompressionHandler(mux)
+}
+
+// allowMethod refuses requests made with any other method.
+func allowMethod(method string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			encodeHTTPJSON(w, http.StatusMethodNotAllowed, httpError{Error: "method not allowed"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// decodeHTTPJSONRequest unmarshals a REST request body into v.
+func decodeHTTPJSONRequest(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return httpBadRequest{errors.Wrap(err, "decoding request body")}
+	}
+	return nil
+}
+
+// encodeHTTPResponse writes a REST response, or the error the service
+// returned in its place.
+func encodeHTTPResponse(w http.ResponseWriter, response interface{}, err error) error {
+	if err != nil {
+		return encodeHTTPJSON(w, http.StatusInternalServerError, httpError{Error: err.Error()})
+	}
+	return encodeHTTPJSON(w, http.StatusOK, response)
+}
+
+func encodeHTTPError(_ context.Context, err error, w http.ResponseWriter) {
+	status := http.StatusInternalServerError
+	if _, ok := err.(httpBadRequest); ok {
+		status = http.StatusBadRequest
+	}
+	encodeHTTPJSON(w, status, httpError{Error: err.Error()})
+}
+
+func encodeHTTPJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	return json.NewEncoder(w).Encode(v)
+}
@@ -6,7 +6,7 @@ import (
 )
 
 func NewJSONRPCServer(endpoints Endpoints, logger log.Logger, options ...jsonrpc.ServerOption) *jsonrpc.Server {
-	options = append(options, jsonrpc.ServerErrorLogger(logger))
+	options = append(options, jsonrpc.ServerErrorLogger(logger), jsonrpc.ServerBefore(parseHTTPChunk), jsonrpc.ServerAfter(advertiseHTTPChunks))
 	handler := jsonrpc.NewServer(
 		makeEndpointCodecMap(endpoints),
 		options...,
@@ -0,0 +1,49 @@
+package service
+
+import "sync/atomic"
+
+// TransportStats counts the work the transports have done to fit payloads
+// into the server's request limits.
+type TransportStats struct {
+	// CompressedRequests is the number of requests sent compressed.
+	CompressedRequests uint64
+	// LogsSplit is the number of oversized logs split into chunks.
+	LogsSplit uint64
+	// LogsDropped is the number of oversized logs dropped because the
+	// server hadn't advertised that it reassembles chunks.
+	LogsDropped uint64
+	// ResultsSplit is the number of oversized distributed query results
+	// split into chunks.
+	ResultsSplit uint64
+	// ChunksSent is the number of requests carrying a chunk.
+	ChunksSent uint64
+	// RowsDropped is the number of result rows dropped because a single
+	// row was larger than the request limit.
+	RowsDropped uint64
+	// ChunksReassembled is the number of chunked payloads a server has
+	// put back together.
+	ChunksReassembled uint64
+	// ChunksExpired is the number of chunked payloads a server gave up
+	// on, because the remaining chunks never arrived.
+	ChunksExpired uint64
+}
+
+var transportStats TransportStats
+
+// Stats returns a snapshot of the transport counters.
+func Stats() TransportStats {
+	return TransportStats{
+		CompressedRequests: atomic.LoadUint64(&transportStats.CompressedRequests),
+		LogsSplit:          atomic.LoadUint64(&transportStats.LogsSplit),
+		LogsDropped:        atomic.LoadUint64(&transportStats.LogsDropped),
+		ResultsSplit:       atomic.LoadUint64(&transportStats.ResultsSplit),
+		ChunksSent:         atomic.LoadUint64(&transportStats.ChunksSent),
+		RowsDropped:        atomic.LoadUint64(&transportStats.RowsDropped),
+		ChunksReassembled:  atomic.LoadUint64(&transportStats.ChunksReassembled),
+		ChunksExpired:      atomic.LoadUint64(&transportStats.ChunksExpired),
+	}
+}
+
+func recordCompressedRequest() {
+	atomic.AddUint64(&transportStats.CompressedRequests, 1)
+}
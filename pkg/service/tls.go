@@ -6,47 +6,151 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"errors"
+	"net"
+	"sync"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 )
 
-func makeTLSConfig(host string, insecureTLS bool, certPins [][]byte, rootPool *x509.CertPool, logger log.Logger) *tls.Config {
-	conf := &tls.Config{
-		ServerName:         host,
-		InsecureSkipVerify: insecureTLS,
-		RootCAs:            rootPool,
-		MinVersion:         tls.VersionTLS12,
-	}
-
-	if len(certPins) > 0 {
-		conf.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
-			for _, chain := range verifiedChains {
-				for _, cert := range chain {
-					// Compare SHA256 hash of
-					// SubjectPublicKeyInfo with each of
-					// the pinned hashes.
-					hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
-					for _, pin := range certPins {
-						if bytes.Equal(pin, hash[:]) {
-							// Cert matches pin.
-							return nil
-						}
-					}
+// ClientCertificateFunc returns the certificate launcher authenticates
+// itself to the server with, for mutual TLS. It's called during each TLS
+// handshake, so the certificate can be rotated without reconnecting.
+// Returning an empty certificate sends none.
+type ClientCertificateFunc func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
+
+// TLSSettings are how the transports verify the server: the root CAs, or
+// nil for the system roots, and optional hashes of pinned subject public
+// key info. They may be updated while launcher runs, and are checked on
+// every TLS handshake.
+type TLSSettings struct {
+	lock      sync.RWMutex
+	certPins  [][]byte
+	rootPool  *x509.CertPool
+	observers []func()
+}
+
+// NewTLSSettings returns TLSSettings with the given pins and root CAs.
+func NewTLSSettings(certPins [][]byte, rootPool *x509.CertPool) *TLSSettings {
+	return &TLSSettings{
+		certPins: certPins,
+		rootPool: rootPool,
+	}
+}
+
+// Update replaces the pins and root CAs. Transports drop the connections
+// they made with the old settings, so their next request reconnects with
+// the new ones.
+func (s *TLSSettings) Update(certPins [][]byte, rootPool *x509.CertPool) {
+	s.lock.Lock()
+	s.certPins = certPins
+	s.rootPool = rootPool
+	s.lock.Unlock()
+
+	s.Reconnect()
+}
+
+// Reconnect has the transports drop their connections without changing the
+// settings, so their next request handshakes again. It's used when the
+// client certificate changes.
+func (s *TLSSettings) Reconnect() {
+	s.lock.RLock()
+	observers := s.observers
+	s.lock.RUnlock()
+
+	for _, observer := range observers {
+		observer()
+	}
+}
+
+// onUpdate registers f to be called after each update.
+func (s *TLSSettings) onUpdate(f func()) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.observers = append(s.observers, f)
+}
+
+func (s *TLSSettings) current() ([][]byte, *x509.CertPool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.certPins, s.rootPool
+}
+
+func makeTLSConfig(host string, insecureTLS bool, settings *TLSSettings, clientCert ClientCertificateFunc, logger log.Logger) *tls.Config {
+	if settings == nil {
+		settings = NewTLSSettings(nil, nil)
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+
+	// Go's own verification is skipped, as it could only use the root
+	// CAs at the time the config was made. verifyServer does the same
+	// with the current settings instead.
+	return &tls.Config{
+		ServerName:           host,
+		InsecureSkipVerify:   true,
+		MinVersion:           tls.VersionTLS12,
+		GetClientCertificate: clientCert,
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			return verifyServer(cs, host, insecureTLS, settings, logger)
+		},
+	}
+}
+
+// verifyServer checks the server certificate chain against the root CAs,
+// unless insecureTLS is set, and then against any pins.
+func verifyServer(cs tls.ConnectionState, host string, insecureTLS bool, settings *TLSSettings, logger log.Logger) error {
+	certPins, rootPool := settings.current()
+
+	var verifiedChains [][]*x509.Certificate
+	if !insecureTLS {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("server presented no certificates")
+		}
+
+		intermediates := x509.NewCertPool()
+		for _, cert := range cs.PeerCertificates[1:] {
+			intermediates.AddCert(cert)
+		}
+
+		var err error
+		verifiedChains, err = cs.PeerCertificates[0].Verify(x509.VerifyOptions{
+			DNSName:       host,
+			Roots:         rootPool,
+			Intermediates: intermediates,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(certPins) == 0 {
+		return nil
+	}
+
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			// Compare SHA256 hash of
+			// SubjectPublicKeyInfo with each of
+			// the pinned hashes.
+			hash := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			for _, pin := range certPins {
+				if bytes.Equal(pin, hash[:]) {
+					// Cert matches pin.
+					return nil
 				}
 			}
-
-			// Normally we wouldn't log and return an error, but
-			// gRPC does not seem to expose the error in a way that
-			// we can get at it later. At least this provides some
-			// feedback to the user about what is going wrong.
-			level.Info(logger).Log(
-				"msg", "no match found with pinned certificates",
-				"err", "certificate pin validation failed",
-			)
-			return errors.New("no match found with pinned cert")
 		}
 	}
 
-	return conf
+	// Normally we wouldn't log and return an error, but
+	// gRPC does not seem to expose the error in a way that
+	// we can get at it later. At least this provides some
+	// feedback to the user about what is going wrong.
+	level.Info(logger).Log(
+		"msg", "no match found with pinned certificates",
+		"err", "certificate pin validation failed",
+	)
+	return errors.New("no match found with pinned cert")
 }
@@ -0,0 +1,130 @@
+package service
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTLSSettingsUpdate(t *testing.T) {
+	t.Parallel()
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]int{"status": 1})
+	}))
+	defer server.Close()
+
+	// Start out trusting nothing but an unrelated CA
+	otherPool := x509.NewCertPool()
+	otherPool.AddCert(&x509.Certificate{Raw: []byte("unrelated")})
+	settings := NewTLSSettings(nil, otherPool)
+	client := NewHTTPClient(strings.TrimPrefix(server.URL, "https://"), false, false, settings, nil, log.NewNopLogger())
+
+	_, err := client.CheckHealth(context.Background())
+	require.Error(t, err)
+
+	// Trusting the server's certificate lets the next request through.
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(server.Certificate())
+	settings.Update(nil, rootPool)
+
+	status, err := client.CheckHealth(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), status)
+
+	// A pin that doesn't match drops the existing connection, and the
+	// new one is refused.
+	settings.Update([][]byte{[]byte("not a pin")}, rootPool)
+	_, err = client.CheckHealth(context.Background())
+	require.Error(t, err)
+
+	// And a matching pin accepts it again.
+	pin := sha256.Sum256(server.Certificate().RawSubjectPublicKeyInfo)
+	settings.Update([][]byte{pin[:]}, rootPool)
+	status, err = client.CheckHealth(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, int32(1), status)
+}
+
+// selfSignedCert returns a throwaway certificate with the given name.
+func selfSignedCert(t *testing.T, name string) *tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestTLSSettingsReconnect(t *testing.T) {
+	t.Parallel()
+
+	var lock sync.Mutex
+	var presented []string
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		if len(r.TLS.PeerCertificates) > 0 {
+			presented = append(presented, r.TLS.PeerCertificates[0].Subject.CommonName)
+		}
+		lock.Unlock()
+		json.NewEncoder(w).Encode(map[string]int{"status": 1})
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	rootPool := x509.NewCertPool()
+	rootPool.AddCert(server.Certificate())
+	settings := NewTLSSettings(nil, rootPool)
+
+	cert := selfSignedCert(t, "first")
+	clientCert := func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		lock.Lock()
+		defer lock.Unlock()
+		return cert, nil
+	}
+	client := NewHTTPClient(strings.TrimPrefix(server.URL, "https://"), false, false, settings, clientCert, log.NewNopLogger())
+
+	_, err := client.CheckHealth(context.Background())
+	require.NoError(t, err)
+
+	// A rotated certificate isn't presented on the existing connection...
+	lock.Lock()
+	cert = selfSignedCert(t, "second")
+	lock.Unlock()
+	_, err = client.CheckHealth(context.Background())
+	require.NoError(t, err)
+
+	// ...until it's dropped, and the next request handshakes again.
+	settings.Reconnect()
+	_, err = client.CheckHealth(context.Background())
+	require.NoError(t, err)
+
+	lock.Lock()
+	defer lock.Unlock()
+	require.Equal(t, []string{"first", "first", "second"}, presented)
+}
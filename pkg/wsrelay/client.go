@@ -5,6 +5,7 @@ import (
 	"net/url"
 
 	"github.com/gorilla/websocket"
+	"github.com/kolide/launcher/pkg/proxy"
 	"github.com/pkg/errors"
 )
 
@@ -30,7 +31,10 @@ func NewClient(brokerAddr, path string, disableTLS bool, insecure bool) (*Client
 	}
 
 	// connect to the websocket at the given URL
-	dialer := websocket.Dialer{TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure}}
+	dialer := websocket.Dialer{
+		NetDialContext:  proxy.DialContext,
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
+	}
 	conn, resp, err := dialer.Dial(u.String(), nil)
 
 	if err != nil {